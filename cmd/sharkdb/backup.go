@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sharkDB/internal/pager2"
+)
+
+// runBackup implements `sharkdb backup [-db file | -from url] <out|->`. With
+// -db it opens the database itself, so no server may have it open; with
+// -from it downloads GET /admin/backup from a running HTTP server, or sends
+// BACKUP STREAM to a TCP server given as tcp://host:port, and checks the
+// stream as it is saved.
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dbPath := fs.String("db", "sharkdb.gob", "path to database file (not open elsewhere)")
+	from := fs.String("from", "", "base URL of a running HTTP server, e.g. http://localhost:8090, or tcp://host:port of a TCP server")
+	token := fs.String("token", "", "bearer token (HTTP) or AUTH token (TCP) for -from")
+	keyFile := keyFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb backup [-db file | -from url|tcp://host:port [-token t]] <out|->")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	out := fs.Arg(0)
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		return 2
+	}
+
+	if *from == "" {
+		p, err := pager2.OpenOptions(*dbPath, pager2.Options{Key: key})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "backup: open pager:", err)
+			return 1
+		}
+		snap, err := p.Snapshot()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "backup:", err)
+			return 1
+		}
+		defer snap.Close()
+		var n int64
+		if out == "-" {
+			w := bufio.NewWriterSize(os.Stdout, 1<<20)
+			if n, err = snap.WriteTo(w); err == nil {
+				err = w.Flush()
+			}
+		} else {
+			n, err = snap.WriteFile(out)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "backup:", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "backup: wrote %d bytes\n", n)
+		return 0
+	}
+
+	var body io.ReadCloser
+	if addr, ok := strings.CutPrefix(*from, "tcp://"); ok {
+		body, err = fetchTCP(addr, *token)
+	} else {
+		body, err = fetchHTTP(*from, *token)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		return 1
+	}
+	defer body.Close()
+	var f *os.File
+	var tmp string
+	if out == "-" {
+		f = os.Stdout
+	} else {
+		if f, err = os.CreateTemp(dirOf(out), ".sharkdb-backup-*"); err != nil {
+			fmt.Fprintln(os.Stderr, "backup:", err)
+			return 1
+		}
+		tmp = f.Name()
+		defer os.Remove(tmp)
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	info, err := pager2.VerifyBackup(io.TeeReader(body, w), key)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil && tmp != "" {
+		if err = f.Sync(); err == nil {
+			err = f.Close()
+		}
+		if err == nil {
+			err = os.Rename(tmp, out)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "backup: wrote %d bytes, verified\n", info.Size())
+	return 0
+}
+
+// fetchHTTP requests GET /admin/backup from the HTTP server at base.
+func fetchHTTP(base, token string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(base, "/")+"/admin/backup", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// fetchTCP sends BACKUP STREAM to the TCP server at addr, after AUTH if a
+// token is given, and returns the backup stream that follows the reply.
+func fetchTCP(addr, token string) (io.ReadCloser, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(conn, 1<<20)
+	reply := func() (string, error) {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err == nil && strings.HasPrefix(line, "ERR") {
+			err = fmt.Errorf("%s: %s", addr, line)
+		}
+		return line, err
+	}
+	fail := func(err error) (io.ReadCloser, error) {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := reply(); err != nil { // greeting
+		return fail(err)
+	}
+	if token != "" {
+		fmt.Fprintf(conn, "AUTH %s\n", token)
+		if _, err := reply(); err != nil {
+			return fail(err)
+		}
+	}
+	fmt.Fprintln(conn, "BACKUP STREAM")
+	line, err := reply()
+	if err != nil {
+		return fail(err)
+	}
+	var n int64
+	if _, err := fmt.Sscanf(line, "BACKUP %d", &n); err != nil {
+		return fail(fmt.Errorf("%s: unexpected reply %q", addr, line))
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(r, n), conn}, nil
+}
+
+// runRestore implements `sharkdb restore [-db file] [-verify] [-wal dir
+// [-to-time t | -to-lsn n]] <backup|->`. Every checksum is verified, and
+// archived WAL segments are replayed, before the database file is replaced.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dbPath := fs.String("db", "sharkdb.gob", "database file to replace (must not be open)")
+	verify := fs.Bool("verify", false, "only verify the backup")
+	walDir := fs.String("wal", "", "replay the archived WAL segments in this directory onto the backup")
+	toTime := fs.String("to-time", "", "stop replaying after the last write at or before this RFC 3339 time")
+	toLSN := fs.Uint64("to-lsn", 0, "stop replaying after the write with this LSN")
+	keyFile := keyFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb restore [-db file] [-verify] [-wal dir [-to-time t | -to-lsn n]] <backup|->")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 2
+	}
+	to := pager2.Target{LSN: *toLSN}
+	if *toTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, *toTime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "restore: -to-time:", err)
+			return 2
+		}
+		to.Time = t
+	}
+	if (to.LSN != 0 || *toTime != "") && *walDir == "" {
+		fmt.Fprintln(os.Stderr, "restore: -to-time and -to-lsn need -wal")
+		return 2
+	}
+	var in io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "restore:", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	in = bufio.NewReaderSize(in, 1<<20)
+	const stamp = "2006-01-02 15:04:05.000 MST"
+	if *verify {
+		info, err := pager2.VerifyBackup(in, key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "restore:", err)
+			return 1
+		}
+		if info.Encrypted && key == nil {
+			fmt.Printf("backup OK: %d pages, encrypted (checksums only; give the key to authenticate it), taken %s\n", info.Pages, info.Created.Format(stamp))
+			return 0
+		}
+		fmt.Printf("backup OK: %d pages, LSN %d, taken %s\n", info.Pages, info.LSN, info.Created.Format(stamp))
+		return 0
+	}
+	info, rec, err := pager2.RestoreTo(in, *dbPath, *walDir, to, key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	fmt.Printf("restored %d pages to %s (backup LSN %d, taken %s)\n", info.Pages, *dbPath, info.LSN, info.Created.Format(stamp))
+	if *walDir != "" {
+		at := "no writes replayed"
+		if !rec.Time.IsZero() {
+			at = "written " + rec.Time.Format(stamp)
+		}
+		fmt.Printf("replayed %d WAL segments up to LSN %d (%s)\n", rec.Segments, rec.LSN, at)
+	}
+	return 0
+}
+
+func dirOf(path string) string {
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		return path[:i+1]
+	}
+	return "."
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/storage"
+)
+
+// runBench implements `sharkdb bench [flags]`. It builds a scratch database
+// and times the same random reads through the page cache and through the
+// memory map, first of whole table blobs at the pager and then of GETs
+// through the engine, which adds decoding the tree.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	tables := fs.Int("tables", 8, "tables to create")
+	rows := fs.Int("rows", 5000, "rows per table")
+	valueSize := fs.Int("value", 100, "bytes per value")
+	reads := fs.Int("reads", 2000, "blob reads to time per mode")
+	gets := fs.Int("gets", 200, "GETs to time per mode")
+	dir := fs.String("dir", "", "directory for the scratch database (default the system temp dir)")
+	keyFile := keyFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb bench [-tables n] [-rows n] [-value n] [-reads n] [-gets n] [-dir d] [-keyfile f]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *tables < 1 || *rows < 1 {
+		fs.Usage()
+		return 2
+	}
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		return 2
+	}
+	tmp, err := os.MkdirTemp(*dir, "sharkdb-bench-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		return 1
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "bench.db")
+	if err := benchLoad(path, key, *tables, *rows, *valueSize); err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		return 1
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		return 1
+	}
+	fmt.Printf("%d tables x %d rows of %d bytes, %.1f MB file\n", *tables, *rows, *valueSize, float64(fi.Size())/(1<<20))
+	fmt.Printf("%-6s %12s %10s %10s %12s\n", "mode", "blob read", "MB/s", "GET", "GETs/s")
+	for _, mmap := range []bool{false, true} {
+		r, err := benchReads(path, key, mmap, *tables, *rows, *reads, *gets)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			return 1
+		}
+		fmt.Printf("%-6s %12s %10.0f %10s %12.0f\n", r.mode, r.perRead.Round(time.Microsecond), r.mbps, r.perGet.Round(time.Microsecond), float64(time.Second)/float64(r.perGet))
+	}
+	return 0
+}
+
+// benchLoad fills a new database at path with tables of rows.
+func benchLoad(path string, key []byte, tables, rows, valueSize int) error {
+	p, err := pager2.OpenOptions(path, pager2.Options{Key: key})
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	eng := engine.New(storage.Pager2(p))
+	value := strings.Repeat("x", valueSize)
+	for t := 0; t < tables; t++ {
+		table := fmt.Sprintf("t%d", t)
+		if _, err := eng.Create(table); err != nil {
+			return err
+		}
+		l, err := eng.NewBulkLoad(table)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < rows; i++ {
+			if err := l.Add(fmt.Sprintf("k%08d", i), value); err != nil {
+				l.Close()
+				return err
+			}
+		}
+		_, err = l.Commit()
+		l.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type benchResult struct {
+	mode            string
+	perRead, perGet time.Duration
+	mbps            float64
+}
+
+// benchReads opens the database at path, warms it up with one read of
+// every table and times random blob reads and GETs.
+func benchReads(path string, key []byte, mmap bool, tables, rows, reads, gets int) (benchResult, error) {
+	r := benchResult{mode: "cache"}
+	if mmap {
+		r.mode = "mmap"
+	}
+	p, err := pager2.OpenOptions(path, pager2.Options{Key: key, MMap: mmap})
+	if err != nil {
+		return r, err
+	}
+	defer p.Close()
+	ids := make([]uint64, 0, tables)
+	for _, id := range p.Meta().Tables {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		if _, err := p.ReadTableBlob(id); err != nil {
+			return r, err
+		}
+	}
+	rnd := rand.New(rand.NewSource(1))
+	var bytes int
+	start := time.Now()
+	for i := 0; i < reads; i++ {
+		blob, err := p.ReadTableBlob(ids[rnd.Intn(len(ids))])
+		if err != nil {
+			return r, err
+		}
+		bytes += len(blob)
+	}
+	elapsed := time.Since(start)
+	r.perRead = elapsed / time.Duration(max(reads, 1))
+	r.mbps = float64(bytes) / (1 << 20) / elapsed.Seconds()
+
+	eng := engine.New(storage.Pager2(p))
+	start = time.Now()
+	for i := 0; i < gets; i++ {
+		table := fmt.Sprintf("t%d", rnd.Intn(tables))
+		if _, err := eng.Get(table, fmt.Sprintf("k%08d", rnd.Intn(rows))); err != nil {
+			return r, err
+		}
+	}
+	r.perGet = time.Since(start) / time.Duration(max(gets, 1))
+	return r, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sharkDB/internal/check"
+)
+
+// runCheck implements `sharkdb check <db>`. It reads the file without
+// opening it through the pager, so it never replays or clears the WAL and
+// can run on a database a server has open, although a busy one may then
+// show spurious errors. The exit status is 1 if the database is damaged.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	keyFile := keyFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb check [-keyfile f] <db>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check:", err)
+		return 2
+	}
+	r, err := check.File(fs.Arg(0), key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check:", err)
+		return 2
+	}
+	r.Print(os.Stdout)
+	if r.Errors() > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/engine"
+	"sharkDB/internal/format"
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/storage"
+)
+
+// runImport implements `sharkdb import [flags] <table> <file>`, a bulk load
+// of a tsv, csv or jsonl file ("-" for stdin) without starting the REPL.
+// The database must not be open in a running server at the same time.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := fs.String("db", "sharkdb.gob", "path to database file")
+	create := fs.Bool("create", false, "create the table if it does not exist")
+	fmtName := fs.String("format", "tsv", "input format: tsv, csv or jsonl")
+	header := fs.Bool("header", false, "the first line names the columns (csv, tsv)")
+	keyCol := fs.String("key", "", "column or field holding the key (default \"key\")")
+	onError := fs.String("onerror", "abort", "on a bad row: skip or abort")
+	keyFile := keyFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb import [flags] <table> <file|->")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	table, path := fs.Arg(0), fs.Arg(1)
+	hdr := ""
+	if *header {
+		hdr = "HEADER"
+	}
+	fo, err := format.OptionsFromArgs([]string{*fmtName, hdr, *keyCol, *onError})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "import:", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 2
+	}
+	p, err := pager2.OpenOptions(*dbPath, pager2.Options{Key: key})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import: open pager:", err)
+		return 1
+	}
+	eng := engine.New(storage.Pager2(p))
+	if *create {
+		if _, err := eng.Create(table); err != nil && !errors.Is(err, catalog.ErrTableExists) {
+			fmt.Fprintln(os.Stderr, "import:", err)
+			return 1
+		}
+	}
+	rows, err := format.NewReader(in, fo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 2
+	}
+	res, err := eng.Load(table, rows, fo.SkipErrors)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 1
+	}
+	fmt.Println(res)
+	for _, e := range res.Errors {
+		fmt.Fprintln(os.Stderr, "skipped", e)
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"sharkDB/internal/inspect"
+)
+
+// runInspect implements `sharkdb inspect <db> <what>`. Like check it reads
+// the file raw, so writes still in the WAL are not shown.
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	depth := fs.Int("depth", 2, "tree: print nodes this many levels below the root (-1 = all)")
+	keyFile := keyFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb inspect [-depth n] [-keyfile f] <db> meta | pages | tables | page <n> | tree <table>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	what, rest := fs.Arg(1), fs.Args()[2:]
+	wantArgs := 0
+	if what == "page" || what == "tree" {
+		wantArgs = 1
+	}
+	if len(rest) != wantArgs {
+		fs.Usage()
+		return 2
+	}
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "inspect:", err)
+		return 2
+	}
+	db, err := inspect.Open(fs.Arg(0), key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "inspect:", err)
+		return 2
+	}
+	defer db.Close()
+	if n := db.WALBytes(); n > 0 {
+		fmt.Fprintf(os.Stderr, "inspect: the WAL holds %d bytes of writes that may not be shown\n", n)
+	}
+	switch what {
+	case "meta":
+		db.Meta(os.Stdout)
+	case "pages":
+		err = db.Pages(os.Stdout)
+	case "tables":
+		db.Tables(os.Stdout)
+	case "page":
+		var pid uint64
+		if pid, err = strconv.ParseUint(rest[0], 10, 64); err == nil {
+			err = db.Page(os.Stdout, pid)
+		}
+	case "tree":
+		err = db.Tree(os.Stdout, rest[0], *depth)
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "inspect:", err)
+		return 1
+	}
+	return 0
+}
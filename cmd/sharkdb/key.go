@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sharkDB/internal/pager2"
+)
+
+// keyEnv holds the encryption key when no key file is given.
+const keyEnv = "SHARKDB_KEY"
+
+// keyFlag registers -keyfile on fs.
+func keyFlag(fs *flag.FlagSet) *string {
+	return fs.String("keyfile", "", "file holding the encryption key, 64 hex digits or 32 raw bytes (default $"+keyEnv+")")
+}
+
+// loadKey reads the key from path, or from the environment variable env if
+// path is empty. It returns nil if neither is set: the database is plain.
+func loadKey(path, env string) ([]byte, error) {
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := pager2.ParseKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return key, nil
+	}
+	if v := os.Getenv(env); v != "" {
+		key, err := pager2.ParseKey([]byte(v))
+		if err != nil {
+			return nil, fmt.Errorf("$%s: %w", env, err)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
@@ -2,37 +2,91 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"sharkDB/internal/bptree"
 	"sharkDB/internal/engine"
+	"sharkDB/internal/format"
 	"sharkDB/internal/httpserver"
 	"sharkDB/internal/pager2"
 	"sharkDB/internal/parser"
 	"sharkDB/internal/server"
+	"sharkDB/internal/storage"
 	"sharkDB/internal/txn"
 )
 
 func main() {
-	dbFlag := flag.String("db", "sharkdb.gob", "path to database file")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "salvage":
+			os.Exit(runSalvage(os.Args[2:]))
+		case "inspect":
+			os.Exit(runInspect(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "rekey":
+			os.Exit(runRekey(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		}
+	}
+	dbFlag := flag.String("db", "sharkdb.gob", "path to database file, or "+storage.MemPrefix+" for an in-memory database")
+	storageFlag := flag.String("storage", "", "storage backend: "+strings.Join(storage.Kinds, ", ")+" (default pager2, or mem for "+storage.MemPrefix+")")
 	serve := flag.String("serve", "", "listen address for TCP server, e.g. :8080 (empty = CLI mode)")
 	httpAddr := flag.String("http", "", "listen address for HTTP server, e.g. :8090 (empty = off)")
 	auth := flag.String("auth", "", "require this token for TCP writes (AUTH <token>)")
 	readonly := flag.Bool("readonly", false, "start TCP server in read-only mode (blocks writes)")
 	httpAuth := flag.String("httpauth", "", "require this bearer token for HTTP writes")
 	httpReadonly := flag.Bool("httpreadonly", false, "start HTTP server in read-only mode (blocks writes)")
+	httpTxTimeout := flag.Duration("httptxtimeout", 30*time.Second, "abort HTTP transactions idle for longer than this")
+	httpConditional := flag.Bool("httprequireconditional", false, "answer 428 to HTTP key writes without If-Match or If-None-Match")
+	sweepEvery := flag.Duration("sweep", time.Minute, "interval between sweeps of expired keys (0 = off)")
+	sweepBatch := flag.Int("sweepbatch", 1000, "maximum expired keys deleted per sweep batch")
+	walArchive := flag.String("walarchive", "", "copy closed WAL segments to this directory for point-in-time recovery")
+	walSegment := flag.Int64("walsegment", pager2.DefaultSegmentBytes>>20, "close WAL segments once they reach this many MB")
+	walSwitch := flag.Duration("walswitch", 0, "also close the WAL segment at this interval if it has writes (0 = only when full)")
+	mmap := flag.Bool("mmap", false, "read pages through a memory map of the database file (Linux)")
+	keyFile := keyFlag(flag.CommandLine)
 	flag.Parse()
 
 	dbPath := *dbFlag
-	p, err := pager2.Open(dbPath)
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		log.Fatalf("key: %v", err)
+	}
+	st, err := storage.Open(dbPath, *storageFlag, pager2.Options{ArchiveDir: *walArchive, SegmentBytes: *walSegment << 20, Key: key, MMap: *mmap})
 	if err != nil {
-		log.Fatalf("open pager: %v", err)
+		log.Fatalf("open storage: %v", err)
 	}
-	eng := engine.New(p)
+	defer st.Close()
+	if *walSwitch > 0 {
+		if err := st.SwitchWAL(); errors.Is(err, storage.ErrNoWAL) {
+			log.Fatalf("-walswitch needs the pager2 storage backend")
+		}
+		go runWALSwitcher(st, *walSwitch)
+	}
+	eng := engine.New(st)
 	tm := txn.NewManager()
+	if *sweepEvery > 0 {
+		go runSweeper(eng, tm, *sweepEvery, *sweepBatch)
+	}
 
 	if *serve != "" {
 		log.Printf("starting server on %s", *serve)
@@ -45,7 +99,7 @@ func main() {
 
 	if *httpAddr != "" {
 		log.Printf("starting HTTP server on %s", *httpAddr)
-		if err := httpserver.Start(*httpAddr, eng, tm, httpserver.Options{RequireToken: *httpAuth, ReadOnly: *httpReadonly}); err != nil {
+		if err := httpserver.Start(*httpAddr, eng, tm, httpserver.Options{RequireToken: *httpAuth, ReadOnly: *httpReadonly, TxTimeout: *httpTxTimeout, RequireConditional: *httpConditional}); err != nil {
 			log.Fatal(err)
 		}
 		return
@@ -80,10 +134,23 @@ func main() {
 			fmt.Println("Commands:")
 			fmt.Println("  BEGIN [READONLY] | COMMIT | ABORT")
 			fmt.Println("  CREATE <table> | DROP <table> | RENAME <old> <new> | TRUNCATE <table>")
+			fmt.Println("  CREATE TABLE <table> (<col> int|float|string|bool|bytes|timestamp [REQUIRED] [DEFAULT v], ...)")
+			fmt.Println("  SCHEMA <table>")
 			fmt.Println("  INSERT <table> <key> <value> | UPDATE <table> <key> <value> | DELETE <table> [key]")
+			fmt.Println("  CAS <table> <key> <expected> <new> | SETNX <table> <key> <value>")
+			fmt.Println("  INCR <table> <key> | DECR <table> <key> | INCRBY <table> <key> <n>")
+			fmt.Println("  SETEX <table> <key> <seconds> <value> | EXPIRE <table> <key> <seconds> | TTL <table> <key>")
 			fmt.Println("  GET <table> <key> | EXISTS <table> <key>")
+			fmt.Println("  CREATE INDEX <name> ON <table> (<field>) | DROP INDEX <name>")
+			fmt.Println("  FIND <table> WHERE <field> =|!=|<|<=|>|>= <value>")
 			fmt.Println("  TABLES | SCAN <table> [start] [limit] | PREFIXSCAN <table> <prefix> [limit]")
-			fmt.Println("  COUNT <table> | STATS <table> | DUMP <table> [file] | LOAD <table> <file>")
+			fmt.Println("  SCAN <table> [start] [limit] WHERE <filter> PROJECT <path>, ...")
+			fmt.Println("  AGG <table> [PREFIX <p> | RANGE <a> <b>] COUNT|SUM|MIN|MAX|AVG [path] [GROUPBY PREFIXLEN <n>]")
+			fmt.Println("  COUNT <table> [RANGE <a> <b>] | RANK <table> <key> | NTH <table> <i>")
+			fmt.Println("  STATS <table> | COMPRESS <table> none|fast|best")
+			fmt.Println("  DUMP <table> [file] [FORMAT tsv|csv|jsonl] [HEADER] [KEY <col>]")
+			fmt.Println("  LOAD <table> <file> [FORMAT tsv|csv|jsonl] [HEADER] [KEY <col>] [ON ERROR skip|abort]")
+			fmt.Println("  BACKUP <path>")
 			fmt.Println("  HELP | EXIT | QUIT")
 			continue
 		case "EXIT", "QUIT":
@@ -137,7 +204,14 @@ func main() {
 				implicit = true
 			}
 			table := cmd.Args[0]
-			if out, err := eng.Create(table); err != nil {
+			var out string
+			var err error
+			if len(cmd.Args) == 2 {
+				out, err = eng.CreateWithSchema(table, cmd.Args[1])
+			} else {
+				out, err = eng.Create(table)
+			}
+			if err != nil {
 				fmt.Println("ERR:", err)
 			} else {
 				fmt.Println(out)
@@ -148,7 +222,7 @@ func main() {
 				inTx = false
 				writeTx = false
 			}
-		case "INSERT":
+		case "INSERT", "SETEX":
 			implicit := false
 			if !inTx || !writeTx {
 				curTx = tm.Begin(false)
@@ -156,8 +230,17 @@ func main() {
 				writeTx = true
 				implicit = true
 			}
-			table, key, val := cmd.Args[0], cmd.Args[1], cmd.Args[2]
-			if out, err := eng.Insert(table, key, val); err != nil {
+			var out string
+			if cmd.Name == "SETEX" {
+				secs, _ := strconv.ParseInt(cmd.Args[2], 10, 64)
+				var ttl time.Duration
+				if ttl, err = engine.TTLSeconds(secs); err == nil {
+					out, err = eng.InsertTTL(cmd.Args[0], cmd.Args[1], cmd.Args[3], ttl)
+				}
+			} else {
+				out, err = eng.Insert(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+			}
+			if err != nil {
 				fmt.Println("ERR:", err)
 			} else {
 				fmt.Println(out)
@@ -188,6 +271,107 @@ func main() {
 				inTx = false
 				writeTx = false
 			}
+		case "CAS":
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			if out, err := eng.CompareAndSwap(cmd.Args[0], cmd.Args[1], cmd.Args[2], cmd.Args[3]); err != nil {
+				fmt.Println("ERR:", err)
+			} else {
+				fmt.Println(out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "SETNX":
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			if ok, err := eng.SetNX(cmd.Args[0], cmd.Args[1], cmd.Args[2]); err != nil {
+				fmt.Println("ERR:", err)
+			} else {
+				fmt.Println(ok)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "INCR", "DECR", "INCRBY":
+			delta := int64(1)
+			if cmd.Name == "DECR" {
+				delta = -1
+			} else if cmd.Name == "INCRBY" {
+				delta, _ = strconv.ParseInt(cmd.Args[2], 10, 64)
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			if n, err := eng.IncrBy(cmd.Args[0], cmd.Args[1], delta); err != nil {
+				fmt.Println("ERR:", err)
+			} else {
+				fmt.Println(n)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "EXPIRE":
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			secs, _ := strconv.ParseInt(cmd.Args[2], 10, 64)
+			ttl, err := engine.TTLSeconds(secs)
+			var ok bool
+			if err == nil {
+				ok, err = eng.Expire(cmd.Args[0], cmd.Args[1], ttl)
+			}
+			if err != nil {
+				fmt.Println("ERR:", err)
+			} else {
+				fmt.Println(ok)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "TTL":
+			// seconds left, -1 if the key never expires, -2 if it does not exist
+			d, err := eng.TTL(cmd.Args[0], cmd.Args[1])
+			switch {
+			case errors.Is(err, bptree.ErrKeyNotFound):
+				fmt.Println(-2)
+			case err != nil:
+				fmt.Println("ERR:", err)
+			case d == engine.NoExpiry:
+				fmt.Println(-1)
+			default:
+				fmt.Println(int64(math.Ceil(d.Seconds())))
+			}
 		case "DELETE":
 			if len(cmd.Args) == 1 {
 				// DELETE <table> : drop table shorthand (allow implicit tx)
@@ -251,6 +435,53 @@ func main() {
 				inTx = false
 				writeTx = false
 			}
+		case "CREATEINDEX":
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			if out, err := eng.CreateIndex(cmd.Args[0], cmd.Args[1], cmd.Args[2]); err != nil {
+				fmt.Println("ERR:", err)
+			} else {
+				fmt.Println(out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "DROPINDEX":
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			if out, err := eng.DropIndex(cmd.Args[0]); err != nil {
+				fmt.Println("ERR:", err)
+			} else {
+				fmt.Println(out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "FIND":
+			pairs, err := eng.Find(cmd.Args[0], cmd.Args[1], cmd.Args[2], cmd.Args[3])
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			for _, kv := range pairs {
+				fmt.Printf("%s\t%s\n", kv[0], kv[1])
+			}
 		case "GET":
 			table, key := cmd.Args[0], cmd.Args[1]
 			if v, err := eng.Get(table, key); err != nil {
@@ -292,6 +523,40 @@ func main() {
 			for _, kv := range pairs {
 				fmt.Printf("%s\t%s\n", kv[0], kv[1])
 			}
+		case "SCANQUERY":
+			limit := 0
+			if cmd.Args[2] != "" {
+				if _, err := fmt.Sscanf(cmd.Args[2], "%d", &limit); err != nil {
+					fmt.Println("ERR: bad limit")
+					continue
+				}
+			}
+			sel, err := engine.ParseSelection(cmd.Args[3], cmd.Args[4])
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			pairs, _, err := eng.SelectPage(cmd.Args[0], "", cmd.Args[1], true, limit, sel)
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			for _, kv := range pairs {
+				fmt.Printf("%s\t%s\n", kv[0], kv[1])
+			}
+		case "AGG":
+			res, err := eng.Aggregate(cmd.Args[0], engine.AggQueryFromArgs(cmd.Args[1:]))
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			for _, g := range res {
+				if cmd.Args[6] != "" {
+					fmt.Printf("%s\t%s\n", g.Group, engine.FormatAggValue(g.Value))
+				} else {
+					fmt.Println(engine.FormatAggValue(g.Value))
+				}
+			}
 		case "PREFIXSCAN":
 			if len(cmd.Args) < 2 || len(cmd.Args) > 3 {
 				fmt.Println("ERR: PREFIXSCAN <table> <prefix> [limit]")
@@ -371,6 +636,16 @@ func main() {
 				inTx = false
 				writeTx = false
 			}
+		case "SCHEMA":
+			def, err := eng.Schema(cmd.Args[0])
+			switch {
+			case err != nil:
+				fmt.Println("ERR:", err)
+			case def == "":
+				fmt.Println("(no schema)")
+			default:
+				fmt.Println(def)
+			}
 		case "STATS":
 			if len(cmd.Args) != 1 {
 				fmt.Println("ERR: STATS <table>")
@@ -381,53 +656,104 @@ func main() {
 				fmt.Println("ERR:", err)
 				continue
 			}
-			fmt.Printf("count=%d height=%d min=%s max=%s\n", s.Count, s.Height, s.MinKey, s.MaxKey)
+			fmt.Println(s)
+		case "COMPRESS":
+			if len(cmd.Args) != 2 {
+				fmt.Println("ERR: COMPRESS <table> none|fast|best")
+				continue
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			if out, err := eng.Compress(cmd.Args[0], cmd.Args[1]); err != nil {
+				fmt.Println("ERR:", err)
+			} else {
+				fmt.Println(out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
 		case "COUNT":
-			if len(cmd.Args) != 1 {
-				fmt.Println("ERR: COUNT <table>")
+			if len(cmd.Args) != 1 && len(cmd.Args) != 3 {
+				fmt.Println("ERR: COUNT <table> [RANGE <a> <b>]")
 				continue
 			}
 			tbl := cmd.Args[0]
-			n, err := eng.Count(tbl)
+			var n int
+			var err error
+			if len(cmd.Args) == 3 {
+				n, err = eng.CountRange(tbl, cmd.Args[1], cmd.Args[2])
+			} else {
+				n, err = eng.Count(tbl)
+			}
 			if err != nil {
 				fmt.Println("ERR:", err)
 				continue
 			}
 			fmt.Println(n)
-		case "DUMP":
-			// DUMP <table> [filepath]; prints TSV if no file
-			if len(cmd.Args) != 1 && len(cmd.Args) != 2 {
-				fmt.Println("ERR: DUMP <table> [file]")
+		case "RANK":
+			n, err := eng.Rank(cmd.Args[0], cmd.Args[1])
+			if err != nil {
+				fmt.Println("ERR:", err)
 				continue
 			}
-			tbl := cmd.Args[0]
-			pairs, err := eng.Scan(tbl, "", 0)
+			fmt.Println(n)
+		case "NTH":
+			i, _ := strconv.Atoi(cmd.Args[1])
+			k, v, err := eng.Nth(cmd.Args[0], i)
 			if err != nil {
 				fmt.Println("ERR:", err)
 				continue
 			}
-			if len(cmd.Args) == 1 {
-				for _, kv := range pairs {
-					fmt.Printf("%s\t%s\n", kv[0], kv[1])
+			fmt.Printf("%s\t%s\n", k, v)
+		case "DUMP":
+			// DUMP <table> [file] [FORMAT f] [HEADER] [KEY col]; prints the rows if no file
+			tbl, path := cmd.Args[0], cmd.Args[1]
+			fo, err := format.OptionsFromArgs(cmd.Args[2:])
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			if path == "" {
+				rw, err := format.NewWriter(os.Stdout, fo)
+				if err == nil {
+					_, err = eng.Dump(tbl, rw)
 				}
-			} else {
-				f, err := os.Create(cmd.Args[1])
 				if err != nil {
 					fmt.Println("ERR:", err)
-					continue
-				}
-				w := bufio.NewWriter(f)
-				for _, kv := range pairs {
-					fmt.Fprintf(w, "%s\t%s\n", kv[0], kv[1])
 				}
-				w.Flush()
-				f.Close()
-				fmt.Println("OK")
+				continue
 			}
+			n, err := dumpFile(eng, tbl, path, fo)
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			fmt.Printf("Exported %d rows to %s\n", n, path)
 		case "LOAD":
-			// LOAD <table> <file> (TSV: key\tvalue)
-			if len(cmd.Args) != 2 {
-				fmt.Println("ERR: LOAD <table> <file>")
+			// LOAD <table> <file> [FORMAT f] [HEADER] [KEY col] [ON ERROR skip|abort]
+			tbl, path := cmd.Args[0], cmd.Args[1]
+			fo, err := format.OptionsFromArgs(cmd.Args[2:])
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			rows, err := format.NewReader(f, fo)
+			if err != nil {
+				f.Close()
+				fmt.Println("ERR:", err)
 				continue
 			}
 			implicit := false
@@ -437,8 +763,8 @@ func main() {
 				writeTx = true
 				implicit = true
 			}
-			tbl, path := cmd.Args[0], cmd.Args[1]
-			f, err := os.Open(path)
+			res, err := eng.Load(tbl, rows, fo.SkipErrors)
+			f.Close()
 			if err != nil {
 				fmt.Println("ERR:", err)
 				if implicit {
@@ -449,48 +775,72 @@ func main() {
 				}
 				continue
 			}
-			sc := bufio.NewScanner(f)
-			for sc.Scan() {
-				line := sc.Text()
-				if line == "" {
-					continue
-				}
-				var k, v string
-				parts := strings.SplitN(line, "\t", 2)
-				if len(parts) != 2 {
-					fmt.Println("ERR: bad line", line)
-					if implicit {
-						curTx.Abort()
-						curTx = nil
-						inTx = false
-						writeTx = false
-					}
-					f.Close()
-					continue
-				}
-				k, v = parts[0], parts[1]
-				if _, err := eng.Insert(tbl, k, v); err != nil {
-					fmt.Println("ERR:", err)
-					if implicit {
-						curTx.Abort()
-						curTx = nil
-						inTx = false
-						writeTx = false
-					}
-					f.Close()
-					continue
-				}
-			}
-			f.Close()
 			if implicit {
 				curTx.Commit()
 				curTx = nil
 				inTx = false
 				writeTx = false
 			}
-			fmt.Println("OK")
+			fmt.Println(res)
+			for _, e := range res.Errors {
+				fmt.Println("  skipped", e)
+			}
+		case "BACKUP":
+			// The write lock is held only while the snapshot is taken;
+			// other sessions keep working while it is written out.
+			var lock *txn.Tx
+			if !inTx || !writeTx {
+				lock = tm.Begin(false)
+			}
+			snap, err := eng.Snapshot()
+			if lock != nil {
+				lock.Commit()
+			}
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			n, err := snap.WriteFile(cmd.Args[0])
+			if cerr := snap.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				fmt.Println("ERR:", err)
+				continue
+			}
+			fmt.Printf("Backup written to %s (%d bytes)\n", cmd.Args[0], n)
+		case "DUMPSTREAM", "LOADSTREAM":
+			fmt.Println("ERR: STREAM is for TCP clients; use a file here, or sharkdb import for stdin")
+		case "BACKUPSTREAM":
+			fmt.Println("ERR: STREAM is for TCP clients; use BACKUP <path> here")
 		default:
 			fmt.Println("ERR: unknown command")
 		}
 	}
 }
+
+// dumpFile exports table to path. The rows go to a temporary file in the
+// same directory that replaces path only once complete, so a failed DUMP
+// leaves an existing file as it was.
+func dumpFile(eng *engine.Engine, table, path string, fo format.Options) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	rw, err := format.NewWriter(f, fo)
+	n := 0
+	if err == nil {
+		n, err = eng.Dump(table, rw)
+	}
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/format"
+	"sharkDB/internal/storage"
+)
+
+// A failed DUMP leaves the file it would have replaced untouched, and no
+// temporary files behind.
+func TestDumpFile(t *testing.T) {
+	eng := engine.New(storage.NewMemory())
+	if _, err := eng.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.Insert("t", "k", "line\nbreak"); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.tsv")
+	if err := os.WriteFile(path, []byte("precious\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		table string
+		ok    bool
+		want  string
+	}{
+		{"missing", false, "precious\n"},
+		{"t", true, "k\tline\\nbreak\n"},
+		{"missing", false, "k\tline\\nbreak\n"},
+	} {
+		n, err := dumpFile(eng, tc.table, path, format.Options{Format: format.TSV})
+		if (err == nil) != tc.ok || (tc.ok && n != 1) {
+			t.Fatalf("dumpFile(%s) = %d, %v", tc.table, n, err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != tc.want {
+			t.Fatalf("after dumping %s the file holds %q, %v; want %q", tc.table, got, err, tc.want)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("directory holds %v, %v", entries, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Fatalf("dump mode %v, %v", fi.Mode(), err)
+	}
+}
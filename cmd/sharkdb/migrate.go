@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/compress"
+	"sharkDB/internal/pager"
+	"sharkDB/internal/pager2"
+)
+
+// runMigrate implements `sharkdb migrate <legacy> [out]`. It converts an
+// image written by the legacy single-image pager into the current paged
+// format, keeping table names and ids, indexes, schemas, compression and
+// the marks on tables holding keys with an expiry. Every table and index
+// must decode as a valid tree or nothing is written. Without out the
+// database is converted in place and the original is kept as
+// <legacy>.legacy.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb migrate <legacy> [out]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	src := fs.Arg(0)
+	out, inPlace := fs.Arg(1), fs.NArg() == 1
+	if inPlace {
+		out = src + ".migrate"
+	}
+	if _, err := os.Stat(out); err == nil {
+		fmt.Fprintf(os.Stderr, "migrate: %s already exists\n", out)
+		return 2
+	}
+	// The legacy pager creates missing files, so make sure src exists.
+	if _, err := os.Stat(src); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 2
+	}
+	old, err := pager.Open(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %s is not a legacy database: %v\n", src, err)
+		return 2
+	}
+	tables, keys, err := migrate(old, out)
+	if err != nil {
+		os.Remove(out)
+		os.Remove(out + ".wal")
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 1
+	}
+	if inPlace {
+		if err := os.Rename(src, src+".legacy"); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		if err := os.Rename(out, src); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		if err := os.Rename(out+".wal", src+".wal"); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		fmt.Printf("migrated %d tables (%d keys) in place; the original is kept as %s\n", tables, keys, src+".legacy")
+		return 0
+	}
+	fmt.Printf("migrated %d tables (%d keys) to %s\n", tables, keys, out)
+	return 0
+}
+
+// migrate writes the tables and indexes of the legacy image to a new
+// database at out and returns the number of tables and keys written.
+func migrate(old *pager.Pager, out string) (int, int, error) {
+	m := old.Meta()
+	type tree struct {
+		label string
+		id    uint64
+		table bool
+	}
+	var trees []tree
+	for _, name := range sortedNames(m.Tables) {
+		trees = append(trees, tree{"table " + name, m.Tables[name], true})
+	}
+	for _, name := range sortedNames(m.Indexes) {
+		trees = append(trees, tree{"index " + name, m.Indexes[name].TreeID, false})
+	}
+	blobs := make(map[uint64][]byte)
+	keys := 0
+	for _, tr := range trees {
+		if tr.id > m.NextTableID {
+			return 0, 0, fmt.Errorf("%s has id %d, above the next id %d", tr.label, tr.id, m.NextTableID)
+		}
+		blob, ok := old.LoadTableBlob(tr.id)
+		if !ok || len(blob) == 0 {
+			continue // an empty table
+		}
+		data, err := compress.Decode(blob)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %v", tr.label, err)
+		}
+		var t bptree.BPTree
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&t); err != nil {
+			return 0, 0, fmt.Errorf("%s does not decode: %v", tr.label, err)
+		}
+		t.EnsureCounts()
+		if err := t.Validate(); err != nil {
+			return 0, 0, fmt.Errorf("%s: %v", tr.label, err)
+		}
+		if tr.table {
+			keys += t.Len()
+		}
+		blobs[tr.id] = blob
+	}
+
+	p, err := pager2.Open(out)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = p.UpdateMeta(func(meta *pager2.Meta) {
+		meta.NextTableID = m.NextTableID
+		for name, id := range m.Tables {
+			meta.Tables[name] = id
+		}
+		for name, ix := range m.Indexes {
+			if meta.Indexes == nil {
+				meta.Indexes = make(map[string]pager2.IndexMeta)
+			}
+			meta.Indexes[name] = pager2.IndexMeta{TableID: ix.TableID, Field: ix.Field, TreeID: ix.TreeID}
+		}
+		meta.Schemas, meta.Compression, meta.Expiring = m.Schemas, m.Compression, m.Expiring
+	})
+	if err == nil && len(blobs) > 0 {
+		err = p.StoreTableBlobs(blobs)
+	}
+	if err == nil {
+		// Every write is in the file; leave no WAL behind.
+		err = p.SwitchWAL()
+	}
+	if cerr := p.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("writing %s: %w", out, err)
+	}
+	return len(m.Tables), keys, nil
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/pager"
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/storage"
+)
+
+// legacyDB writes a legacy image holding a typed table users indexed on
+// age, a compressed table logs whose keys expire, and an empty table.
+func legacyDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.gob")
+	l, err := storage.OpenLegacy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := engine.New(l)
+	if _, err := e.CreateWithSchema("users", "name string REQUIRED, age int DEFAULT 0"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"logs", "empty"} {
+		if _, err := e.Create(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.Compress("logs", "best"); err != nil {
+		t.Fatal(err)
+	}
+	b := e.NewBatch()
+	for i := 0; i < 200; i++ {
+		if err := b.Put("users", fmt.Sprintf("u%03d", i), fmt.Sprintf(`{"name":"n%d","age":%d}`, i, i%50)); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutTTL("logs", fmt.Sprintf("l%03d", i), strings.Repeat("log ", 10), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CreateIndex("users_age", "users", "age"); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrate(t *testing.T) {
+	src := legacyDB(t)
+	old, err := pager.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "new.db")
+	tables, keys, err := migrate(old, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 3 || keys != 400 {
+		t.Fatalf("migrated %d tables, %d keys", tables, keys)
+	}
+	if fi, err := os.Stat(out + ".wal"); err == nil && fi.Size() != 0 {
+		t.Fatalf("migrate left a %d byte WAL", fi.Size())
+	}
+
+	p, err := pager2.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	lm, m := old.Meta(), p.Meta()
+	for name, id := range lm.Tables {
+		if m.Tables[name] != id {
+			t.Fatalf("table %s has id %d, was %d", name, m.Tables[name], id)
+		}
+	}
+	if m.NextTableID != lm.NextTableID || m.Indexes["users_age"] != pager2.IndexMeta(lm.Indexes["users_age"]) {
+		t.Fatalf("meta = %+v", m)
+	}
+	if !m.Expiring[m.Tables["logs"]] || m.Expiring[m.Tables["users"]] {
+		t.Fatalf("expiry marks = %v", m.Expiring)
+	}
+
+	e := engine.New(storage.Pager2(p))
+	for _, tc := range []struct {
+		name  string
+		check func() error
+	}{
+		{"schema", func() error {
+			if def, err := e.Schema("users"); err != nil || def != "name string REQUIRED, age int DEFAULT 0" {
+				return fmt.Errorf("schema = %q, %v", def, err)
+			}
+			return nil
+		}},
+		{"index", func() error {
+			got, err := e.Find("users", "age", "=", "7")
+			if err != nil || len(got) != 4 {
+				return fmt.Errorf("find age = 7: %v, %v", got, err)
+			}
+			return nil
+		}},
+		{"compressed", func() error {
+			if v, err := e.Get("logs", "l123"); err != nil || v != strings.Repeat("log ", 10) {
+				return fmt.Errorf("logs/l123 = %q, %v", v, err)
+			}
+			return nil
+		}},
+		{"expiry", func() error {
+			if ttl, err := e.TTL("logs", "l001"); err != nil || ttl <= 0 || ttl > time.Hour {
+				return fmt.Errorf("ttl of logs/l001 = %v, %v", ttl, err)
+			}
+			return nil
+		}},
+		{"empty", func() error {
+			if n, err := e.Count("empty"); err != nil || n != 0 {
+				return fmt.Errorf("count = %d, %v", n, err)
+			}
+			return nil
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.check(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// A legacy image that fails validation is not migrated at all.
+func TestMigrateRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		damage func(p *pager.Pager) error
+		want   string
+	}{
+		{"tree does not decode", func(p *pager.Pager) error {
+			return p.StoreTableBlob(p.Meta().Tables["users"], []byte("not a tree"))
+		}, "table users"},
+		{"index tree does not decode", func(p *pager.Pager) error {
+			return p.StoreTableBlob(p.Meta().Indexes["users_age"].TreeID, []byte("not a tree"))
+		}, "index users_age"},
+		{"id above the next id", func(p *pager.Pager) error {
+			return p.UpdateMeta(func(m *pager.Meta) { m.Tables["stray"] = m.NextTableID + 5 })
+		}, "above the next id"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			old, err := pager.Open(legacyDB(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.damage(old); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(t.TempDir(), "new.db")
+			if _, _, err := migrate(old, out); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want an error mentioning %q", err, tc.want)
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Fatalf("a rejected migration wrote %s: %v", out, err)
+			}
+		})
+	}
+}
+
+func TestRunMigrateInPlace(t *testing.T) {
+	src := legacyDB(t)
+	if code := runMigrate([]string{src}); code != 0 {
+		t.Fatalf("exit status %d", code)
+	}
+	if _, err := pager2.Open(src + ".legacy"); err == nil {
+		t.Fatal("the kept original opens as a current database")
+	}
+	p, err := pager2.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if n, err := engine.New(storage.Pager2(p)).Count("users"); err != nil || n != 200 {
+		t.Fatalf("count = %d, %v", n, err)
+	}
+	if code := runMigrate([]string{src}); code != 2 {
+		t.Fatalf("migrating a current database: exit status %d, want 2", code)
+	}
+}
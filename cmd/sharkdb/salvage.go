@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sharkDB/internal/salvage"
+)
+
+// runSalvage implements `sharkdb salvage <db> <out>`. The damaged database
+// is only read; out must not exist and is encrypted with the same key. The
+// exit status is 1 if any table was lost entirely.
+func runSalvage(args []string) int {
+	fs := flag.NewFlagSet("salvage", flag.ExitOnError)
+	keyFile := keyFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sharkdb salvage [-keyfile f] <db> <out>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	key, err := loadKey(*keyFile, keyEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "salvage:", err)
+		return 2
+	}
+	r, err := salvage.Run(fs.Arg(0), fs.Arg(1), key)
+	if r != nil {
+		r.Print(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "salvage:", err)
+		return 2
+	}
+	if n := r.Lost(); n > 0 {
+		fmt.Printf("%d tables lost; the rest were written to %s\n", n, fs.Arg(1))
+		return 1
+	}
+	fmt.Printf("all %d tables written to %s\n", len(r.Tables), fs.Arg(1))
+	return 0
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/txn"
+)
+
+// runSweeper deletes expired keys every interval. Each batch of at most
+// batch keys runs in its own write transaction so the sweeper never holds
+// the write lock for long; full batches are followed immediately by another.
+func runSweeper(eng *engine.Engine, tm *txn.Manager, interval time.Duration, batch int) {
+	if batch <= 0 {
+		batch = 1000
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		for {
+			tx := tm.Begin(false)
+			n, err := eng.SweepExpired(batch)
+			tx.Commit()
+			if err != nil {
+				log.Printf("sweep expired keys: %v", err)
+				break
+			}
+			if n < batch {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"sharkDB/internal/storage"
+)
+
+// runWALSwitcher closes the WAL segment every interval so the archive never
+// lags the database by more than that, however slowly segments fill.
+func runWALSwitcher(p storage.Storage, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if err := p.SwitchWAL(); err != nil {
+			log.Printf("close WAL segment: %v", err)
+		}
+	}
+}
@@ -18,15 +18,240 @@ type Node struct {
     Keys     []string
     Children []*Node   // for internal nodes: child pointers of length len(Keys)+1
     Values   []string  // for leaf nodes: values aligned with Keys
+    Versions []uint64  // for leaf nodes: write versions aligned with Keys (missing = 0)
+    Expires  []int64   // for leaf nodes: expiry as Unix nanoseconds aligned with Keys (0 = never)
+    Counts   []int     // for internal nodes: number of keys under each child
+    Soonest  []int64   // for internal nodes: earliest expiry under each child (0 = none)
     Next     *Node     // leaf-level linked list (for range scans)
 }
 
+// Entry is a stored value with its metadata.
+type Entry struct {
+    Value     string
+    Version   uint64
+    ExpiresAt int64 // Unix nanoseconds, 0 if the key never expires
+}
+
+// Expired reports whether the entry has expired at now (Unix nanoseconds).
+func (e Entry) Expired(now int64) bool {
+    return e.ExpiresAt != 0 && e.ExpiresAt <= now
+}
+
 type BPTree struct {
-    Root *Node
+    Root     *Node
+    Seq      uint64 // last version assigned by Insert
+    Expiring int    // number of keys that carry an expiry
+    Counted  bool   // Counts and Expiring are maintained; false for older trees
+    Timed    bool   // Soonest is maintained; false for older trees
 }
 
 func New() *BPTree {
-    return &BPTree{Root: &Node{IsLeaf: true}}
+    return &BPTree{Root: &Node{IsLeaf: true}, Counted: true, Timed: true}
+}
+
+// EnsureCounts fills in subtree counts, soonest expiries and the
+// expiring-key count for trees stored before they were maintained. It is a
+// no-op on trees that have them.
+func (t *BPTree) EnsureCounts() {
+    if t.Counted && t.Timed {
+        return
+    }
+    t.Expiring = 0
+    if t.Root != nil {
+        t.recount(t.Root)
+    }
+    t.Counted, t.Timed = true, true
+}
+
+func (t *BPTree) recount(n *Node) int {
+    if n.IsLeaf {
+        for _, e := range n.Expires {
+            if e != 0 {
+                t.Expiring++
+            }
+        }
+        return len(n.Keys)
+    }
+    n.Counts = make([]int, len(n.Children))
+    n.Soonest = make([]int64, len(n.Children))
+    total := 0
+    for i, c := range n.Children {
+        n.Counts[i] = t.recount(c)
+        n.Soonest[i] = nodeSoonest(c)
+        total += n.Counts[i]
+    }
+    return total
+}
+
+// nodeSoonest returns the earliest expiry under n, 0 if no key under it
+// expires.
+func nodeSoonest(n *Node) int64 {
+    var min int64
+    list := n.Soonest
+    if n.IsLeaf {
+        list = n.Expires
+    }
+    for _, e := range list {
+        min = soonest(min, e)
+    }
+    return min
+}
+
+// soonest returns the earlier of two expiries, where 0 means never.
+func soonest(a, b int64) int64 {
+    if a == 0 || (b != 0 && b < a) {
+        return b
+    }
+    return a
+}
+
+// nodeCount returns the number of keys under n.
+func nodeCount(n *Node) int {
+    if n.IsLeaf {
+        return len(n.Keys)
+    }
+    total := 0
+    for _, c := range n.Counts {
+        total += c
+    }
+    return total
+}
+
+// Len returns the number of keys in the tree, expired or not.
+func (t *BPTree) Len() int {
+    if t.Root == nil {
+        return 0
+    }
+    return nodeCount(t.Root)
+}
+
+// Rank returns the number of keys that sort before key.
+func (t *BPTree) Rank(key string) int {
+    if t.Root == nil {
+        return 0
+    }
+    r := 0
+    n := t.Root
+    for !n.IsLeaf {
+        idx := upperBound(n.Keys, key)
+        for _, c := range n.Counts[:idx] {
+            r += c
+        }
+        n = n.Children[idx]
+    }
+    return r + sort.SearchStrings(n.Keys, key)
+}
+
+// Nth returns the key and entry at 0-based position i in key order.
+func (t *BPTree) Nth(i int) (string, Entry, bool) {
+    if t.Root == nil || i < 0 || i >= t.Len() {
+        return "", Entry{}, false
+    }
+    n := t.Root
+    for !n.IsLeaf {
+        j := 0
+        for j < len(n.Counts)-1 && i >= n.Counts[j] {
+            i -= n.Counts[j]
+            j++
+        }
+        n = n.Children[j]
+    }
+    return n.Keys[i], entryAt(n, i), true
+}
+
+// The Live variants of Len, Rank and Nth leave out keys that have expired
+// at now (Unix nanoseconds). A child whose soonest expiry is still ahead is
+// taken from its count; only subtrees holding expired keys are walked, so
+// they stay O(log n) as long as few expired keys await removal.
+
+// LiveLen returns the number of keys that have not expired at now.
+func (t *BPTree) LiveLen(now int64) int {
+    if t.Root == nil {
+        return 0
+    }
+    return liveCount(t.Root, now)
+}
+
+// LiveRank returns the number of unexpired keys that sort before key.
+func (t *BPTree) LiveRank(key string, now int64) int {
+    if t.Root == nil {
+        return 0
+    }
+    r := 0
+    n := t.Root
+    for !n.IsLeaf {
+        idx := upperBound(n.Keys, key)
+        for j := 0; j < idx; j++ {
+            r += childLive(n, j, now)
+        }
+        n = n.Children[idx]
+    }
+    for i := 0; i < len(n.Keys) && n.Keys[i] < key; i++ {
+        if !entryAt(n, i).Expired(now) {
+            r++
+        }
+    }
+    return r
+}
+
+// LiveNth returns the key and entry at 0-based position i among the keys
+// that have not expired at now.
+func (t *BPTree) LiveNth(i int, now int64) (string, Entry, bool) {
+    if t.Root == nil || i < 0 {
+        return "", Entry{}, false
+    }
+    n := t.Root
+    for !n.IsLeaf {
+        j := 0
+        for ; j < len(n.Children); j++ {
+            c := childLive(n, j, now)
+            if i < c {
+                break
+            }
+            i -= c
+        }
+        if j == len(n.Children) {
+            return "", Entry{}, false
+        }
+        n = n.Children[j]
+    }
+    for k := range n.Keys {
+        e := entryAt(n, k)
+        if e.Expired(now) {
+            continue
+        }
+        if i == 0 {
+            return n.Keys[k], e, true
+        }
+        i--
+    }
+    return "", Entry{}, false
+}
+
+// liveCount returns the number of keys under n that have not expired at now.
+func liveCount(n *Node, now int64) int {
+    if n.IsLeaf {
+        c := 0
+        for i := range n.Keys {
+            if !entryAt(n, i).Expired(now) {
+                c++
+            }
+        }
+        return c
+    }
+    total := 0
+    for j := range n.Children {
+        total += childLive(n, j, now)
+    }
+    return total
+}
+
+// childLive is liveCount of child j of the internal node n.
+func childLive(n *Node, j int, now int64) int {
+    if s := n.Soonest[j]; s == 0 || s > now {
+        return n.Counts[j]
+    }
+    return liveCount(n.Children[j], now)
 }
 
 // Get returns the value for key, or empty string and false if not found.
@@ -46,21 +271,126 @@ func (t *BPTree) Get(key string) (string, bool) {
     return "", false
 }
 
-// Insert sets key to value (upsert semantics).
+// GetVersion returns the value and write version for key. Keys written before
+// versions were tracked report version 0.
+func (t *BPTree) GetVersion(key string) (string, uint64, bool) {
+    e, ok := t.Lookup(key)
+    return e.Value, e.Version, ok
+}
+
+// Lookup returns the entry for key, including its version and expiry.
+// Expiry is not checked here; callers decide what counts as expired.
+func (t *BPTree) Lookup(key string) (Entry, bool) {
+    if t.Root == nil {
+        return Entry{}, false
+    }
+    n := t.Root
+    for !n.IsLeaf {
+        idx := upperBound(n.Keys, key)
+        n = n.Children[idx]
+    }
+    i := sort.SearchStrings(n.Keys, key)
+    if i < len(n.Keys) && n.Keys[i] == key {
+        return entryAt(n, i), true
+    }
+    return Entry{}, false
+}
+
+// SetExpiry changes the expiry of an existing key without touching its value
+// or version. Returns false if the key is absent.
+func (t *BPTree) SetExpiry(key string, expiresAt int64) bool {
+    if t.Root == nil {
+        return false
+    }
+    var path []step
+    n := t.Root
+    for !n.IsLeaf {
+        idx := upperBound(n.Keys, key)
+        path = append(path, step{n, idx})
+        n = n.Children[idx]
+    }
+    i := sort.SearchStrings(n.Keys, key)
+    if i < len(n.Keys) && n.Keys[i] == key {
+        padLeaf(n)
+        t.Expiring += expiryDelta(n.Expires[i], expiresAt)
+        n.Expires[i] = expiresAt
+        resoon(path)
+        return true
+    }
+    return false
+}
+
+// step is one internal node on the way down to a key and the child taken.
+type step struct {
+    n   *Node
+    idx int
+}
+
+// resoon recomputes the soonest expiries along path, bottom up, after a
+// leaf at its end changed.
+func resoon(path []step) {
+    for k := len(path) - 1; k >= 0; k-- {
+        s := path[k]
+        s.n.Soonest[s.idx] = nodeSoonest(s.n.Children[s.idx])
+    }
+}
+
+// expiryDelta is the change in expiring keys when an expiry goes from old to new.
+func expiryDelta(old, new int64) int {
+    switch {
+    case old == 0 && new != 0:
+        return 1
+    case old != 0 && new == 0:
+        return -1
+    }
+    return 0
+}
+
+func entryAt(n *Node, i int) Entry {
+    e := Entry{Value: n.Values[i]}
+    if i < len(n.Versions) {
+        e.Version = n.Versions[i]
+    }
+    if i < len(n.Expires) {
+        e.ExpiresAt = n.Expires[i]
+    }
+    return e
+}
+
+// Insert sets key to value (upsert semantics) and assigns it the next version.
+// Any expiry previously set on the key is cleared.
 func (t *BPTree) Insert(key, value string) {
+    t.Put(key, value, 0)
+}
+
+// Put is Insert with an expiry in Unix nanoseconds (0 = never).
+func (t *BPTree) Put(key, value string, expiresAt int64) {
     if t.Root == nil {
         t.Root = &Node{IsLeaf: true}
     }
+    t.Seq++
+    old, _ := t.Lookup(key)
+    t.Expiring += expiryDelta(old.ExpiresAt, expiresAt)
     root := t.Root
     if len(root.Keys) >= Order-1 && root.IsLeaf {
         // Preemptive split of a full leaf root for simpler logic
         left, sep, right := splitLeaf(root)
-        t.Root = &Node{IsLeaf: false, Keys: []string{sep}, Children: []*Node{left, right}}
+        t.Root = newRoot(left, sep, right)
     }
-    newChild, sep, grew := insertRecursive(t.Root, key, value)
+    newChild, sep, grew := insertRecursive(t.Root, key, value, t.Seq, expiresAt)
     if grew {
         // Root split
-        t.Root = &Node{IsLeaf: false, Keys: []string{sep}, Children: []*Node{t.Root, newChild}}
+        t.Root = newRoot(t.Root, sep, newChild)
+    }
+}
+
+func newRoot(left *Node, sep string, right *Node) *Node {
+    return &Node{
+        IsLeaf:   false,
+        Keys:     []string{sep},
+        Children: []*Node{left, right},
+        Counts:   []int{nodeCount(left), nodeCount(right)},
+        Soonest:  []int64{nodeSoonest(left), nodeSoonest(right)},
     }
 }
 
@@ -71,15 +401,25 @@ func (t *BPTree) Delete(key string) bool {
     if t.Root == nil {
         return false
     }
+    var path []step
     n := t.Root
     for !n.IsLeaf {
         idx := upperBound(n.Keys, key)
+        path = append(path, step{n, idx})
         n = n.Children[idx]
     }
     i := sort.SearchStrings(n.Keys, key)
     if i < len(n.Keys) && n.Keys[i] == key {
+        padLeaf(n)
+        for _, s := range path {
+            s.n.Counts[s.idx]--
+        }
+        t.Expiring += expiryDelta(n.Expires[i], 0)
         n.Keys = append(n.Keys[:i], n.Keys[i+1:]...)
         n.Values = append(n.Values[:i], n.Values[i+1:]...)
+        n.Versions = append(n.Versions[:i], n.Versions[i+1:]...)
+        n.Expires = append(n.Expires[:i], n.Expires[i+1:]...)
+        resoon(path)
         return true
     }
     return false
@@ -89,47 +429,64 @@ func (t *BPTree) Delete(key string) bool {
 // If start is empty, iteration begins at the leftmost leaf. If limit <= 0, returns all.
 func (t *BPTree) RangeFrom(start string, limit int) [][2]string {
     var results [][2]string
-    if t.Root == nil { return results }
-    // Find starting leaf
-    n := t.Root
-    if start == "" {
-        for !n.IsLeaf { n = n.Children[0] }
-    } else {
-        for !n.IsLeaf {
-            idx := upperBound(n.Keys, start)
-            n = n.Children[idx]
-        }
-    }
-    // Position within the leaf
-    i := 0
-    if start != "" {
-        i = sort.SearchStrings(n.Keys, start)
-    }
-    for n != nil {
-        for i < len(n.Keys) {
-            kv := [2]string{n.Keys[i], n.Values[i]}
-            results = append(results, kv)
-            i++
-            if limit > 0 && len(results) >= limit { return results }
-        }
-        n = n.Next
-        i = 0
-    }
+    t.Ascend(start, func(key, value string) bool {
+        results = append(results, [2]string{key, value})
+        return limit <= 0 || len(results) < limit
+    })
+    return results
+}
+
+// RangeAfter returns up to limit key/value pairs whose key sorts strictly
+// after the given key. If limit <= 0, returns all.
+func (t *BPTree) RangeAfter(after string, limit int) [][2]string {
+    var results [][2]string
+    t.Ascend(after, func(key, value string) bool {
+        if key == after { return true }
+        results = append(results, [2]string{key, value})
+        return limit <= 0 || len(results) < limit
+    })
     return results
 }
 
 // RangePrefix returns up to limit key/value pairs whose key has the given prefix.
 func (t *BPTree) RangePrefix(prefix string, limit int) [][2]string {
-    results := t.RangeFrom(prefix, 0)
-    out := make([][2]string, 0, len(results))
-    for _, kv := range results {
-        if !hasPrefix(kv[0], prefix) { break }
-        out = append(out, kv)
-        if limit > 0 && len(out) >= limit { break }
-    }
+    var out [][2]string
+    t.Ascend(prefix, func(key, value string) bool {
+        if !hasPrefix(key, prefix) { return false }
+        out = append(out, [2]string{key, value})
+        return limit <= 0 || len(out) < limit
+    })
     return out
 }
 
+// Ascend calls fn for each key/value pair with key >= start in key order
+// until fn returns false. It descends from the root instead of following
+// leaf Next links, which are not kept across splits of the root or gob
+// round-trips.
+func (t *BPTree) Ascend(start string, fn func(key, value string) bool) {
+    t.AscendEntries(start, func(key string, e Entry) bool { return fn(key, e.Value) })
+}
+
+// AscendEntries is Ascend with each key's full Entry.
+func (t *BPTree) AscendEntries(start string, fn func(key string, e Entry) bool) {
+    if t.Root == nil { return }
+    ascendNode(t.Root, start, fn)
+}
+
+func ascendNode(n *Node, start string, fn func(key string, e Entry) bool) bool {
+    if n.IsLeaf {
+        for i := sort.SearchStrings(n.Keys, start); i < len(n.Keys); i++ {
+            if !fn(n.Keys[i], entryAt(n, i)) { return false }
+        }
+        return true
+    }
+    // Children left of upperBound only hold keys < start.
+    for i := upperBound(n.Keys, start); i < len(n.Children); i++ {
+        if !ascendNode(n.Children[i], start, fn) { return false }
+    }
+    return true
+}
+
 func hasPrefix(s, prefix string) bool {
     if len(prefix) == 0 { return true }
     if len(s) < len(prefix) { return false }
@@ -169,15 +526,20 @@ func (t *BPTree) Height() int {
 
 // insertRecursive inserts into subtree rooted at n. If the child grew and split,
 // returns (newRightChild, separatorKey, grew=true). For leaves, grew indicates a split occurred.
-func insertRecursive(n *Node, key, value string) (*Node, string, bool) {
+func insertRecursive(n *Node, key, value string, version uint64, expiresAt int64) (*Node, string, bool) {
     if n.IsLeaf {
+        padLeaf(n)
         i := sort.SearchStrings(n.Keys, key)
         if i < len(n.Keys) && n.Keys[i] == key {
             n.Values[i] = value
+            n.Versions[i] = version
+            n.Expires[i] = expiresAt
             return nil, "", false
         }
         n.Keys = insertString(n.Keys, i, key)
         n.Values = insertString(n.Values, i, value)
+        n.Versions = insertUint64(n.Versions, i, version)
+        n.Expires = insertInt64(n.Expires, i, expiresAt)
         if len(n.Keys) <= Order-1 {
             return nil, "", false
         }
@@ -188,13 +550,17 @@ func insertRecursive(n *Node, key, value string) (*Node, string, bool) {
     // Internal node: descend
     idx := upperBound(n.Keys, key)
     child := n.Children[idx]
-    newChild, sep, grew := insertRecursive(child, key, value)
+    newChild, sep, grew := insertRecursive(child, key, value, version, expiresAt)
+    n.Counts[idx] = nodeCount(child)
+    n.Soonest[idx] = nodeSoonest(child)
     if !grew {
         return nil, "", false
     }
     // Insert separator and newChild after idx
     n.Keys = insertString(n.Keys, idx, sep)
     n.Children = insertNode(n.Children, idx+1, newChild)
+    n.Counts = insertInt(n.Counts, idx+1, nodeCount(newChild))
+    n.Soonest = insertInt64(n.Soonest, idx+1, nodeSoonest(newChild))
     if len(n.Keys) <= Order-1 {
         return nil, "", false
     }
@@ -211,13 +577,18 @@ func splitLeafReturnRight(n *Node) (*Node, string) {
 }
 
 func splitLeaf(n *Node) (*Node, string, *Node) {
+    padLeaf(n)
     mid := len(n.Keys) / 2
     right := &Node{IsLeaf: true}
     right.Keys = append(right.Keys, n.Keys[mid:]...)
     right.Values = append(right.Values, n.Values[mid:]...)
+    right.Versions = append(right.Versions, n.Versions[mid:]...)
+    right.Expires = append(right.Expires, n.Expires[mid:]...)
     sep := right.Keys[0]
     n.Keys = n.Keys[:mid]
     n.Values = n.Values[:mid]
+    n.Versions = n.Versions[:mid]
+    n.Expires = n.Expires[:mid]
     return n, sep, right
 }
 
@@ -228,9 +599,13 @@ func splitInternalReturnRight(n *Node) (*Node, string) {
     right := &Node{IsLeaf: false}
     right.Keys = append(right.Keys, n.Keys[mid+1:]...)
     right.Children = append(right.Children, n.Children[mid+1:]...)
+    right.Counts = append(right.Counts, n.Counts[mid+1:]...)
+    right.Soonest = append(right.Soonest, n.Soonest[mid+1:]...)
 
     n.Keys = n.Keys[:mid]
     n.Children = n.Children[:mid+1]
+    n.Counts = n.Counts[:mid+1]
+    n.Soonest = n.Soonest[:mid+1]
     return right, sep
 }
 
@@ -241,6 +616,38 @@ func insertString(slice []string, idx int, val string) []string {
     return slice
 }
 
+func insertInt(slice []int, idx int, val int) []int {
+    slice = append(slice, 0)
+    copy(slice[idx+1:], slice[idx:])
+    slice[idx] = val
+    return slice
+}
+
+func insertUint64(slice []uint64, idx int, val uint64) []uint64 {
+    slice = append(slice, 0)
+    copy(slice[idx+1:], slice[idx:])
+    slice[idx] = val
+    return slice
+}
+
+func insertInt64(slice []int64, idx int, val int64) []int64 {
+    slice = append(slice, 0)
+    copy(slice[idx+1:], slice[idx:])
+    slice[idx] = val
+    return slice
+}
+
+// padLeaf extends a leaf's Versions and Expires to match its Keys. Trees
+// stored before this metadata was tracked decode without it.
+func padLeaf(n *Node) {
+    for len(n.Versions) < len(n.Keys) {
+        n.Versions = append(n.Versions, 0)
+    }
+    for len(n.Expires) < len(n.Keys) {
+        n.Expires = append(n.Expires, 0)
+    }
+}
+
 func insertNode(slice []*Node, idx int, val *Node) []*Node {
     slice = append(slice, nil)
     copy(slice[idx+1:], slice[idx:])
@@ -259,7 +666,7 @@ func (t *BPTree) Clone() *BPTree {
         return New()
     }
     visited := make(map[*Node]*Node)
-    return &BPTree{Root: cloneNode(t.Root, visited)}
+    return &BPTree{Root: cloneNode(t.Root, visited), Seq: t.Seq, Expiring: t.Expiring, Counted: t.Counted, Timed: t.Timed}
 }
 
 func cloneNode(n *Node, visited map[*Node]*Node) *Node {
@@ -274,8 +681,12 @@ func cloneNode(n *Node, visited map[*Node]*Node) *Node {
     c.Keys = append(c.Keys, n.Keys...)
     if n.IsLeaf {
         c.Values = append(c.Values, n.Values...)
+        c.Versions = append(c.Versions, n.Versions...)
+        c.Expires = append(c.Expires, n.Expires...)
         // Do not clone Next chain to avoid cycles; it will be rebuilt on splits
     } else {
+        c.Counts = append(c.Counts, n.Counts...)
+        c.Soonest = append(c.Soonest, n.Soonest...)
         for _, ch := range n.Children {
             c.Children = append(c.Children, cloneNode(ch, visited))
         }
@@ -0,0 +1,300 @@
+package bptree
+
+import (
+    "bytes"
+    "encoding/gob"
+    "fmt"
+    "math/rand"
+    "sort"
+    "testing"
+)
+
+// checkTree compares t against the model: length, key order through Nth,
+// Rank of present and absent keys, the expiring count and the invariants
+// Validate checks.
+func checkTree(t *testing.T, tree *BPTree, model map[string]int64) {
+    t.Helper()
+    if err := tree.Validate(); err != nil {
+        t.Fatal(err)
+    }
+    keys := make([]string, 0, len(model))
+    expiring := 0
+    for k, exp := range model {
+        keys = append(keys, k)
+        if exp != 0 {
+            expiring++
+        }
+    }
+    sort.Strings(keys)
+    if tree.Len() != len(keys) {
+        t.Fatalf("Len = %d, want %d", tree.Len(), len(keys))
+    }
+    if tree.Expiring != expiring {
+        t.Fatalf("Expiring = %d, want %d", tree.Expiring, expiring)
+    }
+    for i, k := range keys {
+        got, e, ok := tree.Nth(i)
+        if !ok || got != k {
+            t.Fatalf("Nth(%d) = %q, %v, want %q", i, got, ok, k)
+        }
+        if e.ExpiresAt != model[k] {
+            t.Fatalf("Nth(%d) expires at %d, want %d", i, e.ExpiresAt, model[k])
+        }
+        if r := tree.Rank(k); r != i {
+            t.Fatalf("Rank(%q) = %d, want %d", k, r, i)
+        }
+        // A key just after k sorts after i+1 keys whether or not it exists.
+        if r := tree.Rank(k + "\x00"); r != i+1 {
+            t.Fatalf("Rank(%q) = %d, want %d", k+"\x00", r, i+1)
+        }
+    }
+    if _, _, ok := tree.Nth(len(keys)); ok {
+        t.Fatalf("Nth(%d) found a key past the end", len(keys))
+    }
+    if _, _, ok := tree.Nth(-1); ok {
+        t.Fatal("Nth(-1) found a key")
+    }
+}
+
+func TestCountsAfterPutAndDelete(t *testing.T) {
+    rnd := rand.New(rand.NewSource(1))
+    tree := New()
+    model := make(map[string]int64)
+    for i := 0; i < 2000; i++ {
+        k := fmt.Sprintf("k%04d", rnd.Intn(500))
+        switch rnd.Intn(4) {
+        case 0:
+            tree.Delete(k)
+            delete(model, k)
+        case 1:
+            exp := int64(1 + rnd.Intn(1000))
+            tree.Put(k, "v", exp)
+            model[k] = exp
+        case 2:
+            if tree.SetExpiry(k, 0) {
+                model[k] = 0
+            }
+        default:
+            tree.Insert(k, "v")
+            model[k] = 0
+        }
+        if i%100 == 0 {
+            checkTree(t, tree, model)
+        }
+    }
+    checkTree(t, tree, model)
+    if tree.Height() < 3 {
+        t.Fatalf("height %d: the test should split internal nodes", tree.Height())
+    }
+}
+
+// Delete does not merge underflowing nodes; counts must stay right while
+// leaves empty out and the tree shrinks to nothing.
+func TestCountsDeleteToEmpty(t *testing.T) {
+    tree := New()
+    model := make(map[string]int64)
+    for i := 0; i < 300; i++ {
+        k := fmt.Sprintf("k%04d", i)
+        tree.Put(k, "v", int64(i%3))
+        model[k] = int64(i % 3)
+    }
+    checkTree(t, tree, model)
+    for i := 0; i < 300; i += 2 {
+        k := fmt.Sprintf("k%04d", i)
+        if !tree.Delete(k) {
+            t.Fatalf("Delete(%q) found nothing", k)
+        }
+        delete(model, k)
+    }
+    checkTree(t, tree, model)
+    for i := 1; i < 300; i += 2 {
+        tree.Delete(fmt.Sprintf("k%04d", i))
+    }
+    checkTree(t, tree, map[string]int64{})
+    if tree.Delete("k0001") {
+        t.Fatal("Delete of a removed key reported success")
+    }
+    // The emptied tree still takes inserts.
+    tree.Insert("again", "v")
+    checkTree(t, tree, map[string]int64{"again": 0})
+}
+
+func TestBuilderCounts(t *testing.T) {
+    b := NewBuilder()
+    model := make(map[string]int64)
+    for i := 0; i < 1000; i++ {
+        k := fmt.Sprintf("k%04d", i)
+        exp := int64(0)
+        if i%7 == 0 {
+            exp = int64(i + 1)
+        }
+        if err := b.Add(k, Entry{Value: "v", ExpiresAt: exp}); err != nil {
+            t.Fatal(err)
+        }
+        model[k] = exp
+    }
+    if err := b.Add("k0000", Entry{}); err == nil {
+        t.Fatal("Builder accepted a key out of order")
+    }
+    tree := b.Finish()
+    checkTree(t, tree, model)
+
+    // A built tree keeps its counts through later writes.
+    tree.Insert("k0500x", "v")
+    model["k0500x"] = 0
+    tree.Delete("k0007")
+    delete(model, "k0007")
+    checkTree(t, tree, model)
+}
+
+// Trees stored before counts were kept decode without them; EnsureCounts
+// must rebuild them.
+func TestEnsureCounts(t *testing.T) {
+    tree := New()
+    model := make(map[string]int64)
+    for i := 0; i < 200; i++ {
+        k := fmt.Sprintf("k%04d", i)
+        tree.Put(k, "v", int64(i%2))
+        model[k] = int64(i % 2)
+    }
+    var buf bytes.Buffer
+    if err := gob.NewEncoder(&buf).Encode(tree); err != nil {
+        t.Fatal(err)
+    }
+    var old BPTree
+    if err := gob.NewDecoder(&buf).Decode(&old); err != nil {
+        t.Fatal(err)
+    }
+    old.Counted, old.Timed, old.Expiring = false, false, 0
+    stripCounts(old.Root)
+    old.EnsureCounts()
+    checkTree(t, &old, model)
+
+    // Trees stored with counts but before soonest expiries get those alone.
+    tree.Timed = false
+    stripSoonest(tree.Root)
+    tree.EnsureCounts()
+    checkTree(t, tree, model)
+}
+
+func stripCounts(n *Node) {
+    n.Counts = nil
+    stripSoonest(n)
+    for _, c := range n.Children {
+        stripCounts(c)
+    }
+}
+
+func stripSoonest(n *Node) {
+    n.Soonest = nil
+    for _, c := range n.Children {
+        stripSoonest(c)
+    }
+}
+
+// checkLive compares the Live methods against the model at now.
+func checkLive(t *testing.T, tree *BPTree, model map[string]int64, now int64) {
+    t.Helper()
+    var live []string
+    for k, exp := range model {
+        if exp == 0 || exp > now {
+            live = append(live, k)
+        }
+    }
+    sort.Strings(live)
+    if n := tree.LiveLen(now); n != len(live) {
+        t.Fatalf("LiveLen(%d) = %d, want %d", now, n, len(live))
+    }
+    for i, k := range live {
+        if got, _, ok := tree.LiveNth(i, now); !ok || got != k {
+            t.Fatalf("LiveNth(%d, %d) = %q, %v, want %q", i, now, got, ok, k)
+        }
+        if r := tree.LiveRank(k, now); r != i {
+            t.Fatalf("LiveRank(%q, %d) = %d, want %d", k, now, r, i)
+        }
+        if r := tree.LiveRank(k+"\x00", now); r != i+1 {
+            t.Fatalf("LiveRank(%q, %d) = %d, want %d", k+"\x00", now, r, i+1)
+        }
+    }
+    if _, _, ok := tree.LiveNth(len(live), now); ok {
+        t.Fatalf("LiveNth(%d, %d) found a key past the end", len(live), now)
+    }
+}
+
+func TestLiveCounts(t *testing.T) {
+    rnd := rand.New(rand.NewSource(2))
+    tree := New()
+    model := make(map[string]int64)
+    for i := 0; i < 3000; i++ {
+        k := fmt.Sprintf("k%04d", rnd.Intn(600))
+        switch rnd.Intn(5) {
+        case 0:
+            tree.Delete(k)
+            delete(model, k)
+        case 1, 2:
+            exp := int64(1 + rnd.Intn(100))
+            tree.Put(k, "v", exp)
+            model[k] = exp
+        case 3:
+            exp := int64(rnd.Intn(100))
+            if tree.SetExpiry(k, exp) {
+                model[k] = exp
+            }
+        default:
+            tree.Insert(k, "v")
+            model[k] = 0
+        }
+        if i%250 == 0 {
+            checkTree(t, tree, model)
+            for _, now := range []int64{0, 1, 50, 100} {
+                checkLive(t, tree, model, now)
+            }
+        }
+    }
+    checkTree(t, tree, model)
+    for _, now := range []int64{0, 25, 99, 100} {
+        checkLive(t, tree, model, now)
+    }
+    // Built trees carry soonest expiries too.
+    b := NewBuilder()
+    for i := 0; i < 400; i++ {
+        k := fmt.Sprintf("k%04d", i)
+        if err := b.Add(k, Entry{Value: "v", ExpiresAt: model[k]}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    built := b.Finish()
+    want := make(map[string]int64)
+    for i := 0; i < 400; i++ {
+        k := fmt.Sprintf("k%04d", i)
+        want[k] = model[k]
+    }
+    checkTree(t, built, want)
+    checkLive(t, built, want, 50)
+}
+
+func TestBuilderKeep(t *testing.T) {
+    b := NewBuilder()
+    b.SetSeq(10)
+    if err := b.Keep("a", Entry{Value: "old"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := b.Add("b", Entry{Value: "new"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := b.Keep("c", Entry{Value: "later", Version: 20}); err != nil {
+        t.Fatal(err)
+    }
+    if err := b.Keep("c", Entry{}); err == nil {
+        t.Fatal("Keep accepted a key out of order")
+    }
+    tree := b.Finish()
+    for key, want := range map[string]uint64{"a": 0, "b": 11, "c": 20} {
+        if _, ver, _ := tree.GetVersion(key); ver != want {
+            t.Errorf("%s: version %d, want %d", key, ver, want)
+        }
+    }
+    if tree.Seq != 20 {
+        t.Errorf("Seq = %d, want 20", tree.Seq)
+    }
+}
@@ -0,0 +1,110 @@
+package bptree
+
+import "fmt"
+
+// Builder constructs a tree bottom-up from keys supplied in ascending order.
+// Leaves are packed full and each internal level is built once, so loading
+// n keys costs O(n) instead of n separate inserts. Leaves are not linked
+// through Next: scans descend from the root, and gob would serialize the
+// whole chain again under every leaf.
+type Builder struct {
+    leaves   []*Node
+    cur      *Node
+    last     string
+    n        int
+    seq      uint64
+    expiring int
+}
+
+func NewBuilder() *Builder {
+    return &Builder{}
+}
+
+// Add appends a key. Keys must be strictly ascending. A version of 0 takes
+// the next one from the builder's sequence, as Insert would.
+func (b *Builder) Add(key string, e Entry) error {
+    if e.Version == 0 {
+        e.Version = b.seq + 1
+    }
+    return b.Keep(key, e)
+}
+
+// Keep appends a key like Add but stores e as it is, so an existing row
+// carried into the new tree keeps its version even if that is 0.
+func (b *Builder) Keep(key string, e Entry) error {
+    if b.n > 0 && key <= b.last {
+        return fmt.Errorf("bptree: builder keys out of order: %q after %q", key, b.last)
+    }
+    if e.Version > b.seq {
+        b.seq = e.Version
+    }
+    if e.ExpiresAt != 0 {
+        b.expiring++
+    }
+    if b.cur == nil || len(b.cur.Keys) >= Order-1 {
+        b.cur = &Node{IsLeaf: true}
+        b.leaves = append(b.leaves, b.cur)
+    }
+    b.cur.Keys = append(b.cur.Keys, key)
+    b.cur.Values = append(b.cur.Values, e.Value)
+    b.cur.Versions = append(b.cur.Versions, e.Version)
+    b.cur.Expires = append(b.cur.Expires, e.ExpiresAt)
+    b.last = key
+    b.n++
+    return nil
+}
+
+// SetSeq raises the version sequence so that versions assigned by Add, and
+// by inserts into the finished tree, continue after seq.
+func (b *Builder) SetSeq(seq uint64) {
+    if seq > b.seq {
+        b.seq = seq
+    }
+}
+
+// Len returns the number of keys added.
+func (b *Builder) Len() int {
+    return b.n
+}
+
+// Finish returns the built tree. The builder must not be used afterwards.
+func (b *Builder) Finish() *BPTree {
+    t := &BPTree{Seq: b.seq, Expiring: b.expiring, Counted: true, Timed: true}
+    if len(b.leaves) == 0 {
+        t.Root = &Node{IsLeaf: true}
+        return t
+    }
+    level := b.leaves
+    firsts := make([]string, len(level))
+    for i, n := range level {
+        firsts[i] = n.Keys[0]
+    }
+    for len(level) > 1 {
+        var up []*Node
+        var upFirsts []string
+        for i := 0; i < len(level); {
+            size := Order
+            // Never leave a single child for the last node of a level.
+            if rest := len(level) - i; rest <= Order {
+                size = rest
+            } else if rest == Order+1 {
+                size = Order - 1
+            }
+            n := &Node{}
+            for j := i; j < i+size; j++ {
+                if j > i {
+                    n.Keys = append(n.Keys, firsts[j])
+                }
+                n.Children = append(n.Children, level[j])
+                n.Counts = append(n.Counts, nodeCount(level[j]))
+                n.Soonest = append(n.Soonest, nodeSoonest(level[j]))
+            }
+            up = append(up, n)
+            upFirsts = append(upFirsts, firsts[i])
+            i += size
+        }
+        level, firsts = up, upFirsts
+    }
+    t.Root = level[0]
+    return t
+}
@@ -0,0 +1,115 @@
+package bptree
+
+import "fmt"
+
+// Validate checks the structural invariants of the tree and returns the
+// first violation found: keys strictly ascending in every node, every key
+// within the bounds set by the separators above it, internal nodes with one
+// more child than keys, leaf metadata no longer than the keys, all leaves at
+// the same depth and no node reachable twice. It also checks that Seq is at
+// least every version and, for counted trees, the subtree counts, the
+// soonest expiries and the expiring-key count.
+func (t *BPTree) Validate() error {
+    if t.Root == nil {
+        return nil
+    }
+    v := validator{t: t, seen: make(map[*Node]bool), leafDepth: -1}
+    if _, _, err := v.node(t.Root, 0, "", "", false, false); err != nil {
+        return err
+    }
+    if t.Counted && v.expiring != t.Expiring {
+        return fmt.Errorf("bptree: %d keys expire but the tree counts %d", v.expiring, t.Expiring)
+    }
+    if v.maxVersion > t.Seq {
+        return fmt.Errorf("bptree: version %d is above the sequence %d", v.maxVersion, t.Seq)
+    }
+    return nil
+}
+
+type validator struct {
+    t          *BPTree
+    seen       map[*Node]bool
+    leafDepth  int
+    expiring   int
+    maxVersion uint64
+}
+
+// node checks the subtree at n, whose keys must lie in [lo, hi), and returns
+// its key count and soonest expiry.
+func (v *validator) node(n *Node, depth int, lo, hi string, hasLo, hasHi bool) (int, int64, error) {
+    if n == nil {
+        return 0, 0, fmt.Errorf("bptree: nil node at depth %d", depth)
+    }
+    if v.seen[n] {
+        return 0, 0, fmt.Errorf("bptree: node at depth %d is reachable twice", depth)
+    }
+    v.seen[n] = true
+    for i, k := range n.Keys {
+        if i > 0 && k <= n.Keys[i-1] {
+            return 0, 0, fmt.Errorf("bptree: keys out of order at depth %d: %q after %q", depth, k, n.Keys[i-1])
+        }
+        if hasLo && k < lo {
+            return 0, 0, fmt.Errorf("bptree: key %q at depth %d sorts before its separator %q", k, depth, lo)
+        }
+        if hasHi && k >= hi {
+            return 0, 0, fmt.Errorf("bptree: key %q at depth %d does not sort before its separator %q", k, depth, hi)
+        }
+    }
+    if n.IsLeaf {
+        if v.leafDepth < 0 {
+            v.leafDepth = depth
+        } else if depth != v.leafDepth {
+            return 0, 0, fmt.Errorf("bptree: leaf at depth %d, others at depth %d", depth, v.leafDepth)
+        }
+        if len(n.Values) != len(n.Keys) {
+            return 0, 0, fmt.Errorf("bptree: leaf has %d keys and %d values", len(n.Keys), len(n.Values))
+        }
+        if len(n.Versions) > len(n.Keys) || len(n.Expires) > len(n.Keys) {
+            return 0, 0, fmt.Errorf("bptree: leaf has %d keys but %d versions and %d expiries", len(n.Keys), len(n.Versions), len(n.Expires))
+        }
+        for _, ver := range n.Versions {
+            if ver > v.maxVersion {
+                v.maxVersion = ver
+            }
+        }
+        for _, e := range n.Expires {
+            if e != 0 {
+                v.expiring++
+            }
+        }
+        return len(n.Keys), nodeSoonest(n), nil
+    }
+    if len(n.Children) != len(n.Keys)+1 {
+        return 0, 0, fmt.Errorf("bptree: internal node at depth %d has %d keys and %d children", depth, len(n.Keys), len(n.Children))
+    }
+    if v.t.Counted && len(n.Counts) != len(n.Children) {
+        return 0, 0, fmt.Errorf("bptree: internal node at depth %d has %d children and %d counts", depth, len(n.Children), len(n.Counts))
+    }
+    if v.t.Timed && len(n.Soonest) != len(n.Children) {
+        return 0, 0, fmt.Errorf("bptree: internal node at depth %d has %d children and %d soonest expiries", depth, len(n.Children), len(n.Soonest))
+    }
+    total := 0
+    var min int64
+    for i, c := range n.Children {
+        clo, chi, cHasLo, cHasHi := lo, hi, hasLo, hasHi
+        if i > 0 {
+            clo, cHasLo = n.Keys[i-1], true
+        }
+        if i < len(n.Keys) {
+            chi, cHasHi = n.Keys[i], true
+        }
+        cnt, first, err := v.node(c, depth+1, clo, chi, cHasLo, cHasHi)
+        if err != nil {
+            return 0, 0, err
+        }
+        if v.t.Counted && n.Counts[i] != cnt {
+            return 0, 0, fmt.Errorf("bptree: child %d at depth %d holds %d keys but is counted as %d", i, depth, cnt, n.Counts[i])
+        }
+        if v.t.Timed && n.Soonest[i] != first {
+            return 0, 0, fmt.Errorf("bptree: child %d at depth %d first expires at %d but is recorded as %d", i, depth, first, n.Soonest[i])
+        }
+        total += cnt
+        min = soonest(min, first)
+    }
+    return total, min, nil
+}
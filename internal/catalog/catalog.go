@@ -5,34 +5,100 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"sort"
 
 	"sharkDB/internal/bptree"
-	"sharkDB/internal/pager2"
+	"sharkDB/internal/compress"
+	"sharkDB/internal/dbmeta"
+	"sharkDB/internal/storage"
 )
 
+var (
+	ErrTableNotFound = errors.New("table not found")
+	ErrTableExists   = errors.New("table already exists")
+	ErrIndexNotFound = errors.New("index not found")
+	ErrIndexExists   = errors.New("index already exists")
+)
+
+// nameError keeps the "table <name> not found" wording while letting callers
+// match the underlying sentinel with errors.Is.
+type nameError struct {
+	kind string
+	name string
+	err  error
+}
+
+func (e *nameError) Error() string {
+	if e.err == ErrTableExists || e.err == ErrIndexExists {
+		return fmt.Sprintf("%s %s already exists", e.kind, e.name)
+	}
+	return fmt.Sprintf("%s %s not found", e.kind, e.name)
+}
+
+func (e *nameError) Unwrap() error { return e.err }
+
+// TableNotFound returns an error for a missing table that matches ErrTableNotFound.
+func TableNotFound(name string) error {
+	return &nameError{kind: "table", name: name, err: ErrTableNotFound}
+}
+
+// TableExists returns an error for a duplicate table that matches ErrTableExists.
+func TableExists(name string) error {
+	return &nameError{kind: "table", name: name, err: ErrTableExists}
+}
+
+// IndexNotFound returns an error for a missing index that matches ErrIndexNotFound.
+func IndexNotFound(name string) error {
+	return &nameError{kind: "index", name: name, err: ErrIndexNotFound}
+}
+
+// IndexExists returns an error for a duplicate index that matches ErrIndexExists.
+func IndexExists(name string) error {
+	return &nameError{kind: "index", name: name, err: ErrIndexExists}
+}
+
 // Catalog maps table names to persistent table ids and stores/loads
-// each table's B+ tree as a serialized blob via the pager.
+// each table's B+ tree as a serialized blob via the storage backend.
 
 type Catalog struct {
-	p *pager2.Pager
+	p storage.Storage
 }
 
-func New(p *pager2.Pager) *Catalog { return &Catalog{p: p} }
+func New(p storage.Storage) *Catalog { return &Catalog{p: p} }
 
 func (c *Catalog) CreateTable(name string) error {
+	return c.CreateTableSchema(name, "")
+}
+
+// CreateTableSchema creates a table whose rows follow the given schema
+// definition. An empty definition creates a schemaless table.
+func (c *Catalog) CreateTableSchema(name, schema string) error {
 	m := c.p.Meta()
 	if _, exists := m.Tables[name]; exists {
-		return fmt.Errorf("table %s already exists", name)
+		return TableExists(name)
 	}
-	return c.p.UpdateMeta(func(meta *pager2.Meta) {
+	return c.p.UpdateMeta(func(meta *dbmeta.Meta) {
 		if meta.Tables == nil {
 			meta.Tables = make(map[string]uint64)
 		}
 		meta.NextTableID++
 		meta.Tables[name] = meta.NextTableID
+		if schema != "" {
+			if meta.Schemas == nil {
+				meta.Schemas = make(map[uint64]string)
+			}
+			meta.Schemas[meta.NextTableID] = schema
+		}
 	})
 }
 
+// TableSchema returns the schema definition of a table, if it has one.
+func (c *Catalog) TableSchema(tableID uint64) (string, bool) {
+	m := c.p.Meta()
+	def, ok := m.Schemas[tableID]
+	return def, ok
+}
+
 func (c *Catalog) GetTableID(name string) (uint64, bool) {
 	m := c.p.Meta()
 	id, ok := m.Tables[name]
@@ -40,16 +106,23 @@ func (c *Catalog) GetTableID(name string) (uint64, bool) {
 }
 
 func (c *Catalog) LoadTree(tableID uint64) (*bptree.BPTree, error) {
-	blob, ok := c.p.LoadTableBlob(tableID)
-	if !ok || len(blob) == 0 {
+	blob, err := c.p.ReadTableBlob(tableID)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) == 0 {
 		// New empty tree
 		return bptree.New(), nil
 	}
+	if blob, err = compress.Decode(blob); err != nil {
+		return nil, err
+	}
 	var tree bptree.BPTree
 	dec := gob.NewDecoder(bytes.NewReader(blob))
 	if err := dec.Decode(&tree); err != nil {
 		return nil, err
 	}
+	tree.EnsureCounts()
 	return &tree, nil
 }
 
@@ -57,36 +130,244 @@ func (c *Catalog) StoreTree(tableID uint64, tree *bptree.BPTree) error {
 	if tree == nil {
 		return errors.New("nil tree")
 	}
+	return c.StoreTrees(map[uint64]*bptree.BPTree{tableID: tree})
+}
+
+// StoreTrees serializes several trees and persists them in one atomic
+// storage write, together with any change to the expiring marks of their
+// tables.
+func (c *Catalog) StoreTrees(trees map[uint64]*bptree.BPTree) error {
+	blobs := make(map[uint64][]byte, len(trees))
+	for id, tree := range trees {
+		if tree == nil {
+			return errors.New("nil tree")
+		}
+		blob, err := encodeTree(c.compression(id), tree)
+		if err != nil {
+			return err
+		}
+		blobs[id] = blob
+	}
+	return c.p.StoreTableBlobsMeta(blobs, c.expiringUpdate(trees))
+}
+
+// expiringChanges returns the tables among trees whose expiring mark must
+// be set or cleared to match their tree. Index trees are skipped.
+func (c *Catalog) expiringChanges(trees map[uint64]*bptree.BPTree) (set, clear []uint64) {
+	m := c.p.Meta()
+	tables := make(map[uint64]bool, len(m.Tables))
+	for _, id := range m.Tables {
+		tables[id] = true
+	}
+	for id, tree := range trees {
+		if !tables[id] {
+			continue
+		}
+		switch has := tree.Expiring > 0; {
+		case has && !m.Expiring[id]:
+			set = append(set, id)
+		case !has && m.Expiring[id]:
+			clear = append(clear, id)
+		}
+	}
+	return set, clear
+}
+
+// expiringUpdate returns the meta change that brings the expiring marks of
+// the tables among trees in line with them, or nil if they already are.
+func (c *Catalog) expiringUpdate(trees map[uint64]*bptree.BPTree) func(meta *dbmeta.Meta) {
+	set, clear := c.expiringChanges(trees)
+	if len(set) == 0 && len(clear) == 0 {
+		return nil
+	}
+	return func(meta *dbmeta.Meta) {
+		for _, id := range clear {
+			delete(meta.Expiring, id)
+		}
+		if len(set) > 0 && meta.Expiring == nil {
+			meta.Expiring = make(map[uint64]bool)
+		}
+		for _, id := range set {
+			meta.Expiring[id] = true
+		}
+	}
+}
+
+// TableExpiring reports whether the table is marked as holding keys with an
+// expiry. Tables whose trees were last stored by an older version are never
+// marked, whatever they hold.
+func (c *Catalog) TableExpiring(tableID uint64) bool {
+	return c.p.Meta().Expiring[tableID]
+}
+
+// encodeTree serializes the tree and compresses it with method.
+func encodeTree(method compress.Method, tree *bptree.BPTree) ([]byte, error) {
 	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(tree); err != nil {
-		return err
+	if err := gob.NewEncoder(&buf).Encode(tree); err != nil {
+		return nil, err
 	}
-	return c.p.StoreTableBlob(tableID, buf.Bytes())
+	return compress.Encode(method, buf.Bytes())
 }
 
-// DeleteTable removes table metadata and its blob.
+// compression returns the method for the tree stored under id: its own
+// table's, or for an index tree the method of the table it indexes.
+func (c *Catalog) compression(id uint64) compress.Method {
+	m := c.p.Meta()
+	for _, im := range m.Indexes {
+		if im.TreeID == id {
+			id = im.TableID
+			break
+		}
+	}
+	method, _ := compress.Parse(m.Compression[id])
+	return method
+}
+
+// TableCompression returns the compression method of a table.
+func (c *Catalog) TableCompression(tableID uint64) compress.Method {
+	method, _ := compress.Parse(c.p.Meta().Compression[tableID])
+	return method
+}
+
+// SetTableCompression records the compression method of a table. Its trees
+// and those of its indexes are compressed with it from their next store
+// on; trees stored before keep their method until then.
+func (c *Catalog) SetTableCompression(tableID uint64, method compress.Method) error {
+	return c.p.UpdateMeta(func(meta *dbmeta.Meta) {
+		setCompression(meta, tableID, method)
+	})
+}
+
+// CompressTable sets the compression method of a table as
+// SetTableCompression does and stores trees, the table's tree and those of
+// its indexes, compressed with it, all in one atomic storage write.
+func (c *Catalog) CompressTable(tableID uint64, method compress.Method, trees map[uint64]*bptree.BPTree) error {
+	blobs := make(map[uint64][]byte, len(trees))
+	for id, tree := range trees {
+		blob, err := encodeTree(method, tree)
+		if err != nil {
+			return err
+		}
+		blobs[id] = blob
+	}
+	expiring := c.expiringUpdate(trees)
+	return c.p.StoreTableBlobsMeta(blobs, func(meta *dbmeta.Meta) {
+		setCompression(meta, tableID, method)
+		if expiring != nil {
+			expiring(meta)
+		}
+	})
+}
+
+func setCompression(meta *dbmeta.Meta, tableID uint64, method compress.Method) {
+	if method == compress.None {
+		delete(meta.Compression, tableID)
+		return
+	}
+	if meta.Compression == nil {
+		meta.Compression = make(map[uint64]string)
+	}
+	meta.Compression[tableID] = method.String()
+}
+
+// TreeSize returns the bytes the tree stored under id takes, and how many
+// it takes uncompressed.
+func (c *Catalog) TreeSize(id uint64) (stored, raw int, err error) {
+	blob, err := c.p.ReadTableBlob(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, raw, err = compress.Info(blob)
+	return len(blob), raw, err
+}
+
+// DeleteTable removes table metadata and its blob, along with any indexes
+// on the table.
 func (c *Catalog) DeleteTable(name string) error {
 	m := c.p.Meta()
 	id, ok := m.Tables[name]
 	if !ok {
-		return fmt.Errorf("table %s not found", name)
+		return TableNotFound(name)
+	}
+	for _, ix := range c.TableIndexes(id) {
+		if err := c.DropIndex(ix.Name); err != nil {
+			return err
+		}
 	}
 	if err := c.p.DeleteTableBlob(id); err != nil {
 		return err
 	}
-	return c.p.UpdateMeta(func(meta *pager2.Meta) {
+	return c.p.UpdateMeta(func(meta *dbmeta.Meta) {
 		delete(meta.Tables, name)
+		delete(meta.Schemas, id)
+		delete(meta.Compression, id)
+		delete(meta.Expiring, id)
+	})
+}
+
+// Index is a named secondary index on a JSON field of a table.
+type Index struct {
+	Name    string
+	TableID uint64
+	Field   string
+	TreeID  uint64
+}
+
+// CreateIndex registers an index on field of the table and allocates the id
+// its tree is stored under. The tree starts out empty.
+func (c *Catalog) CreateIndex(name string, tableID uint64, field string) (Index, error) {
+	m := c.p.Meta()
+	if _, exists := m.Indexes[name]; exists {
+		return Index{}, IndexExists(name)
+	}
+	ix := Index{Name: name, TableID: tableID, Field: field}
+	err := c.p.UpdateMeta(func(meta *dbmeta.Meta) {
+		if meta.Indexes == nil {
+			meta.Indexes = make(map[string]dbmeta.IndexMeta)
+		}
+		meta.NextTableID++
+		ix.TreeID = meta.NextTableID
+		meta.Indexes[name] = dbmeta.IndexMeta{TableID: tableID, Field: field, TreeID: ix.TreeID}
+	})
+	return ix, err
+}
+
+// DropIndex removes an index and its tree.
+func (c *Catalog) DropIndex(name string) error {
+	m := c.p.Meta()
+	im, ok := m.Indexes[name]
+	if !ok {
+		return IndexNotFound(name)
+	}
+	if err := c.p.DeleteTableBlob(im.TreeID); err != nil {
+		return err
+	}
+	return c.p.UpdateMeta(func(meta *dbmeta.Meta) {
+		delete(meta.Indexes, name)
 	})
 }
 
-// ListTables returns all table names.
+// TableIndexes returns the indexes on a table sorted by name.
+func (c *Catalog) TableIndexes(tableID uint64) []Index {
+	m := c.p.Meta()
+	var out []Index
+	for name, im := range m.Indexes {
+		if im.TableID == tableID {
+			out = append(out, Index{Name: name, TableID: im.TableID, Field: im.Field, TreeID: im.TreeID})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ListTables returns all table names in sorted order.
 func (c *Catalog) ListTables() []string {
 	m := c.p.Meta()
 	out := make([]string, 0, len(m.Tables))
 	for name := range m.Tables {
 		out = append(out, name)
 	}
+	sort.Strings(out)
 	return out
 }
 
@@ -94,13 +375,13 @@ func (c *Catalog) ListTables() []string {
 func (c *Catalog) RenameTable(oldName, newName string) error {
 	m := c.p.Meta()
 	if _, ok := m.Tables[newName]; ok {
-		return fmt.Errorf("table %s already exists", newName)
+		return TableExists(newName)
 	}
 	id, ok := m.Tables[oldName]
 	if !ok {
-		return fmt.Errorf("table %s not found", oldName)
+		return TableNotFound(oldName)
 	}
-	return c.p.UpdateMeta(func(meta *pager2.Meta) {
+	return c.p.UpdateMeta(func(meta *dbmeta.Meta) {
 		delete(meta.Tables, oldName)
 		if meta.Tables == nil {
 			meta.Tables = make(map[string]uint64)
@@ -0,0 +1,379 @@
+package check
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/compress"
+	"sharkDB/internal/pager2"
+)
+
+// Check reads a database file without the pager's recovery and reports
+// every inconsistency it finds. Each page belongs to exactly one owner: the
+// meta (page 0), the chain of one blob or the free list. Walking the meta's
+// chains and the free list finds pages claimed twice, cycles, links past
+// the end of the file and chain pages with impossible lengths; pages nobody
+// claims are orphaned. Every blob is then decoded as a tree and validated.
+//
+// Chains a backup held back when the process stopped are listed in the
+// meta and freed on the next open; until then they are reported as held.
+// Orphaned pages only waste space (a crash between writing a chain and
+// recording it leaves them behind), so they are warnings; everything else
+// is an error.
+
+// Severity ranks a problem.
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "ERROR"
+	}
+	return "WARNING"
+}
+
+// Problem is one inconsistency.
+type Problem struct {
+	Severity Severity
+	Msg      string
+}
+
+// Blob describes one stored tree.
+type Blob struct {
+	ID     uint64
+	Kind   string // "table", "index" or "orphan"
+	Name   string
+	Pages  int
+	Bytes  int
+	Keys   int
+	Height int
+}
+
+// Report is the result of a check.
+type Report struct {
+	Pages    uint64 // including the meta page
+	Used     uint64 // pages in blob chains
+	Free     uint64 // pages on the free list
+	Held     uint64 // pages of replaced chains a backup held back
+	Orphaned uint64
+	WALBytes int64
+	LSN      uint64
+	Version  uint32 // format version of the file
+	Blobs    []Blob
+	Problems []Problem
+}
+
+// Errors returns the number of problems that are errors.
+func (r *Report) Errors() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == Error {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) add(sev Severity, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{sev, fmt.Sprintf(format, args...)})
+}
+
+// maxOrphansListed bounds the orphaned pages named in the report.
+const maxOrphansListed = 20
+
+// File checks the database at path, which key opens if it is encrypted.
+// The error is non-nil only if the file cannot be read at all; damage,
+// including pages that fail to authenticate, is reported in the Report.
+func File(path string, key []byte) (*Report, error) {
+	rf, err := pager2.OpenRaw(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rf.Close()
+	r := &Report{Pages: rf.Pages, WALBytes: rf.WALBytes}
+	if rf.Tail != 0 {
+		r.add(Error, "file ends with %d bytes of a partial page", rf.Tail)
+	}
+	if rf.WALBytes > 0 {
+		r.add(Warning, "WAL holds %d bytes; writes in it that the file lacks are applied when the database is next opened", rf.WALBytes)
+	}
+	if rf.Pages == 0 {
+		r.add(Error, "file has no meta page")
+		return r, nil
+	}
+	m, err := rf.Meta()
+	if err != nil {
+		r.add(Error, "%v", err)
+		return r, nil
+	}
+	r.LSN = m.LSN
+	r.Version, _ = rf.Version()
+	c := &checker{rf: rf, r: r, owner: make([]string, rf.Pages)}
+	c.owner[0] = "the meta"
+
+	names := blobNames(m, r)
+	ids := make([]uint64, 0, len(m.TableHead))
+	for id := range m.TableHead {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		b, ok := names[id]
+		if !ok {
+			b = Blob{Kind: "orphan", Name: "-"}
+			r.add(Error, "blob %d has a page chain but no table or index", id)
+		}
+		b.ID = id
+		data, pages, ok := c.chain(id, m.TableHead[id])
+		b.Pages, b.Bytes = pages, len(data)
+		if ok && len(data) > 0 {
+			c.tree(&b, data)
+		}
+		delete(names, id)
+		r.Blobs = append(r.Blobs, b)
+	}
+	// Tables and indexes without a chain are empty.
+	for id, b := range names {
+		b.ID = id
+		r.Blobs = append(r.Blobs, b)
+	}
+	sort.Slice(r.Blobs, func(i, j int) bool { return r.Blobs[i].ID < r.Blobs[j].ID })
+
+	c.freeList(m.FreeList)
+	for _, head := range m.Deferred {
+		c.held(head)
+	}
+	if r.Held > 0 {
+		r.add(Warning, "%d pages of chains replaced while a backup was running are not free yet; they are freed when the database is next opened", r.Held)
+	}
+
+	var orphans []uint64
+	for pid := uint64(1); pid < rf.Pages; pid++ {
+		if c.owner[pid] == "" {
+			r.Orphaned++
+			if len(orphans) < maxOrphansListed {
+				orphans = append(orphans, pid)
+			}
+		}
+	}
+	if r.Orphaned > 0 {
+		more := ""
+		if r.Orphaned > uint64(len(orphans)) {
+			more = ", ..."
+		}
+		r.add(Warning, "%d orphaned pages are neither in use nor free: %v%s", r.Orphaned, orphans, more)
+	}
+	return r, nil
+}
+
+// blobNames maps blob ids to the tables and indexes the meta stores under
+// them, reporting references that make no sense.
+func blobNames(m pager2.Meta, r *Report) map[uint64]Blob {
+	names := make(map[uint64]Blob)
+	for _, name := range sortedKeys(m.Tables) {
+		id := m.Tables[name]
+		if prev, dup := names[id]; dup {
+			r.add(Error, "tables %s and %s share id %d", prev.Name, name, id)
+		}
+		if id > m.NextTableID {
+			r.add(Error, "table %s has id %d, above the next id %d", name, id, m.NextTableID)
+		}
+		names[id] = Blob{Kind: "table", Name: name}
+	}
+	for _, name := range sortedKeys(m.Indexes) {
+		ix := m.Indexes[name]
+		if prev, dup := names[ix.TreeID]; dup {
+			r.add(Error, "index %s shares id %d with %s %s", name, ix.TreeID, prev.Kind, prev.Name)
+		}
+		if ix.TreeID > m.NextTableID {
+			r.add(Error, "index %s has id %d, above the next id %d", name, ix.TreeID, m.NextTableID)
+		}
+		if b, ok := names[ix.TableID]; !ok || b.Kind != "table" {
+			r.add(Error, "index %s is on table id %d, which does not exist", name, ix.TableID)
+		}
+		names[ix.TreeID] = Blob{Kind: "index", Name: name}
+	}
+	for id := range m.Schemas {
+		if b, ok := names[id]; !ok || b.Kind != "table" {
+			r.add(Error, "schema for table id %d, which does not exist", id)
+		}
+	}
+	for id, method := range m.Compression {
+		if b, ok := names[id]; !ok || b.Kind != "table" {
+			r.add(Error, "compression for table id %d, which does not exist", id)
+		}
+		if _, err := compress.Parse(method); err != nil {
+			r.add(Error, "table id %d: %v", id, err)
+		}
+	}
+	for id := range m.Expiring {
+		if b, ok := names[id]; !ok || b.Kind != "table" {
+			r.add(Error, "expiring mark for table id %d, which does not exist", id)
+		}
+	}
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type checker struct {
+	rf    *pager2.RawFile
+	r     *Report
+	owner []string
+}
+
+// claim records that page pid belongs to owner. It reports and returns
+// false if the page cannot be claimed, so the walk stops there.
+func (c *checker) claim(pid uint64, owner, from string) bool {
+	switch {
+	case pid == 0:
+		c.r.add(Error, "%s links to page 0, the meta page (%s)", owner, from)
+		return false
+	case pid >= c.rf.Pages:
+		c.r.add(Error, "%s links to page %d, past the end of the file (%s)", owner, pid, from)
+		return false
+	case c.owner[pid] == "":
+		c.owner[pid] = owner
+		return true
+	case c.owner[pid] == owner:
+		c.r.add(Error, "%s has a cycle: page %d is reached again (%s)", owner, pid, from)
+		return false
+	case c.owner[pid] == "free list" || owner == "free list":
+		other := c.owner[pid]
+		if other == "free list" {
+			other = owner
+		}
+		c.r.add(Error, "page %d is both free and in use by %s", pid, other)
+		return false
+	}
+	c.r.add(Error, "page %d is claimed by both %s and %s", pid, c.owner[pid], owner)
+	return false
+}
+
+// chain walks the page chain of a blob and returns its bytes, the number of
+// pages claimed and whether the whole chain could be read.
+func (c *checker) chain(id, head uint64) ([]byte, int, bool) {
+	owner := fmt.Sprintf("blob %d", id)
+	var data []byte
+	pages := 0
+	from := "head"
+	defer func() { c.r.Used += uint64(pages) }()
+	for pid := head; pid != 0; {
+		if !c.claim(pid, owner, from) {
+			return data, pages, false
+		}
+		pages++
+		page, err := c.rf.Page(pid)
+		if err != nil {
+			c.r.add(Error, "%s: page %d: %v", owner, pid, err)
+			return data, pages, false
+		}
+		next, d, ok := pager2.ChainPage(page)
+		if !ok {
+			c.r.add(Error, "%s: page %d states a data length that does not fit in a page", owner, pid)
+			return data, pages, false
+		}
+		data = append(data, d...)
+		from = fmt.Sprintf("from page %d", pid)
+		pid = next
+	}
+	return data, pages, true
+}
+
+// tree decodes and validates the blob of b.
+func (c *checker) tree(b *Blob, data []byte) {
+	data, err := compress.Decode(data)
+	if err != nil {
+		c.r.add(Error, "%s %s (blob %d) does not decompress: %v", b.Kind, b.Name, b.ID, err)
+		return
+	}
+	var t bptree.BPTree
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&t); err != nil {
+		c.r.add(Error, "%s %s (blob %d) does not decode as a tree: %v", b.Kind, b.Name, b.ID, err)
+		return
+	}
+	if err := t.Validate(); err != nil {
+		c.r.add(Error, "%s %s (blob %d): %v", b.Kind, b.Name, b.ID, err)
+		return
+	}
+	t.EnsureCounts()
+	b.Keys, b.Height = t.Len(), t.Height()
+}
+
+// freeList walks the free list.
+func (c *checker) freeList(head uint64) {
+	from := "head"
+	for pid := head; pid != 0; {
+		if !c.claim(pid, "free list", from) {
+			return
+		}
+		c.r.Free++
+		page, err := c.rf.Page(pid)
+		if err != nil {
+			c.r.add(Error, "free list: page %d: %v", pid, err)
+			return
+		}
+		from = fmt.Sprintf("from page %d", pid)
+		pid = pager2.FreePage(page)
+	}
+}
+
+// held walks a replaced chain that a backup kept from the free list.
+func (c *checker) held(head uint64) {
+	owner := fmt.Sprintf("held chain at page %d", head)
+	from := "meta"
+	for pid := head; pid != 0; {
+		if !c.claim(pid, owner, from) {
+			return
+		}
+		c.r.Held++
+		page, err := c.rf.Page(pid)
+		if err != nil {
+			c.r.add(Error, "%s: page %d: %v", owner, pid, err)
+			return
+		}
+		next, _, ok := pager2.ChainPage(page)
+		if !ok {
+			c.r.add(Error, "%s: page %d states a data length that does not fit in a page", owner, pid)
+			return
+		}
+		from = fmt.Sprintf("from page %d", pid)
+		pid = next
+	}
+}
+
+// Print writes the report for people.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "pages: %d (1 meta, %d in use, %d free, %d orphaned)\n", r.Pages, r.Used, r.Free, r.Orphaned)
+	fmt.Fprintf(w, "format: %d, LSN: %d, WAL: %d bytes\n", r.Version, r.LSN, r.WALBytes)
+	for _, b := range r.Blobs {
+		fmt.Fprintf(w, "%-6s %-20s blob %-4d %5d pages %9d bytes", b.Kind, b.Name, b.ID, b.Pages, b.Bytes)
+		if b.Height > 0 {
+			fmt.Fprintf(w, "  %d keys, height %d", b.Keys, b.Height)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(w, "%s: %s\n", p.Severity, p.Msg)
+	}
+	if n := r.Errors(); n > 0 {
+		fmt.Fprintf(w, "%d errors, %d warnings: the database is damaged\n", n, len(r.Problems)-n)
+	} else {
+		fmt.Fprintf(w, "no errors, %d warnings\n", len(r.Problems))
+	}
+}
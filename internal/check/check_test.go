@@ -0,0 +1,203 @@
+package check
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/storage"
+)
+
+// buildDB writes a database with two tables whose trees span several pages
+// and returns its path and the head page of each table's chain.
+func buildDB(t *testing.T, key []byte) (string, map[string]uint64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "check.db")
+	p, err := pager2.OpenOptions(path, pager2.Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := engine.New(storage.Pager2(p))
+	for _, table := range []string{"a", "b"} {
+		if _, err := e.Create(table); err != nil {
+			t.Fatal(err)
+		}
+		b := e.NewBatch()
+		for i := 0; i < 300; i++ {
+			if err := b.Put(table, fmt.Sprintf("key%04d", i), strings.Repeat("v", 20)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := p.Meta()
+	heads := map[string]uint64{"a": m.TableHead[m.Tables["a"]], "b": m.TableHead[m.Tables["b"]]}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, heads
+}
+
+// setNext points the plain page pid at next.
+func setNext(t *testing.T, path string, pid, next uint64) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], next)
+	if _, err := f.WriteAt(buf[:], int64(pid)*pager2.PageSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// overwrite writes data into the file at off.
+func overwrite(t *testing.T, path string, off int64, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(data, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectError checks path and fails unless an error mentions want.
+func expectError(t *testing.T, path string, key []byte, want string) {
+	t.Helper()
+	r, err := File(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range r.Problems {
+		if p.Severity == Error && strings.Contains(p.Msg, want) {
+			return
+		}
+	}
+	var buf bytes.Buffer
+	r.Print(&buf)
+	t.Fatalf("no error mentioning %q:\n%s", want, buf.String())
+}
+
+func TestClean(t *testing.T) {
+	for _, key := range [][]byte{nil, bytes.Repeat([]byte{1}, pager2.KeySize)} {
+		path, _ := buildDB(t, key)
+		r, err := File(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Errors() != 0 {
+			var buf bytes.Buffer
+			r.Print(&buf)
+			t.Fatalf("clean database reports errors:\n%s", buf.String())
+		}
+		for _, b := range r.Blobs {
+			if b.Kind == "table" && b.Keys != 300 {
+				t.Fatalf("table %s: %d keys, want 300", b.Name, b.Keys)
+			}
+		}
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(t *testing.T, path string, heads map[string]uint64)
+		want    string
+	}{
+		{"cycle", func(t *testing.T, path string, heads map[string]uint64) {
+			setNext(t, path, heads["a"], heads["a"])
+		}, "has a cycle"},
+		{"shared page", func(t *testing.T, path string, heads map[string]uint64) {
+			setNext(t, path, heads["a"], heads["b"])
+		}, "claimed by both"},
+		{"past the end", func(t *testing.T, path string, heads map[string]uint64) {
+			setNext(t, path, heads["a"], 1<<20)
+		}, "past the end of the file"},
+		{"garbage data", func(t *testing.T, path string, heads map[string]uint64) {
+			overwrite(t, path, int64(heads["a"])*pager2.PageSize+12, bytes.Repeat([]byte{0xa5}, 64))
+		}, "does not decode as a tree"},
+		{"bad data length", func(t *testing.T, path string, heads map[string]uint64) {
+			overwrite(t, path, int64(heads["a"])*pager2.PageSize+8, []byte{0xff, 0xff, 0, 0})
+		}, "does not fit in a page"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, heads := buildDB(t, nil)
+			c.corrupt(t, path, heads)
+			expectError(t, path, nil, c.want)
+		})
+	}
+}
+
+func TestCorruptedEncrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, pager2.KeySize)
+	path, heads := buildDB(t, key)
+	// Any flipped byte of a sealed page fails authentication.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pager2.OpenRaw(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot := fi.Size() / int64(r.Pages)
+	r.Close()
+	overwrite(t, path, int64(heads["b"])*slot+100, []byte{0xff, 0x00, 0xff})
+	expectError(t, path, key, "authentication failed")
+}
+
+// Chains a backup held back when the process stopped are not orphans: the
+// meta lists them and the next open frees them.
+func TestHeldChains(t *testing.T) {
+	path, heads := buildDB(t, nil)
+	p, err := pager2.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	e := engine.New(storage.Pager2(p))
+	if _, err := e.Insert("a", "new", "v"); err != nil {
+		t.Fatal(err)
+	}
+	// Stop without closing the snapshot, as a crash would.
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := File(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	r.Print(&buf)
+	if r.Errors() != 0 || r.Orphaned != 0 || r.Held == 0 || !strings.Contains(buf.String(), "freed when the database is next opened") {
+		t.Fatalf("held chain of page %d not reported as held:\n%s", heads["a"], buf.String())
+	}
+
+	p, err = pager2.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = File(path, nil); err != nil || r.Held != 0 || r.Orphaned != 0 || len(r.Problems) != 0 {
+		t.Fatalf("after reopen: %+v, %v", r, err)
+	}
+}
@@ -0,0 +1,133 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Tree blobs may be stored compressed. A compressed blob is framed as
+//
+//	magic "\x00SKZ" | method (1 byte) | raw length (uvarint) | deflate stream
+//
+// Blobs are gob streams, which never start with a zero byte, so a blob
+// without the magic is stored raw and decodes as itself. Blobs written
+// before compression existed, or under method none, therefore need no
+// conversion, and a table's method can change at any time: the next write
+// of its tree uses the new one. Both methods use compress/flate; fast trades
+// ratio for speed the way snappy does and best trades speed for ratio the
+// way zstd's higher levels do.
+
+// Method is a compression method.
+type Method uint8
+
+const (
+	None Method = iota
+	Fast
+	Best
+)
+
+var magic = []byte{0, 'S', 'K', 'Z'}
+
+// ErrCorrupt is returned for compressed blobs that do not decode.
+var ErrCorrupt = errors.New("compress: corrupt blob")
+
+var names = [...]string{None: "none", Fast: "fast", Best: "best"}
+
+func (m Method) String() string {
+	if int(m) < len(names) {
+		return names[m]
+	}
+	return fmt.Sprintf("method(%d)", uint8(m))
+}
+
+// Parse returns the method named s: none, fast or best. snappy and zstd
+// are accepted as aliases of fast and best.
+func Parse(s string) (Method, error) {
+	switch s {
+	case "none", "":
+		return None, nil
+	case "fast", "snappy":
+		return Fast, nil
+	case "best", "zstd":
+		return Best, nil
+	}
+	return None, fmt.Errorf("compress: unknown method %q (want none, fast or best)", s)
+}
+
+func (m Method) level() int {
+	if m == Best {
+		return flate.BestCompression
+	}
+	return flate.BestSpeed
+}
+
+// Encode compresses data with m. Data that does not shrink is returned
+// raw, as is all data under None.
+func Encode(m Method, data []byte) ([]byte, error) {
+	if m == None || len(data) == 0 {
+		return data, nil
+	}
+	if m > Best {
+		return nil, fmt.Errorf("compress: unknown method %d", uint8(m))
+	}
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.WriteByte(byte(m))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	w, err := flate.NewWriter(&buf, m.level())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// Info reports the method a blob was stored with and its length once
+// decoded, without decoding it.
+func Info(blob []byte) (Method, int, error) {
+	if !bytes.HasPrefix(blob, magic) {
+		return None, len(blob), nil
+	}
+	if len(blob) < len(magic)+1 {
+		return None, 0, ErrCorrupt
+	}
+	m := Method(blob[len(magic)])
+	n, k := binary.Uvarint(blob[len(magic)+1:])
+	// deflate shrinks data at most 1032-fold, which bounds the length a
+	// damaged header can claim.
+	if m == None || m > Best || k <= 0 || n > uint64(len(blob))*1032 {
+		return None, 0, ErrCorrupt
+	}
+	return m, int(n), nil
+}
+
+// Decode returns the raw bytes of a blob written by Encode.
+func Decode(blob []byte) ([]byte, error) {
+	if !bytes.HasPrefix(blob, magic) {
+		return blob, nil
+	}
+	_, n, err := Info(blob)
+	if err != nil {
+		return nil, err
+	}
+	_, k := binary.Uvarint(blob[len(magic)+1:])
+	r := flate.NewReader(bytes.NewReader(blob[len(magic)+1+k:]))
+	defer r.Close()
+	out := make([]byte, n)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("a gob stream of a tree with many similar keys; ", 200))
+	for _, m := range []Method{None, Fast, Best} {
+		blob, err := Encode(m, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != None && len(blob) >= len(data) {
+			t.Fatalf("%s: %d bytes did not shrink (%d)", m, len(data), len(blob))
+		}
+		method, raw, err := Info(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if method != m || raw != len(data) {
+			t.Fatalf("%s: Info = %s, %d; want %s, %d", m, method, raw, m, len(data))
+		}
+		got, err := Decode(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: decoded data differs", m)
+		}
+	}
+}
+
+func TestIncompressibleStaysRaw(t *testing.T) {
+	data := []byte{0x0f, 0xff, 0x81, 0x03, 0x01}
+	blob, err := Encode(Best, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob, data) {
+		t.Fatal("data that does not shrink was framed")
+	}
+	if m, raw, err := Info(blob); err != nil || m != None || raw != len(data) {
+		t.Fatalf("Info = %s, %d, %v", m, raw, err)
+	}
+	if _, err := Encode(Method(9), bytes.Repeat([]byte("x"), 100)); err == nil {
+		t.Fatal("unknown method accepted")
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	blob, err := Encode(Fast, bytes.Repeat([]byte("sharkdb "), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]byte{
+		"no method":      blob[:len(magic)],
+		"unknown method": append(append([]byte{}, magic...), 9, 10),
+		"huge length":    append(append([]byte{}, magic...), byte(Fast), 0xff, 0xff, 0xff, 0xff, 0x0f),
+		"truncated":      blob[:len(blob)/2],
+	}
+	for name, b := range cases {
+		if _, err := Decode(b); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: got %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]Method{"": None, "none": None, "fast": Fast, "snappy": Fast, "best": Best, "zstd": Best} {
+		m, err := Parse(s)
+		if err != nil || m != want {
+			t.Errorf("Parse(%q) = %s, %v; want %s", s, m, err, want)
+		}
+	}
+	if _, err := Parse("lz4"); err == nil {
+		t.Error("Parse accepted an unknown method")
+	}
+	if Best.String() != "best" || Method(7).String() != "method(7)" {
+		t.Error("String gives wrong names")
+	}
+}
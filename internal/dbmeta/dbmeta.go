@@ -0,0 +1,77 @@
+// Package dbmeta defines the database meta: the catalog of tables, indexes
+// and their settings that every storage backend keeps next to the blobs.
+// It sits below both the backends and the catalog so neither depends on
+// the other's package for it.
+package dbmeta
+
+import "slices"
+
+// Meta is the database meta. pager2 stores it (gob-encoded) in page 0.
+type Meta struct {
+	Tables      map[string]uint64 // table name -> table id
+	NextTableID uint64
+
+	TableHead map[uint64]uint64 // table id -> head page id of blob chain (0 if none)
+	FreeList  uint64            // head page id of free list (0 if empty)
+
+	Indexes map[string]IndexMeta // index name -> definition
+	Schemas map[uint64]string    // table id -> column list, for typed tables
+
+	Compression map[uint64]string // table id -> compression method, if not none
+	Expiring    map[uint64]bool   // table id -> true if some of its keys carry an expiry
+
+	// Deferred holds the heads of replaced chains an open backup snapshot
+	// still reads. They are freed when the last snapshot closes, or when the
+	// database is next opened if the process stopped first.
+	Deferred []uint64
+
+	LSN uint64 // last WAL commit applied to the file
+}
+
+// IndexMeta describes a secondary index. Its tree is stored as a blob under
+// TreeID, which is allocated from the same id space as tables.
+type IndexMeta struct {
+	TableID uint64
+	Field   string
+	TreeID  uint64
+}
+
+// Clone returns a deep copy of m, whose maps can be changed without
+// affecting m.
+func (m Meta) Clone() Meta {
+	c := m
+	c.Tables = make(map[string]uint64, len(m.Tables))
+	for k, v := range m.Tables {
+		c.Tables[k] = v
+	}
+	c.TableHead = make(map[uint64]uint64, len(m.TableHead))
+	for k, v := range m.TableHead {
+		c.TableHead[k] = v
+	}
+	if m.Indexes != nil {
+		c.Indexes = make(map[string]IndexMeta, len(m.Indexes))
+		for k, v := range m.Indexes {
+			c.Indexes[k] = v
+		}
+	}
+	if m.Schemas != nil {
+		c.Schemas = make(map[uint64]string, len(m.Schemas))
+		for k, v := range m.Schemas {
+			c.Schemas[k] = v
+		}
+	}
+	if m.Compression != nil {
+		c.Compression = make(map[uint64]string, len(m.Compression))
+		for k, v := range m.Compression {
+			c.Compression[k] = v
+		}
+	}
+	if m.Expiring != nil {
+		c.Expiring = make(map[uint64]bool, len(m.Expiring))
+		for k, v := range m.Expiring {
+			c.Expiring[k] = v
+		}
+	}
+	c.Deferred = slices.Clone(m.Deferred)
+	return c
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/jsonpath"
+)
+
+// AggQuery describes an aggregate over the JSON values of a table.
+type AggQuery struct {
+	Func string // COUNT, SUM, MIN, MAX or AVG
+	Path string // JSON path to aggregate; optional for COUNT
+
+	// Rows are limited to keys with Prefix, or with Range set to keys in
+	// [Start, End]. The zero value covers the whole table.
+	Prefix     string
+	Range      bool
+	Start, End string
+
+	// GroupPrefixLen > 0 groups rows by the first GroupPrefixLen bytes of
+	// their key.
+	GroupPrefixLen int
+}
+
+// AggQueryFromArgs builds a query from the normalized AGG arguments
+// produced by the parser: mode, a, b, func, path and group length.
+func AggQueryFromArgs(args []string) AggQuery {
+	q := AggQuery{Func: args[3], Path: args[4]}
+	switch args[0] {
+	case "PREFIX":
+		q.Prefix = args[1]
+	case "RANGE":
+		q.Range, q.Start, q.End = true, args[1], args[2]
+	}
+	q.GroupPrefixLen, _ = strconv.Atoi(args[5])
+	return q
+}
+
+// FormatAggValue renders an aggregate value for text output.
+func FormatAggValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case string:
+		return x
+	}
+	return fmt.Sprint(v)
+}
+
+// AggResult is the aggregate of one group. Count is the number of values
+// aggregated. Value is an int for COUNT, a float64 for SUM and AVG and the
+// smallest or largest JSON scalar for MIN and MAX; it is nil when the group
+// has no values to aggregate.
+type AggResult struct {
+	Group string `json:"group"`
+	Count int64  `json:"count"`
+	Value any    `json:"value"`
+}
+
+type aggState struct {
+	AggResult
+	sum    float64
+	minmax string // index encoding of Value, for MIN and MAX
+}
+
+// Aggregate computes q over table in a single ordered pass. Groups are
+// returned in key order; without grouping there is exactly one result.
+//
+// SUM and AVG use numeric values only. MIN and MAX order any JSON scalar
+// as a secondary index does: booleans, then numbers, then strings.
+func (e *Engine) Aggregate(table string, q AggQuery) ([]AggResult, error) {
+	fn := strings.ToUpper(q.Func)
+	switch fn {
+	case "COUNT", "SUM", "MIN", "MAX", "AVG":
+	default:
+		return nil, fmt.Errorf("unknown aggregate %s", q.Func)
+	}
+	var path jsonpath.Path
+	hasPath := strings.TrimSpace(q.Path) != "" && q.Path != "*"
+	if hasPath {
+		var err error
+		if path, err = jsonpath.Parse(q.Path); err != nil {
+			return nil, err
+		}
+	} else if fn != "COUNT" {
+		return nil, fmt.Errorf("%s requires a JSON path", fn)
+	}
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return nil, catalog.TableNotFound(table)
+	}
+	t, err := e.c.LoadTree(id)
+	if err != nil {
+		return nil, err
+	}
+
+	start := q.Prefix
+	if q.Range {
+		start = q.Start
+	}
+	now := nowNanos()
+	out := []AggResult{} // an empty grouping is [] in JSON, not null
+	var cur *aggState
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if fn == "AVG" && cur.Count > 0 {
+			cur.Value = cur.sum / float64(cur.Count)
+		}
+		out = append(out, cur.AggResult)
+	}
+	var ierr error
+	t.AscendEntries(start, func(k string, ent bptree.Entry) bool {
+		if q.Range && k > q.End {
+			return false
+		}
+		if !q.Range && !strings.HasPrefix(k, q.Prefix) {
+			return false
+		}
+		if ent.Expired(now) {
+			return true
+		}
+		group := ""
+		if q.GroupPrefixLen > 0 {
+			group = k
+			if len(group) > q.GroupPrefixLen {
+				group = group[:q.GroupPrefixLen]
+			}
+		}
+		// Keys arrive in order, so each group is a contiguous run.
+		if cur == nil || cur.Group != group {
+			flush()
+			cur = &aggState{AggResult: AggResult{Group: group}}
+			cur.Value = zeroAgg(fn)
+		}
+		if !hasPath {
+			cur.Count++
+			cur.Value = cur.Count
+			return true
+		}
+		value, err := e.decodeValue(id, ent.Value)
+		if err != nil {
+			ierr = err
+			return false
+		}
+		v, ok := path.Extract(value)
+		if !ok {
+			return true
+		}
+		switch fn {
+		case "COUNT":
+			cur.Count++
+			cur.Value = cur.Count
+		case "SUM", "AVG":
+			f, ok := jsonpath.Number(v)
+			if !ok {
+				return true
+			}
+			cur.Count++
+			cur.sum += f
+			if fn == "SUM" {
+				cur.Value = cur.sum
+			}
+		case "MIN", "MAX":
+			enc, ok := encodeIndexValue(v)
+			if !ok {
+				return true
+			}
+			cur.Count++
+			if cur.Count == 1 || (fn == "MIN" && enc < cur.minmax) || (fn == "MAX" && enc > cur.minmax) {
+				cur.minmax = enc
+				if f, ok := jsonpath.Number(v); ok {
+					v = f
+				}
+				cur.Value = v
+			}
+		}
+		return true
+	})
+	if ierr != nil {
+		return nil, ierr
+	}
+	flush()
+	if len(out) == 0 && q.GroupPrefixLen <= 0 {
+		out = append(out, AggResult{Value: zeroAgg(fn)})
+	}
+	return out, nil
+}
+
+// zeroAgg is the value of an aggregate over no rows.
+func zeroAgg(fn string) any {
+	switch fn {
+	case "COUNT":
+		return int64(0)
+	case "SUM":
+		return float64(0)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"sharkDB/internal/storage"
+)
+
+// aggString renders results as "group:count:value" words for comparison.
+func aggString(res []AggResult) string {
+	parts := make([]string, len(res))
+	for i, r := range res {
+		parts[i] = fmt.Sprintf("%s:%d:%s", r.Group, r.Count, FormatAggValue(r.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestAggregate(t *testing.T) {
+	e := New(storage.NewMemory())
+	for _, name := range []string{"t", "empty"} {
+		if _, err := e.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, kv := range [][2]string{
+		{"eu:1", `{"n":1,"s":"b"}`},
+		{"eu:2", `{"n":2.5,"s":"a"}`},
+		{"eu:3", `{"n":"x","s":true}`},
+		{"us:1", `{"n":10}`},
+		{"us:2", `{"m":1}`},
+		{"us:3", `not json`},
+	} {
+		if _, err := e.Insert("t", kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.InsertTTL("t", "eu:4", `{"n":100}`, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	for _, tc := range []struct {
+		table string
+		q     AggQuery
+		want  string
+	}{
+		{"t", AggQuery{Func: "count"}, ":6:6"},
+		{"t", AggQuery{Func: "COUNT", Path: "*"}, ":6:6"},
+		{"t", AggQuery{Func: "COUNT", Path: "$.n"}, ":4:4"},
+		{"t", AggQuery{Func: "SUM", Path: "n"}, ":3:13.5"},
+		{"t", AggQuery{Func: "AVG", Path: "n"}, ":3:4.5"},
+		{"t", AggQuery{Func: "MIN", Path: "n"}, ":4:1"},
+		{"t", AggQuery{Func: "MAX", Path: "n"}, ":4:x"},
+		{"t", AggQuery{Func: "MIN", Path: "s"}, ":3:true"},
+		{"t", AggQuery{Func: "SUM", Path: "n", Prefix: "us:"}, ":1:10"},
+		{"t", AggQuery{Func: "COUNT", Range: true, Start: "eu:2", End: "us:1"}, ":3:3"},
+		{"t", AggQuery{Func: "SUM", Path: "n", GroupPrefixLen: 2}, "eu:2:3.5 us:1:10"},
+		{"t", AggQuery{Func: "AVG", Path: "m", GroupPrefixLen: 3}, "eu::0:null us::1:1"},
+		{"t", AggQuery{Func: "COUNT", GroupPrefixLen: 100}, "eu:1:1:1 eu:2:1:1 eu:3:1:1 us:1:1:1 us:2:1:1 us:3:1:1"},
+		// Empty input: one result with the zero value, or no groups.
+		{"empty", AggQuery{Func: "COUNT"}, ":0:0"},
+		{"empty", AggQuery{Func: "SUM", Path: "n"}, ":0:0"},
+		{"empty", AggQuery{Func: "AVG", Path: "n"}, ":0:null"},
+		{"empty", AggQuery{Func: "MIN", Path: "n"}, ":0:null"},
+		{"empty", AggQuery{Func: "MAX", Path: "n"}, ":0:null"},
+		{"empty", AggQuery{Func: "COUNT", GroupPrefixLen: 2}, ""},
+		{"t", AggQuery{Func: "SUM", Path: "n", Prefix: "zz"}, ":0:0"},
+		{"t", AggQuery{Func: "AVG", Path: "n", Range: true, Start: "b", End: "a"}, ":0:null"},
+		{"t", AggQuery{Func: "AVG", Path: "missing"}, ":0:null"},
+	} {
+		res, err := e.Aggregate(tc.table, tc.q)
+		if err != nil {
+			t.Errorf("%s %+v: %v", tc.table, tc.q, err)
+			continue
+		}
+		if got := aggString(res); got != tc.want {
+			t.Errorf("%s %+v = %q, want %q", tc.table, tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		table string
+		q     AggQuery
+		want  string
+	}{
+		{"t", AggQuery{Func: "MEDIAN", Path: "n"}, "unknown aggregate"},
+		{"t", AggQuery{Func: "SUM"}, "requires a JSON path"},
+		{"t", AggQuery{Func: "AVG", Path: "*"}, "requires a JSON path"},
+		{"t", AggQuery{Func: "SUM", Path: "$."}, "invalid JSON path"},
+		{"missing", AggQuery{Func: "COUNT"}, "not found"},
+	} {
+		if _, err := e.Aggregate(tc.table, tc.q); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s %+v: got %v, want an error mentioning %q", tc.table, tc.q, err, tc.want)
+		}
+	}
+}
+
+func TestAggQueryFromArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want AggQuery
+	}{
+		{[]string{"", "", "", "COUNT", "", ""}, AggQuery{Func: "COUNT"}},
+		{[]string{"PREFIX", "eu", "", "SUM", "$.n", "2"}, AggQuery{Func: "SUM", Path: "$.n", Prefix: "eu", GroupPrefixLen: 2}},
+		{[]string{"RANGE", "a", "b", "AVG", "n", ""}, AggQuery{Func: "AVG", Path: "n", Range: true, Start: "a", End: "b"}},
+	} {
+		if got := AggQueryFromArgs(tc.args); got != tc.want {
+			t.Errorf("AggQueryFromArgs(%q) = %+v, want %+v", tc.args, got, tc.want)
+		}
+	}
+}
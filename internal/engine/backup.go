@@ -0,0 +1,14 @@
+package engine
+
+import "sharkDB/internal/storage"
+
+// ErrNoBackup is returned by Snapshot for storage backends other than
+// pager2, which have no backup format.
+var ErrNoBackup = storage.ErrNoBackup
+
+// Snapshot captures the database for a backup; see pager2.Snapshot. The
+// caller holds the write lock while taking it and may release the lock
+// before writing the snapshot out.
+func (e *Engine) Snapshot() (storage.Snapshot, error) {
+	return e.p.Snapshot()
+}
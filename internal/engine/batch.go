@@ -0,0 +1,247 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+)
+
+var (
+	ErrNotInteger = errors.New("value is not an integer")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+
+	// ErrTypedCounter is returned by IncrBy on a table with a schema, whose
+	// rows are objects and never integers. It wraps ErrNotInteger.
+	ErrTypedCounter = fmt.Errorf("%w: INCR and DECR need a table without a schema", ErrNotInteger)
+)
+
+// Batch stages writes against in-memory copies of the table trees it touches.
+// Reads through the batch see its own staged writes. Nothing reaches the
+// pager until Commit, which persists every modified tree in one atomic write;
+// dropping a batch without committing discards its changes.
+//
+// A Batch is not safe for concurrent use. Callers serialize writers with the
+// txn manager as for the other write operations.
+type Batch struct {
+	e     *Engine
+	trees map[uint64]*bptree.BPTree
+	dirty map[uint64]bool
+}
+
+// NewBatch starts an empty batch.
+func (e *Engine) NewBatch() *Batch {
+	return &Batch{e: e, trees: make(map[uint64]*bptree.BPTree), dirty: make(map[uint64]bool)}
+}
+
+// tree returns the staged tree for table, loading it on first use.
+func (b *Batch) tree(table string) (uint64, *bptree.BPTree, error) {
+	id, ok := b.e.c.GetTableID(table)
+	if !ok {
+		return 0, nil, catalog.TableNotFound(table)
+	}
+	t, err := b.treeByID(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, t, nil
+}
+
+// treeByID returns the staged tree stored under id, which may belong to a
+// table or an index.
+func (b *Batch) treeByID(id uint64) (*bptree.BPTree, error) {
+	if t, ok := b.trees[id]; ok {
+		return t, nil
+	}
+	t, err := b.e.c.LoadTree(id)
+	if err != nil {
+		return nil, err
+	}
+	b.trees[id] = t
+	return t, nil
+}
+
+// put writes key into the table tree t and keeps the table's indexes in step.
+// Rows of a table with a schema are validated and encoded first.
+func (b *Batch) put(id uint64, t *bptree.BPTree, key, value string, expiresAt int64) error {
+	stored := value
+	s, err := b.e.tableSchema(id)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		if stored, err = s.Encode(value); err != nil {
+			return err
+		}
+		// Index the row as reads will see it, with defaults filled in.
+		if value, err = s.Decode(stored); err != nil {
+			return err
+		}
+	}
+	old, had := t.Lookup(key)
+	oldValue := old.Value
+	if had {
+		if oldValue, err = b.e.decodeValue(id, old.Value); err != nil {
+			return err
+		}
+	}
+	t.Put(key, stored, expiresAt)
+	b.dirty[id] = true
+	return b.reindex(id, key, oldValue, had, value, true)
+}
+
+// remove deletes key from the table tree t and from the table's indexes.
+func (b *Batch) remove(id uint64, t *bptree.BPTree, key string) error {
+	old, had := t.Lookup(key)
+	if !had {
+		return nil
+	}
+	oldValue, err := b.e.decodeValue(id, old.Value)
+	if err != nil {
+		return err
+	}
+	t.Delete(key)
+	b.dirty[id] = true
+	return b.reindex(id, key, oldValue, true, "", false)
+}
+
+// Put stages an upsert of key in table.
+func (b *Batch) Put(table, key, value string) error {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return err
+	}
+	return b.put(id, t, key, value, 0)
+}
+
+// Delete stages removal of key from table. It fails if the key is absent.
+func (b *Batch) Delete(table, key string) error {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return err
+	}
+	if _, ok := lookupLive(t, key, nowNanos()); !ok {
+		return bptree.ErrKeyNotFound
+	}
+	return b.remove(id, t, key)
+}
+
+// IncrBy adds delta to the integer stored at key, treating a missing key as
+// 0, and returns the new value.
+func (b *Batch) IncrBy(table, key string, delta int64) (int64, error) {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return 0, err
+	}
+	if s, err := b.e.tableSchema(id); err != nil {
+		return 0, err
+	} else if s != nil {
+		return 0, ErrTypedCounter
+	}
+	var n int64
+	ent, ok := lookupLive(t, key, nowNanos())
+	if ok {
+		n, err = strconv.ParseInt(ent.Value, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+	// Like a counter in Redis, the key keeps any expiry it already had.
+	if err := b.put(id, t, key, strconv.FormatInt(n, 10), ent.ExpiresAt); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Truncate stages removal of every row of table and empties its indexes.
+// The table keeps its version sequence so that versions handed out before
+// the truncate are never reused.
+func (b *Batch) Truncate(table string) error {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return err
+	}
+	empty := bptree.New()
+	empty.Seq = t.Seq
+	b.trees[id] = empty
+	b.dirty[id] = true
+	for _, ix := range b.e.c.TableIndexes(id) {
+		b.trees[ix.TreeID] = bptree.New()
+		b.dirty[ix.TreeID] = true
+	}
+	return nil
+}
+
+// Get reads key from table, including writes staged in this batch.
+func (b *Batch) Get(table, key string) (string, error) {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return "", err
+	}
+	ent, ok := lookupLive(t, key, nowNanos())
+	if !ok {
+		return "", bptree.ErrKeyNotFound
+	}
+	return b.e.decodeValue(id, ent.Value)
+}
+
+// GetVersion is Engine.GetVersion over the batch's view of table.
+func (b *Batch) GetVersion(table, key string) (string, Version, error) {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return "", Version{}, err
+	}
+	ent, ok := lookupLive(t, key, nowNanos())
+	if !ok {
+		return "", Version{}, bptree.ErrKeyNotFound
+	}
+	v, err := b.e.decodeValue(id, ent.Value)
+	return v, Version{id, ent.Version}, err
+}
+
+// Scan is Engine.Scan over the batch's view of table.
+func (b *Batch) Scan(table, start string, limit int) ([][2]string, error) {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return nil, err
+	}
+	return b.e.decodePairs(id, rangeLive(t, start, "", limit, nowNanos()))
+}
+
+// PrefixScan is Engine.PrefixScan over the batch's view of table.
+func (b *Batch) PrefixScan(table, prefix string, limit int) ([][2]string, error) {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return nil, err
+	}
+	return b.e.decodePairs(id, rangeLive(t, prefix, prefix, limit, nowNanos()))
+}
+
+// ScanPage is Engine.ScanPage over the batch's view of table.
+func (b *Batch) ScanPage(table, prefix, from string, inclusive bool, size int) ([][2]string, bool, error) {
+	return b.SelectPage(table, prefix, from, inclusive, size, nil)
+}
+
+// Commit persists every tree modified by the batch atomically. A batch that
+// only read is a no-op.
+func (b *Batch) Commit() error {
+	if len(b.dirty) == 0 {
+		return nil
+	}
+	trees := make(map[uint64]*bptree.BPTree, len(b.dirty))
+	for id := range b.dirty {
+		trees[id] = b.trees[id]
+	}
+	if err := b.e.c.StoreTrees(trees); err != nil {
+		return err
+	}
+	b.dirty = make(map[uint64]bool)
+	return nil
+}
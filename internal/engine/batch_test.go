@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"sharkDB/internal/storage"
+)
+
+func TestIncrBy(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("plain"); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct{ delta, want int64 }{{5, 5}, {-2, 3}} {
+		n, err := e.IncrBy("plain", "c", step.delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != step.want {
+			t.Fatalf("IncrBy(%d) = %d, want %d", step.delta, n, step.want)
+		}
+	}
+	if _, err := e.Insert("plain", "s", "text"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.IncrBy("plain", "s", 1); !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("non-integer value: got %v, want ErrNotInteger", err)
+	}
+}
+
+func TestIncrByTypedTable(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.CreateWithSchema("users", "age int"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Insert("users", "alice", `{"age":25}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"alice", "missing"} {
+		if _, err := e.IncrBy("users", key, 1); !errors.Is(err, ErrTypedCounter) || !errors.Is(err, ErrNotInteger) {
+			t.Fatalf("%s: got %v, want ErrTypedCounter", key, err)
+		}
+	}
+	if v, err := e.Get("users", "alice"); err != nil || v != `{"age":25}` {
+		t.Fatalf("row changed: %q, %v", v, err)
+	}
+}
@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/extsort"
+	"sharkDB/internal/format"
+	"sharkDB/internal/jsonpath"
+	"sharkDB/internal/schema"
+)
+
+// A bulk load replaces per-row inserts with a sort and a rebuild. Rows are
+// fed to an external merge sort, which spills sorted runs to temporary files
+// once its buffer fills, so the input need not be sorted or fit in memory.
+// Commit merges the sorted rows with the rows already in the table, builds a
+// packed tree bottom-up in one pass, rebuilds the table's indexes the same
+// way and persists every tree in a single atomic write.
+//
+// Adding rows takes no lock; only Commit needs the caller to hold the write
+// lock, so a slow upload does not block other writers.
+
+// BulkLoad collects rows for one table. Close must be called to remove the
+// sort's temporary files.
+type BulkLoad struct {
+	e      *Engine
+	table  string
+	id     uint64
+	schema *schema.Schema
+	sorter *extsort.Sorter
+
+	skipped int
+	errs    []string
+}
+
+// NewBulkLoad starts a bulk load into table.
+func (e *Engine) NewBulkLoad(table string) (*BulkLoad, error) {
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return nil, catalog.TableNotFound(table)
+	}
+	s, err := e.tableSchema(id)
+	if err != nil {
+		return nil, err
+	}
+	return &BulkLoad{e: e, table: table, id: id, schema: s, sorter: extsort.New("", 0)}, nil
+}
+
+// Add queues an upsert of key. Rows of a table with a schema are validated
+// here, so a bad row fails before anything is written. When a key is added
+// twice the later value wins.
+func (l *BulkLoad) Add(key, value string) error {
+	if l.schema != nil {
+		var err error
+		if value, err = l.schema.Encode(value); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+	return l.sorter.Add(key, value)
+}
+
+// maxLoadErrors bounds the skipped-row errors kept for reporting.
+const maxLoadErrors = 10
+
+// LoadResult reports a bulk load.
+type LoadResult struct {
+	Table   string   `json:"table"`
+	Loaded  int      `json:"loaded"`
+	Skipped int      `json:"skipped,omitempty"`
+	Errors  []string `json:"errors,omitempty"` // the first skipped rows
+}
+
+func (r LoadResult) String() string {
+	s := fmt.Sprintf("Loaded %d rows into %s", r.Loaded, r.Table)
+	if r.Skipped > 0 {
+		s += fmt.Sprintf(" (%d skipped)", r.Skipped)
+	}
+	return s
+}
+
+// AddFrom adds every row of r. Errors name the input line. With skipBad,
+// rows that fail to parse or validate are skipped and reported by Commit
+// instead; errors reading the input still abort.
+func (l *BulkLoad) AddFrom(r format.RowReader, skipBad bool) error {
+	for {
+		k, v, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err == nil {
+			if err = l.Add(k, v); err != nil {
+				err = &format.LineError{Line: r.Line(), Err: err}
+			}
+		}
+		if err == nil {
+			continue
+		}
+		var le *format.LineError
+		if !skipBad || !errors.As(err, &le) {
+			return err
+		}
+		l.skipped++
+		if len(l.errs) < maxLoadErrors {
+			l.errs = append(l.errs, err.Error())
+		}
+	}
+}
+
+// Commit writes the queued rows. The result counts distinct keys loaded
+// and the rows AddFrom skipped. Loaded keys get no expiry and, unless the
+// load leaves their stored value unchanged, a new version; other rows keep
+// their version and expiry, except that rows which have already expired are
+// dropped. Unchanged rows therefore keep their ETags.
+func (l *BulkLoad) Commit() (LoadResult, error) {
+	if id, ok := l.e.c.GetTableID(l.table); !ok || id != l.id {
+		return LoadResult{}, catalog.TableNotFound(l.table)
+	}
+	old, err := l.e.c.LoadTree(l.id)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	it, err := l.sorter.Iter()
+	if err != nil {
+		return LoadResult{}, err
+	}
+	defer it.Close()
+
+	b := bptree.NewBuilder()
+	b.SetSeq(old.Seq)
+	loaded := 0
+	now := nowNanos()
+	k, v, more := it.Next()
+	var berr error
+	add := func(key string, ent bptree.Entry) bool {
+		berr = b.Add(key, ent)
+		return berr == nil
+	}
+	keep := func(key string, ent bptree.Entry) bool {
+		berr = b.Keep(key, ent)
+		return berr == nil
+	}
+	// Both sides are in key order; on a tie the loaded row wins.
+	old.AscendEntries("", func(ek string, ent bptree.Entry) bool {
+		for more && k < ek {
+			if !add(k, bptree.Entry{Value: v}) {
+				return false
+			}
+			loaded++
+			k, v, more = it.Next()
+		}
+		if ent.Expired(now) {
+			return true
+		}
+		if !more || k != ek {
+			return keep(ek, ent)
+		}
+		loaded++
+		ok := false
+		if v == ent.Value {
+			ok = keep(ek, bptree.Entry{Value: v, Version: ent.Version})
+		} else {
+			ok = add(ek, bptree.Entry{Value: v})
+		}
+		k, v, more = it.Next()
+		return ok
+	})
+	for ; more && berr == nil; k, v, more = it.Next() {
+		if add(k, bptree.Entry{Value: v}) {
+			loaded++
+		}
+	}
+	if berr != nil {
+		return LoadResult{}, berr
+	}
+	if err := it.Err(); err != nil {
+		return LoadResult{}, err
+	}
+	t := b.Finish()
+
+	trees := map[uint64]*bptree.BPTree{l.id: t}
+	for _, ix := range l.e.c.TableIndexes(l.id) {
+		if trees[ix.TreeID], err = l.e.buildIndex(l.id, t, ix.Field); err != nil {
+			return LoadResult{}, err
+		}
+	}
+	if err := l.e.c.StoreTrees(trees); err != nil {
+		return LoadResult{}, err
+	}
+	return LoadResult{Table: l.table, Loaded: loaded, Skipped: l.skipped, Errors: l.errs}, nil
+}
+
+// Close removes the temporary files of the load.
+func (l *BulkLoad) Close() error {
+	return l.sorter.Close()
+}
+
+// buildIndex builds the index on field of table id from scratch.
+func (e *Engine) buildIndex(id uint64, t *bptree.BPTree, field string) (*bptree.BPTree, error) {
+	p, err := jsonpath.Parse(field)
+	if err != nil {
+		return nil, err
+	}
+	s := extsort.New("", 0)
+	defer s.Close()
+	var derr error
+	t.Ascend("", func(k, v string) bool {
+		if v, derr = e.decodeValue(id, v); derr != nil {
+			return false
+		}
+		if ik, ok := indexEntry(p, v, k); ok {
+			derr = s.Add(ik, k)
+		}
+		return derr == nil
+	})
+	if derr != nil {
+		return nil, derr
+	}
+	it, err := s.Iter()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	b := bptree.NewBuilder()
+	for ik, pk, ok := it.Next(); ok; ik, pk, ok = it.Next() {
+		if err := b.Add(ik, bptree.Entry{Value: pk}); err != nil {
+			return nil, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return b.Finish(), nil
+}
+
+// Load bulk-loads the rows of r into table in one step. The caller holds the
+// write lock for the whole load.
+func (e *Engine) Load(table string, r format.RowReader, skipBad bool) (LoadResult, error) {
+	l, err := e.NewBulkLoad(table)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	defer l.Close()
+	if err := l.AddFrom(r, skipBad); err != nil {
+		return LoadResult{}, err
+	}
+	return l.Commit()
+}
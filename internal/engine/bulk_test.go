@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"testing"
+
+	"sharkDB/internal/storage"
+)
+
+func load(t *testing.T, e *Engine, table string, rows [][2]string) {
+	t.Helper()
+	l, err := e.NewBulkLoad(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, r := range rows {
+		if err := l.Add(r[0], r[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBulkLoadKeepsUnchangedVersions(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	load(t, e, "t", [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}})
+	before := make(map[string]Version)
+	for _, k := range []string{"a", "b", "c"} {
+		_, ver, err := e.GetVersion("t", k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before[k] = ver
+	}
+
+	// a is rewritten unchanged, b changes, c is untouched and d is new.
+	load(t, e, "t", [][2]string{{"a", "1"}, {"b", "20"}, {"d", "4"}})
+	for k, changed := range map[string]bool{"a": false, "b": true, "c": false} {
+		_, ver, err := e.GetVersion("t", k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ver != before[k]) != changed {
+			t.Errorf("%s: version %d -> %d, changed should be %v", k, before[k], ver, changed)
+		}
+	}
+	_, vd, err := e.GetVersion("t", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, vb, _ := e.GetVersion("t", "b")
+	if vd == vb || vd.Row <= before["c"].Row {
+		t.Errorf("new key d got version %d (b %d, c %d)", vd, vb, before["c"])
+	}
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"sharkDB/internal/storage"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		expected  string
+		err       error
+		want      string
+		missingOK bool
+	}{
+		{"matching value", "old", nil, "new", false},
+		{"different value", "other", ErrConflict, "old", false},
+		{"missing key", "old", ErrConflict, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := New(storage.NewMemory())
+			if _, err := e.Create("t"); err != nil {
+				t.Fatal(err)
+			}
+			key := "k"
+			if tc.missingOK {
+				key = "missing"
+			} else if _, err := e.Insert("t", key, "old"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.CompareAndSwap("t", key, tc.expected, "new"); !errors.Is(err, tc.err) {
+				t.Fatalf("CompareAndSwap: got %v, want %v", err, tc.err)
+			}
+			v, err := e.Get("t", key)
+			if tc.missingOK {
+				if err == nil {
+					t.Fatalf("a failed swap created the key: %q", v)
+				}
+				return
+			}
+			if err != nil || v != tc.want {
+				t.Fatalf("value = %q, %v; want %q", v, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetNX(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		value string
+		set   bool
+	}{{"first", true}, {"second", false}} {
+		if set, err := e.SetNX("t", "k", tc.value); err != nil || set != tc.set {
+			t.Fatalf("SetNX(%s) = %v, %v; want %v", tc.value, set, err, tc.set)
+		}
+	}
+	if v, err := e.Get("t", "k"); err != nil || v != "first" {
+		t.Fatalf("value = %q, %v", v, err)
+	}
+	if _, err := e.SetNX("nope", "k", "v"); err == nil {
+		t.Fatal("SetNX on a missing table succeeded")
+	}
+}
+
+// Every write gives a key a new version, and a table created again after
+// a drop never hands out a version the old one did.
+func TestVersions(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[Version]bool)
+	for _, write := range []func() error{
+		func() error { _, err := e.Insert("t", "k", "a"); return err },
+		func() error { _, err := e.Update("t", "k", "a"); return err },
+		func() error { _, err := e.CompareAndSwap("t", "k", "a", "5"); return err },
+		func() error { _, err := e.IncrBy("t", "k", 1); return err },
+		func() error {
+			if _, err := e.Drop("t"); err != nil {
+				return err
+			}
+			if _, err := e.Create("t"); err != nil {
+				return err
+			}
+			_, err := e.Insert("t", "k", "a")
+			return err
+		},
+	} {
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		_, ver, err := e.GetVersion("t", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[ver] {
+			t.Fatalf("version %+v repeated", ver)
+		}
+		seen[ver] = true
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/storage"
+)
+
+// COMPRESS records the method and rewrites the table and its index in one
+// commit, and the rows read back after a reopen.
+func TestCompressOneCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	p, err := pager2.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(storage.Pager2(p))
+	if _, err := e.Create("users"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := e.Insert("users", fmt.Sprintf("u%02d", i), fmt.Sprintf(`{"age":%d,"bio":"the same words again and again"}`, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.CreateIndex("by_age", "users", "age"); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"best", "fast", "none"} {
+		lsn := p.Meta().LSN
+		if _, err := e.Compress("users", method); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Meta().LSN; got != lsn+1 {
+			t.Fatalf("COMPRESS %s took %d commits, want 1", method, got-lsn)
+		}
+		s, err := e.Stats("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Compression != method || s.Count != 50 {
+			t.Fatalf("after COMPRESS %s: %+v", method, s)
+		}
+	}
+	if _, err := e.Compress("users", "best"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = pager2.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	e = New(storage.Pager2(p))
+	if v, err := e.Get("users", "u07"); err != nil || v != `{"age":7,"bio":"the same words again and again"}` {
+		t.Fatalf("Get after reopen = %q, %v", v, err)
+	}
+	if s, err := e.Stats("users"); err != nil || s.Compression != "best" || s.Bytes >= s.RawBytes {
+		t.Fatalf("Stats after reopen = %+v, %v", s, err)
+	}
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/format"
+)
+
+// Dump writes every live row of table to w in key order and returns the
+// number written. Rows stream straight from the tree without being
+// collected first. w is flushed on success.
+func (e *Engine) Dump(table string, w format.RowWriter) (int, error) {
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return 0, catalog.TableNotFound(table)
+	}
+	t, err := e.c.LoadTree(id)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	now := nowNanos()
+	var werr error
+	t.AscendEntries("", func(k string, ent bptree.Entry) bool {
+		if ent.Expired(now) {
+			return true
+		}
+		v, err := e.decodeValue(id, ent.Value)
+		if err == nil {
+			err = w.Write(k, v)
+		}
+		if err != nil {
+			werr = err
+			return false
+		}
+		n++
+		return true
+	})
+	if werr != nil {
+		return n, werr
+	}
+	return n, w.Flush()
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"sharkDB/internal/format"
+	"sharkDB/internal/storage"
+)
+
+// Every format carries a table out through Dump and back in through Load.
+func TestDumpLoadRoundTrip(t *testing.T) {
+	rows := [][2]string{
+		{"a", "tab\there"},
+		{"b", "two\nlines"},
+		{"c", `{"n":1,"s":"x"}`},
+		{"d", `back\slash`},
+		{"e", ""},
+	}
+	for _, o := range []format.Options{
+		{Format: format.TSV},
+		{Format: format.TSV, Header: true, KeyColumn: "id"},
+		{Format: format.CSV, Header: true},
+		{Format: format.JSONL},
+	} {
+		e := New(storage.NewMemory())
+		for _, name := range []string{"src", "dst"} {
+			if _, err := e.Create(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		load(t, e, "src", rows)
+		var buf bytes.Buffer
+		w, err := format.NewWriter(&buf, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := e.Dump("src", w); err != nil || n != len(rows) {
+			t.Fatalf("%s: Dump = %d, %v", o.Format, n, err)
+		}
+		r, err := format.NewReader(&buf, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := e.Load("dst", r, false)
+		if err != nil || res.Loaded != len(rows) {
+			t.Fatalf("%s: Load = %+v, %v", o.Format, res, err)
+		}
+		got, err := e.Scan("dst", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range rows {
+			if i >= len(got) || got[i] != want {
+				t.Fatalf("%s: loaded %q, want %q", o.Format, got, rows)
+			}
+		}
+	}
+}
+
+func TestLoadErrorPolicy(t *testing.T) {
+	input := "a\t1\nbroken\nb\t2\nc\n"
+	for _, tc := range []struct {
+		skip    bool
+		loaded  int
+		skipped int
+		err     string
+	}{
+		{false, 0, 0, "line 2: missing tab"},
+		{true, 2, 2, ""},
+	} {
+		e := New(storage.NewMemory())
+		if _, err := e.Create("t"); err != nil {
+			t.Fatal(err)
+		}
+		r, err := format.NewReader(strings.NewReader(input), format.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := e.Load("t", r, tc.skip)
+		if tc.err != "" {
+			var le *format.LineError
+			if !errors.As(err, &le) || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Fatalf("abort: got %v, want %q", err, tc.err)
+			}
+			if n, _ := e.Count("t"); n != 0 {
+				t.Fatalf("an aborted load stored %d rows", n)
+			}
+			continue
+		}
+		if err != nil || res.Loaded != tc.loaded || res.Skipped != tc.skipped || len(res.Errors) != tc.skipped {
+			t.Fatalf("skip: %+v, %v", res, err)
+		}
+		if !strings.HasPrefix(res.Errors[1], "line 4:") {
+			t.Fatalf("skipped row errors %q do not name line 4", res.Errors)
+		}
+	}
+}
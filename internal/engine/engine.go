@@ -1,25 +1,33 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 
 	"sharkDB/internal/bptree"
 	"sharkDB/internal/catalog"
-	"sharkDB/internal/pager2"
+	"sharkDB/internal/compress"
+	"sharkDB/internal/storage"
 )
 
-// Engine wires pager, catalog and per-table trees. It loads the tree on-demand,
+// ErrConflict is returned by conditional writes whose precondition does not hold.
+var ErrConflict = errors.New("precondition failed")
+
+// Engine wires storage, catalog and per-table trees. It loads the tree on-demand,
 // mutates it, and persists after write operations.
 
 type Engine struct {
-	p *pager2.Pager
+	p storage.Storage
 	c *catalog.Catalog
+
+	swept bool // SweepExpired has checked every table once
 }
 
-func New(p *pager2.Pager) *Engine {
+func New(p storage.Storage) *Engine {
 	return &Engine{p: p, c: catalog.New(p)}
 }
 
+// Create makes a schemaless table; see CreateWithSchema for typed tables.
 func (e *Engine) Create(table string) (string, error) {
 	if err := e.c.CreateTable(table); err != nil {
 		return "", err
@@ -28,16 +36,11 @@ func (e *Engine) Create(table string) (string, error) {
 }
 
 func (e *Engine) Insert(table, key, value string) (string, error) {
-	id, ok := e.c.GetTableID(table)
-	if !ok {
-		return "", fmt.Errorf("table %s not found", table)
-	}
-	tree, err := e.c.LoadTree(id)
-	if err != nil {
+	b := e.NewBatch()
+	if err := b.Put(table, key, value); err != nil {
 		return "", err
 	}
-	tree.Insert(key, value)
-	if err := e.c.StoreTree(id, tree); err != nil {
+	if err := b.Commit(); err != nil {
 		return "", err
 	}
 	return "OK", nil
@@ -46,37 +49,113 @@ func (e *Engine) Insert(table, key, value string) (string, error) {
 func (e *Engine) Get(table, key string) (string, error) {
 	id, ok := e.c.GetTableID(table)
 	if !ok {
-		return "", fmt.Errorf("table %s not found", table)
+		return "", catalog.TableNotFound(table)
 	}
 	tree, err := e.c.LoadTree(id)
 	if err != nil {
 		return "", err
 	}
-	v, ok := tree.Get(key)
+	ent, ok := lookupLive(tree, key, nowNanos())
 	if !ok {
 		return "", bptree.ErrKeyNotFound
 	}
-	return v, nil
+	return e.decodeValue(id, ent.Value)
 }
 
-func (e *Engine) Update(table, key, value string) (string, error) {
-	// Upsert semantics
-	return e.Insert(table, key, value)
+// Version identifies one write of a key, for optimistic concurrency
+// control. Row is the version the write assigned, unique within the table.
+// Row versions start over in a table created after one of the same name
+// was dropped, so Table holds the table's id, which is never reused.
+type Version struct {
+	Table uint64
+	Row   uint64
 }
 
-func (e *Engine) Delete(table, key string) (string, error) {
+// GetVersion returns the value of key together with its version. Every write
+// to a key assigns it a new version.
+func (e *Engine) GetVersion(table, key string) (string, Version, error) {
 	id, ok := e.c.GetTableID(table)
 	if !ok {
-		return "", fmt.Errorf("table %s not found", table)
+		return "", Version{}, catalog.TableNotFound(table)
 	}
 	tree, err := e.c.LoadTree(id)
+	if err != nil {
+		return "", Version{}, err
+	}
+	ent, ok := lookupLive(tree, key, nowNanos())
+	if !ok {
+		return "", Version{}, bptree.ErrKeyNotFound
+	}
+	v, err := e.decodeValue(id, ent.Value)
+	return v, Version{id, ent.Version}, err
+}
+
+// CompareAndSwap sets key to value only if its current value equals expected.
+// It returns ErrConflict if the key is missing or holds a different value.
+func (e *Engine) CompareAndSwap(table, key, expected, value string) (string, error) {
+	b := e.NewBatch()
+	cur, err := b.Get(table, key)
+	if errors.Is(err, bptree.ErrKeyNotFound) || (err == nil && cur != expected) {
+		return "", ErrConflict
+	}
 	if err != nil {
 		return "", err
 	}
-	if ok := tree.Delete(key); !ok {
-		return "", bptree.ErrKeyNotFound
+	if err := b.Put(table, key, value); err != nil {
+		return "", err
+	}
+	if err := b.Commit(); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+// SetNX inserts key only if it is absent and reports whether it did.
+func (e *Engine) SetNX(table, key, value string) (bool, error) {
+	b := e.NewBatch()
+	_, err := b.Get(table, key)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, bptree.ErrKeyNotFound) {
+		return false, err
+	}
+	if err := b.Put(table, key, value); err != nil {
+		return false, err
+	}
+	if err := b.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IncrBy atomically adds delta to the integer value of key and returns the
+// result. A missing key counts as 0. It fails with ErrNotInteger if the
+// current value is not a base-10 int64, with ErrTypedCounter on a table with
+// a schema and with ErrOverflow if the result would not fit in one.
+func (e *Engine) IncrBy(table, key string, delta int64) (int64, error) {
+	b := e.NewBatch()
+	n, err := b.IncrBy(table, key, delta)
+	if err != nil {
+		return 0, err
 	}
-	if err := e.c.StoreTree(id, tree); err != nil {
+	if err := b.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (e *Engine) Update(table, key, value string) (string, error) {
+	// Upsert semantics
+	return e.Insert(table, key, value)
+}
+
+func (e *Engine) Delete(table, key string) (string, error) {
+	b := e.NewBatch()
+	if err := b.Delete(table, key); err != nil {
+		return "", err
+	}
+	if err := b.Commit(); err != nil {
 		return "", err
 	}
 	return "OK", nil
@@ -96,51 +175,59 @@ func (e *Engine) ListTables() []string {
 func (e *Engine) Scan(table, start string, limit int) ([][2]string, error) {
 	id, ok := e.c.GetTableID(table)
 	if !ok {
-		return nil, fmt.Errorf("table %s not found", table)
+		return nil, catalog.TableNotFound(table)
 	}
 	tree, err := e.c.LoadTree(id)
 	if err != nil {
 		return nil, err
 	}
-	return tree.RangeFrom(start, limit), nil
+	return e.decodePairs(id, rangeLive(tree, start, "", limit, nowNanos()))
 }
 
 func (e *Engine) Count(table string) (int, error) {
 	id, ok := e.c.GetTableID(table)
 	if !ok {
-		return 0, fmt.Errorf("table %s not found", table)
+		return 0, catalog.TableNotFound(table)
 	}
 	tree, err := e.c.LoadTree(id)
 	if err != nil {
 		return 0, err
 	}
-	pairs := tree.RangeFrom("", 0)
-	return len(pairs), nil
+	return countRange(tree, "", "", false, nowNanos()), nil
 }
 
 func (e *Engine) Exists(table, key string) (bool, error) {
 	id, ok := e.c.GetTableID(table)
 	if !ok {
-		return false, fmt.Errorf("table %s not found", table)
+		return false, catalog.TableNotFound(table)
 	}
 	tree, err := e.c.LoadTree(id)
 	if err != nil {
 		return false, err
 	}
-	_, ok = tree.Get(key)
+	_, ok = lookupLive(tree, key, nowNanos())
 	return ok, nil
 }
 
 func (e *Engine) PrefixScan(table, prefix string, limit int) ([][2]string, error) {
 	id, ok := e.c.GetTableID(table)
 	if !ok {
-		return nil, fmt.Errorf("table %s not found", table)
+		return nil, catalog.TableNotFound(table)
 	}
 	tree, err := e.c.LoadTree(id)
 	if err != nil {
 		return nil, err
 	}
-	return tree.RangePrefix(prefix, limit), nil
+	return e.decodePairs(id, rangeLive(tree, prefix, prefix, limit, nowNanos()))
+}
+
+// ScanPage returns up to size pairs whose key has prefix, in key order, for
+// paginated reads. Iteration begins at from when inclusive is set, otherwise
+// strictly after it, so a page resumed from the last key it returned neither
+// skips nor repeats rows when other keys are written in between. The boolean
+// result reports whether more matching rows follow the page.
+func (e *Engine) ScanPage(table, prefix, from string, inclusive bool, size int) ([][2]string, bool, error) {
+	return e.SelectPage(table, prefix, from, inclusive, size, nil)
 }
 
 func (e *Engine) Rename(oldName, newName string) (string, error) {
@@ -151,42 +238,95 @@ func (e *Engine) Rename(oldName, newName string) (string, error) {
 }
 
 func (e *Engine) Truncate(table string) (string, error) {
-	id, ok := e.c.GetTableID(table)
-	if !ok {
-		return "", fmt.Errorf("table %s not found", table)
+	b := e.NewBatch()
+	if err := b.Truncate(table); err != nil {
+		return "", err
 	}
-	// Replace with a fresh empty tree
-	empty := bptree.New()
-	if err := e.c.StoreTree(id, empty); err != nil {
+	if err := b.Commit(); err != nil {
 		return "", err
 	}
 	return "OK", nil
 }
 
 type Stats struct {
-	Count  int
-	Height int
-	MinKey string
-	MaxKey string
+	Count  int    `json:"count"`
+	Height int    `json:"height"`
+	MinKey string `json:"min_key"`
+	MaxKey string `json:"max_key"`
+
+	// Compression is the table's method. Bytes is what its tree takes in the
+	// file and RawBytes what it would take uncompressed; Ratio is RawBytes
+	// over Bytes.
+	Compression string  `json:"compression"`
+	Bytes       int     `json:"bytes"`
+	RawBytes    int     `json:"raw_bytes"`
+	Ratio       float64 `json:"ratio"`
+}
+
+// String formats the stats as the STATS command prints them.
+func (s Stats) String() string {
+	return fmt.Sprintf("count=%d height=%d min=%s max=%s compression=%s bytes=%d raw=%d ratio=%.2f",
+		s.Count, s.Height, s.MinKey, s.MaxKey, s.Compression, s.Bytes, s.RawBytes, s.Ratio)
 }
 
 func (e *Engine) Stats(table string) (Stats, error) {
 	var s Stats
 	id, ok := e.c.GetTableID(table)
 	if !ok {
-		return s, fmt.Errorf("table %s not found", table)
+		return s, catalog.TableNotFound(table)
 	}
 	tree, err := e.c.LoadTree(id)
 	if err != nil {
 		return s, err
 	}
-	s.Count = len(tree.RangeFrom("", 0))
+	now := nowNanos()
+	s.Count = countRange(tree, "", "", false, now)
 	s.Height = tree.Height()
-	if k, ok := tree.LeftmostKey(); ok {
-		s.MinKey = k
+	if s.Count > 0 {
+		s.MinKey, _, _ = nthLive(tree, 0, now)
+		s.MaxKey, _, _ = nthLive(tree, -1, now)
+	}
+	s.Compression = e.c.TableCompression(id).String()
+	if s.Bytes, s.RawBytes, err = e.c.TreeSize(id); err != nil {
+		return s, err
 	}
-	if k, ok := tree.RightmostKey(); ok {
-		s.MaxKey = k
+	s.Ratio = 1
+	if s.Bytes > 0 {
+		s.Ratio = float64(s.RawBytes) / float64(s.Bytes)
 	}
 	return s, nil
 }
+
+// Compress sets the compression method of a table, one of none, fast or
+// best, and rewrites its tree and the trees of its indexes with it in the
+// same commit.
+func (e *Engine) Compress(table, method string) (string, error) {
+	m, err := compress.Parse(method)
+	if err != nil {
+		return "", err
+	}
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return "", catalog.TableNotFound(table)
+	}
+	trees := make(map[uint64]*bptree.BPTree)
+	ids := []uint64{id}
+	for _, ix := range e.c.TableIndexes(id) {
+		ids = append(ids, ix.TreeID)
+	}
+	for _, tid := range ids {
+		t, err := e.c.LoadTree(tid)
+		if err != nil {
+			return "", err
+		}
+		trees[tid] = t
+	}
+	if err := e.c.CompressTable(id, m, trees); err != nil {
+		return "", err
+	}
+	stored, raw, err := e.c.TreeSize(id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Table %s now uses %s compression: %d bytes, %d raw", table, m, stored, raw), nil
+}
@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/jsonpath"
+)
+
+// Secondary indexes map a field extracted from JSON values back to primary
+// keys. Each index is a B+ tree whose keys are the encoded field value
+// followed by the primary key, so rows sharing a value stay adjacent and
+// sorted. Values that are not JSON, lack the field, or hold an object or
+// array there are simply not indexed. Batch keeps every index of a table in
+// step with its rows, and the index trees are committed in the same atomic
+// write as the table.
+
+// Type tags order the encoded values: false/true, then numbers, then strings.
+const (
+	tagBool   = 0x02
+	tagNumber = 0x03
+	tagString = 0x04
+)
+
+// encodeIndexValue encodes a JSON scalar so that byte order matches value
+// order and no encoding is a prefix of another.
+func encodeIndexValue(v any) (string, bool) {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return "", false
+		}
+		v = f
+	}
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return string([]byte{tagBool, 1}), true
+		}
+		return string([]byte{tagBool, 0}), true
+	case float64:
+		if x == 0 {
+			x = 0 // fold -0 into 0
+		}
+		bits := math.Float64bits(x)
+		if x < 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		var buf [9]byte
+		buf[0] = tagNumber
+		binary.BigEndian.PutUint64(buf[1:], bits)
+		return string(buf[:]), true
+	case string:
+		// 0x00 is escaped as 0x00 0xff and the value ends with 0x00 0x01.
+		return string([]byte{tagString}) + strings.ReplaceAll(x, "\x00", "\x00\xff") + "\x00\x01", true
+	}
+	return "", false
+}
+
+func indexEntry(p jsonpath.Path, value, pk string) (string, bool) {
+	v, ok := p.Extract(value)
+	if !ok {
+		return "", false
+	}
+	enc, ok := encodeIndexValue(v)
+	if !ok {
+		return "", false
+	}
+	return enc + pk, true
+}
+
+// reindex updates the indexes of table id after key changed from old (if
+// had) to value (if has).
+func (b *Batch) reindex(id uint64, key, old string, had bool, value string, has bool) error {
+	for _, ix := range b.e.c.TableIndexes(id) {
+		p, err := jsonpath.Parse(ix.Field)
+		if err != nil {
+			return err
+		}
+		var oldKey, newKey string
+		var oldOK, newOK bool
+		if had {
+			oldKey, oldOK = indexEntry(p, old, key)
+		}
+		if has {
+			newKey, newOK = indexEntry(p, value, key)
+		}
+		if oldOK == newOK && oldKey == newKey {
+			continue
+		}
+		it, err := b.treeByID(ix.TreeID)
+		if err != nil {
+			return err
+		}
+		if oldOK {
+			it.Delete(oldKey)
+		}
+		if newOK {
+			it.Insert(newKey, key)
+		}
+		b.dirty[ix.TreeID] = true
+	}
+	return nil
+}
+
+// CreateIndex creates an index on field (a JSON path such as "age" or
+// "$.address.city") of table and fills it from the existing rows.
+func (e *Engine) CreateIndex(name, table, field string) (string, error) {
+	p, err := jsonpath.Parse(field)
+	if err != nil {
+		return "", err
+	}
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return "", catalog.TableNotFound(table)
+	}
+	t, err := e.c.LoadTree(id)
+	if err != nil {
+		return "", err
+	}
+	ix, err := e.c.CreateIndex(name, id, p.String())
+	if err != nil {
+		return "", err
+	}
+	// Expired rows are indexed too; the sweeper removes them from the
+	// index when it deletes them.
+	it := bptree.New()
+	n := 0
+	var derr error
+	t.Ascend("", func(k, v string) bool {
+		if v, derr = e.decodeValue(id, v); derr != nil {
+			return false
+		}
+		if ik, ok := indexEntry(p, v, k); ok {
+			it.Insert(ik, k)
+			n++
+		}
+		return true
+	})
+	if derr != nil {
+		_ = e.c.DropIndex(name)
+		return "", derr
+	}
+	if err := e.c.StoreTree(ix.TreeID, it); err != nil {
+		_ = e.c.DropIndex(name)
+		return "", err
+	}
+	return fmt.Sprintf("Index %s created on %s (%s), %d rows indexed", name, table, p, n), nil
+}
+
+// DropIndex removes an index.
+func (e *Engine) DropIndex(name string) (string, error) {
+	if err := e.c.DropIndex(name); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Index %s dropped", name), nil
+}
+
+// Find returns the rows of table whose JSON field compares to literal with
+// op (= != < <= > >=). literal is read as JSON when it parses as a number,
+// string, bool or null and as a bare string otherwise. When an index covers
+// field the query walks the index and returns rows in field order;
+// otherwise it scans the table and returns rows in key order.
+func (e *Engine) Find(table, field, op, literal string) ([][2]string, error) {
+	p, err := jsonpath.Parse(field)
+	if err != nil {
+		return nil, err
+	}
+	if !jsonpath.ValidOp(op) {
+		return nil, fmt.Errorf("unknown operator %q", op)
+	}
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return nil, catalog.TableNotFound(table)
+	}
+	t, err := e.c.LoadTree(id)
+	if err != nil {
+		return nil, err
+	}
+	lit := jsonpath.ParseLiteral(literal)
+	now := nowNanos()
+	var out [][2]string
+	var derr error
+	match := func(k string, ent bptree.Entry) {
+		if ent.Expired(now) || derr != nil {
+			return
+		}
+		value, err := e.decodeValue(id, ent.Value)
+		if err != nil {
+			derr = err
+			return
+		}
+		if v, ok := p.Extract(value); ok && jsonpath.Match(v, op, lit) {
+			out = append(out, [2]string{k, value})
+		}
+	}
+	if ix, ok := e.indexFor(id, p); ok && op != "!=" {
+		if enc, ok := encodeIndexValue(lit); ok {
+			it, err := e.c.LoadTree(ix.TreeID)
+			if err != nil {
+				return nil, err
+			}
+			for _, pk := range indexRange(it, enc, op) {
+				if ent, ok := t.Lookup(pk); ok {
+					match(pk, ent)
+				}
+			}
+			return out, derr
+		}
+	}
+	t.AscendEntries("", func(k string, ent bptree.Entry) bool {
+		match(k, ent)
+		return derr == nil
+	})
+	return out, derr
+}
+
+func (e *Engine) indexFor(tableID uint64, p jsonpath.Path) (catalog.Index, bool) {
+	for _, ix := range e.c.TableIndexes(tableID) {
+		if ix.Field == p.String() {
+			return ix, true
+		}
+	}
+	return catalog.Index{}, false
+}
+
+// indexRange returns the primary keys in index tree it whose value compares
+// to the encoded value enc with op. Comparisons never cross value types.
+func indexRange(it *bptree.BPTree, enc, op string) []string {
+	typ := enc[:1]
+	start := enc
+	if op == "<" || op == "<=" {
+		start = typ
+	}
+	var pks []string
+	it.Ascend(start, func(k, pk string) bool {
+		same := strings.HasPrefix(k, enc)
+		switch op {
+		case "=", "==":
+			if !same {
+				return false
+			}
+		case ">":
+			if same {
+				return true
+			}
+			if !strings.HasPrefix(k, typ) {
+				return false
+			}
+		case ">=":
+			if !strings.HasPrefix(k, typ) {
+				return false
+			}
+		case "<":
+			if k >= enc {
+				return false
+			}
+		case "<=":
+			if k >= enc && !same {
+				return false
+			}
+		}
+		pks = append(pks, pk)
+		return true
+	})
+	return pks
+}
@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"sharkDB/internal/storage"
+)
+
+func TestEncodeIndexValueOrder(t *testing.T) {
+	// In ascending order; each must encode below the next.
+	values := []any{
+		false, true,
+		-1e300, -2.5, -1.0, 0.0, json.Number("0.5"), 1.0, 2.0, 1e300,
+		"", "\x00", "\x00a", "a", "a\x00", "ab", "b",
+	}
+	var prev string
+	for i, v := range values {
+		enc, ok := encodeIndexValue(v)
+		if !ok {
+			t.Fatalf("%#v not encodable", v)
+		}
+		if i > 0 && prev >= enc {
+			t.Errorf("%#v does not encode above %#v", v, values[i-1])
+		}
+		prev = enc
+	}
+	for _, v := range []any{nil, map[string]any{}, []any{1}, json.Number("x")} {
+		if _, ok := encodeIndexValue(v); ok {
+			t.Errorf("%#v encoded", v)
+		}
+	}
+	negZero, _ := encodeIndexValue(-1 * 0.0)
+	zero, _ := encodeIndexValue(0.0)
+	if negZero != zero {
+		t.Error("-0 and 0 encode differently")
+	}
+}
+
+// findRows are the rows Find is tried against: numbers, strings, bools,
+// a missing field, an object where a scalar is expected and non-JSON.
+var findRows = [][2]string{
+	{"u1", `{"age":30,"city":"Oslo"}`},
+	{"u2", `{"age":25,"city":"Bergen"}`},
+	{"u3", `{"age":30.5}`},
+	{"u4", `{"age":"30"}`},
+	{"u5", `{"age":true}`},
+	{"u6", `{"city":"Oslo"}`},
+	{"u7", `{"age":{"years":30}}`},
+	{"u8", `not json`},
+	{"u9", `{"age":-4,"city":"Oslo"}`},
+}
+
+func TestFind(t *testing.T) {
+	for _, tc := range []struct {
+		field, op, lit string
+		want           string // matching keys, sorted
+	}{
+		{"age", "=", "30", "u1"},
+		{"age", "==", "30", "u1"},
+		{"$.age", "!=", "30", "u2 u3 u4 u5 u7 u9"},
+		{"age", ">", "25", "u1 u3"},
+		{"age", ">=", "25", "u1 u2 u3"},
+		{"age", "<", "30", "u2 u9"},
+		{"age", "<=", "30", "u1 u2 u9"},
+		{"age", ">", "100", ""},
+		{"age", "<", "-100", ""},
+		{"age", "=", `"30"`, "u4"},
+		{"age", ">", `"1"`, "u4"},
+		{"age", "=", "true", "u5"},
+		{"city", "=", "Oslo", "u1 u6 u9"},
+		{"city", ">=", "Bergen", "u1 u2 u6 u9"},
+		{"city", "<", "Oslo", "u2"},
+		{"nope", "=", "1", ""},
+	} {
+		for _, indexed := range []bool{false, true} {
+			e := New(storage.NewMemory())
+			if _, err := e.Create("users"); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range findRows {
+				if _, err := e.Insert("users", r[0], r[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if indexed {
+				if _, err := e.CreateIndex("ix", "users", tc.field); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rows, err := e.Find("users", tc.field, tc.op, tc.lit)
+			if err != nil {
+				t.Fatalf("FIND %s %s %s: %v", tc.field, tc.op, tc.lit, err)
+			}
+			if got := rowKeys(rows); got != tc.want {
+				t.Errorf("FIND %s %s %s (indexed %v) = %q, want %q", tc.field, tc.op, tc.lit, indexed, got, tc.want)
+			}
+		}
+	}
+}
+
+// rowKeys returns the keys of rows, sorted and joined by spaces.
+func rowKeys(rows [][2]string) string {
+	keys := make([]string, len(rows))
+	for i, r := range rows {
+		keys[i] = r[0]
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
+
+// Writes after CREATE INDEX keep the index in step with the rows, and an
+// indexed FIND returns rows in field order.
+func TestIndexMaintained(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CreateIndex("by_age", "users", "age"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := e.Insert("users", fmt.Sprintf("u%02d", i), fmt.Sprintf(`{"age":%d}`, 40-i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := e.NewBatch()
+	for _, step := range []struct {
+		key, value string // empty value deletes
+	}{
+		{"u00", `{"age":1}`},
+		{"u01", ""},
+		{"u02", `{"name":"no age"}`},
+		{"u03", `{"age":1}`},
+	} {
+		var err error
+		if step.value == "" {
+			err = b.Delete("users", step.key)
+		} else {
+			err = b.Put("users", step.key, step.value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := e.Find("users", "age", "<", "25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r[0])
+	}
+	want := "u00 u03 u19 u18 u17 u16"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("indexed FIND = %v, want %s in age order", got, want)
+	}
+	if rows, err := e.Find("users", "age", "=", "39"); err != nil || len(rows) != 0 {
+		t.Fatalf("deleted row still found: %v, %v", rows, err)
+	}
+	if rows, err := e.Find("users", "age", "=", "38"); err != nil || len(rows) != 0 {
+		t.Fatalf("row that lost its field still found: %v, %v", rows, err)
+	}
+}
+
+func TestFindErrors(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("users"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ table, field, op string }{
+		{"users", "age", "~"},
+		{"users", "$.", "="},
+		{"missing", "age", "="},
+	} {
+		if _, err := e.Find(tc.table, tc.field, tc.op, "1"); err == nil {
+			t.Errorf("FIND %s WHERE %s %s 1 succeeded", tc.table, tc.field, tc.op)
+		}
+	}
+	if _, err := e.CreateIndex("ix", "missing", "age"); err == nil {
+		t.Error("CREATE INDEX on a missing table succeeded")
+	}
+	if _, err := e.CreateIndex("ix", "users", "age"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CreateIndex("ix", "users", "city"); err == nil {
+		t.Error("CREATE INDEX with a taken name succeeded")
+	}
+	if _, err := e.DropIndex("ix"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.DropIndex("ix"); err == nil {
+		t.Error("DROP INDEX of a dropped index succeeded")
+	}
+}
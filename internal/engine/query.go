@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"strings"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/jsonpath"
+)
+
+// Selection filters and projects JSON values during a scan, so that only
+// matching rows, trimmed to the requested fields, leave the engine. Rows
+// that are not JSON never match a selection.
+type Selection struct {
+	Filter *jsonpath.Filter
+	Fields []jsonpath.Path
+}
+
+// ParseSelection builds a selection from a filter expression and a
+// comma-separated field list. Either may be empty; if both are, it
+// returns nil, which selects every row unchanged.
+func ParseSelection(filter, fields string) (*Selection, error) {
+	if strings.TrimSpace(filter) == "" && strings.TrimSpace(fields) == "" {
+		return nil, nil
+	}
+	sel := &Selection{}
+	var err error
+	if strings.TrimSpace(filter) != "" {
+		if sel.Filter, err = jsonpath.ParseFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+	if strings.TrimSpace(fields) != "" {
+		if sel.Fields, err = jsonpath.ParseFields(fields); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// apply returns the value to emit for a row and whether the row matches.
+func (s *Selection) apply(value string) (string, bool, error) {
+	if s == nil {
+		return value, true, nil
+	}
+	doc, err := jsonpath.Decode(value)
+	if err != nil {
+		return "", false, nil
+	}
+	if s.Filter != nil && !s.Filter.Match(doc) {
+		return "", false, nil
+	}
+	if s.Fields == nil {
+		return value, true, nil
+	}
+	out, err := jsonpath.Project(doc, s.Fields)
+	return out, err == nil, err
+}
+
+// SelectPage is ScanPage with a selection applied while iterating: up to
+// size matching rows are returned and the boolean reports whether more
+// matching rows follow.
+func (e *Engine) SelectPage(table, prefix, from string, inclusive bool, size int, sel *Selection) ([][2]string, bool, error) {
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return nil, false, catalog.TableNotFound(table)
+	}
+	tree, err := e.c.LoadTree(id)
+	if err != nil {
+		return nil, false, err
+	}
+	return e.selectPage(id, tree, prefix, from, inclusive, size, sel)
+}
+
+// SelectPage is Engine.SelectPage over the batch's view of table.
+func (b *Batch) SelectPage(table, prefix, from string, inclusive bool, size int, sel *Selection) ([][2]string, bool, error) {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return nil, false, err
+	}
+	return b.e.selectPage(id, t, prefix, from, inclusive, size, sel)
+}
+
+func (e *Engine) selectPage(id uint64, t *bptree.BPTree, prefix, from string, inclusive bool, size int, sel *Selection) ([][2]string, bool, error) {
+	if from < prefix {
+		from, inclusive = prefix, true
+	}
+	now := nowNanos()
+	var out [][2]string
+	more := false
+	var ierr error
+	t.AscendEntries(from, func(k string, ent bptree.Entry) bool {
+		if !inclusive && k == from {
+			return true
+		}
+		if !strings.HasPrefix(k, prefix) {
+			return false
+		}
+		if ent.Expired(now) {
+			return true
+		}
+		v, err := e.decodeValue(id, ent.Value)
+		if err != nil {
+			ierr = err
+			return false
+		}
+		v, ok, err := sel.apply(v)
+		if err != nil {
+			ierr = err
+			return false
+		}
+		if !ok {
+			return true
+		}
+		if size > 0 && len(out) >= size {
+			more = true
+			return false
+		}
+		out = append(out, [2]string{k, v})
+		return true
+	})
+	if ierr != nil {
+		return nil, false, ierr
+	}
+	return out, more, nil
+}
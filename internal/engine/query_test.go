@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"sharkDB/internal/storage"
+)
+
+func TestSelectPage(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("users"); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range findRows {
+		if _, err := e.Insert("users", r[0], r[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		filter, fields string
+		prefix, from   string
+		inclusive      bool
+		size           int
+		want           string
+		more           bool
+	}{
+		{"$.age >= 30", "", "", "", true, 0, `u1={"age":30,"city":"Oslo"} u3={"age":30.5}`, false},
+		{"$.city = Oslo", "$.age", "", "", true, 0, `u1={"age":30} u6={} u9={"age":-4}`, false},
+		{"", "city", "", "u2", true, 2, `u2={"city":"Bergen"} u3={}`, true},
+		{"", "city", "", "u2", false, 2, `u3={} u4={}`, true},
+		{"$.city = Oslo", "city", "", "", true, 2, `u1={"city":"Oslo"} u6={"city":"Oslo"}`, true},
+		{"$.city = Oslo", "city", "", "u6", false, 2, `u9={"city":"Oslo"}`, false},
+		{"$.age < 0 OR $.age = true", "", "u", "", true, 0, `u5={"age":true} u9={"age":-4,"city":"Oslo"}`, false},
+		{"$.age > 0", "", "x", "x", true, 0, ``, false},
+	} {
+		sel, err := ParseSelection(tc.filter, tc.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs, more, err := e.SelectPage("users", tc.prefix, tc.from, tc.inclusive, tc.size, sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for i, kv := range pairs {
+			if i > 0 {
+				got += " "
+			}
+			got += fmt.Sprintf("%s=%s", kv[0], kv[1])
+		}
+		if got != tc.want || more != tc.more {
+			t.Errorf("WHERE %q PROJECT %q from %q: got %s (more %v), want %s (more %v)", tc.filter, tc.fields, tc.from, got, more, tc.want, tc.more)
+		}
+	}
+}
+
+func TestParseSelection(t *testing.T) {
+	if sel, err := ParseSelection("", ""); sel != nil || err != nil {
+		t.Fatalf("empty selection = %v, %v; want nil", sel, err)
+	}
+	for _, tc := range []struct{ filter, fields string }{
+		{"$.age >", ""},
+		{"", "$."},
+		{"$.a = 1", "a,,$$"},
+	} {
+		if _, err := ParseSelection(tc.filter, tc.fields); err == nil {
+			t.Errorf("ParseSelection(%q, %q) succeeded", tc.filter, tc.fields)
+		}
+	}
+	e := New(storage.NewMemory())
+	sel, _ := ParseSelection("$.a = 1", "")
+	if _, _, err := e.SelectPage("missing", "", "", true, 0, sel); err == nil {
+		t.Error("SelectPage on a missing table succeeded")
+	}
+}
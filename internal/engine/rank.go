@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"errors"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+)
+
+// Table trees keep the number of keys and the soonest expiry under every
+// internal node, so counts, ranks and positional lookups take O(log n)
+// even while a table holds keys with an expiry: only subtrees holding keys
+// that have expired but not yet been swept are walked. Each query still
+// loads the table's tree from storage, which decodes all of it.
+
+var ErrOutOfRange = errors.New("index out of range")
+
+// countRange returns the number of live keys in [start, end], or in the
+// whole tree when bounded is false.
+func countRange(t *bptree.BPTree, start, end string, bounded bool, now int64) int {
+	if !bounded {
+		return t.LiveLen(now)
+	}
+	if end < start {
+		return 0
+	}
+	n := t.LiveRank(end, now) - t.LiveRank(start, now)
+	if _, ok := lookupLive(t, end, now); ok {
+		n++
+	}
+	return n
+}
+
+// nthLive returns the live key at position i, counting from the end when i
+// is negative (-1 is the last key).
+func nthLive(t *bptree.BPTree, i int, now int64) (string, bptree.Entry, bool) {
+	if i < 0 {
+		i += t.LiveLen(now)
+	}
+	return t.LiveNth(i, now)
+}
+
+// CountRange returns the number of keys of table in the inclusive range [start, end].
+func (e *Engine) CountRange(table, start, end string) (int, error) {
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return 0, catalog.TableNotFound(table)
+	}
+	tree, err := e.c.LoadTree(id)
+	if err != nil {
+		return 0, err
+	}
+	return countRange(tree, start, end, true, nowNanos()), nil
+}
+
+// Rank returns the 0-based position of key in table's key order.
+func (e *Engine) Rank(table, key string) (int, error) {
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return 0, catalog.TableNotFound(table)
+	}
+	tree, err := e.c.LoadTree(id)
+	if err != nil {
+		return 0, err
+	}
+	now := nowNanos()
+	if _, ok := lookupLive(tree, key, now); !ok {
+		return 0, bptree.ErrKeyNotFound
+	}
+	return tree.LiveRank(key, now), nil
+}
+
+// Nth returns the key and value at 0-based position i of table in key
+// order; negative positions count from the end.
+func (e *Engine) Nth(table string, i int) (string, string, error) {
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return "", "", catalog.TableNotFound(table)
+	}
+	tree, err := e.c.LoadTree(id)
+	if err != nil {
+		return "", "", err
+	}
+	k, ent, ok := nthLive(tree, i, nowNanos())
+	if !ok {
+		return "", "", ErrOutOfRange
+	}
+	v, err := e.decodeValue(id, ent.Value)
+	return k, v, err
+}
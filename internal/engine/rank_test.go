@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/storage"
+)
+
+// Expired keys the sweeper has not removed yet are left out of counts,
+// ranks and positions.
+func TestCountsSkipExpired(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	var live []string
+	for i := 0; i < 40; i++ {
+		k := fmt.Sprintf("k%02d", i)
+		var err error
+		switch i % 4 {
+		case 0:
+			_, err = e.InsertTTL("t", k, "v", time.Nanosecond)
+		case 1:
+			_, err = e.InsertTTL("t", k, "v", time.Hour)
+			live = append(live, k)
+		default:
+			_, err = e.Insert("t", k, "v")
+			live = append(live, k)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	if n, err := e.Count("t"); err != nil || n != len(live) {
+		t.Fatalf("Count = %d, %v; want %d", n, err, len(live))
+	}
+	for _, tc := range []struct {
+		start, end string
+		want       int
+	}{
+		{"k00", "k39", 30},
+		{"k00", "k00", 0},
+		{"k01", "k03", 3},
+		{"k04", "k07", 3},
+		{"k10", "k05", 0},
+	} {
+		if n, err := e.CountRange("t", tc.start, tc.end); err != nil || n != tc.want {
+			t.Errorf("CountRange(%s, %s) = %d, %v; want %d", tc.start, tc.end, n, err, tc.want)
+		}
+	}
+	for i, k := range live {
+		if r, err := e.Rank("t", k); err != nil || r != i {
+			t.Fatalf("Rank(%s) = %d, %v; want %d", k, r, err, i)
+		}
+		if got, _, err := e.Nth("t", i); err != nil || got != k {
+			t.Fatalf("Nth(%d) = %s, %v; want %s", i, got, err, k)
+		}
+	}
+	if _, err := e.Rank("t", "k00"); !errors.Is(err, bptree.ErrKeyNotFound) {
+		t.Fatalf("Rank of an expired key: %v", err)
+	}
+	if got, _, err := e.Nth("t", -1); err != nil || got != live[len(live)-1] {
+		t.Fatalf("Nth(-1) = %s, %v", got, err)
+	}
+	if _, _, err := e.Nth("t", len(live)); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("Nth past the end: %v", err)
+	}
+	s, err := e.Stats("t")
+	if err != nil || s.Count != len(live) || s.MinKey != "k01" || s.MaxKey != "k39" {
+		t.Fatalf("Stats = %+v, %v", s, err)
+	}
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/schema"
+)
+
+// Tables created with a schema store rows in the binary format of package
+// schema. Writes take JSON objects and encode them in Batch.put; every read
+// decodes rows back to JSON, so callers above the engine only ever see JSON.
+
+// parsedSchemas caches parsed schemas by their definition text.
+var parsedSchemas sync.Map
+
+// tableSchema returns the schema of table id, or nil for a schemaless table.
+func (e *Engine) tableSchema(id uint64) (*schema.Schema, error) {
+	def, ok := e.c.TableSchema(id)
+	if !ok {
+		return nil, nil
+	}
+	if s, ok := parsedSchemas.Load(def); ok {
+		return s.(*schema.Schema), nil
+	}
+	s, err := schema.Parse(def)
+	if err != nil {
+		return nil, err
+	}
+	parsedSchemas.Store(def, s)
+	return s, nil
+}
+
+// decodeValue turns a stored value of table id into the value callers see.
+func (e *Engine) decodeValue(id uint64, raw string) (string, error) {
+	s, err := e.tableSchema(id)
+	if err != nil || s == nil {
+		return raw, err
+	}
+	return s.Decode(raw)
+}
+
+// decodePairs decodes the values of pairs in place.
+func (e *Engine) decodePairs(id uint64, pairs [][2]string) ([][2]string, error) {
+	s, err := e.tableSchema(id)
+	if err != nil || s == nil {
+		return pairs, err
+	}
+	for i := range pairs {
+		if pairs[i][1], err = s.Decode(pairs[i][1]); err != nil {
+			return nil, fmt.Errorf("key %s: %w", pairs[i][0], err)
+		}
+	}
+	return pairs, nil
+}
+
+// CreateWithSchema creates a table whose rows must match the column list
+// def, e.g. "name string REQUIRED, age int DEFAULT 0".
+func (e *Engine) CreateWithSchema(table, def string) (string, error) {
+	s, err := schema.Parse(def)
+	if err != nil {
+		return "", err
+	}
+	if err := e.c.CreateTableSchema(table, s.String()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Table %s created", table), nil
+}
+
+// Schema returns the column list of table, or "" if it is schemaless.
+func (e *Engine) Schema(table string) (string, error) {
+	id, ok := e.c.GetTableID(table)
+	if !ok {
+		return "", catalog.TableNotFound(table)
+	}
+	def, _ := e.c.TableSchema(id)
+	return def, nil
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"sharkDB/internal/schema"
+	"sharkDB/internal/storage"
+)
+
+func TestTypedTable(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.CreateWithSchema("bad", "age integer"); !errors.Is(err, schema.ErrBadSchema) {
+		t.Fatalf("unknown type: got %v, want ErrBadSchema", err)
+	}
+	if _, err := e.Schema("bad"); err == nil {
+		t.Fatal("a rejected schema created its table")
+	}
+	if _, err := e.CreateWithSchema("users", "name string REQUIRED, age int DEFAULT 0"); err != nil {
+		t.Fatal(err)
+	}
+	if def, err := e.Schema("users"); err != nil || def != "name string REQUIRED, age int DEFAULT 0" {
+		t.Fatalf("Schema = %q, %v", def, err)
+	}
+	if _, err := e.Insert("users", "ann", `{"name":"Ann"}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ key, value string }{
+		{"bob", `{"age":3}`},
+		{"bob", `{"name":"Bob","age":"3"}`},
+		{"bob", `{"name":"Bob","role":"admin"}`},
+		{"bob", `Bob`},
+		{"ann", `{"name":1}`},
+	} {
+		if _, err := e.Insert("users", tc.key, tc.value); !errors.Is(err, schema.ErrInvalid) {
+			t.Errorf("Insert(%s, %s): got %v, want ErrInvalid", tc.key, tc.value, err)
+		}
+	}
+	if v, err := e.Get("users", "ann"); err != nil || v != `{"name":"Ann","age":0}` {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if _, err := e.Get("users", "bob"); err == nil {
+		t.Fatal("a rejected row was stored")
+	}
+
+	// A batch is left uncommitted when a row is rejected, so it writes nothing.
+	b := e.NewBatch()
+	if err := b.Put("users", "cy", `{"name":"Cy"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put("users", "dee", `{"name":"Dee","age":1.5}`); !errors.Is(err, schema.ErrInvalid) {
+		t.Fatalf("Put of a bad row: %v", err)
+	}
+	if n, err := e.Count("users"); err != nil || n != 1 {
+		t.Fatalf("Count = %d, %v; want 1", n, err)
+	}
+	pairs, err := e.Scan("users", "", 0)
+	if err != nil || len(pairs) != 1 || pairs[0][1] != `{"name":"Ann","age":0}` {
+		t.Fatalf("Scan = %v, %v", pairs, err)
+	}
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"sharkDB/internal/bptree"
+)
+
+// Keys may carry an expiry stored next to the value in the tree leaves.
+// Expired keys are hidden from every read as soon as they expire and are
+// physically removed later by SweepExpired, which callers run periodically
+// under the write lock.
+
+// NoExpiry is the TTL reported for a key that never expires.
+const NoExpiry time.Duration = -1
+
+// ErrTTLRange is returned for a TTL so long that its expiry time cannot be
+// stored, and by Expire for a TTL that is not positive.
+var ErrTTLRange = errors.New("ttl out of range")
+
+// TTLSeconds converts a TTL given in seconds to a duration, rejecting
+// values a time.Duration cannot hold.
+func TTLSeconds(secs int64) (time.Duration, error) {
+	if secs > math.MaxInt64/int64(time.Second) || secs < math.MinInt64/int64(time.Second) {
+		return 0, ErrTTLRange
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+func nowNanos() int64 { return time.Now().UnixNano() }
+
+// expiryAt returns the expiry time for ttl in unix nanoseconds, 0 if ttl
+// is not positive.
+func expiryAt(ttl time.Duration) (int64, error) {
+	if ttl <= 0 {
+		return 0, nil
+	}
+	now := nowNanos()
+	if int64(ttl) > math.MaxInt64-now {
+		return 0, ErrTTLRange
+	}
+	return now + int64(ttl), nil
+}
+
+// lookupLive returns the entry for key unless it is missing or expired.
+func lookupLive(t *bptree.BPTree, key string, now int64) (bptree.Entry, bool) {
+	e, ok := t.Lookup(key)
+	if !ok || e.Expired(now) {
+		return bptree.Entry{}, false
+	}
+	return e, true
+}
+
+// rangeLive returns up to limit unexpired pairs with key >= start and the
+// given prefix. If limit <= 0, returns all.
+func rangeLive(t *bptree.BPTree, start, prefix string, limit int, now int64) [][2]string {
+	if start < prefix {
+		start = prefix
+	}
+	var out [][2]string
+	t.AscendEntries(start, func(k string, e bptree.Entry) bool {
+		if !strings.HasPrefix(k, prefix) {
+			return false
+		}
+		if e.Expired(now) {
+			return true
+		}
+		out = append(out, [2]string{k, e.Value})
+		return limit <= 0 || len(out) < limit
+	})
+	return out
+}
+
+// PutTTL stages an upsert of key that expires after ttl (ttl <= 0 = never).
+func (b *Batch) PutTTL(table, key, value string, ttl time.Duration) error {
+	id, t, err := b.tree(table)
+	if err != nil {
+		return err
+	}
+	at, err := expiryAt(ttl)
+	if err != nil {
+		return err
+	}
+	return b.put(id, t, key, value, at)
+}
+
+// Expire stages a new expiry for an existing key. ttl must be positive, as
+// for SETEX; rewriting the key without a TTL removes its expiry.
+func (b *Batch) Expire(table, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrTTLRange
+	}
+	id, t, err := b.tree(table)
+	if err != nil {
+		return err
+	}
+	if _, ok := lookupLive(t, key, nowNanos()); !ok {
+		return bptree.ErrKeyNotFound
+	}
+	at, err := expiryAt(ttl)
+	if err != nil {
+		return err
+	}
+	t.SetExpiry(key, at)
+	b.dirty[id] = true
+	return nil
+}
+
+// TTL returns the time left before key expires, or NoExpiry.
+func (b *Batch) TTL(table, key string) (time.Duration, error) {
+	_, t, err := b.tree(table)
+	if err != nil {
+		return 0, err
+	}
+	now := nowNanos()
+	ent, ok := lookupLive(t, key, now)
+	if !ok {
+		return 0, bptree.ErrKeyNotFound
+	}
+	if ent.ExpiresAt == 0 {
+		return NoExpiry, nil
+	}
+	return time.Duration(ent.ExpiresAt - now), nil
+}
+
+// InsertTTL upserts key with an expiry after ttl.
+func (e *Engine) InsertTTL(table, key, value string, ttl time.Duration) (string, error) {
+	b := e.NewBatch()
+	if err := b.PutTTL(table, key, value, ttl); err != nil {
+		return "", err
+	}
+	if err := b.Commit(); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+// Expire sets key to expire after ttl, which must be positive, and reports
+// whether the key existed.
+func (e *Engine) Expire(table, key string, ttl time.Duration) (bool, error) {
+	b := e.NewBatch()
+	if err := b.Expire(table, key, ttl); err != nil {
+		if err == bptree.ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := b.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TTL returns the remaining lifetime of key, or NoExpiry if it has none.
+func (e *Engine) TTL(table, key string) (time.Duration, error) {
+	return e.NewBatch().TTL(table, key)
+}
+
+// SweepExpired deletes up to max expired keys across all tables and returns
+// how many it removed. Callers hold the write lock and call it repeatedly
+// while it returns max.
+//
+// Only tables the catalog marks as holding keys with an expiry are loaded.
+// The first sweep after New checks every table instead, since trees stored
+// before the marks existed are unmarked, and stores the ones whose mark is
+// wrong so later sweeps can trust it.
+func (e *Engine) SweepExpired(max int) (int, error) {
+	now := nowNanos()
+	b := e.NewBatch()
+	n := 0
+	for _, table := range e.c.ListTables() {
+		if max > 0 && n >= max {
+			break
+		}
+		id, ok := e.c.GetTableID(table)
+		if !ok || (e.swept && !e.c.TableExpiring(id)) {
+			continue
+		}
+		_, t, err := b.tree(table)
+		if err != nil {
+			return 0, err
+		}
+		if (t.Expiring > 0) != e.c.TableExpiring(id) {
+			b.dirty[id] = true
+		}
+		if t.Expiring == 0 {
+			continue
+		}
+		var expired []string
+		t.AscendEntries("", func(k string, ent bptree.Entry) bool {
+			if ent.Expired(now) {
+				expired = append(expired, k)
+			}
+			return max <= 0 || n+len(expired) < max
+		})
+		for _, k := range expired {
+			if err := b.remove(id, t, k); err != nil {
+				return 0, err
+			}
+		}
+		n += len(expired)
+	}
+	if err := b.Commit(); err != nil {
+		return 0, err
+	}
+	if max <= 0 || n < max {
+		e.swept = true
+	}
+	return n, nil
+}
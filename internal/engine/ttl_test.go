@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"sharkDB/internal/storage"
+)
+
+func TestTTLSeconds(t *testing.T) {
+	max := int64(math.MaxInt64 / int64(time.Second))
+	for _, tc := range []struct {
+		secs int64
+		want time.Duration
+		err  error
+	}{
+		{1, time.Second, nil},
+		{3600, time.Hour, nil},
+		{max, time.Duration(max) * time.Second, nil},
+		{max + 1, 0, ErrTTLRange},
+		{math.MaxInt64, 0, ErrTTLRange},
+		{math.MinInt64, 0, ErrTTLRange},
+	} {
+		got, err := TTLSeconds(tc.secs)
+		if !errors.Is(err, tc.err) || got != tc.want {
+			t.Errorf("TTLSeconds(%d) = %v, %v; want %v, %v", tc.secs, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+// A TTL a duration can hold may still put the expiry past what a unix
+// nanosecond timestamp can hold; it must fail instead of wrapping around.
+func TestTTLOverflow(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	long := time.Duration(math.MaxInt64)
+	if _, err := e.InsertTTL("t", "k", "v", long); !errors.Is(err, ErrTTLRange) {
+		t.Fatalf("InsertTTL: got %v, want ErrTTLRange", err)
+	}
+	if _, err := e.Insert("t", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Expire("t", "k", long); !errors.Is(err, ErrTTLRange) {
+		t.Fatalf("Expire: got %v, want ErrTTLRange", err)
+	}
+	if d, err := e.TTL("t", "k"); err != nil || d != NoExpiry {
+		t.Fatalf("TTL after the failed Expire = %v, %v; want NoExpiry", d, err)
+	}
+}
+
+func TestExpireRejectsNonPositive(t *testing.T) {
+	e := New(storage.NewMemory())
+	if _, err := e.Create("t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.InsertTTL("t", "k", "v", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if _, err := e.Expire("t", "k", ttl); !errors.Is(err, ErrTTLRange) {
+			t.Errorf("Expire(%v): got %v, want ErrTTLRange", ttl, err)
+		}
+	}
+	if d, err := e.TTL("t", "k"); err != nil || d <= 0 || d > time.Hour {
+		t.Fatalf("TTL = %v, %v; the expiry should be unchanged", d, err)
+	}
+	if ok, err := e.Expire("t", "missing", time.Minute); ok || err != nil {
+		t.Fatalf("Expire of a missing key = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,255 @@
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// DefaultChunkBytes is the in-memory buffer size used when none is given.
+const DefaultChunkBytes = 64 << 20
+
+type pair struct {
+	key, value string
+	seq        uint64 // insertion order, to keep the last duplicate
+}
+
+// Sorter sorts key/value pairs that may not fit in memory. Pairs are
+// buffered until the buffer holds about its chunk size, then sorted and spilled
+// to a temporary run file; Iter merges the runs. When a key is added more
+// than once the pair added last wins, as it would with repeated inserts.
+type Sorter struct {
+	dir        string
+	chunkBytes int
+	buf        []pair
+	bufBytes   int
+	seq        uint64
+	runs       []string
+	done       bool
+}
+
+// New returns a sorter that spills runs to dir (os.TempDir() if empty)
+// whenever its buffer reaches chunkBytes (DefaultChunkBytes if <= 0).
+func New(dir string, chunkBytes int) *Sorter {
+	if chunkBytes <= 0 {
+		chunkBytes = DefaultChunkBytes
+	}
+	return &Sorter{dir: dir, chunkBytes: chunkBytes}
+}
+
+// Add buffers a pair, spilling a sorted run if the buffer is full.
+func (s *Sorter) Add(key, value string) error {
+	if s.done {
+		return errors.New("extsort: Add after Iter")
+	}
+	s.seq++
+	s.buf = append(s.buf, pair{key, value, s.seq})
+	s.bufBytes += len(key) + len(value) + 48
+	if s.bufBytes >= s.chunkBytes {
+		return s.spill()
+	}
+	return nil
+}
+
+// Len returns the number of pairs added, counting duplicates.
+func (s *Sorter) Len() int { return int(s.seq) }
+
+func sortPairs(ps []pair) []pair {
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].key != ps[j].key {
+			return ps[i].key < ps[j].key
+		}
+		return ps[i].seq < ps[j].seq
+	})
+	// keep the last of each run of equal keys
+	out := ps[:0]
+	for i, p := range ps {
+		if i+1 < len(ps) && ps[i+1].key == p.key {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func (s *Sorter) spill() error {
+	f, err := os.CreateTemp(s.dir, "sharkdb-sort-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriter(f)
+	var hdr [binary.MaxVarintLen64 * 3]byte
+	for _, p := range sortPairs(s.buf) {
+		n := binary.PutUvarint(hdr[:], p.seq)
+		n += binary.PutUvarint(hdr[n:], uint64(len(p.key)))
+		n += binary.PutUvarint(hdr[n:], uint64(len(p.value)))
+		if _, err := w.Write(hdr[:n]); err != nil {
+			f.Close()
+			return err
+		}
+		w.WriteString(p.key)
+		w.WriteString(p.value)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	s.buf, s.bufBytes = nil, 0
+	return f.Close()
+}
+
+// Close removes the run files.
+func (s *Sorter) Close() error {
+	var first error
+	for _, name := range s.runs {
+		if err := os.Remove(name); err != nil && first == nil && !os.IsNotExist(err) {
+			first = err
+		}
+	}
+	s.runs = nil
+	return first
+}
+
+// Iterator yields pairs in key order with duplicates removed.
+type Iterator struct {
+	h     mergeHeap
+	files []*os.File
+	err   error
+}
+
+// Iter finishes adding and returns an iterator over the sorted pairs. The
+// sorter must not be used for Add afterwards.
+func (s *Sorter) Iter() (*Iterator, error) {
+	s.done = true
+	it := &Iterator{}
+	if len(s.buf) > 0 {
+		it.h = append(it.h, &source{mem: sortPairs(s.buf), idx: -1})
+		s.buf = nil
+	}
+	for _, name := range s.runs {
+		f, err := os.Open(name)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.files = append(it.files, f)
+		it.h = append(it.h, &source{r: bufio.NewReader(f)})
+	}
+	live := it.h[:0]
+	for _, src := range it.h {
+		ok, err := src.advance()
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		if ok {
+			live = append(live, src)
+		}
+	}
+	it.h = live
+	heap.Init(&it.h)
+	return it, nil
+}
+
+// Next returns the next pair. ok is false at the end or on error; check Err.
+func (it *Iterator) Next() (key, value string, ok bool) {
+	for len(it.h) > 0 {
+		top := it.h[0]
+		p := top.cur
+		if ok, err := top.advance(); err != nil {
+			it.err = err
+			return "", "", false
+		} else if ok {
+			heap.Fix(&it.h, 0)
+		} else {
+			heap.Pop(&it.h)
+		}
+		// Later duplicates of the key sort after p; skip ahead to the last one.
+		for len(it.h) > 0 && it.h[0].cur.key == p.key {
+			p = it.h[0].cur
+			if ok, err := it.h[0].advance(); err != nil {
+				it.err = err
+				return "", "", false
+			} else if ok {
+				heap.Fix(&it.h, 0)
+			} else {
+				heap.Pop(&it.h)
+			}
+		}
+		return p.key, p.value, true
+	}
+	return "", "", false
+}
+
+// Err returns the first read error, if any.
+func (it *Iterator) Err() error { return it.err }
+
+// Close releases the run files opened by the iterator.
+func (it *Iterator) Close() {
+	for _, f := range it.files {
+		f.Close()
+	}
+	it.files = nil
+}
+
+type source struct {
+	mem []pair
+	idx int
+	r   *bufio.Reader
+	cur pair
+}
+
+func (s *source) advance() (bool, error) {
+	if s.r == nil {
+		s.idx++
+		if s.idx >= len(s.mem) {
+			return false, nil
+		}
+		s.cur = s.mem[s.idx]
+		return true, nil
+	}
+	seq, err := binary.ReadUvarint(s.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	kl, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		return false, err
+	}
+	vl, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		return false, err
+	}
+	buf := make([]byte, kl+vl)
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return false, err
+	}
+	s.cur = pair{key: string(buf[:kl]), value: string(buf[kl:]), seq: seq}
+	return true, nil
+}
+
+type mergeHeap []*source
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].cur.key != h[j].cur.key {
+		return h[i].cur.key < h[j].cur.key
+	}
+	return h[i].cur.seq < h[j].cur.seq
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(*source)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,132 @@
+package extsort
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+)
+
+// sortAll adds pairs to a sorter with the given chunk size and returns what
+// the iterator yields, along with the number of runs spilled.
+func sortAll(t *testing.T, chunkBytes int, pairs [][2]string) ([][2]string, int) {
+	t.Helper()
+	dir := t.TempDir()
+	s := New(dir, chunkBytes)
+	defer s.Close()
+	for _, p := range pairs {
+		if err := s.Add(p[0], p[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs := len(s.runs)
+	it, err := s.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var out [][2]string
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		out = append(out, [2]string{k, v})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("late", "x"); err == nil {
+		t.Fatal("Add after Iter succeeded")
+	}
+	return out, runs
+}
+
+// want sorts pairs by key and keeps the last value added for each key.
+func want(pairs [][2]string) [][2]string {
+	last := make(map[string]string)
+	for _, p := range pairs {
+		last[p[0]] = p[1]
+	}
+	out := make([][2]string, 0, len(last))
+	for k, v := range last {
+		out = append(out, [2]string{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	return out
+}
+
+func randomPairs(n, keys int) [][2]string {
+	rnd := rand.New(rand.NewSource(int64(n)))
+	pairs := make([][2]string, n)
+	for i := range pairs {
+		pairs[i] = [2]string{fmt.Sprintf("key%06d", rnd.Intn(keys)), fmt.Sprintf("value%d", i)}
+	}
+	return pairs
+}
+
+func TestSortInMemory(t *testing.T) {
+	pairs := randomPairs(1000, 300)
+	got, runs := sortAll(t, 0, pairs)
+	if runs != 0 {
+		t.Fatalf("spilled %d runs under the default chunk size", runs)
+	}
+	checkPairs(t, got, want(pairs))
+}
+
+func TestSortWithSpills(t *testing.T) {
+	// Duplicates land in different runs and in the final in-memory buffer,
+	// so the merge has to pick the last one across runs.
+	pairs := randomPairs(5000, 1500)
+	got, runs := sortAll(t, 4096, pairs)
+	if runs < 10 {
+		t.Fatalf("spilled %d runs, want many", runs)
+	}
+	checkPairs(t, got, want(pairs))
+}
+
+func TestSortSpillsOnly(t *testing.T) {
+	// A chunk size of one byte spills every pair into its own run.
+	pairs := [][2]string{{"b", "1"}, {"a", "2"}, {"b", "3"}, {"c", "4"}, {"a", "5"}}
+	got, runs := sortAll(t, 1, pairs)
+	if runs != len(pairs) {
+		t.Fatalf("spilled %d runs, want %d", runs, len(pairs))
+	}
+	checkPairs(t, got, [][2]string{{"a", "5"}, {"b", "3"}, {"c", "4"}})
+}
+
+func TestCloseRemovesRuns(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, 64)
+	for _, p := range randomPairs(200, 200) {
+		if err := s.Add(p[0], p[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.runs) == 0 {
+		t.Fatal("no runs spilled")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 0 {
+		t.Fatalf("%d run files left after Close", len(ents))
+	}
+}
+
+func checkPairs(t *testing.T, got, want [][2]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d pairs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pair %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package format
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type csvReader struct {
+	r      *csv.Reader
+	line   int
+	header bool
+	key    string
+	m      *mapping
+}
+
+func newCSVReader(r io.Reader, o Options) *csvReader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // field counts are checked per row
+	return &csvReader{r: cr, header: o.Header, key: o.keyColumn()}
+}
+
+func (c *csvReader) Line() int { return c.line }
+
+func (c *csvReader) record() ([]string, error) {
+	rec, err := c.r.Read()
+	if err != nil {
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			c.line = pe.StartLine
+			return nil, &LineError{pe.StartLine, pe.Err}
+		}
+		return nil, err
+	}
+	c.line, _ = c.r.FieldPos(0)
+	return rec, nil
+}
+
+func (c *csvReader) Read() (string, string, error) {
+	if c.header && c.m == nil {
+		rec, err := c.record()
+		if err != nil {
+			return "", "", err
+		}
+		// A bad header is fatal: no later row could be mapped.
+		if c.m, err = newMapping(rec, c.key); err != nil {
+			return "", "", fmt.Errorf("line %d: %w", c.line, err)
+		}
+	}
+	rec, err := c.record()
+	if err != nil {
+		return "", "", err
+	}
+	if c.m == nil {
+		if len(rec) != 2 {
+			return "", "", &LineError{c.line, fmt.Errorf("got %d fields, want key and value", len(rec))}
+		}
+		return rec[0], rec[1], nil
+	}
+	k, v, err := c.m.row(rec)
+	if err != nil {
+		return "", "", &LineError{c.line, err}
+	}
+	return k, v, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, o Options) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w)}
+	if o.Header {
+		if err := c.Write(o.keyColumn(), "value"); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *csvWriter) Write(key, value string) error {
+	return c.w.Write([]string{key, value})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
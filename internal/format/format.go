@@ -0,0 +1,204 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Rows are exchanged with files and network streams as key/value pairs in
+// one of three formats:
+//
+//   - tsv: "key<TAB>value" per line, with tabs, line breaks and backslashes
+//     in either written as \t, \n, \r and \\. Unescaped tabs in the value
+//     of a two-column file are kept.
+//   - csv: RFC 4180 records of key and value, quoted as needed. Everything
+//     survives a round trip except CR LF inside a value, which reads back
+//     as LF as in every RFC 4180 reader.
+//   - jsonl: one {"key": ..., "value": ...} object per line. Values that
+//     are JSON objects or arrays are embedded as JSON, others as strings.
+//
+// With a header (csv and tsv) or for jsonl objects, the key may come from
+// any named column. A single other column called "value" is taken as the
+// value verbatim; any other set of columns becomes a JSON object.
+
+// RowReader yields rows until it returns io.EOF. Problems with a single row
+// are reported as *LineError, after which reading may continue; any other
+// error is fatal.
+type RowReader interface {
+	Read() (key, value string, err error)
+	// Line returns the input line on which the last row read started.
+	Line() int
+}
+
+// RowWriter writes rows. Flush must be called after the last row.
+type RowWriter interface {
+	Write(key, value string) error
+	Flush() error
+}
+
+// LineError is a problem with the row starting on Line.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+func (e *LineError) Unwrap() error { return e.Err }
+
+// Formats.
+const (
+	TSV   = "tsv"
+	CSV   = "csv"
+	JSONL = "jsonl"
+)
+
+// Options select a format and how rows map onto it.
+type Options struct {
+	Format     string // TSV (default), CSV or JSONL
+	Header     bool   // csv and tsv: the first line names the columns
+	KeyColumn  string // column or field holding the key (default "key")
+	SkipErrors bool   // loads skip bad rows instead of aborting
+}
+
+// OptionsFromArgs builds options from the normalized DUMP and LOAD
+// arguments produced by the parser: format, HEADER flag, key column and,
+// for LOAD, the ON ERROR policy.
+func OptionsFromArgs(args []string) (Options, error) {
+	o := Options{Format: args[0], Header: args[1] != "", KeyColumn: args[2]}
+	if len(args) > 3 {
+		switch strings.ToLower(args[3]) {
+		case "", "abort":
+		case "skip":
+			o.SkipErrors = true
+		default:
+			return o, fmt.Errorf("unknown error policy %q (want skip or abort)", args[3])
+		}
+	}
+	return o, o.check()
+}
+
+func (o *Options) check() error {
+	o.Format = strings.ToLower(o.Format)
+	if o.Format == "" {
+		o.Format = TSV
+	}
+	switch o.Format {
+	case TSV, CSV:
+		if o.KeyColumn != "" && !o.Header {
+			return fmt.Errorf("KEY needs HEADER for %s", o.Format)
+		}
+	case JSONL:
+		if o.Header {
+			return fmt.Errorf("HEADER does not apply to jsonl")
+		}
+	default:
+		return fmt.Errorf("unknown format %q (want tsv, csv or jsonl)", o.Format)
+	}
+	return nil
+}
+
+func (o Options) keyColumn() string {
+	if o.KeyColumn == "" {
+		return "key"
+	}
+	return o.KeyColumn
+}
+
+// NewReader returns a reader for r in the format o selects.
+func NewReader(r io.Reader, o Options) (RowReader, error) {
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	switch o.Format {
+	case CSV:
+		return newCSVReader(r, o), nil
+	case JSONL:
+		return newJSONLReader(r, o), nil
+	}
+	t := NewTSVReader(r)
+	t.header, t.key = o.Header, o.keyColumn()
+	return t, nil
+}
+
+// NewWriter returns a writer to w in the format o selects. With Header set
+// it writes a header line naming the key column and "value".
+func NewWriter(w io.Writer, o Options) (RowWriter, error) {
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	switch o.Format {
+	case CSV:
+		return newCSVWriter(w, o)
+	case JSONL:
+		return newJSONLWriter(w, o), nil
+	}
+	return newTSVWriter(w, o)
+}
+
+// mapping turns a row of named columns into a key and value. key is the
+// index of the key column.
+type mapping struct {
+	names []string
+	key   int
+}
+
+func newMapping(names []string, keyColumn string) (*mapping, error) {
+	for i, n := range names {
+		if n == keyColumn {
+			return &mapping{names: names, key: i}, nil
+		}
+	}
+	return nil, fmt.Errorf("header has no column %q", keyColumn)
+}
+
+// row maps cells onto a key and value.
+func (m *mapping) row(cells []string) (string, string, error) {
+	if len(cells) != len(m.names) {
+		return "", "", fmt.Errorf("got %d fields, header has %d", len(cells), len(m.names))
+	}
+	if len(m.names) == 2 && m.names[1-m.key] == "value" {
+		return cells[m.key], cells[1-m.key], nil
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	n := 0
+	for i, name := range m.names {
+		if i == m.key {
+			continue
+		}
+		if n > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(quote(name))
+		b.WriteByte(':')
+		b.WriteString(cellJSON(cells[i]))
+		n++
+	}
+	b.WriteByte('}')
+	return cells[m.key], b.String(), nil
+}
+
+// cellJSON renders a text cell as a JSON value: numbers, true, false and
+// null as themselves, anything else as a string.
+func cellJSON(s string) string {
+	switch s {
+	case "true", "false", "null":
+		return s
+	}
+	if s != "" && (s[0] == '-' || (s[0] >= '0' && s[0] <= '9')) && json.Valid([]byte(s)) {
+		return s
+	}
+	return quote(s)
+}
+
+// quote returns s as a JSON string without HTML escaping.
+func quote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,210 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// roundTripRows hold the bytes that tend to break text formats.
+var roundTripRows = [][2]string{
+	{"plain", "value"},
+	{"tab\tkey", "a\tb"},
+	{"nl", "line one\nline two\r\n"},
+	{"cr", "a\rb"},
+	{`back\slash`, `C:\temp\new \t \\n`},
+	{"quote", `say "hi", then, leave`},
+	{"json", `{"a":[1,2],"b":"x"}`},
+	{"json-spaced", ` {"a":1}`},
+	{"array", `[1,"two"]`},
+	{"empty", ""},
+	{"unicode", "ünïcødé ✓"},
+	{"", "empty key"},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, o := range []Options{
+		{Format: TSV},
+		{Format: TSV, Header: true},
+		{Format: TSV, Header: true, KeyColumn: "id"},
+		{Format: CSV},
+		{Format: CSV, Header: true, KeyColumn: "id"},
+		{Format: JSONL},
+		{Format: JSONL, KeyColumn: "id"},
+	} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range roundTripRows {
+			if err := w.Write(r[0], r[1]); err != nil {
+				t.Fatalf("%+v: Write(%q): %v", o, r[0], err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if o.Format == TSV && strings.Count(buf.String(), "\n") != len(roundTripRows)+btoi(o.Header) {
+			t.Errorf("%+v: a row spans several lines:\n%s", o, buf.String())
+		}
+		rd, err := NewReader(bytes.NewReader(buf.Bytes()), o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range roundTripRows {
+			k, v, err := rd.Read()
+			if err != nil {
+				t.Fatalf("%+v: reading %q: %v", o, want[0], err)
+			}
+			if o.Format == CSV {
+				// CSV readers turn CR LF inside a field into LF.
+				want[1] = strings.ReplaceAll(want[1], "\r\n", "\n")
+			}
+			if k != want[0] || v != want[1] {
+				t.Errorf("%+v: got %q=%q, want %q=%q", o, k, v, want[0], want[1])
+			}
+		}
+		if _, _, err := rd.Read(); err != io.EOF {
+			t.Errorf("%+v: after the last row: %v", o, err)
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestRead(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		o     Options
+		input string
+		want  []string // "key=value" per row
+	}{
+		{"tsv", Options{}, "a\t1\n\nb\tx\ty\r\n", []string{"a=1", "b=x\ty"}},
+		{"tsv escapes", Options{}, `a	x\ty\nz\\w\q` + "\n", []string{"a=x\ty\nz\\w\\q"}},
+		{"tsv trailing backslash", Options{}, "a\tend\\\n", []string{"a=end\\"}},
+		{"tsv header with columns", Options{Header: true, KeyColumn: "id"}, "name\tid\tage\tok\nAnn\tu1\t30\ttrue\n", []string{`u1={"name":"Ann","age":30,"ok":true}`}},
+		{"tsv header value column", Options{Header: true}, "value\tkey\nv\tk\n", []string{"k=v"}},
+		{"csv", Options{Format: CSV}, "a,1\n\"b\",\"x,\"\"y\"\"\nz\"\n", []string{"a=1", "b=x,\"y\"\nz"}},
+		{"csv header with columns", Options{Format: CSV, Header: true}, "key,n,s\nk,-1.5,007x\n", []string{`k={"n":-1.5,"s":"007x"}`}},
+		{"jsonl", Options{Format: JSONL}, "{\"key\":\"a\",\"value\":\"1\"}\n\n{\"value\":{\"x\":1},\"key\":2}\n", []string{"a=1", `2={"x":1}`}},
+		{"jsonl fields", Options{Format: JSONL, KeyColumn: "id"}, `{"id":"u1","name":"Ann","tags":["a"]}` + "\n", []string{`u1={"name":"Ann","tags":["a"]}`}},
+	} {
+		rd, err := NewReader(strings.NewReader(tc.input), tc.o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			k, v, err := rd.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			got = append(got, k+"="+v)
+		}
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// Bad rows are LineErrors naming their line, after which reading goes on;
+// a bad header is fatal.
+func TestReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		o     Options
+		input string
+		line  int
+		want  string
+		next  string // key of the row read after the error, if reading goes on
+	}{
+		{"tsv without a tab", Options{}, "a\t1\nbroken\nc\t3\n", 2, "missing tab", "c"},
+		{"tsv field count", Options{Header: true}, "key\ta\tb\nk\t1\n", 2, "got 2 fields, header has 3", ""},
+		{"csv field count", Options{Format: CSV}, "a,1\nb\nc,3\n", 2, "want key and value", "c"},
+		{"csv bad quote", Options{Format: CSV}, "a,1\nb,\"x\"y\n", 2, "quote", ""},
+		{"jsonl not an object", Options{Format: JSONL}, "{\"key\":\"a\",\"value\":1}\n[1]\n", 2, "not a JSON object", ""},
+		{"jsonl no key", Options{Format: JSONL}, `{"value":1}` + "\n", 1, `no "key" field`, ""},
+		{"jsonl object key", Options{Format: JSONL}, `{"key":{},"value":1}` + "\n", 1, "must be a string or number", ""},
+		{"jsonl only a key", Options{Format: JSONL}, `{"key":"a"}` + "\n", 1, "no value fields", ""},
+		{"jsonl trailing data", Options{Format: JSONL}, `{"key":"a","value":1} 2` + "\n", 1, "trailing data", ""},
+	} {
+		rd, err := NewReader(strings.NewReader(tc.input), tc.o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var le *LineError
+		for {
+			_, _, err = rd.Read()
+			if err == nil {
+				continue
+			}
+			break
+		}
+		if !errors.As(err, &le) || le.Line != tc.line || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want line %d: %s", tc.name, err, tc.line, tc.want)
+			continue
+		}
+		if tc.next != "" {
+			if k, _, err := rd.Read(); err != nil || k != tc.next {
+				t.Errorf("%s: row after the error = %q, %v", tc.name, k, err)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		o     Options
+		input string
+	}{
+		{"tsv header without the key", Options{Header: true, KeyColumn: "id"}, "key\tvalue\nk\tv\n"},
+		{"csv header without the key", Options{Format: CSV, Header: true}, "id,value\nk,v\n"},
+	} {
+		rd, err := NewReader(strings.NewReader(tc.input), tc.o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var le *LineError
+		if _, _, err := rd.Read(); err == nil || errors.As(err, &le) || !strings.Contains(err.Error(), "header has no column") {
+			t.Errorf("%s: got %v, want a fatal header error", tc.name, err)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want Options
+		err  string
+	}{
+		{[]string{"", "", ""}, Options{Format: TSV}, ""},
+		{[]string{"CSV", "HEADER", "id"}, Options{Format: CSV, Header: true, KeyColumn: "id"}, ""},
+		{[]string{"jsonl", "", "id", "skip"}, Options{Format: JSONL, KeyColumn: "id", SkipErrors: true}, ""},
+		{[]string{"tsv", "", "", "ABORT"}, Options{Format: TSV}, ""},
+		{[]string{"xml", "", ""}, Options{}, "unknown format"},
+		{[]string{"csv", "", "id"}, Options{}, "KEY needs HEADER"},
+		{[]string{"jsonl", "HEADER", ""}, Options{}, "does not apply"},
+		{[]string{"tsv", "", "", "retry"}, Options{}, "unknown error policy"},
+	} {
+		got, err := OptionsFromArgs(tc.args)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("OptionsFromArgs(%q): got %v, want an error containing %q", tc.args, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("OptionsFromArgs(%q) = %+v, %v; want %+v", tc.args, got, err, tc.want)
+		}
+	}
+}
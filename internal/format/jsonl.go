@@ -0,0 +1,162 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type jsonlReader struct {
+	r    *bufio.Reader
+	line int
+	key  string
+}
+
+func newJSONLReader(r io.Reader, o Options) *jsonlReader {
+	return &jsonlReader{r: bufio.NewReaderSize(r, 64<<10), key: o.keyColumn()}
+}
+
+func (j *jsonlReader) Line() int { return j.line }
+
+func (j *jsonlReader) Read() (string, string, error) {
+	var b []byte
+	for {
+		var err error
+		b, err = j.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(b) == 0) {
+			return "", "", err
+		}
+		j.line++
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			break
+		}
+	}
+	k, v, err := j.row(b)
+	if err != nil {
+		return "", "", &LineError{j.line, err}
+	}
+	return k, v, nil
+}
+
+// row maps one object onto a key and value, keeping the field order of the
+// input when the value is built from several fields.
+func (j *jsonlReader) row(b []byte) (string, string, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return "", "", errors.New("not a JSON object")
+	}
+	var names []string
+	var raws []json.RawMessage
+	key := -1
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return "", "", err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return "", "", err
+		}
+		name := t.(string)
+		if name == j.key {
+			key = len(names)
+		}
+		names = append(names, name)
+		raws = append(raws, raw)
+	}
+	if _, err := dec.Token(); err != nil {
+		return "", "", err
+	}
+	if dec.More() {
+		return "", "", errors.New("trailing data after object")
+	}
+	if key < 0 {
+		return "", "", fmt.Errorf("no %q field", j.key)
+	}
+	k, ok := scalarText(raws[key])
+	if !ok {
+		return "", "", fmt.Errorf("field %q must be a string or number", j.key)
+	}
+	switch {
+	case len(names) == 1:
+		return "", "", errors.New("no value fields")
+	case len(names) == 2 && names[1-key] == "value":
+		v := raws[1-key]
+		if s, ok := scalarText(v); ok && v[0] == '"' {
+			return k, s, nil
+		}
+		return k, string(v), nil
+	}
+	var out strings.Builder
+	out.WriteByte('{')
+	n := 0
+	for i, name := range names {
+		if i == key {
+			continue
+		}
+		if n > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteString(quote(name))
+		out.WriteByte(':')
+		out.Write(raws[i])
+		n++
+	}
+	out.WriteByte('}')
+	return k, out.String(), nil
+}
+
+// scalarText returns the text of a JSON string or number.
+func scalarText(raw json.RawMessage) (string, bool) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", false
+	}
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case json.Number:
+		return x.String(), true
+	}
+	return "", false
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	key string
+}
+
+func newJSONLWriter(w io.Writer, o Options) *jsonlWriter {
+	return &jsonlWriter{w: bufio.NewWriter(w), key: o.keyColumn()}
+}
+
+// Write embeds values that are JSON objects or arrays on one line as JSON and
+// writes anything else as a string, so that loading the line restores the
+// value exactly.
+func (j *jsonlWriter) Write(key, value string) error {
+	j.w.WriteByte('{')
+	j.w.WriteString(quote(j.key))
+	j.w.WriteByte(':')
+	j.w.WriteString(quote(key))
+	j.w.WriteString(`,"value":`)
+	j.w.WriteString(jsonValue(value))
+	j.w.WriteByte('}')
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Flush() error { return j.w.Flush() }
+
+// jsonValue embeds value only when loading it back yields the same bytes.
+func jsonValue(value string) string {
+	if value == "" || (value[0] != '{' && value[0] != '[') || strings.ContainsAny(value, "\n\r") ||
+		strings.TrimSpace(value) != value || !json.Valid([]byte(value)) {
+		return quote(value)
+	}
+	return value
+}
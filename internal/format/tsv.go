@@ -0,0 +1,137 @@
+package format
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Tabs, line breaks and backslashes in cells are written as \t, \n, \r and
+// \\, so any key and value survive a round trip. The reader undoes these
+// escapes and leaves any other backslash as it is.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// tsvUnescape reverses tsvEscaper.
+func tsvUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '\\':
+				c = '\\'
+			case 't':
+				c = '\t'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			default:
+				b.WriteByte(c)
+				continue
+			}
+			i++
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// TSVReader reads TSV rows. Lines may be arbitrarily long; blank lines are
+// skipped and a trailing CR is dropped.
+type TSVReader struct {
+	r      *bufio.Reader
+	line   int
+	header bool
+	key    string
+	m      *mapping
+}
+
+func NewTSVReader(r io.Reader) *TSVReader {
+	return &TSVReader{r: bufio.NewReaderSize(r, 64<<10)}
+}
+
+func (t *TSVReader) Line() int { return t.line }
+
+func (t *TSVReader) next() (string, error) {
+	for {
+		s, err := t.r.ReadString('\n')
+		if err != nil && (err != io.EOF || s == "") {
+			return "", err
+		}
+		t.line++
+		s = strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+		if s != "" {
+			return s, nil
+		}
+	}
+}
+
+func (t *TSVReader) Read() (string, string, error) {
+	if t.header && t.m == nil {
+		s, err := t.next()
+		if err != nil {
+			return "", "", err
+		}
+		if t.m, err = newMapping(tsvCells(s, -1), t.key); err != nil {
+			return "", "", fmt.Errorf("line %d: %w", t.line, err)
+		}
+	}
+	s, err := t.next()
+	if err != nil {
+		return "", "", err
+	}
+	// Without a header, or with two columns, the value may contain tabs.
+	cells := tsvCells(s, 2)
+	if t.m != nil && len(t.m.names) > 2 {
+		cells = tsvCells(s, -1)
+	}
+	if t.m == nil {
+		if len(cells) != 2 {
+			return "", "", &LineError{t.line, errors.New("missing tab between key and value")}
+		}
+		return cells[0], cells[1], nil
+	}
+	k, v, err := t.m.row(cells)
+	if err != nil {
+		return "", "", &LineError{t.line, err}
+	}
+	return k, v, nil
+}
+
+// tsvCells splits a line into at most n unescaped cells (all if n < 0).
+func tsvCells(s string, n int) []string {
+	cells := strings.SplitN(s, "\t", n)
+	for i, c := range cells {
+		cells[i] = tsvUnescape(c)
+	}
+	return cells
+}
+
+type tsvWriter struct {
+	w *bufio.Writer
+}
+
+func newTSVWriter(w io.Writer, o Options) (*tsvWriter, error) {
+	t := &tsvWriter{w: bufio.NewWriter(w)}
+	if o.Header {
+		if err := t.Write(o.keyColumn(), "value"); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *tsvWriter) Write(key, value string) error {
+	tsvEscaper.WriteString(t.w, key)
+	t.w.WriteByte('\t')
+	tsvEscaper.WriteString(t.w, value)
+	return t.w.WriteByte('\n')
+}
+
+func (t *tsvWriter) Flush() error { return t.w.Flush() }
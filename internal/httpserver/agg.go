@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"sharkDB/internal/engine"
+)
+
+// GET /agg/{table}?fn=count|sum|min|max|avg&path=$.x computes an aggregate
+// in one pass over the table. prefix=<p>, or start=<a>&end=<b> for the
+// inclusive key range [a, b], restrict the rows; group_prefix_len=<n> groups
+// them by the first n bytes of their key. Text responses carry one value,
+// or one "group<TAB>value" line per group; JSON responses look like
+//
+//	{"table": "t", "fn": "AVG", "path": "$.age",
+//	 "results": [{"group": "", "count": 3, "value": 27}]}
+func handleAgg(eng *engine.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		table := r.URL.Path[len("/agg/"):]
+		q := r.URL.Query()
+		aq := engine.AggQuery{Func: q.Get("fn"), Path: q.Get("path"), Prefix: q.Get("prefix")}
+		if aq.Func == "" {
+			aq.Func = "count"
+		}
+		if q.Has("start") || q.Has("end") {
+			if !q.Has("start") || !q.Has("end") || aq.Prefix != "" {
+				writeError(w, r, http.StatusBadRequest, codeBadRequest, "a range needs both start and end and no prefix")
+				return
+			}
+			aq.Range, aq.Start, aq.End = true, q.Get("start"), q.Get("end")
+		}
+		if s := q.Get("group_prefix_len"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				writeError(w, r, http.StatusBadRequest, codeBadRequest, "group_prefix_len must be a positive integer")
+				return
+			}
+			aq.GroupPrefixLen = n
+		}
+		res, err := eng.Aggregate(table, aq)
+		if err != nil {
+			writeEngineError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, struct {
+				Table   string             `json:"table"`
+				Fn      string             `json:"fn"`
+				Path    string             `json:"path,omitempty"`
+				Results []engine.AggResult `json:"results"`
+			}{table, aq.Func, aq.Path, res})
+			return
+		}
+		for _, g := range res {
+			line := engine.FormatAggValue(g.Value)
+			if aq.GroupPrefixLen > 0 {
+				line = g.Group + "\t" + line
+			}
+			_, _ = io.WriteString(w, line+"\n")
+		}
+	}
+}
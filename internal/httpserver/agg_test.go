@@ -0,0 +1,54 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAgg(t *testing.T) {
+	h, eng := testServer(t, Options{})
+	for _, kv := range [][2]string{{"eu:1", `{"n":1}`}, {"eu:2", `{"n":3}`}, {"us:1", `{"n":10}`}} {
+		if _, err := eng.Insert("a", kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		target string
+		json   bool
+		status int
+		body   string
+	}{
+		{"/agg/a", false, http.StatusOK, "3\n"},
+		{"/agg/a?fn=sum&path=$.n", false, http.StatusOK, "14\n"},
+		{"/agg/a?fn=avg&path=n&prefix=eu", false, http.StatusOK, "2\n"},
+		{"/agg/a?fn=max&path=n&start=eu:2&end=us:9", false, http.StatusOK, "10\n"},
+		{"/agg/a?fn=sum&path=n&group_prefix_len=2", false, http.StatusOK, "eu\t4\nus\t10\n"},
+		{"/agg/a?fn=sum&path=n&group_prefix_len=2", true, http.StatusOK, `{"table":"a","fn":"sum","path":"n","results":[{"group":"eu","count":2,"value":4},{"group":"us","count":1,"value":10}]}` + "\n"},
+		// Empty input.
+		{"/agg/b", false, http.StatusOK, "0\n"},
+		{"/agg/b?fn=avg&path=n", false, http.StatusOK, "null\n"},
+		{"/agg/b?fn=avg&path=n", true, http.StatusOK, `{"table":"b","fn":"avg","path":"n","results":[{"group":"","count":0,"value":null}]}` + "\n"},
+		{"/agg/b?group_prefix_len=2", true, http.StatusOK, `{"table":"b","fn":"count","results":[]}` + "\n"},
+		{"/agg/a?prefix=zz&fn=sum&path=n", false, http.StatusOK, "0\n"},
+		// Errors.
+		{"/agg/a?fn=median&path=n", true, http.StatusBadRequest, codeBadRequest},
+		{"/agg/a?fn=sum", true, http.StatusBadRequest, codeBadRequest},
+		{"/agg/a?start=a", true, http.StatusBadRequest, codeBadRequest},
+		{"/agg/a?start=a&end=b&prefix=c", true, http.StatusBadRequest, codeBadRequest},
+		{"/agg/a?group_prefix_len=0", true, http.StatusBadRequest, codeBadRequest},
+		{"/agg/nope", true, http.StatusNotFound, codeTableNotFound},
+	} {
+		var w = call(t, h, http.MethodGet, tc.target, "")
+		if tc.json {
+			w = call(t, h, http.MethodGet, tc.target, "", "Accept", "application/json")
+		}
+		got := w.Body.String()
+		ok := got == tc.body
+		if tc.status != http.StatusOK {
+			ok = containsCode(got, tc.body)
+		}
+		if w.Code != tc.status || !ok {
+			t.Errorf("GET %s: status %d, body %q; want %d, %q", tc.target, w.Code, got, tc.status, tc.body)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/txn"
+)
+
+// POST /batch executes a list of put/delete/get operations as one write
+// transaction. Writes are staged in an engine.Batch and persisted together,
+// so either every write in the request is applied or none is. Gets observe
+// the writes staged before them; a missing key on a get is reported in that
+// operation's result without failing the batch.
+//
+//	{"ops": [{"op": "put", "table": "t", "key": "k", "value": "v"},
+//	         {"op": "get", "table": "t", "key": "k"},
+//	         {"op": "delete", "table": "t", "key": "old"}]}
+
+const (
+	maxBatchOps   = 10000
+	maxBatchBytes = 32 << 20
+)
+
+type batchRequest struct {
+	Ops []batchOp `json:"ops"`
+}
+
+type batchOp struct {
+	Op    string `json:"op"`
+	Table string `json:"table"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type batchResult struct {
+	Op    string       `json:"op"`
+	OK    bool         `json:"ok"`
+	Value *string      `json:"value,omitempty"`
+	Error *errorDetail `json:"error,omitempty"`
+}
+
+type batchErrorBody struct {
+	Error batchErrorDetail `json:"error"`
+}
+
+type batchErrorDetail struct {
+	errorDetail
+	Index int `json:"index"`
+}
+
+func handleBatch(eng *engine.Engine, tm *txn.Manager, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req batchRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		if err := dec.Decode(&req); err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, codeBadRequest, "batch body too large")
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid batch body: "+err.Error())
+			return
+		}
+		if len(req.Ops) > maxBatchOps {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("batch exceeds %d ops", maxBatchOps))
+			return
+		}
+		writes := false
+		for i, op := range req.Ops {
+			op.Op = strings.ToLower(op.Op)
+			req.Ops[i].Op = op.Op
+			switch op.Op {
+			case "put", "delete":
+				writes = true
+			case "get":
+			default:
+				writeBatchError(w, r, i, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("unknown op %q", op.Op))
+				return
+			}
+		}
+		if writes && !checkWrite(w, r, opts) {
+			return
+		}
+
+		tx := tm.Begin(!writes)
+		defer tx.Commit()
+		b := eng.NewBatch()
+		results := make([]batchResult, len(req.Ops))
+		for i, op := range req.Ops {
+			res := batchResult{Op: op.Op, OK: true}
+			switch op.Op {
+			case "put":
+				if err := b.Put(op.Table, op.Key, op.Value); err != nil {
+					writeBatchEngineError(w, r, i, err)
+					return
+				}
+			case "delete":
+				if err := b.Delete(op.Table, op.Key); err != nil {
+					writeBatchEngineError(w, r, i, err)
+					return
+				}
+			case "get":
+				v, err := b.Get(op.Table, op.Key)
+				if err != nil {
+					_, code := engineErrorStatus(err, http.StatusNotFound)
+					res.OK = false
+					res.Error = &errorDetail{Code: code, Message: err.Error()}
+				} else {
+					res.Value = &v
+				}
+			}
+			results[i] = res
+		}
+		if err := b.Commit(); err != nil {
+			writeEngineError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, struct {
+				OK      bool          `json:"ok"`
+				Results []batchResult `json:"results"`
+			}{true, results})
+			return
+		}
+		for _, res := range results {
+			switch {
+			case res.Error != nil:
+				_, _ = io.WriteString(w, "ERR: "+res.Error.Message+"\n")
+			case res.Value != nil:
+				_, _ = io.WriteString(w, *res.Value+"\n")
+			default:
+				_, _ = io.WriteString(w, "OK\n")
+			}
+		}
+	}
+}
+
+// writeBatchError reports a failed batch, naming the index of the operation
+// that caused it. Nothing from the batch has been applied.
+func writeBatchError(w http.ResponseWriter, r *http.Request, index, status int, code, msg string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, batchErrorBody{Error: batchErrorDetail{errorDetail{Code: code, Message: msg}, index}})
+		return
+	}
+	http.Error(w, fmt.Sprintf("op %d: %s", index, msg), status)
+}
+
+func writeBatchEngineError(w http.ResponseWriter, r *http.Request, index int, err error) {
+	status, code := engineErrorStatus(err, http.StatusBadRequest)
+	writeBatchError(w, r, index, status, code, err.Error())
+}
@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"sharkDB/internal/bptree"
+)
+
+func TestBatch(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   Options
+		body   string
+		status int
+		index  int      // of the failing op, for errors
+		code   string   // of the batch error, or of each op result
+		stored []string // keys of table a afterwards
+		inB    bool     // whether b/k2 was stored
+	}{
+		{
+			name:   "writes and reads",
+			body:   `{"ops":[{"op":"put","table":"a","key":"k1","value":"v1"},{"op":"get","table":"a","key":"k1"},{"op":"put","table":"b","key":"k2","value":"v2"},{"op":"put","table":"a","key":"k0","value":"v0"},{"op":"delete","table":"a","key":"k0"}]}`,
+			status: http.StatusOK,
+			stored: []string{"k1"},
+			inB:    true,
+		},
+		{
+			name:   "missing key on a get does not fail the batch",
+			body:   `{"ops":[{"op":"PUT","table":"a","key":"k1","value":"v1"},{"op":"get","table":"a","key":"nope"}]}`,
+			status: http.StatusOK,
+			code:   codeKeyNotFound,
+			stored: []string{"k1"},
+		},
+		{
+			name:   "missing key on a delete fails the batch",
+			body:   `{"ops":[{"op":"put","table":"b","key":"k2","value":"v2"},{"op":"delete","table":"a","key":"nope"}]}`,
+			status: http.StatusNotFound,
+			index:  1,
+			code:   codeKeyNotFound,
+		},
+		{
+			name:   "unknown table rolls back earlier writes",
+			body:   `{"ops":[{"op":"put","table":"a","key":"k1","value":"v1"},{"op":"put","table":"nope","key":"k","value":"v"}]}`,
+			status: http.StatusNotFound,
+			index:  1,
+			code:   codeTableNotFound,
+		},
+		{
+			name:   "unknown op",
+			body:   `{"ops":[{"op":"get","table":"a","key":"k"},{"op":"upsert","table":"a","key":"k1","value":"v"}]}`,
+			status: http.StatusBadRequest,
+			index:  1,
+			code:   codeBadRequest,
+		},
+		{
+			name:   "invalid body",
+			body:   `{"ops":[`,
+			status: http.StatusBadRequest,
+			code:   codeBadRequest,
+		},
+		{
+			name:   "writes on a read-only server",
+			opts:   Options{ReadOnly: true},
+			body:   `{"ops":[{"op":"put","table":"a","key":"k1","value":"v1"}]}`,
+			status: http.StatusForbidden,
+			code:   codeReadOnly,
+		},
+		{
+			name:   "reads on a read-only server",
+			opts:   Options{ReadOnly: true},
+			body:   `{"ops":[{"op":"get","table":"a","key":"nope"}]}`,
+			status: http.StatusOK,
+			code:   codeKeyNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, eng := testServer(t, tc.opts)
+			w := call(t, h, http.MethodPost, "/batch", tc.body, "Accept", "application/json")
+			if w.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if tc.status == http.StatusOK {
+				var resp struct {
+					OK      bool          `json:"ok"`
+					Results []batchResult `json:"results"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				for _, res := range resp.Results {
+					if res.Op == "get" && res.OK && (res.Value == nil || *res.Value != "v1") {
+						t.Errorf("get result %+v", res)
+					}
+					if !res.OK && (res.Error == nil || res.Error.Code != tc.code) {
+						t.Errorf("failed %s result %+v, want code %s", res.Op, res, tc.code)
+					}
+				}
+			} else {
+				var resp batchErrorBody
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Error.Code != tc.code || resp.Error.Index != tc.index {
+					t.Errorf("error %+v, want code %s at op %d", resp.Error, tc.code, tc.index)
+				}
+			}
+			pairs, err := eng.Scan("a", "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pairs) != len(tc.stored) {
+				t.Fatalf("table a holds %v, want keys %v", pairs, tc.stored)
+			}
+			for i, kv := range pairs {
+				if kv[0] != tc.stored[i] {
+					t.Fatalf("table a holds %v, want keys %v", pairs, tc.stored)
+				}
+			}
+			if _, err := eng.Get("b", "k2"); (err == nil) != tc.inB || err != nil && !errors.Is(err, bptree.ErrKeyNotFound) {
+				t.Errorf("b/k2: %v", err)
+			}
+		})
+	}
+}
+
+// Without Accept: application/json each op answers on its own line.
+func TestBatchText(t *testing.T) {
+	h, _ := testServer(t, Options{})
+	w := call(t, h, http.MethodPost, "/batch", `{"ops":[{"op":"put","table":"a","key":"k","value":"v"},{"op":"get","table":"a","key":"k"},{"op":"get","table":"a","key":"x"}]}`)
+	if w.Code != http.StatusOK || w.Body.String() != "OK\nv\nERR: key not found\n" {
+		t.Fatalf("status %d, body %q", w.Code, w.Body)
+	}
+	if w := call(t, h, http.MethodGet, "/batch", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /batch: status %d", w.Code)
+	}
+}
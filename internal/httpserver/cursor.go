@@ -0,0 +1,90 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Paginated scans hand out an opaque cursor naming the table, the prefix of
+// the scan ("" for /scan/) and the last key of the page. The next page resumes
+// strictly after that key, so rows written or deleted between requests never
+// cause skipped or repeated keys. A cursor is only valid for the table and
+// prefix it was issued for.
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	cursorVersion   = "c2"
+)
+
+var errBadCursor = errors.New("invalid cursor")
+
+func cursorHeader(table, prefix string) string {
+	return cursorVersion + "\x00" + table + "\x00" + prefix + "\x00"
+}
+
+func encodeCursor(table, prefix, lastKey string) string {
+	raw := cursorHeader(table, prefix) + lastKey
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor returns the last key of a cursor issued for table and prefix.
+func decodeCursor(table, prefix, cursor string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errBadCursor
+	}
+	key, ok := strings.CutPrefix(string(raw), cursorHeader(table, prefix))
+	if !ok {
+		return "", errBadCursor
+	}
+	return key, nil
+}
+
+// pageRequest holds the pagination parameters of a scan request.
+type pageRequest struct {
+	paged  bool   // page_size or cursor was supplied
+	size   int    // rows per page
+	after  string // last key of the previous page
+	resume bool   // after is set
+}
+
+func parsePageRequest(r *http.Request, table, prefix string) (pageRequest, error) {
+	q := r.URL.Query()
+	var pr pageRequest
+	if s := q.Get("page_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return pr, errors.New("invalid page_size")
+		}
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+		pr.paged = true
+		pr.size = n
+	}
+	if c := q.Get("cursor"); c != "" {
+		key, err := decodeCursor(table, prefix, c)
+		if err != nil {
+			return pr, err
+		}
+		pr.paged = true
+		pr.after = key
+		pr.resume = true
+	}
+	if pr.paged && pr.size == 0 {
+		pr.size = defaultPageSize
+	}
+	return pr, nil
+}
+
+// nextCursor returns the cursor for the page after pairs, or "" on the last page.
+func nextCursor(table, prefix string, pairs [][2]string, more bool) string {
+	if !more || len(pairs) == 0 {
+		return ""
+	}
+	return encodeCursor(table, prefix, pairs[len(pairs)-1][0])
+}
@@ -0,0 +1,23 @@
+package httpserver
+
+import "testing"
+
+func TestCursorBinding(t *testing.T) {
+	c := encodeCursor("users", "ab", "abc")
+	if key, err := decodeCursor("users", "ab", c); err != nil || key != "abc" {
+		t.Fatalf("decodeCursor = %q, %v", key, err)
+	}
+	for _, tc := range []struct{ table, prefix string }{
+		{"users", ""},
+		{"users", "a"},
+		{"users", "abc"},
+		{"orders", "ab"},
+	} {
+		if _, err := decodeCursor(tc.table, tc.prefix, c); err != errBadCursor {
+			t.Errorf("cursor for users/ab accepted for %s/%q", tc.table, tc.prefix)
+		}
+	}
+	if _, err := decodeCursor("users", "", "not base64!"); err != errBadCursor {
+		t.Error("garbage cursor accepted")
+	}
+}
@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/engine"
+)
+
+// Keys carry a version that changes on every write. GET /kv returns it as a
+// strong ETag, and PUT/DELETE honour If-Match and If-None-Match against it,
+// answering 412 Precondition Failed when the key changed underneath the client.
+// The ETag names the table id as well as the row version, since a table
+// created after one of the same name was dropped starts its versions over.
+
+const (
+	codePreconditionFailed   = "precondition_failed"
+	codePreconditionRequired = "precondition_required"
+)
+
+func formatETag(ver engine.Version) string {
+	return `"` + strconv.FormatUint(ver.Table, 10) + "." + strconv.FormatUint(ver.Row, 10) + `"`
+}
+
+// etagMatches reports whether the header value (an If-Match or If-None-Match
+// list) names ver. "*" matches any existing key. RFC 9110 has If-Match use
+// the strong comparison, under which a weak tag never matches, and
+// If-None-Match the weak one, which ignores the W/ prefix.
+func etagMatches(header string, exists bool, ver engine.Version, weak bool) bool {
+	if !exists {
+		return false
+	}
+	want := formatETag(ver)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match for a write to key
+// through b. It writes the error response and returns false when the write
+// must not proceed.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, opts Options, b *engine.Batch, table, key string) bool {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		if opts.RequireConditional {
+			writeError(w, r, http.StatusPreconditionRequired, codePreconditionRequired, "this server requires If-Match or If-None-Match on writes")
+			return false
+		}
+		return true
+	}
+	_, ver, err := b.GetVersion(table, key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, bptree.ErrKeyNotFound) {
+		writeEngineError(w, r, err, http.StatusBadRequest)
+		return false
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, exists, ver, false) {
+		writeError(w, r, http.StatusPreconditionFailed, codePreconditionFailed, "If-Match precondition failed")
+		return false
+	}
+	if ifNoneMatch != "" && etagMatches(ifNoneMatch, exists, ver, true) {
+		writeError(w, r, http.StatusPreconditionFailed, codePreconditionFailed, "If-None-Match precondition failed")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"sharkDB/internal/engine"
+)
+
+func TestETagMatches(t *testing.T) {
+	ver := engine.Version{Table: 3, Row: 7}
+	for _, tc := range []struct {
+		header string
+		exists bool
+		weak   bool
+		want   bool
+	}{
+		{`"3.7"`, true, false, true},
+		{`"3.7"`, false, false, false},
+		{`"3.8"`, true, false, false},
+		{`"4.7"`, true, false, false},
+		{`"1.1", "3.7"`, true, false, true},
+		{`*`, true, false, true},
+		{`*`, false, false, false},
+		{`W/"3.7"`, true, false, false},
+		{`W/"3.7"`, true, true, true},
+		{`"3.7"`, true, true, true},
+		{`3.7`, true, true, false},
+	} {
+		if got := etagMatches(tc.header, tc.exists, ver, tc.weak); got != tc.want {
+			t.Errorf("etagMatches(%s, exists %v, weak %v) = %v, want %v", tc.header, tc.exists, tc.weak, got, tc.want)
+		}
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    Options
+		method  string
+		header  []string // "current" stands for the key's ETag
+		status  int
+		code    string
+		changed bool
+	}{
+		{"unconditional put", Options{}, http.MethodPut, nil, http.StatusOK, "", true},
+		{"put with the current tag", Options{}, http.MethodPut, []string{"If-Match", "current"}, http.StatusOK, "", true},
+		{"put with a stale tag", Options{}, http.MethodPut, []string{"If-Match", `"1.99"`}, http.StatusPreconditionFailed, codePreconditionFailed, false},
+		{"put with a weak tag", Options{}, http.MethodPut, []string{"If-Match", "W/current"}, http.StatusPreconditionFailed, codePreconditionFailed, false},
+		{"create only over an existing key", Options{}, http.MethodPut, []string{"If-None-Match", "*"}, http.StatusPreconditionFailed, codePreconditionFailed, false},
+		{"delete with the current tag", Options{}, http.MethodDelete, []string{"If-Match", "current"}, http.StatusOK, "", true},
+		{"delete with a stale tag", Options{}, http.MethodDelete, []string{"If-Match", `"1.99"`}, http.StatusPreconditionFailed, codePreconditionFailed, false},
+		{"incr with the current tag", Options{}, http.MethodPost, []string{"If-Match", "current"}, http.StatusOK, "", true},
+		{"required: put without a precondition", Options{RequireConditional: true}, http.MethodPut, nil, http.StatusPreconditionRequired, codePreconditionRequired, false},
+		{"required: delete without a precondition", Options{RequireConditional: true}, http.MethodDelete, nil, http.StatusPreconditionRequired, codePreconditionRequired, false},
+		{"required: incr without a precondition", Options{RequireConditional: true}, http.MethodPost, nil, http.StatusPreconditionRequired, codePreconditionRequired, false},
+		{"required: put with the current tag", Options{RequireConditional: true}, http.MethodPut, []string{"If-Match", "current"}, http.StatusOK, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, eng := testServer(t, tc.opts)
+			if _, err := eng.Insert("a", "k", "1"); err != nil {
+				t.Fatal(err)
+			}
+			get := call(t, h, http.MethodGet, "/kv/a/k", "")
+			etag := get.Header().Get("ETag")
+			if get.Code != http.StatusOK || etag == "" {
+				t.Fatalf("GET: status %d, ETag %q", get.Code, etag)
+			}
+			header := []string{"Accept", "application/json"}
+			for i := 0; i+1 < len(tc.header); i += 2 {
+				v := tc.header[i+1]
+				switch v {
+				case "current":
+					v = etag
+				case "W/current":
+					v = "W/" + etag
+				}
+				header = append(header, tc.header[i], v)
+			}
+			target := "/kv/a/k"
+			if tc.method == http.MethodPost {
+				target += "/incr"
+			}
+			w := call(t, h, tc.method, target, "2", header...)
+			if w.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if tc.code != "" && !containsCode(w.Body.String(), tc.code) {
+				t.Errorf("body %s, want code %s", w.Body, tc.code)
+			}
+			_, ver, err := eng.GetVersion("a", "k")
+			changed := err != nil || formatETag(ver) != etag
+			if changed != tc.changed {
+				t.Fatalf("key changed = %v, want %v", changed, tc.changed)
+			}
+			if tc.changed && err == nil && w.Header().Get("ETag") != formatETag(ver) {
+				t.Errorf("response ETag %q, key now at %s", w.Header().Get("ETag"), formatETag(ver))
+			}
+		})
+	}
+}
+
+func TestConditionalGetAndCreate(t *testing.T) {
+	h, _ := testServer(t, Options{})
+	if w := call(t, h, http.MethodPut, "/kv/a/k", "v", "If-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match * on a missing key: status %d", w.Code)
+	}
+	if w := call(t, h, http.MethodPut, "/kv/a/k", "v", "If-None-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("create only: status %d", w.Code)
+	}
+	etag := call(t, h, http.MethodGet, "/kv/a/k", "").Header().Get("ETag")
+	for _, inm := range []string{etag, "W/" + etag, "*"} {
+		if w := call(t, h, http.MethodGet, "/kv/a/k", "", "If-None-Match", inm); w.Code != http.StatusNotModified {
+			t.Errorf("GET with If-None-Match %s: status %d", inm, w.Code)
+		}
+	}
+
+	// A table dropped and created again must not accept the old tags.
+	if w := call(t, h, http.MethodDelete, "/tables/a", ""); w.Code != http.StatusOK {
+		t.Fatalf("drop: status %d", w.Code)
+	}
+	if w := call(t, h, http.MethodPost, "/tables?name=a", ""); w.Code != http.StatusOK {
+		t.Fatalf("create: status %d", w.Code)
+	}
+	if w := call(t, h, http.MethodPut, "/kv/a/k", "v", "If-None-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("create only in the new table: status %d", w.Code)
+	}
+	if got := call(t, h, http.MethodGet, "/kv/a/k", "").Header().Get("ETag"); got == etag {
+		t.Fatalf("the new table repeats ETag %s", etag)
+	}
+	if w := call(t, h, http.MethodDelete, "/kv/a/k", "", "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("tag of the dropped table: status %d", w.Code)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"sharkDB/internal/engine"
 	"sharkDB/internal/txn"
@@ -12,32 +14,44 @@ import (
 type Options struct {
 	RequireToken string
 	ReadOnly     bool
+	TxTimeout    time.Duration // idle timeout for interactive transactions (0 = 30s)
+	// RequireConditional makes PUT, DELETE and increments of /kv keys
+	// carry If-Match or If-None-Match; others get 428 Precondition Required.
+	RequireConditional bool
 }
 
 // Start launches an HTTP server on addr with basic endpoints over the engine.
 // Write endpoints take an implicit write transaction using the provided txn manager.
+// Responses are plain text by default and JSON when requested via Accept.
 func Start(addr string, eng *engine.Engine, tm *txn.Manager, opts Options) error {
+	return http.ListenAndServe(addr, Handler(eng, tm, opts))
+}
+
+// Handler returns the endpoints Start serves.
+func Handler(eng *engine.Engine, tm *txn.Manager, opts Options) http.Handler {
 	mux := http.NewServeMux()
+	txs := newTxRegistry(tm, eng, opts.TxTimeout)
 
 	// List tables
 	mux.HandleFunc("/tables", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
-			for _, n := range eng.ListTables() {
+			names := eng.ListTables()
+			if wantsJSON(r) {
+				writeJSON(w, http.StatusOK, map[string][]string{"tables": names})
+				return
+			}
+			for _, n := range names {
 				_, _ = io.WriteString(w, n+"\n")
 				// one name per line
 			}
 			return
 		}
 		if r.Method == http.MethodPost {
-			if opts.ReadOnly {
-				http.Error(w, "read-only", http.StatusForbidden)
-				return
-			}
-			if opts.RequireToken != "" && r.Header.Get("Authorization") != "Bearer "+opts.RequireToken {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			if !checkWrite(w, r, opts) {
 				return
 			}
-			// create table, expects ?name=tbl or body as name
+			// create table, expects ?name=tbl or body as name; an optional
+			// ?schema=<column list> makes it a typed table
 			tbl := r.URL.Query().Get("name")
 			if tbl == "" {
 				b, _ := io.ReadAll(r.Body)
@@ -45,45 +59,44 @@ func Start(addr string, eng *engine.Engine, tm *txn.Manager, opts Options) error
 			}
 			tx := tm.Begin(false)
 			defer tx.Commit()
-			if out, err := eng.Create(tbl); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+			create := eng.Create
+			if def := r.URL.Query().Get("schema"); def != "" {
+				create = func(t string) (string, error) { return eng.CreateWithSchema(t, def) }
+			}
+			if out, err := create(tbl); err != nil {
+				writeEngineError(w, r, err, http.StatusBadRequest)
 			} else {
-				_, _ = io.WriteString(w, out+"\n")
+				writeOK(w, r, out)
 			}
 			return
 		}
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	})
 
 	// Drop table: DELETE /tables/{table}
 	mux.HandleFunc("/tables/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		if opts.ReadOnly {
-			http.Error(w, "read-only", http.StatusForbidden)
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 			return
 		}
-		if opts.RequireToken != "" && r.Header.Get("Authorization") != "Bearer "+opts.RequireToken {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if !checkWrite(w, r, opts) {
 			return
 		}
 		name := r.URL.Path[len("/tables/"):]
 		if name == "" {
-			http.Error(w, "missing table", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing table")
 			return
 		}
 		tx := tm.Begin(false)
 		defer tx.Commit()
 		if _, err := eng.Drop(name); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeEngineError(w, r, err, http.StatusBadRequest)
 			return
 		}
-		_, _ = io.WriteString(w, "OK\n")
+		writeOK(w, r, "OK")
 	})
 
-	// KV endpoints: GET/PUT/DELETE /kv/{table}/{key}
+	// KV endpoints: GET/PUT/DELETE /kv/{table}/{key}, POST /kv/{table}/{key}/incr
 	mux.HandleFunc("/kv/", func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path[len("/kv/"):]
 		// expected: table/key
@@ -95,119 +108,339 @@ func Start(addr string, eng *engine.Engine, tm *txn.Manager, opts Options) error
 			}
 		}
 		if slash == -1 {
-			http.Error(w, "expected /kv/{table}/{key}", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected /kv/{table}/{key}")
 			return
 		}
 		table := path[:slash]
 		key := path[slash+1:]
+		htx, ok := txs.fromRequest(w, r)
+		if !ok {
+			return
+		}
+		var rd reader = eng
+		if htx != nil {
+			defer txs.release(htx)
+			rd = htx.b
+		}
 		switch r.Method {
 		case http.MethodGet:
-			v, err := eng.Get(table, key)
+			v, ver, err := rd.GetVersion(table, key)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusNotFound)
+				writeEngineError(w, r, err, http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", formatETag(ver))
+			if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, true, ver, true) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			if wantsJSON(r) {
+				writeJSON(w, http.StatusOK, struct {
+					Table   string `json:"table"`
+					Key     string `json:"key"`
+					Value   string `json:"value"`
+					Version uint64 `json:"version"`
+				}{table, key, v, ver.Row})
 				return
 			}
 			_, _ = io.WriteString(w, v)
-		case http.MethodPut:
-			if opts.ReadOnly {
-				http.Error(w, "read-only", http.StatusForbidden)
+		case http.MethodPut, http.MethodDelete:
+			if !checkWrite(w, r, opts) {
 				return
 			}
-			if opts.RequireToken != "" && r.Header.Get("Authorization") != "Bearer "+opts.RequireToken {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			var body []byte
+			var ttl time.Duration
+			if r.Method == http.MethodPut {
+				body, _ = io.ReadAll(r.Body)
+				// PUT /kv/{table}/{key}?ttl=<seconds>
+				if q := r.URL.Query().Get("ttl"); q != "" {
+					secs, err := strconv.ParseInt(q, 10, 64)
+					if err == nil {
+						ttl, err = engine.TTLSeconds(secs)
+					}
+					if err != nil || secs <= 0 {
+						writeError(w, r, http.StatusBadRequest, codeBadRequest, "ttl must be a positive integer")
+						return
+					}
+				}
+			}
+			// Writes outside an interactive transaction run in an implicit one.
+			b := eng.NewBatch()
+			if htx != nil {
+				b = htx.b
+			} else {
+				tx := tm.Begin(false)
+				defer tx.Commit()
+			}
+			if !checkPreconditions(w, r, opts, b, table, key) {
 				return
 			}
-			b, _ := io.ReadAll(r.Body)
-			tx := tm.Begin(false)
-			defer tx.Commit()
-			if _, err := eng.Update(table, key, string(b)); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+			var err error
+			if r.Method == http.MethodPut {
+				err = b.PutTTL(table, key, string(body), ttl)
+			} else {
+				err = b.Delete(table, key)
+			}
+			if err == nil && htx == nil {
+				err = b.Commit()
+			}
+			if err != nil {
+				writeEngineError(w, r, err, http.StatusBadRequest)
 				return
 			}
-			_, _ = io.WriteString(w, "OK\n")
-		case http.MethodDelete:
-			if opts.ReadOnly {
-				http.Error(w, "read-only", http.StatusForbidden)
+			if _, ver, err := b.GetVersion(table, key); err == nil {
+				w.Header().Set("ETag", formatETag(ver))
+			}
+			writeOK(w, r, "OK")
+		case http.MethodPost:
+			// POST /kv/{table}/{key}/incr?by=n, or with the increment as the body
+			key, ok := strings.CutSuffix(key, "/incr")
+			if !ok {
+				writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 				return
 			}
-			if opts.RequireToken != "" && r.Header.Get("Authorization") != "Bearer "+opts.RequireToken {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			if !checkWrite(w, r, opts) {
 				return
 			}
-			tx := tm.Begin(false)
-			defer tx.Commit()
-			if _, err := eng.Delete(table, key); err != nil {
-				http.Error(w, err.Error(), http.StatusNotFound)
+			by := r.URL.Query().Get("by")
+			if by == "" {
+				body, _ := io.ReadAll(r.Body)
+				by = strings.TrimSpace(string(body))
+			}
+			delta := int64(1)
+			if by != "" {
+				n, err := strconv.ParseInt(by, 10, 64)
+				if err != nil {
+					writeError(w, r, http.StatusBadRequest, codeBadRequest, "increment must be an integer")
+					return
+				}
+				delta = n
+			}
+			b := eng.NewBatch()
+			if htx != nil {
+				b = htx.b
+			} else {
+				tx := tm.Begin(false)
+				defer tx.Commit()
+			}
+			if !checkPreconditions(w, r, opts, b, table, key) {
 				return
 			}
-			_, _ = io.WriteString(w, "OK\n")
+			n, err := b.IncrBy(table, key, delta)
+			if err == nil && htx == nil {
+				err = b.Commit()
+			}
+			if err != nil {
+				writeEngineError(w, r, err, http.StatusBadRequest)
+				return
+			}
+			if _, ver, err := b.GetVersion(table, key); err == nil {
+				w.Header().Set("ETag", formatETag(ver))
+			}
+			if wantsJSON(r) {
+				writeJSON(w, http.StatusOK, struct {
+					Table string `json:"table"`
+					Key   string `json:"key"`
+					Value int64  `json:"value"`
+				}{table, key, n})
+				return
+			}
+			_, _ = io.WriteString(w, strconv.FormatInt(n, 10)+"\n")
 		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		}
 	})
 
-	// Scan: GET /scan/{table}?start=&limit=
+	// Scan: GET /scan/{table}?start=&limit= or, paginated, ?page_size=&cursor=;
+	// both forms take an optional ?filter=<expr>&fields=<paths>
 	mux.HandleFunc("/scan/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 			return
 		}
 		table := r.URL.Path[len("/scan/"):]
+		htx, ok := txs.fromRequest(w, r)
+		if !ok {
+			return
+		}
+		var rd reader = eng
+		if htx != nil {
+			defer txs.release(htx)
+			rd = htx.b
+		}
 		start := r.URL.Query().Get("start")
+		pr, err := parsePageRequest(r, table, "")
+		if err != nil {
+			writePageError(w, r, err)
+			return
+		}
+		sel, err := engine.ParseSelection(r.URL.Query().Get("filter"), r.URL.Query().Get("fields"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidFilter, err.Error())
+			return
+		}
+		if pr.paged {
+			from, inclusive := start, true
+			if pr.resume {
+				from, inclusive = pr.after, false
+			}
+			pairs, more, err := rd.SelectPage(table, "", from, inclusive, pr.size, sel)
+			if err != nil {
+				writeEngineError(w, r, err, http.StatusBadRequest)
+				return
+			}
+			writePage(w, r, table, "", pairs, more)
+			return
+		}
 		limit := 0
 		if s := r.URL.Query().Get("limit"); s != "" {
 			if n, err := strconv.Atoi(s); err == nil {
 				limit = n
 			}
 		}
-		pairs, err := eng.Scan(table, start, limit)
+		var pairs [][2]string
+		if sel != nil {
+			pairs, _, err = rd.SelectPage(table, "", start, true, limit, sel)
+		} else {
+			pairs, err = rd.Scan(table, start, limit)
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeEngineError(w, r, err, http.StatusBadRequest)
 			return
 		}
-		for _, kv := range pairs {
-			_, _ = io.WriteString(w, kv[0]+"\t"+kv[1]+"\n")
-		}
+		writePairs(w, r, table, pairs, "")
 	})
 
-	// Prefix scan: GET /prefix/{table}?prefix=&limit=
+	// Prefix scan: GET /prefix/{table}?prefix=&limit= or, paginated, ?prefix=&page_size=&cursor=
 	mux.HandleFunc("/prefix/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 			return
 		}
 		table := r.URL.Path[len("/prefix/"):]
+		htx, ok := txs.fromRequest(w, r)
+		if !ok {
+			return
+		}
+		var rd reader = eng
+		if htx != nil {
+			defer txs.release(htx)
+			rd = htx.b
+		}
 		prefix := r.URL.Query().Get("prefix")
+		pr, err := parsePageRequest(r, table, prefix)
+		if err != nil {
+			writePageError(w, r, err)
+			return
+		}
+		sel, err := engine.ParseSelection(r.URL.Query().Get("filter"), r.URL.Query().Get("fields"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidFilter, err.Error())
+			return
+		}
+		if pr.paged {
+			from, inclusive := prefix, true
+			if pr.resume {
+				from, inclusive = pr.after, false
+			}
+			pairs, more, err := rd.SelectPage(table, prefix, from, inclusive, pr.size, sel)
+			if err != nil {
+				writeEngineError(w, r, err, http.StatusBadRequest)
+				return
+			}
+			writePage(w, r, table, prefix, pairs, more)
+			return
+		}
 		limit := 0
 		if s := r.URL.Query().Get("limit"); s != "" {
 			if n, err := strconv.Atoi(s); err == nil {
 				limit = n
 			}
 		}
-		pairs, err := eng.PrefixScan(table, prefix, limit)
+		var pairs [][2]string
+		if sel != nil {
+			pairs, _, err = rd.SelectPage(table, prefix, prefix, true, limit, sel)
+		} else {
+			pairs, err = rd.PrefixScan(table, prefix, limit)
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeEngineError(w, r, err, http.StatusBadRequest)
 			return
 		}
-		for _, kv := range pairs {
-			_, _ = io.WriteString(w, kv[0]+"\t"+kv[1]+"\n")
-		}
+		writePairs(w, r, table, pairs, "")
 	})
 
+	// Batch: POST /batch executes put/delete/get ops atomically
+	mux.HandleFunc("/batch", handleBatch(eng, tm, opts))
+
+	// Interactive transactions: POST /tx, POST /tx/{id}/commit, POST /tx/{id}/abort
+	mux.HandleFunc("/tx", handleTx(txs, opts))
+	mux.HandleFunc("/tx/", handleTx(txs, opts))
+
 	// Stats: GET /stats/{table}
 	mux.HandleFunc("/stats/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 			return
 		}
 		table := r.URL.Path[len("/stats/"):]
 		s, err := eng.Stats(table)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeEngineError(w, r, err, http.StatusBadRequest)
 			return
 		}
-		_, _ = io.WriteString(w, "count="+strconv.Itoa(s.Count)+" height="+strconv.Itoa(s.Height)+" min="+s.MinKey+" max="+s.MaxKey+"\n")
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, struct {
+				Table string `json:"table"`
+				engine.Stats
+			}{table, s})
+			return
+		}
+		_, _ = io.WriteString(w, s.String()+"\n")
 	})
 
-	return http.ListenAndServe(addr, mux)
+	// Aggregates: GET /agg/{table}?fn=&path=[&prefix=|&start=&end=][&group_prefix_len=]
+	mux.HandleFunc("/agg/", handleAgg(eng))
+
+	// Bulk load and export: POST /load/{table}, GET /dump/{table}
+	mux.HandleFunc("/load/", handleLoad(eng, tm, opts))
+	mux.HandleFunc("/dump/", handleDump(eng))
+
+	// Backup: GET /admin/backup
+	mux.HandleFunc("/admin/backup", handleBackup(eng, tm, opts))
+
+	return mux
+}
+
+// checkWrite rejects writes on a read-only server or without the bearer token.
+// It reports whether the request may proceed.
+func checkWrite(w http.ResponseWriter, r *http.Request, opts Options) bool {
+	if opts.ReadOnly {
+		writeError(w, r, http.StatusForbidden, codeReadOnly, "read-only")
+		return false
+	}
+	if opts.RequireToken != "" && r.Header.Get("Authorization") != "Bearer "+opts.RequireToken {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return false
+	}
+	return true
+}
+
+// writePage writes one page of a paginated scan. The continuation token, if
+// any, is returned in the X-Sharkdb-Next-Cursor header and, for JSON
+// responses, as next_cursor in the body.
+func writePage(w http.ResponseWriter, r *http.Request, table, prefix string, pairs [][2]string, more bool) {
+	c := nextCursor(table, prefix, pairs, more)
+	if c != "" {
+		w.Header().Set("X-Sharkdb-Next-Cursor", c)
+	}
+	writePairs(w, r, table, pairs, c)
+}
+
+func writePageError(w http.ResponseWriter, r *http.Request, err error) {
+	code := codeBadRequest
+	if err == errBadCursor {
+		code = codeInvalidCursor
+	}
+	writeError(w, r, http.StatusBadRequest, code, err.Error())
 }
@@ -0,0 +1,75 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/storage"
+	"sharkDB/internal/txn"
+)
+
+// testServer serves a fresh in-memory engine holding the empty tables a and b.
+func testServer(t *testing.T, opts Options) (http.Handler, *engine.Engine) {
+	t.Helper()
+	eng := engine.New(storage.NewMemory())
+	for _, name := range []string{"a", "b"} {
+		if _, err := eng.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Handler(eng, txn.NewManager(), opts), eng
+}
+
+// call sends a request to h. header lists header names and values in turn.
+func call(t *testing.T, h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// containsCode reports whether a JSON error body carries code.
+func containsCode(body, code string) bool {
+	return strings.Contains(body, `"code":"`+code+`"`)
+}
+
+func TestScanSelection(t *testing.T) {
+	h, eng := testServer(t, Options{})
+	for _, kv := range [][2]string{{"u1", `{"age":30,"name":"Ann"}`}, {"u2", `{"age":20,"name":"Bob"}`}, {"u3", "plain"}} {
+		if _, err := eng.Insert("a", kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/scan/a?filter=$.age%3E25&fields=name", http.StatusOK, "u1\t{\"name\":\"Ann\"}\n"},
+		{"/scan/a?fields=$.age", http.StatusOK, "u1\t{\"age\":30}\nu2\t{\"age\":20}\n"},
+		{"/scan/a?filter=$.age%3E25&page_size=1", http.StatusOK, "u1\t{\"age\":30,\"name\":\"Ann\"}\n"},
+		{"/prefix/a?prefix=u&filter=name=Bob", http.StatusOK, "u2\t{\"age\":20,\"name\":\"Bob\"}\n"},
+		{"/scan/a?filter=$.age%3E", http.StatusBadRequest, codeInvalidFilter},
+		{"/scan/a?fields=$.", http.StatusBadRequest, codeInvalidFilter},
+		{"/prefix/a?filter=(age=1", http.StatusBadRequest, codeInvalidFilter},
+	} {
+		w := call(t, h, http.MethodGet, tc.target, "", "Accept", "text/plain")
+		if tc.status != http.StatusOK {
+			w = call(t, h, http.MethodGet, tc.target, "", "Accept", "application/json")
+			if w.Code != tc.status || !containsCode(w.Body.String(), tc.body) {
+				t.Errorf("GET %s: status %d, body %s", tc.target, w.Code, w.Body)
+			}
+			continue
+		}
+		if w.Code != tc.status || w.Body.String() != tc.body {
+			t.Errorf("GET %s: status %d, body %q; want %q", tc.target, w.Code, w.Body, tc.body)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/format"
+	"sharkDB/internal/txn"
+)
+
+// POST /load/{table} bulk-loads the request body. format=tsv|csv|jsonl,
+// header=1, key=<column> and on_error=skip|abort select the input format as
+// LOAD ... FORMAT does. The body is streamed into the engine's external sort
+// without holding the write lock; the lock is taken only to merge and
+// persist, so a large or slow upload does not stall other writers. Rows are
+// loaded all at once or, on an error that is not skipped, not at all. JSON
+// responses look like
+//
+//	{"table": "t", "loaded": 1000, "skipped": 1, "errors": ["line 7: ..."]}
+func handleLoad(eng *engine.Engine, tm *txn.Manager, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !checkWrite(w, r, opts) {
+			return
+		}
+		table := r.URL.Path[len("/load/"):]
+		fo, err := formatOptions(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		rows, err := format.NewReader(r.Body, fo)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		l, err := eng.NewBulkLoad(table)
+		if err != nil {
+			writeEngineError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		defer l.Close()
+		if err := l.AddFrom(rows, fo.SkipErrors); err != nil {
+			writeEngineError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		tx := tm.Begin(false)
+		res, err := l.Commit()
+		if err != nil {
+			tx.Abort()
+			writeEngineError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		tx.Commit()
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, res)
+			return
+		}
+		_, _ = io.WriteString(w, res.String()+"\n")
+		for _, e := range res.Errors {
+			_, _ = io.WriteString(w, "skipped "+e+"\n")
+		}
+	}
+}
+
+// formatOptions reads the format, header, key and on_error query parameters.
+func formatOptions(r *http.Request) (format.Options, error) {
+	q := r.URL.Query()
+	header := ""
+	if h := q.Get("header"); h != "" && h != "0" && h != "false" {
+		header = "HEADER"
+	}
+	return format.OptionsFromArgs([]string{q.Get("format"), header, q.Get("key"), q.Get("on_error")})
+}
+
+// GET /dump/{table} streams every row of table in the format selected by
+// format=tsv|csv|jsonl, header=1 and key=<column>, as DUMP does. Rows are
+// written as they are read, so the response uses chunked encoding and an
+// error after the first rows can only end it early.
+func handleDump(eng *engine.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		table := r.URL.Path[len("/dump/"):]
+		fo, err := formatOptions(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if _, err := eng.Schema(table); err != nil {
+			writeEngineError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		switch fo.Format {
+		case format.CSV:
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		case format.JSONL:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		default:
+			w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+		}
+		cw := &countingWriter{w: w}
+		rw, err := format.NewWriter(cw, fo)
+		if err == nil {
+			_, err = eng.Dump(table, rw)
+		}
+		if err != nil {
+			if cw.n == 0 {
+				w.Header().Del("Content-Type")
+				writeEngineError(w, r, err, http.StatusBadRequest)
+				return
+			}
+			// Abort the response so the client sees a truncated body
+			// rather than a complete-looking one.
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/engine"
+	"sharkDB/internal/schema"
+)
+
+// Responses are plain text unless the client asks for JSON with an Accept
+// header naming application/json. JSON errors share one envelope:
+//
+//	{"error": {"code": "key_not_found", "message": "key not found"}}
+
+// Error codes used in JSON error envelopes.
+const (
+	codeBadRequest       = "bad_request"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeReadOnly         = "read_only"
+	codeUnauthorized     = "unauthorized"
+	codeTableNotFound    = "table_not_found"
+	codeTableExists      = "table_exists"
+	codeKeyNotFound      = "key_not_found"
+	codeInvalidCursor    = "invalid_cursor"
+	codeInvalidFilter    = "invalid_filter"
+	codeNotInteger       = "not_integer"
+	codeOverflow         = "overflow"
+	codeInvalidRow       = "invalid_row"
+	codeInvalidSchema    = "invalid_schema"
+	codeInternal         = "internal"
+)
+
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type okBody struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type kvItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type pageBody struct {
+	Table      string   `json:"table"`
+	Items      []kvItem `json:"items"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// wantsJSON reports whether the Accept header lists application/json.
+func wantsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mt == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError reports an error in the format the client negotiated.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, errorBody{Error: errorDetail{Code: code, Message: msg}})
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// writeEngineError maps an engine error to a status and error code. fallback
+// is the status used for errors without a more specific mapping.
+func writeEngineError(w http.ResponseWriter, r *http.Request, err error, fallback int) {
+	status, code := engineErrorStatus(err, fallback)
+	writeError(w, r, status, code, err.Error())
+}
+
+func engineErrorStatus(err error, fallback int) (int, string) {
+	switch {
+	case errors.Is(err, catalog.ErrTableNotFound):
+		return http.StatusNotFound, codeTableNotFound
+	case errors.Is(err, catalog.ErrTableExists):
+		return http.StatusConflict, codeTableExists
+	case errors.Is(err, bptree.ErrKeyNotFound):
+		return http.StatusNotFound, codeKeyNotFound
+	case errors.Is(err, engine.ErrConflict):
+		return http.StatusPreconditionFailed, codePreconditionFailed
+	case errors.Is(err, engine.ErrNotInteger):
+		return http.StatusConflict, codeNotInteger
+	case errors.Is(err, engine.ErrOverflow):
+		return http.StatusConflict, codeOverflow
+	case errors.Is(err, engine.ErrTTLRange):
+		return http.StatusBadRequest, codeBadRequest
+	case errors.Is(err, schema.ErrInvalid):
+		return http.StatusBadRequest, codeInvalidRow
+	case errors.Is(err, schema.ErrBadSchema):
+		return http.StatusBadRequest, codeInvalidSchema
+	case fallback >= http.StatusInternalServerError:
+		return fallback, codeInternal
+	}
+	return fallback, codeBadRequest
+}
+
+// writeOK acknowledges a write: msg as a text line, or {"ok":true} as JSON.
+func writeOK(w http.ResponseWriter, r *http.Request, msg string) {
+	if wantsJSON(r) {
+		body := okBody{OK: true}
+		if msg != "OK" {
+			body.Message = msg
+		}
+		writeJSON(w, http.StatusOK, body)
+		return
+	}
+	_, _ = io.WriteString(w, msg+"\n")
+}
+
+func pairsToItems(pairs [][2]string) []kvItem {
+	items := make([]kvItem, 0, len(pairs))
+	for _, kv := range pairs {
+		items = append(items, kvItem{Key: kv[0], Value: kv[1]})
+	}
+	return items
+}
+
+// writePairs writes scan results as TSV lines or as a JSON page body.
+func writePairs(w http.ResponseWriter, r *http.Request, table string, pairs [][2]string, cursor string) {
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, pageBody{Table: table, Items: pairsToItems(pairs), NextCursor: cursor})
+		return
+	}
+	for _, kv := range pairs {
+		_, _ = io.WriteString(w, kv[0]+"\t"+kv[1]+"\n")
+	}
+}
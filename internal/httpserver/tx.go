@@ -0,0 +1,203 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/txn"
+)
+
+// Interactive transactions: POST /tx takes the txn manager's write lock and
+// returns an id. Requests to /kv/, /scan/ and /prefix/ carrying that id in the
+// X-Sharkdb-Tx header read and write through the transaction's engine.Batch,
+// so their writes stay invisible to other clients until
+// POST /tx/{id}/commit persists them atomically. POST /tx/{id}/abort discards
+// them. A transaction idle for longer than the timeout is aborted by the
+// server, releasing the write lock.
+
+const (
+	txHeader         = "X-Sharkdb-Tx"
+	defaultTxTimeout = 30 * time.Second
+	codeTxNotFound   = "tx_not_found"
+)
+
+// reader is the read surface shared by the engine and a transaction's batch.
+type reader interface {
+	GetVersion(table, key string) (string, engine.Version, error)
+	Scan(table, start string, limit int) ([][2]string, error)
+	PrefixScan(table, prefix string, limit int) ([][2]string, error)
+	SelectPage(table, prefix, from string, inclusive bool, size int, sel *engine.Selection) ([][2]string, bool, error)
+}
+
+type httpTx struct {
+	mu       sync.Mutex
+	id       string
+	tx       *txn.Tx
+	b        *engine.Batch
+	deadline time.Time
+	timer    *time.Timer
+	done     bool
+}
+
+type txRegistry struct {
+	mu      sync.Mutex
+	txs     map[string]*httpTx
+	tm      *txn.Manager
+	eng     *engine.Engine
+	timeout time.Duration
+}
+
+func newTxRegistry(tm *txn.Manager, eng *engine.Engine, timeout time.Duration) *txRegistry {
+	if timeout <= 0 {
+		timeout = defaultTxTimeout
+	}
+	return &txRegistry{txs: make(map[string]*httpTx), tm: tm, eng: eng, timeout: timeout}
+}
+
+// begin blocks until the write lock is available and registers a new transaction.
+func (reg *txRegistry) begin() (*httpTx, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, err
+	}
+	t := &httpTx{id: hex.EncodeToString(raw[:])}
+	t.tx = reg.tm.Begin(false)
+	t.b = reg.eng.NewBatch()
+	t.deadline = time.Now().Add(reg.timeout)
+	t.timer = time.AfterFunc(reg.timeout, func() { reg.expire(t) })
+	reg.mu.Lock()
+	reg.txs[t.id] = t
+	reg.mu.Unlock()
+	return t, nil
+}
+
+// acquire returns the live transaction named id, locked for the caller's
+// exclusive use, or nil if it does not exist or has already finished.
+func (reg *txRegistry) acquire(id string) *httpTx {
+	reg.mu.Lock()
+	t := reg.txs[id]
+	reg.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		return nil
+	}
+	return t
+}
+
+// release extends the idle deadline of t and unlocks it.
+func (reg *txRegistry) release(t *httpTx) {
+	if !t.done {
+		t.deadline = time.Now().Add(reg.timeout)
+		t.timer.Reset(reg.timeout)
+	}
+	t.mu.Unlock()
+}
+
+// finish ends a locked transaction, committing its batch when commit is set.
+// The write lock is released either way.
+func (reg *txRegistry) finish(t *httpTx, commit bool) error {
+	var err error
+	if commit {
+		err = t.b.Commit()
+	}
+	if commit && err == nil {
+		t.tx.Commit()
+	} else {
+		t.tx.Abort()
+	}
+	t.done = true
+	t.timer.Stop()
+	reg.mu.Lock()
+	delete(reg.txs, t.id)
+	reg.mu.Unlock()
+	return err
+}
+
+func (reg *txRegistry) expire(t *httpTx) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	// A request may have extended the deadline while the timer was firing.
+	if wait := time.Until(t.deadline); wait > 0 {
+		t.timer.Reset(wait)
+		return
+	}
+	log.Printf("http tx %s idle for %s, aborting", t.id, reg.timeout)
+	_ = reg.finish(t, false)
+}
+
+// fromRequest resolves the X-Sharkdb-Tx header. It returns (nil, true) when the
+// header is absent and writes an error and returns false when it names an
+// unknown or finished transaction. A returned transaction must be released.
+func (reg *txRegistry) fromRequest(w http.ResponseWriter, r *http.Request) (*httpTx, bool) {
+	id := r.Header.Get(txHeader)
+	if id == "" {
+		return nil, true
+	}
+	t := reg.acquire(id)
+	if t == nil {
+		writeError(w, r, http.StatusNotFound, codeTxNotFound, "transaction "+id+" not found or expired")
+		return nil, false
+	}
+	return t, true
+}
+
+// handleTx serves POST /tx and POST /tx/{id}/commit|abort.
+func handleTx(reg *txRegistry, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !checkWrite(w, r, opts) {
+			return
+		}
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tx"), "/")
+		if rest == "" {
+			t, err := reg.begin()
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			w.Header().Set(txHeader, t.id)
+			if wantsJSON(r) {
+				writeJSON(w, http.StatusOK, struct {
+					Tx             string  `json:"tx"`
+					TimeoutSeconds float64 `json:"timeout_seconds"`
+				}{t.id, reg.timeout.Seconds()})
+				return
+			}
+			_, _ = io.WriteString(w, t.id+"\n")
+			return
+		}
+		id, action, ok := strings.Cut(rest, "/")
+		if !ok || (action != "commit" && action != "abort") {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected /tx/{id}/commit or /tx/{id}/abort")
+			return
+		}
+		t := reg.acquire(id)
+		if t == nil {
+			writeError(w, r, http.StatusNotFound, codeTxNotFound, "transaction "+id+" not found or expired")
+			return
+		}
+		defer reg.release(t)
+		if err := reg.finish(t, action == "commit"); err != nil {
+			writeEngineError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		writeOK(w, r, "OK")
+	}
+}
@@ -0,0 +1,108 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTx(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		end     func(t *testing.T, h http.Handler, id string)
+		visible bool
+	}{
+		{"commit", func(t *testing.T, h http.Handler, id string) {
+			if w := call(t, h, http.MethodPost, "/tx/"+id+"/commit", ""); w.Code != http.StatusOK {
+				t.Fatalf("commit: status %d: %s", w.Code, w.Body)
+			}
+		}, true},
+		{"abort", func(t *testing.T, h http.Handler, id string) {
+			if w := call(t, h, http.MethodPost, "/tx/"+id+"/abort", ""); w.Code != http.StatusOK {
+				t.Fatalf("abort: status %d: %s", w.Code, w.Body)
+			}
+		}, false},
+		{"idle timeout", func(t *testing.T, h http.Handler, id string) {
+			time.Sleep(150 * time.Millisecond)
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := testServer(t, Options{TxTimeout: 50 * time.Millisecond})
+			w := call(t, h, http.MethodPost, "/tx", "")
+			id := strings.TrimSpace(w.Body.String())
+			if w.Code != http.StatusOK || id == "" || w.Header().Get(txHeader) != id {
+				t.Fatalf("POST /tx: status %d, body %q", w.Code, w.Body)
+			}
+			if w := call(t, h, http.MethodPut, "/kv/a/k", "v", txHeader, id); w.Code != http.StatusOK {
+				t.Fatalf("PUT in tx: status %d: %s", w.Code, w.Body)
+			}
+			if w := call(t, h, http.MethodGet, "/kv/a/k", "", txHeader, id); w.Code != http.StatusOK || w.Body.String() != "v" {
+				t.Fatalf("GET in tx: status %d, body %q", w.Code, w.Body)
+			}
+			if w := call(t, h, http.MethodGet, "/kv/a/k", ""); w.Code != http.StatusNotFound {
+				t.Fatalf("uncommitted write visible outside the tx: status %d", w.Code)
+			}
+			tc.end(t, h, id)
+
+			w = call(t, h, http.MethodGet, "/kv/a/k", "")
+			if got := w.Code == http.StatusOK; got != tc.visible {
+				t.Fatalf("after %s: status %d", tc.name, w.Code)
+			}
+			// The tx is gone and its write lock released.
+			if w := call(t, h, http.MethodGet, "/kv/a/k", "", txHeader, id, "Accept", "application/json"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), codeTxNotFound) {
+				t.Fatalf("finished tx still usable: status %d: %s", w.Code, w.Body)
+			}
+			done := make(chan int)
+			go func() { done <- call(t, h, http.MethodPut, "/kv/a/other", "v").Code }()
+			select {
+			case code := <-done:
+				if code != http.StatusOK {
+					t.Fatalf("PUT after the tx: status %d", code)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the tx still holds the write lock")
+			}
+		})
+	}
+}
+
+// Requests that use a transaction push its idle deadline back.
+func TestTxActivityExtendsTimeout(t *testing.T) {
+	h, _ := testServer(t, Options{TxTimeout: 100 * time.Millisecond})
+	id := strings.TrimSpace(call(t, h, http.MethodPost, "/tx", "").Body.String())
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if w := call(t, h, http.MethodPut, "/kv/a/k", "v", txHeader, id); w.Code != http.StatusOK {
+			t.Fatalf("PUT %d: status %d: %s", i, w.Code, w.Body)
+		}
+	}
+	if w := call(t, h, http.MethodPost, "/tx/"+id+"/commit", ""); w.Code != http.StatusOK {
+		t.Fatalf("commit: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestTxErrors(t *testing.T) {
+	h, _ := testServer(t, Options{})
+	for _, tc := range []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodGet, "/tx", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/tx/abc", http.StatusBadRequest},
+		{http.MethodPost, "/tx/abc/rollback", http.StatusBadRequest},
+		{http.MethodPost, "/tx/abc/commit", http.StatusNotFound},
+		{http.MethodPost, "/tx/abc/abort", http.StatusNotFound},
+	} {
+		if w := call(t, h, tc.method, tc.target, ""); w.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.target, w.Code, tc.status)
+		}
+	}
+	if w := call(t, h, http.MethodGet, "/scan/a", "", txHeader, "abc"); w.Code != http.StatusNotFound {
+		t.Errorf("scan with an unknown tx: status %d", w.Code)
+	}
+	ro, _ := testServer(t, Options{ReadOnly: true})
+	if w := call(t, ro, http.MethodPost, "/tx", ""); w.Code != http.StatusForbidden {
+		t.Errorf("POST /tx on a read-only server: status %d", w.Code)
+	}
+}
@@ -0,0 +1,432 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/compress"
+	"sharkDB/internal/pager2"
+)
+
+// Inspect prints what a database file holds, page by page and tree by
+// tree, for understanding its size and shape. Like check it reads the file
+// raw and never replays the WAL. Damage is shown rather than reported: a
+// page claimed twice keeps its first owner, and pages nobody claims are
+// listed as orphans. Use check to find out whether the file is sound.
+
+// chainCapacity is the blob bytes a chain page holds.
+const chainCapacity = pager2.PageSize - 12
+
+// blob is a table or index tree stored as a page chain.
+type blob struct {
+	id    uint64
+	kind  string // "table", "index" or "blob" when the catalog does not name it
+	name  string
+	head  uint64
+	pages []uint64
+	bytes int
+}
+
+func (b *blob) label() string {
+	if b.kind == "blob" {
+		return fmt.Sprintf("blob %d", b.id)
+	}
+	return b.kind + " " + b.name
+}
+
+// DB is a database file opened for inspection.
+type DB struct {
+	rf    *pager2.RawFile
+	meta  pager2.Meta
+	blobs []*blob
+	owner []string // page id -> what it belongs to
+	free  int
+}
+
+// Open reads the meta of the database at path, which key opens if it is
+// encrypted, and maps its pages.
+func Open(path string, key []byte) (*DB, error) {
+	rf, err := pager2.OpenRaw(path, key)
+	if err != nil {
+		return nil, err
+	}
+	m, err := rf.Meta()
+	if err != nil {
+		rf.Close()
+		return nil, err
+	}
+	db := &DB{rf: rf, meta: m, owner: make([]string, rf.Pages)}
+	db.owner[0] = "meta"
+	for id, head := range m.TableHead {
+		b := &blob{id: id, kind: "blob", head: head}
+		for name, tid := range m.Tables {
+			if tid == id {
+				b.kind, b.name = "table", name
+			}
+		}
+		for name, ix := range m.Indexes {
+			if ix.TreeID == id {
+				b.kind, b.name = "index", name
+			}
+		}
+		db.blobs = append(db.blobs, b)
+	}
+	sort.Slice(db.blobs, func(i, j int) bool { return db.blobs[i].id < db.blobs[j].id })
+	for _, b := range db.blobs {
+		db.walk(b.head, func(pid uint64, page []byte) bool {
+			_, data, ok := pager2.ChainPage(page)
+			if !ok || !db.claim(pid, b.label()) {
+				return false
+			}
+			b.pages = append(b.pages, pid)
+			b.bytes += len(data)
+			return true
+		}, pager2.ChainPage)
+	}
+	for _, head := range m.Deferred {
+		db.walk(head, func(pid uint64, page []byte) bool {
+			_, _, ok := pager2.ChainPage(page)
+			return ok && db.claim(pid, "held (freed on next open)")
+		}, pager2.ChainPage)
+	}
+	db.walk(m.FreeList, func(pid uint64, page []byte) bool {
+		if !db.claim(pid, "free") {
+			return false
+		}
+		db.free++
+		return true
+	}, func(page []byte) (uint64, []byte, bool) { return pager2.FreePage(page), nil, true })
+	return db, nil
+}
+
+// Close closes the file.
+func (db *DB) Close() error { return db.rf.Close() }
+
+// WALBytes returns the size of the WAL, whose writes this view may lack.
+func (db *DB) WALBytes() int64 { return db.rf.WALBytes }
+
+func (db *DB) claim(pid uint64, owner string) bool {
+	if pid >= uint64(len(db.owner)) || db.owner[pid] != "" {
+		return false
+	}
+	db.owner[pid] = owner
+	return true
+}
+
+// walk follows a chain from head, calling fn for each page until fn
+// returns false or the chain ends or breaks.
+func (db *DB) walk(head uint64, fn func(pid uint64, page []byte) bool, link func([]byte) (uint64, []byte, bool)) {
+	for pid := head; pid != 0; {
+		page, err := db.rf.Page(pid)
+		if err != nil || !fn(pid, page) {
+			return
+		}
+		pid, _, _ = link(page)
+	}
+}
+
+// Meta prints the meta page.
+func (db *DB) Meta(w io.Writer) {
+	m := db.meta
+	if v, err := db.rf.Version(); err == nil {
+		fmt.Fprintf(w, "format     %d\n", v)
+	}
+	fmt.Fprintf(w, "LSN        %d\n", m.LSN)
+	fmt.Fprintf(w, "next id    %d\n", m.NextTableID)
+	fmt.Fprintf(w, "free list  %s\n", pageRef(m.FreeList))
+	fmt.Fprintf(w, "tables     %d\n", len(m.Tables))
+	for _, name := range sortedKeys(m.Tables) {
+		id := m.Tables[name]
+		fmt.Fprintf(w, "  %-20s id %-4d head %s", name, id, pageRef(m.TableHead[id]))
+		if def, ok := m.Schemas[id]; ok {
+			fmt.Fprintf(w, "  (%s)", def)
+		}
+		if method, ok := m.Compression[id]; ok {
+			fmt.Fprintf(w, "  compression %s", method)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "indexes    %d\n", len(m.Indexes))
+	for _, name := range sortedKeys(m.Indexes) {
+		ix := m.Indexes[name]
+		fmt.Fprintf(w, "  %-20s id %-4d head %s  on table id %d (%s)\n", name, ix.TreeID, pageRef(m.TableHead[ix.TreeID]), ix.TableID, ix.Field)
+	}
+}
+
+func pageRef(pid uint64) string {
+	if pid == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("page %d", pid)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Pages lists every page with what it belongs to, then totals by type.
+func (db *DB) Pages(w io.Writer) error {
+	fmt.Fprintf(w, "%-8s %-28s %8s %6s %5s\n", "page", "type", "next", "bytes", "fill")
+	totals := make(map[string]int)
+	for pid := uint64(0); pid < db.rf.Pages; pid++ {
+		owner := db.owner[pid]
+		if owner == "" {
+			owner = "orphan"
+		}
+		kind, _, _ := strings.Cut(owner, " ")
+		totals[kind]++
+		if pid == 0 {
+			fmt.Fprintf(w, "%-8d %-28s %8s %6s %5s\n", pid, owner, "-", "-", "-")
+			continue
+		}
+		page, err := db.rf.Page(pid)
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case "table", "index", "blob", "held":
+			next, data, _ := pager2.ChainPage(page)
+			fmt.Fprintf(w, "%-8d %-28s %8s %6d %4.0f%%\n", pid, owner, nextRef(next), len(data), 100*float64(len(data))/chainCapacity)
+		case "free":
+			fmt.Fprintf(w, "%-8d %-28s %8s %6s %5s\n", pid, owner, nextRef(pager2.FreePage(page)), "-", "-")
+		default:
+			fmt.Fprintf(w, "%-8d %-28s %8s %6s %5s\n", pid, owner, "-", "-", "-")
+		}
+	}
+	fmt.Fprintf(w, "\n%d pages:", db.rf.Pages)
+	for _, kind := range []string{"meta", "table", "index", "blob", "held", "free", "orphan"} {
+		if totals[kind] > 0 {
+			fmt.Fprintf(w, " %d %s", totals[kind], kind)
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+func nextRef(pid uint64) string {
+	if pid == 0 {
+		return "-"
+	}
+	return fmt.Sprint(pid)
+}
+
+// Tables prints the pages, bytes and fill factor of every tree, its
+// compression ratio, and the shape of the tree it decodes to. Page fill is
+// the share of the chain's capacity the blob uses; the ratio is the tree's
+// size uncompressed over the bytes it takes; leaf fill is the average
+// leaf's share of the Order-1 keys a leaf can hold.
+func (db *DB) Tables(w io.Writer) {
+	fmt.Fprintf(w, "%-28s %6s %10s %6s %10s %6s %8s %6s %6s %9s\n", "tree", "pages", "bytes", "fill", "raw", "ratio", "keys", "height", "nodes", "leaf fill")
+	var pages, bytes, raw int
+	for _, b := range db.blobs {
+		pages += len(b.pages)
+		bytes += b.bytes
+		fill := 0.0
+		if len(b.pages) > 0 {
+			fill = 100 * float64(b.bytes) / float64(len(b.pages)*chainCapacity)
+		}
+		fmt.Fprintf(w, "%-28s %6d %10d %5.0f%%", b.label(), len(b.pages), b.bytes, fill)
+		t, n, err := db.tree(b)
+		if err != nil {
+			fmt.Fprintf(w, "  %v\n", err)
+			continue
+		}
+		raw += n
+		s := shapeOf(t)
+		fmt.Fprintf(w, " %10d %6s %8d %6d %6d %8.0f%%\n", n, ratio(n, b.bytes), s.keys, len(s.levels), s.nodes(), 100*s.leafFill())
+	}
+	fmt.Fprintf(w, "%-28s %6d %10d %6s %10d %6s\n", "total", pages, bytes, "", raw, ratio(raw, bytes))
+	fmt.Fprintf(w, "file: %d pages, %d free\n", db.rf.Pages, db.free)
+}
+
+// tree decodes the tree of b and returns it with the length of its blob
+// once decompressed.
+func (db *DB) tree(b *blob) (*bptree.BPTree, int, error) {
+	var data []byte
+	for _, pid := range b.pages {
+		page, err := db.rf.Page(pid)
+		if err != nil {
+			return nil, 0, err
+		}
+		_, d, _ := pager2.ChainPage(page)
+		data = append(data, d...)
+	}
+	data, err := compress.Decode(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("does not decompress: %v", err)
+	}
+	var t bptree.BPTree
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&t); err != nil {
+		return nil, 0, fmt.Errorf("does not decode: %v", err)
+	}
+	return &t, len(data), nil
+}
+
+func ratio(raw, stored int) string {
+	if stored == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", float64(raw)/float64(stored))
+}
+
+// shape sums up the nodes of a tree level by level.
+type shape struct {
+	keys   int
+	levels []level
+}
+
+type level struct {
+	nodes, keys int
+}
+
+func (s shape) nodes() int {
+	n := 0
+	for _, l := range s.levels {
+		n += l.nodes
+	}
+	return n
+}
+
+func (s shape) leafFill() float64 {
+	if len(s.levels) == 0 {
+		return 0
+	}
+	leaves := s.levels[len(s.levels)-1]
+	if leaves.nodes == 0 {
+		return 0
+	}
+	return float64(leaves.keys) / float64(leaves.nodes*(bptree.Order-1))
+}
+
+func shapeOf(t *bptree.BPTree) shape {
+	var s shape
+	var walk func(n *bptree.Node, depth int)
+	walk = func(n *bptree.Node, depth int) {
+		if n == nil {
+			return
+		}
+		for len(s.levels) <= depth {
+			s.levels = append(s.levels, level{})
+		}
+		s.levels[depth].nodes++
+		s.levels[depth].keys += len(n.Keys)
+		if n.IsLeaf {
+			s.keys += len(n.Keys)
+			return
+		}
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(t.Root, 0)
+	return s
+}
+
+// Page prints the header of page pid as its owner reads it, then a hex
+// dump of the page up to its last non-zero byte.
+func (db *DB) Page(w io.Writer, pid uint64) error {
+	page, err := db.rf.Page(pid)
+	if err != nil {
+		return err
+	}
+	owner := db.owner[pid]
+	if owner == "" {
+		owner = "orphan (neither in use nor free)"
+	}
+	fmt.Fprintf(w, "page %d: %s\n", pid, owner)
+	kind, _, _ := strings.Cut(owner, " ")
+	switch kind {
+	case "meta":
+		v, _ := db.rf.Version()
+		fmt.Fprintf(w, "format version %d, gob-encoded meta; see inspect meta\n", v)
+	case "table", "index", "blob", "held", "orphan":
+		next, data, ok := pager2.ChainPage(page)
+		if ok {
+			fmt.Fprintf(w, "next %s, %d data bytes at offset 12\n", nextRef(next), len(data))
+		} else if kind != "orphan" {
+			fmt.Fprintf(w, "next %s, data length does not fit in the page\n", nextRef(next))
+		}
+	case "free":
+		fmt.Fprintf(w, "next free %s\n", nextRef(pager2.FreePage(page)))
+	}
+	end := len(bytes.TrimRight(page, "\x00"))
+	end = (end + 15) &^ 15
+	fmt.Fprint(w, hex.Dump(page[:end]))
+	if end < len(page) {
+		fmt.Fprintf(w, "%08x  (zeros to the end of the page)\n", end)
+	}
+	return nil
+}
+
+// Tree prints the shape of the named table's or index's tree level by
+// level, then its nodes down to maxDepth levels below the root (all of
+// them if maxDepth < 0): each internal node with its separators and each
+// leaf with its first and last key.
+func (db *DB) Tree(w io.Writer, name string, maxDepth int) error {
+	var b *blob
+	for _, cand := range db.blobs {
+		if cand.name == name {
+			b = cand
+		}
+	}
+	if b == nil {
+		if _, ok := db.meta.Tables[name]; ok {
+			fmt.Fprintf(w, "table %s is empty\n", name)
+			return nil
+		}
+		return fmt.Errorf("no table or index named %s", name)
+	}
+	t, _, err := db.tree(b)
+	if err != nil {
+		return fmt.Errorf("%s: %v", b.label(), err)
+	}
+	s := shapeOf(t)
+	fmt.Fprintf(w, "%s: %d keys, height %d, %d nodes, seq %d\n", b.label(), s.keys, len(s.levels), s.nodes(), t.Seq)
+	for d, l := range s.levels {
+		kind := "internal"
+		if d == len(s.levels)-1 {
+			kind = "leaf"
+		}
+		fmt.Fprintf(w, "  level %d: %6d %-8s nodes %8d keys  %.1f keys per node\n", d, l.nodes, kind, l.keys, float64(l.keys)/float64(l.nodes))
+	}
+	fmt.Fprintln(w)
+	var walk func(n *bptree.Node, depth int)
+	walk = func(n *bptree.Node, depth int) {
+		indent := strings.Repeat("  ", depth)
+		if n.IsLeaf {
+			if len(n.Keys) == 0 {
+				fmt.Fprintf(w, "%sleaf (empty)\n", indent)
+				return
+			}
+			fmt.Fprintf(w, "%sleaf %d keys %q .. %q\n", indent, len(n.Keys), n.Keys[0], n.Keys[len(n.Keys)-1])
+			return
+		}
+		fmt.Fprintf(w, "%snode %d children, separators %q", indent, len(n.Children), n.Keys)
+		if len(n.Counts) == len(n.Children) {
+			fmt.Fprintf(w, ", counts %v", n.Counts)
+		}
+		fmt.Fprintln(w)
+		if maxDepth >= 0 && depth >= maxDepth {
+			fmt.Fprintf(w, "%s  ...\n", indent)
+			return
+		}
+		for _, c := range n.Children {
+			if c != nil {
+				walk(c, depth+1)
+			}
+		}
+	}
+	walk(t.Root, 0)
+	return nil
+}
@@ -0,0 +1,193 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/storage"
+)
+
+// buildDB writes a database with a compressed table a indexed on n, a plain
+// table b whose tree spans several levels, and an empty table c. It returns
+// the path and the head page of a's chain.
+func buildDB(t *testing.T) (string, uint64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inspect.db")
+	p, err := pager2.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := engine.New(storage.Pager2(p))
+	for _, table := range []string{"a", "b", "c"} {
+		if _, err := e.Create(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.Compress("a", "best"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"a", "b"} {
+		b := e.NewBatch()
+		for i := 0; i < 500; i++ {
+			if err := b.Put(table, fmt.Sprintf("key%04d", i), fmt.Sprintf(`{"n":%d,"pad":"%s"}`, i, strings.Repeat("v", 20))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.CreateIndex("a_n", "a", "n"); err != nil {
+		t.Fatal(err)
+	}
+	m := p.Meta()
+	head := m.TableHead[m.Tables["a"]]
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, head
+}
+
+func open(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestViews(t *testing.T) {
+	path, head := buildDB(t)
+	db := open(t, path)
+	cases := []struct {
+		name string
+		view func(w *bytes.Buffer) error
+		want []string
+	}{
+		{"meta", func(w *bytes.Buffer) error { db.Meta(w); return nil },
+			[]string{"format     ", "tables     3", "indexes    1", "compression", "on table id"}},
+		{"pages", func(w *bytes.Buffer) error { return db.Pages(w) },
+			[]string{"table a", "table b", "index a_n", "1 meta"}},
+		{"tables", func(w *bytes.Buffer) error { db.Tables(w); return nil },
+			[]string{"table a", "table b", "index a_n", "total", "0 free"}},
+		{"meta page", func(w *bytes.Buffer) error { return db.Page(w, 0) },
+			[]string{"page 0: meta", "format version", "00000000  ", "(zeros to the end of the page)"}},
+		{"chain page", func(w *bytes.Buffer) error { return db.Page(w, head) },
+			[]string{fmt.Sprintf("page %d: table a", head), "data bytes at offset 12"}},
+		{"tree", func(w *bytes.Buffer) error { return db.Tree(w, "b", -1) },
+			[]string{"table b: 500 keys", "level 0:", "leaf", `"key0000"`, `"key0499"`}},
+		{"tree to depth 0", func(w *bytes.Buffer) error { return db.Tree(w, "b", 0) },
+			[]string{"node", "  ..."}},
+		{"index tree", func(w *bytes.Buffer) error { return db.Tree(w, "a_n", -1) },
+			[]string{"index a_n: 500 keys"}},
+		{"empty table", func(w *bytes.Buffer) error { return db.Tree(w, "c", -1) },
+			[]string{"table c is empty"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tc.view(&buf); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Fatalf("output lacks %q:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestViewErrors(t *testing.T) {
+	path, _ := buildDB(t)
+	db := open(t, path)
+	var buf bytes.Buffer
+	if err := db.Tree(&buf, "missing", -1); err == nil || !strings.Contains(err.Error(), "no table or index named missing") {
+		t.Fatalf("tree of a missing table: %v", err)
+	}
+	if err := db.Page(&buf, 1<<20); err == nil {
+		t.Fatal("a page past the end of the file was printed")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "none.db"), nil); err == nil {
+		t.Fatal("opened a missing file")
+	}
+}
+
+// Pages cut off from their chain are orphans, and chains held back by a
+// snapshot that was never closed are shown as held.
+func TestOrphanAndHeld(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		damage func(t *testing.T, path string, head uint64)
+		kind   string
+	}{
+		{"orphan", func(t *testing.T, path string, head uint64) {
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var next [8]byte
+			binary.LittleEndian.PutUint64(next[:], 0)
+			if _, err := f.WriteAt(next[:], int64(head)*pager2.PageSize); err != nil {
+				t.Fatal(err)
+			}
+		}, "orphan"},
+		{"held", func(t *testing.T, path string, head uint64) {
+			p, err := pager2.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := engine.New(storage.Pager2(p)).Insert("a", "new", "v"); err != nil {
+				t.Fatal(err)
+			}
+			// Stop without closing the snapshot, as a crash would.
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}, "held"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path, head := buildDB(t)
+			tc.damage(t, path, head)
+			db := open(t, path)
+			var pages bytes.Buffer
+			if err := db.Pages(&pages); err != nil {
+				t.Fatal(err)
+			}
+			totals := pages.String()[strings.LastIndex(pages.String(), "\n\n"):]
+			if !strings.Contains(totals, " "+tc.kind) {
+				t.Fatalf("totals lack %s pages:%s", tc.kind, totals)
+			}
+			var page bytes.Buffer
+			pid := head
+			if tc.kind == "orphan" {
+				pid = head + 1
+				for !strings.Contains(page.String(), "orphan") && pid < head+64 {
+					page.Reset()
+					if err := db.Page(&page, pid); err != nil {
+						t.Fatal(err)
+					}
+					pid++
+				}
+			} else if err := db.Page(&page, pid); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(page.String(), tc.kind) {
+				t.Fatalf("no page shown as %s:\n%s", tc.kind, page.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Filter is a boolean expression over a JSON document, for example
+//
+//	$.age > 25 AND ($.city = "Oslo" OR NOT $.admin = true)
+//
+// Comparisons take a path on the left and a literal (see ParseLiteral) on
+// the right. AND binds tighter than OR. A comparison whose path is missing
+// from the document is false.
+type Filter struct {
+	root node
+}
+
+type node interface {
+	eval(doc any) bool
+}
+
+type andNode struct{ l, r node }
+type orNode struct{ l, r node }
+type notNode struct{ n node }
+type cmpNode struct {
+	path Path
+	op   string
+	lit  any
+}
+
+func (n andNode) eval(doc any) bool { return n.l.eval(doc) && n.r.eval(doc) }
+func (n orNode) eval(doc any) bool  { return n.l.eval(doc) || n.r.eval(doc) }
+func (n notNode) eval(doc any) bool { return !n.n.eval(doc) }
+func (n cmpNode) eval(doc any) bool {
+	v, ok := n.path.Lookup(doc)
+	return ok && Match(v, n.op, n.lit)
+}
+
+// ParseFilter parses a filter expression.
+func ParseFilter(s string) (*Filter, error) {
+	toks, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{toks: toks}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.toks[p.pos])
+	}
+	return &Filter{root: root}, nil
+}
+
+// Match reports whether the decoded document satisfies the filter.
+func (f *Filter) Match(doc any) bool { return f.root.eval(doc) }
+
+type filterParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) or() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) and() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) unary() (node, error) {
+	switch t := p.next(); {
+	case t == "":
+		return nil, fmt.Errorf("filter: unexpected end of expression")
+	case strings.EqualFold(t, "NOT"):
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case t == "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("filter: missing )")
+		}
+		return n, nil
+	default:
+		path, err := Parse(t)
+		if err != nil {
+			return nil, fmt.Errorf("filter: %w", err)
+		}
+		op := p.next()
+		if !ValidOp(op) {
+			return nil, fmt.Errorf("filter: expected comparison after %s, got %q", t, op)
+		}
+		lit := p.next()
+		if lit == "" || lit == "(" || lit == ")" {
+			return nil, fmt.Errorf("filter: missing value after %s %s", t, op)
+		}
+		return cmpNode{path: path, op: op, lit: ParseLiteral(lit)}, nil
+	}
+}
+
+// lexFilter splits a filter into words, quoted strings, parentheses and
+// comparison operators, which need no surrounding spaces.
+func lexFilter(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+		case ch == '(' || ch == ')':
+			toks = append(toks, string(ch))
+			i++
+		case ch == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("filter: unterminated string")
+			}
+			toks = append(toks, s[i:j+1])
+			i = j + 1
+		case strings.IndexByte("=!<>", ch) >= 0:
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(s) && strings.IndexByte(" \t\n()=!<>\"", s[j]) < 0 {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// ParseFields parses a comma-separated list of paths for projection.
+func ParseFields(s string) ([]Path, error) {
+	var out []Path
+	for _, f := range strings.Split(s, ",") {
+		if strings.TrimSpace(f) == "" {
+			continue
+		}
+		p, err := Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: empty field list", ErrBadPath)
+	}
+	return out, nil
+}
+
+// Project builds a JSON object holding the given fields of doc, keyed by
+// the path without its leading "$." and in the order given. Fields missing
+// from doc are left out.
+func Project(doc any, fields []Path) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	n := 0
+	for _, f := range fields {
+		v, ok := f.Lookup(doc)
+		if !ok {
+			continue
+		}
+		name, err := json.Marshal(strings.TrimPrefix(strings.TrimPrefix(f.String(), "$"), "."))
+		if err != nil {
+			return "", err
+		}
+		val, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(val)
+		n++
+	}
+	buf.WriteByte('}')
+	return buf.String(), nil
+}
@@ -0,0 +1,66 @@
+package jsonpath
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	doc, err := Decode(`{"age":30,"city":"Oslo","admin":false,"tags":["x"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		expr string
+		want bool
+	}{
+		{"$.age > 25", true},
+		{"age>25", true},
+		{"$.age >= 31", false},
+		{`$.city = "Oslo"`, true},
+		{`city != "Oslo"`, false},
+		{"$.missing = 1", false},
+		{"NOT $.missing = 1", true},
+		{"$.age > 25 AND $.city = Bergen", false},
+		{"$.age > 25 OR $.city = Bergen", true},
+		{"$.age < 5 OR $.city = Oslo AND $.admin = true", false},
+		{"($.age < 5 OR $.city = Oslo) AND NOT $.admin = true", true},
+		{"tags[0] = x", true},
+		{`$.city = "a \"quoted\" (word)"`, false},
+	} {
+		f, err := ParseFilter(tc.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := f.Match(doc); got != tc.want {
+			t.Errorf("%q matched %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, tc := range []struct{ expr, want string }{
+		{"", "unexpected end"},
+		{"$.age", "expected comparison"},
+		{"$.age ~ 3", "expected comparison"},
+		{"$.age >", "missing value"},
+		{"$.age > )", "missing value"},
+		{"($.age > 1", "missing )"},
+		{"$.age > 1 $.b = 2", "unexpected"},
+		{"$.age > 1 AND", "unexpected end"},
+		{`$.city = "Oslo`, "unterminated string"},
+		{"$. = 1", "invalid JSON path"},
+		{"NOT", "unexpected end"},
+	} {
+		_, err := ParseFilter(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ParseFilter(%q): got %v, want an error mentioning %q", tc.expr, err, tc.want)
+		}
+	}
+	for _, fields := range []string{"", " , ", "a,$.,b"} {
+		if _, err := ParseFields(fields); err == nil {
+			t.Errorf("ParseFields(%q) succeeded", fields)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A small subset of JSONPath for reaching into JSON values: a field name
+// ("age"), dotted fields ("address.city") and array indexes ("tags[0]"),
+// optionally written with a leading "$." as in "$.address.city".
+
+var ErrBadPath = errors.New("invalid JSON path")
+
+type segment struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// Path is a parsed JSON path.
+type Path struct {
+	segs []segment
+}
+
+// Parse parses a path such as "age", "$.address.city" or "$.tags[0]".
+func Parse(s string) (Path, error) {
+	rest := strings.TrimSpace(s)
+	if rest == "$" {
+		return Path{}, nil
+	}
+	if strings.HasPrefix(rest, "$[") {
+		rest = rest[1:]
+	}
+	rest = strings.TrimPrefix(rest, "$.")
+	if rest == "" || strings.HasPrefix(rest, "$") {
+		return Path{}, fmt.Errorf("%w: %q", ErrBadPath, s)
+	}
+	var segs []segment
+	for _, part := range strings.Split(rest, ".") {
+		name, idx, _ := strings.Cut(part, "[")
+		if name == "" && idx == "" {
+			return Path{}, fmt.Errorf("%w: %q", ErrBadPath, s)
+		}
+		if name != "" {
+			segs = append(segs, segment{key: name})
+		}
+		if idx == "" {
+			continue
+		}
+		// one or more [n] suffixes
+		for _, ix := range strings.Split("["+idx, "[")[1:] {
+			n, err := strconv.Atoi(strings.TrimSuffix(ix, "]"))
+			if err != nil || !strings.HasSuffix(ix, "]") || n < 0 {
+				return Path{}, fmt.Errorf("%w: %q", ErrBadPath, s)
+			}
+			segs = append(segs, segment{index: n, isIdx: true})
+		}
+	}
+	return Path{segs: segs}, nil
+}
+
+// String returns the path in canonical "$.a.b[0]" form, so that equivalent
+// spellings of a path compare equal.
+func (p Path) String() string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, s := range p.segs {
+		if s.isIdx {
+			fmt.Fprintf(&sb, "[%d]", s.index)
+		} else {
+			sb.WriteString(".")
+			sb.WriteString(s.key)
+		}
+	}
+	return sb.String()
+}
+
+// Lookup follows the path through a decoded JSON document.
+func (p Path) Lookup(doc any) (any, bool) {
+	cur := doc
+	for _, s := range p.segs {
+		if s.isIdx {
+			arr, ok := cur.([]any)
+			if !ok || s.index >= len(arr) {
+				return nil, false
+			}
+			cur = arr[s.index]
+			continue
+		}
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[s.key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Extract decodes value as JSON and returns the element the path points at.
+// It reports false if value is not JSON or the path does not exist in it.
+func (p Path) Extract(value string) (any, bool) {
+	doc, err := Decode(value)
+	if err != nil {
+		return nil, false
+	}
+	return p.Lookup(doc)
+}
+
+// Decode parses a JSON document, keeping numbers as json.Number so that
+// large integers survive a round trip through Project.
+func Decode(value string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data after JSON value")
+	}
+	return doc, nil
+}
+
+// Number returns v as a float64 if it is a JSON number.
+func Number(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// number converts json.Number to float64 for comparison.
+func number(v any) any {
+	if n, ok := v.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// ParseLiteral interprets a query literal: JSON numbers, strings, true,
+// false and null decode as such, anything else is taken as a bare string.
+func ParseLiteral(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		switch v.(type) {
+		case float64, string, bool, nil:
+			return v
+		}
+	}
+	return s
+}
+
+// Compare orders two JSON scalars of the same kind. It reports false when
+// they cannot be compared (different kinds, objects or arrays).
+func Compare(a, b any) (int, bool) {
+	a, b = number(a), number(b)
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	case nil:
+		return 0, b == nil
+	}
+	return 0, false
+}
+
+// Match reports whether v op lit holds, for op one of = != < <= > >=.
+func Match(v any, op string, lit any) bool {
+	c, ok := Compare(v, lit)
+	if !ok {
+		return op == "!="
+	}
+	switch op {
+	case "=", "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// ValidOp reports whether op is a comparison operator understood by Match.
+func ValidOp(op string) bool {
+	switch op {
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
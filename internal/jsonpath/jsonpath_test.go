@@ -0,0 +1,123 @@
+package jsonpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		err      bool
+	}{
+		{"age", "$.age", false},
+		{"$.age", "$.age", false},
+		{" $.address.city ", "$.address.city", false},
+		{"tags[0]", "$.tags[0]", false},
+		{"$[1]", "$[1]", false},
+		{"$.m[0][2].x", "$.m[0][2].x", false},
+		{"$", "$", false},
+		{"", "", true},
+		{"$.", "", true},
+		{"$$", "", true},
+		{"a..b", "", true},
+		{"a.", "", true},
+		{"tags[x]", "", true},
+		{"tags[-1]", "", true},
+		{"tags[0", "", true},
+		{"tags[]", "", true},
+	} {
+		p, err := Parse(tc.in)
+		if tc.err {
+			if !errors.Is(err, ErrBadPath) {
+				t.Errorf("Parse(%q) = %s, %v; want ErrBadPath", tc.in, p, err)
+			}
+			continue
+		}
+		if err != nil || p.String() != tc.want {
+			t.Errorf("Parse(%q) = %s, %v; want %s", tc.in, p, err, tc.want)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	doc := `{"age":30,"big":12345678901234567890,"address":{"city":"Oslo"},"tags":["a",{"k":true}],"none":null}`
+	for _, tc := range []struct {
+		path string
+		want any
+		ok   bool
+	}{
+		{"age", 30.0, true},
+		{"address.city", "Oslo", true},
+		{"tags[0]", "a", true},
+		{"tags[1].k", true, true},
+		{"none", nil, true},
+		{"tags[2]", nil, false},
+		{"address.zip", nil, false},
+		{"age.x", nil, false},
+		{"address[0]", nil, false},
+	} {
+		p, err := Parse(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok := p.Extract(doc)
+		if ok != tc.ok {
+			t.Errorf("Extract(%s) ok = %v, want %v", tc.path, ok, tc.ok)
+			continue
+		}
+		if n, isNum := Number(v); isNum {
+			v = n
+		}
+		if ok && v != tc.want {
+			t.Errorf("Extract(%s) = %#v, want %#v", tc.path, v, tc.want)
+		}
+	}
+	p, _ := Parse("age")
+	for _, bad := range []string{"not json", `{"age":1} {}`, ""} {
+		if _, ok := p.Extract(bad); ok {
+			t.Errorf("Extract from %q succeeded", bad)
+		}
+	}
+	// Large integers survive projection unrounded.
+	d, err := Decode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := ParseFields("big, address.city, missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Project(d, fields); err != nil || got != `{"big":12345678901234567890,"address.city":"Oslo"}` {
+		t.Errorf("Project = %s, %v", got, err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		v    any
+		op   string
+		lit  string
+		want bool
+	}{
+		{30.0, "=", "30", true},
+		{30.0, "==", "30.0", true},
+		{30.0, ">", "25", true},
+		{30.0, "<=", "25", false},
+		{"Oslo", "=", "Oslo", true},
+		{"Oslo", "=", `"Oslo"`, true},
+		{"Oslo", "<", "Paris", true},
+		{"30", "=", "30", false},
+		{"30", "!=", "30", true},
+		{true, "=", "true", true},
+		{false, "<", "true", true},
+		{nil, "=", "null", true},
+		{nil, "!=", "0", true},
+		{map[string]any{}, "=", "1", false},
+		{30.0, "~", "30", false},
+	} {
+		if got := Match(tc.v, tc.op, ParseLiteral(tc.lit)); got != tc.want {
+			t.Errorf("Match(%#v %s %s) = %v, want %v", tc.v, tc.op, tc.lit, got, tc.want)
+		}
+	}
+}
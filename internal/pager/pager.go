@@ -13,6 +13,20 @@ import (
 type Meta struct {
     Tables map[string]uint64 // table name -> table id
     NextTableID uint64
+
+    // Catalog fields added after the first images were written; older
+    // images decode with them empty.
+    Indexes     map[string]IndexMeta // index name -> definition
+    Schemas     map[uint64]string    // table id -> column list
+    Compression map[uint64]string    // table id -> compression method
+    Expiring    map[uint64]bool      // table id -> some keys carry an expiry
+}
+
+// IndexMeta describes a secondary index whose tree is stored under TreeID.
+type IndexMeta struct {
+    TableID uint64
+    Field   string
+    TreeID  uint64
 }
 
 type DBImage struct {
@@ -81,6 +95,32 @@ func (p *Pager) StoreTableBlob(tableID uint64, blob []byte) error {
     return p.flush()
 }
 
+// StoreTableBlobs persists several blobs with a single write of the image.
+func (p *Pager) StoreTableBlobs(blobs map[uint64][]byte) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.img.Tables == nil { p.img.Tables = make(map[uint64][]byte) }
+    for id, blob := range blobs {
+        p.img.Tables[id] = blob
+    }
+    return p.flush()
+}
+
+// StoreTableBlobsMeta persists several table blobs and a change to the meta
+// in one image write. mut may be nil.
+func (p *Pager) StoreTableBlobsMeta(blobs map[uint64][]byte, mut func(m *Meta)) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.img.Tables == nil { p.img.Tables = make(map[uint64][]byte) }
+    for id, blob := range blobs {
+        p.img.Tables[id] = blob
+    }
+    if mut != nil {
+        mut(&p.img.Meta)
+    }
+    return p.flush()
+}
+
 // LoadTableBlob returns the serialized table state for a table id.
 func (p *Pager) LoadTableBlob(tableID uint64) ([]byte, bool) {
     p.mu.RLock()
@@ -97,4 +137,14 @@ func (p *Pager) DeleteTableBlob(tableID uint64) error {
     return p.flush()
 }
 
-
+// Sync flushes the image file to stable storage.
+func (p *Pager) Sync() error {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    f, err := os.Open(p.path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    return f.Sync()
+}
@@ -0,0 +1,253 @@
+package pager2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WAL archiving and point-in-time recovery. Every write ends its WAL records
+// with a commit marker carrying the write's LSN (a counter stored in the
+// meta, so a backup records the LSN it was taken at) and its time. Closed
+// segments are copied to the archive directory as
+//
+//	<first LSN>-<last LSN>.wal   (16 hex digits each)
+//
+// and a backup plus the segments after it can be replayed up to any commit.
+// Segments are found by content, not by name, so a copy of the live WAL can
+// be dropped into the directory under any name ending in .wal to recover the
+// latest writes too.
+
+// ErrTargetNotReached is returned when the archive ends before the recovery
+// target.
+var ErrTargetNotReached = errors.New("recovery target not reached")
+
+// Target bounds a point-in-time recovery: replay stops after the last commit
+// with an LSN not above LSN and a time not after Time. Zero fields do not
+// bound it.
+type Target struct {
+	LSN  uint64
+	Time time.Time
+}
+
+func (t Target) bounded() bool { return t.LSN != 0 || !t.Time.IsZero() }
+
+// Recovery reports how far a point-in-time recovery got.
+type Recovery struct {
+	LSN      uint64    // last commit applied
+	Time     time.Time // its time; zero if no commit was replayed
+	Segments int       // segment files read
+}
+
+// archiveWAL copies the first size bytes of the WAL into the archive. The
+// copy is synced under a temporary name and then renamed, so the archive
+// only ever holds complete segments.
+func (p *Pager) archiveWAL(size int64) error {
+	dir := p.opts.ArchiveDir
+	tmp, err := os.CreateTemp(dir, ".segment-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.NewSectionReader(p.wal, 0, size))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%016x-%016x.wal", p.segFirst, p.meta.LSN)
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+type segment struct {
+	path  string
+	first uint64
+}
+
+// segments lists the WAL segments in dir by the LSN of their first commit.
+// Files without a commit are left out. The segments of an encrypted
+// database are opened with c.
+func segments(dir string, c *crypter) ([]segment, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []segment
+	for _, ent := range ents {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".wal") {
+			continue
+		}
+		path := filepath.Join(dir, ent.Name())
+		first, err := firstLSN(path, c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if first != 0 {
+			segs = append(segs, segment{path, first})
+		}
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].first < segs[j].first })
+	return segs, nil
+}
+
+// firstLSN returns the LSN of the first commit marker in a segment file,
+// skipping over record bodies.
+func firstLSN(path string, c *crypter) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.ReadSeeker = f
+	if c != nil {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return 0, err
+		}
+		plain, _, err := c.openWAL(data)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(plain)
+	}
+	hdr := make([]byte, 17)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return 0, nil
+		}
+		switch hdr[0] {
+		case 1, 3, 4:
+			n := int64(binary.LittleEndian.Uint64(hdr[9:17]))
+			if _, err := r.Seek(n, io.SeekCurrent); err != nil {
+				return 0, err
+			}
+		case 2:
+		case 5:
+			return binary.LittleEndian.Uint64(hdr[1:9]), nil
+		default:
+			return 0, nil
+		}
+	}
+}
+
+// RestoreTo restores a backup like Restore and then replays the WAL
+// segments in walDir onto it up to the target, all before anything is
+// renamed over path. The segments must continue from the backup's LSN
+// without gaps. A bounded target that lies beyond the end of the archive
+// fails with ErrTargetNotReached and leaves path untouched.
+//
+// The restored database continues from the target's LSN, so its segments
+// must be archived to a fresh directory. An encrypted backup and its
+// segments need their key.
+func RestoreTo(r io.Reader, path, walDir string, to Target, key []byte) (BackupInfo, Recovery, error) {
+	c, err := newCrypter(key)
+	if err != nil {
+		return BackupInfo{}, Recovery{}, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return BackupInfo{}, Recovery{}, err
+	}
+	defer os.Remove(tmp.Name())
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	info, err := restore(r, tmp, c, true)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return info, Recovery{}, err
+	}
+	rec := Recovery{LSN: info.LSN}
+	if walDir != "" {
+		if rec, err = recoverTo(tmp.Name(), walDir, info, to, key); err != nil {
+			return info, rec, err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return info, rec, err
+	}
+	if err := os.Remove(path + ".wal"); err != nil && !os.IsNotExist(err) {
+		return info, rec, err
+	}
+	return info, rec, syncDir(filepath.Dir(path))
+}
+
+// recoverTo replays the archive onto the restored database at path.
+func recoverTo(path, walDir string, info BackupInfo, to Target, key []byte) (Recovery, error) {
+	rec := Recovery{LSN: info.LSN}
+	if to.LSN != 0 && info.LSN > to.LSN {
+		return rec, fmt.Errorf("pager: backup is at LSN %d, past the target", info.LSN)
+	}
+	if !to.Time.IsZero() && info.Created.After(to.Time) {
+		return rec, fmt.Errorf("pager: backup was taken at %s, after the target", info.Created.Format(time.RFC3339))
+	}
+	p, err := OpenOptions(path, Options{SegmentBytes: -1, Key: key})
+	if err != nil {
+		return rec, err
+	}
+	defer os.Remove(path + ".wal")
+	defer p.Close()
+	segs, err := segments(walDir, p.crypt)
+	if err != nil {
+		return rec, err
+	}
+	stop := func(lsn uint64, nanos int64) bool {
+		return (to.LSN != 0 && lsn > to.LSN) || (!to.Time.IsZero() && nanos > to.Time.UnixNano())
+	}
+	stopped := false
+	for i, seg := range segs {
+		if i+1 < len(segs) && segs[i+1].first <= p.meta.LSN+1 {
+			continue // wholly before the backup
+		}
+		data, err := os.ReadFile(seg.path)
+		if err != nil {
+			return rec, err
+		}
+		if data, _, err = p.crypt.openWAL(data); err != nil {
+			return rec, fmt.Errorf("%s: %w", seg.path, err)
+		}
+		rec.Segments++
+		st, err := p.replay(data, stop)
+		if err != nil {
+			return rec, fmt.Errorf("%s: %w", seg.path, err)
+		}
+		rec.LSN = p.meta.LSN
+		if st.last != 0 {
+			rec.Time = time.Unix(0, st.last)
+		}
+		if st.stopped {
+			stopped = true
+			break
+		}
+	}
+	if err := p.flushMeta(); err != nil {
+		return rec, err
+	}
+	if to.bounded() && !stopped && (to.LSN == 0 || rec.LSN < to.LSN) {
+		return rec, fmt.Errorf("%w: the archive ends at LSN %d", ErrTargetNotReached, rec.LSN)
+	}
+	return rec, nil
+}
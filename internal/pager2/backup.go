@@ -0,0 +1,384 @@
+package pager2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A backup is a page-for-page image of the database at one instant, framed
+// so that damage is detected before it is restored:
+//
+//	header  magic "SHKBAK\x00\x01" | page size u32 | flags u32 | page count u64 | created unix nanos i64
+//	pages   page count x (page | CRC-32C of the page u32)
+//	trailer SHA-256 of everything before it
+//
+// Integers are little-endian. Page 0 holds the meta as of the snapshot and
+// every page that was not part of a table chain is written as an empty
+// free-list page, so a restored file has no stale data and a fresh free list.
+// Pages are copied as the file stores them: the backup of an encrypted
+// database has flag 1 set and holds sealed pages of PageSize+28 bytes.
+//
+// A snapshot only takes the pager lock long enough to copy the meta. While
+// a backup is open, chains replaced by writes are not returned to the free
+// list, so the pages the snapshot refers to stay untouched until Close and
+// writers carry on meanwhile. Their heads are kept in the meta, so a crash
+// with a snapshot open does not leak them: Open frees them.
+
+var backupMagic = [8]byte{'S', 'H', 'K', 'B', 'A', 'K', 0, 1}
+
+const backupHeaderSize = 32
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrBadBackup is returned for backups that fail verification.
+var ErrBadBackup = errors.New("invalid backup")
+
+// BackupInfo describes a backup.
+type BackupInfo struct {
+	Pages     uint64
+	Created   time.Time
+	LSN       uint64 // of the last write included; 0 if encrypted and read without a key
+	Encrypted bool
+}
+
+// Size returns the length of the backup stream in bytes.
+func (b BackupInfo) Size() int64 {
+	slot := int64(PageSize)
+	if b.Encrypted {
+		slot += sealOverhead
+	}
+	return backupHeaderSize + int64(b.Pages)*(slot+4) + sha256.Size
+}
+
+// Snapshot is a consistent view of the database for backing up.
+type Snapshot struct {
+	p       *Pager
+	meta    Meta
+	pages   uint64
+	created time.Time
+	closed  bool
+}
+
+// Snapshot captures the current state. Callers that group several pager
+// writes into one operation (as the engine does) should hold their write
+// lock while calling it so the snapshot falls between operations. Close
+// must be called when the snapshot is no longer needed.
+func (p *Pager) Snapshot() (*Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fi, err := p.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	p.backups++
+	return &Snapshot{p: p, meta: p.meta.Clone(), pages: uint64(fi.Size() / p.slot), created: time.Now()}, nil
+}
+
+// Close releases the snapshot and frees the chains whose release it held
+// back.
+func (s *Snapshot) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backups--
+	if p.backups > 0 {
+		return nil
+	}
+	return p.freeDeferred()
+}
+
+// freeDeferred frees the chains snapshots held back. The caller holds the
+// lock, and no snapshot may be open.
+func (p *Pager) freeDeferred() error {
+	heads := p.meta.Deferred
+	if len(heads) == 0 {
+		return nil
+	}
+	p.meta.Deferred = nil
+	for _, head := range heads {
+		if err := p.freeChain(head); err != nil {
+			return err
+		}
+	}
+	return p.flushMeta()
+}
+
+// Size returns the length of the backup stream WriteTo produces.
+func (s *Snapshot) Size() int64 {
+	return BackupInfo{Pages: s.pages, Encrypted: s.p.crypt != nil}.Size()
+}
+
+// WriteFile writes the backup to path. It is written under a temporary name
+// and renamed into place once synced, so path never holds a partial backup.
+func (s *Snapshot) WriteFile(path string) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriterSize(tmp, 1<<20)
+	n, err := s.WriteTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// WriteTo writes the snapshot as a backup stream.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	live, err := s.livePages()
+	if err != nil {
+		return 0, err
+	}
+	// Free pages are chained from the highest down to the lowest.
+	meta := s.meta
+	meta.FreeList, meta.Deferred = 0, nil
+	for pid := s.pages - 1; pid >= 1; pid-- {
+		if !live[pid] {
+			meta.FreeList = pid
+			break
+		}
+	}
+	metaPage, err := encodeMeta(meta)
+	if err != nil {
+		return 0, err
+	}
+
+	sum := sha256.New()
+	out := io.MultiWriter(w, sum)
+	var n int64
+	write := func(b []byte) error {
+		m, err := out.Write(b)
+		n += int64(m)
+		return err
+	}
+	c := s.p.crypt
+	hdr := make([]byte, backupHeaderSize)
+	copy(hdr, backupMagic[:])
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(c.slotSize()))
+	if c != nil {
+		binary.LittleEndian.PutUint32(hdr[12:16], flagEncrypted)
+	}
+	binary.LittleEndian.PutUint64(hdr[16:24], s.pages)
+	binary.LittleEndian.PutUint64(hdr[24:32], uint64(s.created.UnixNano()))
+	if err := write(hdr); err != nil {
+		return n, err
+	}
+	slot := c.slotSize()
+	page := make([]byte, slot+4)
+	free := make([]byte, PageSize)
+	prevFree := uint64(0)
+	for pid := uint64(0); pid < s.pages; pid++ {
+		buf := page[:slot]
+		switch {
+		case pid == 0:
+			copy(buf, c.sealPage(0, metaPage))
+		case live[pid]:
+			if _, err := s.p.f.ReadAt(buf, int64(pid)*slot); err != nil {
+				return n, err
+			}
+		default:
+			clear(free)
+			binary.LittleEndian.PutUint64(free[:8], prevFree)
+			copy(buf, c.sealPage(pid, free))
+			prevFree = pid
+		}
+		binary.LittleEndian.PutUint32(page[slot:], crc32.Checksum(buf, crcTable))
+		if err := write(page); err != nil {
+			return n, err
+		}
+	}
+	m, err := w.Write(sum.Sum(nil))
+	return n + int64(m), err
+}
+
+// livePages marks the pages of every chain in the snapshot.
+func (s *Snapshot) livePages() ([]bool, error) {
+	live := make([]bool, s.pages)
+	for id, head := range s.meta.TableHead {
+		for pid := head; pid != 0; {
+			if pid >= s.pages || live[pid] {
+				return nil, fmt.Errorf("pager: chain of blob %d is corrupt at page %d", id, pid)
+			}
+			live[pid] = true
+			page, err := s.p.readSlot(pid)
+			if err != nil {
+				return nil, err
+			}
+			pid = binary.LittleEndian.Uint64(page[:8])
+		}
+	}
+	return live, nil
+}
+
+// backupReader verifies a backup stream while reading it.
+type backupReader struct {
+	r    io.Reader
+	sum  hash.Hash
+	info BackupInfo
+	next uint64
+}
+
+func newBackupReader(r io.Reader) (*backupReader, error) {
+	br := &backupReader{r: r, sum: sha256.New()}
+	hdr := make([]byte, backupHeaderSize)
+	if err := br.read(hdr); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrBadBackup, err)
+	}
+	if !bytes.Equal(hdr[:8], backupMagic[:]) {
+		return nil, fmt.Errorf("%w: not a sharkDB backup", ErrBadBackup)
+	}
+	br.info.Encrypted = binary.LittleEndian.Uint32(hdr[12:16])&flagEncrypted != 0
+	want := uint32(PageSize)
+	if br.info.Encrypted {
+		want += sealOverhead
+	}
+	if ps := binary.LittleEndian.Uint32(hdr[8:12]); ps != want {
+		return nil, fmt.Errorf("%w: page size %d, want %d", ErrBadBackup, ps, want)
+	}
+	br.info.Pages = binary.LittleEndian.Uint64(hdr[16:24])
+	br.info.Created = time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[24:32])))
+	if br.info.Pages == 0 {
+		return nil, fmt.Errorf("%w: no pages", ErrBadBackup)
+	}
+	return br, nil
+}
+
+func (br *backupReader) read(b []byte) error {
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		return err
+	}
+	br.sum.Write(b)
+	return nil
+}
+
+// page reads the next page into buf and checks its CRC.
+func (br *backupReader) page(buf []byte) error {
+	var crc [4]byte
+	if err := br.read(buf); err != nil {
+		return fmt.Errorf("%w: page %d: %v", ErrBadBackup, br.next, err)
+	}
+	if err := br.read(crc[:]); err != nil {
+		return fmt.Errorf("%w: page %d: %v", ErrBadBackup, br.next, err)
+	}
+	if binary.LittleEndian.Uint32(crc[:]) != crc32.Checksum(buf, crcTable) {
+		return fmt.Errorf("%w: page %d fails its checksum", ErrBadBackup, br.next)
+	}
+	br.next++
+	return nil
+}
+
+// finish checks the trailer and that nothing follows it.
+func (br *backupReader) finish() error {
+	want := br.sum.Sum(nil)
+	got := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(br.r, got); err != nil {
+		return fmt.Errorf("%w: trailer: %v", ErrBadBackup, err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: SHA-256 mismatch", ErrBadBackup)
+	}
+	if n, _ := br.r.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("%w: trailing data", ErrBadBackup)
+	}
+	return nil
+}
+
+// VerifyBackup reads a whole backup stream and checks every checksum and
+// the meta page without writing anything. The pages of an encrypted backup
+// are authenticated too when key is given; without it only the checksums
+// are checked.
+func VerifyBackup(r io.Reader, key []byte) (BackupInfo, error) {
+	c, err := newCrypter(key)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return restore(r, io.Discard, c, false)
+}
+
+// restore copies the pages of a verified backup to w. needKey makes an
+// encrypted backup without a key an error.
+func restore(r io.Reader, w io.Writer, c *crypter, needKey bool) (BackupInfo, error) {
+	br, err := newBackupReader(r)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	switch {
+	case br.info.Encrypted && c == nil && needKey:
+		return br.info, fmt.Errorf("pager: backup: %w", ErrEncrypted)
+	case !br.info.Encrypted && c != nil:
+		return br.info, fmt.Errorf("pager: backup: %w", ErrNotEncrypted)
+	}
+	open := br.info.Encrypted == (c != nil)
+	slot := int64(PageSize)
+	if br.info.Encrypted {
+		slot += sealOverhead
+	}
+	buf := make([]byte, slot)
+	for i := uint64(0); i < br.info.Pages; i++ {
+		if err := br.page(buf); err != nil {
+			return br.info, err
+		}
+		var page []byte
+		if open {
+			if page, err = c.openPage(i, buf); err != nil {
+				return br.info, fmt.Errorf("%w: %v", ErrBadBackup, err)
+			}
+		}
+		if i == 0 && page != nil {
+			m, _, err := decodeMeta(page)
+			if err != nil {
+				return br.info, fmt.Errorf("%w: meta page: %v", ErrBadBackup, err)
+			}
+			br.info.LSN = m.LSN
+		}
+		if _, err := w.Write(buf); err != nil {
+			return br.info, err
+		}
+	}
+	return br.info, br.finish()
+}
+
+// Restore verifies the backup read from r and installs it as the database
+// at path. The pages go to a temporary file first; only when every checksum
+// has matched is it synced and renamed over path, and any WAL left by the
+// old database is removed. The database must not be open while restoring.
+// An encrypted backup needs its key, and every page is authenticated.
+func Restore(r io.Reader, path string, key []byte) (BackupInfo, error) {
+	info, _, err := RestoreTo(r, path, "", Target{}, key)
+	return info, err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some platforms cannot sync directories; the rename stands regardless.
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,239 @@
+package pager2
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A backup is dated when its snapshot is taken, not when it is written out,
+// since point-in-time recovery compares that date with commit times.
+func TestBackupCreatedAtSnapshot(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.StoreTableBlob(1, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	snap, err := p.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	taken := time.Now()
+	time.Sleep(20 * time.Millisecond)
+	var buf bytes.Buffer
+	if _, err := snap.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	info, err := VerifyBackup(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Created.Before(before) || info.Created.After(taken) {
+		t.Fatalf("backup created at %v, want between %v and %v", info.Created, before, taken)
+	}
+}
+
+// Chains replaced while a snapshot is open are kept in the meta, so a
+// process that stops before the snapshot closes does not leak them: the
+// next Open frees them.
+func TestHeldChainsFreedOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	big := bytes.Repeat([]byte("x"), 3*PageSize)
+	storeBlobs(t, path, nil, map[uint64][]byte{1: big})
+
+	p, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.StoreTableBlob(1, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Meta().Deferred) != 1 {
+		t.Fatalf("deferred heads = %v, want the replaced chain", p.Meta().Deferred)
+	}
+	// Stop without closing the snapshot, as a crash would.
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if d := p.Meta().Deferred; len(d) != 0 {
+		t.Fatalf("deferred heads after reopen = %v", d)
+	}
+	if err := p.StoreTableBlob(2, big); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != fi.Size() {
+		t.Fatalf("file grew from %d to %d bytes; the held pages were not reused", fi.Size(), after.Size())
+	}
+	if got, err := p.ReadTableBlob(1); err != nil || string(got) != "small" {
+		t.Fatalf("blob 1 = %q, %v", got, err)
+	}
+}
+
+// backupOf stores blobs in a fresh database and returns a backup of it.
+func backupOf(t *testing.T, key []byte, blobs map[uint64][]byte) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "src.db")
+	storeBlobs(t, path, key, blobs)
+	p, err := OpenOptions(path, Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	snap, err := p.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	var buf bytes.Buffer
+	n, err := snap.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) || n != snap.Size() {
+		t.Fatalf("WriteTo = %d bytes, wrote %d, Size = %d", n, buf.Len(), snap.Size())
+	}
+	return buf.Bytes()
+}
+
+func TestBackupRestore(t *testing.T) {
+	blobs := sampleBlobs()
+	for _, tc := range []struct {
+		name string
+		key  []byte
+	}{
+		{"plain", nil},
+		{"encrypted", testKey(3)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := backupOf(t, tc.key, blobs)
+			info, err := VerifyBackup(bytes.NewReader(data), tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Encrypted != (tc.key != nil) || info.LSN == 0 || info.Size() != int64(len(data)) {
+				t.Fatalf("info = %+v for %d bytes", info, len(data))
+			}
+			out := filepath.Join(t.TempDir(), "restored.db")
+			if err := os.WriteFile(out+".wal", []byte("stale"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Restore(bytes.NewReader(data), out, tc.key); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(out + ".wal"); !os.IsNotExist(err) {
+				t.Fatalf("the old WAL survived the restore: %v", err)
+			}
+			checkBlobs(t, out, tc.key, blobs)
+		})
+	}
+}
+
+func TestRestoreKeys(t *testing.T) {
+	plain := backupOf(t, nil, sampleBlobs())
+	enc := backupOf(t, testKey(4), sampleBlobs())
+	for _, tc := range []struct {
+		name string
+		data []byte
+		key  []byte
+		want error
+	}{
+		{"encrypted without key", enc, nil, ErrEncrypted},
+		{"plain with key", plain, testKey(4), ErrNotEncrypted},
+		{"wrong key", enc, testKey(5), ErrBadBackup},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "db")
+			if _, err := Restore(bytes.NewReader(tc.data), out, tc.key); !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Fatalf("a failed restore left %s: %v", out, err)
+			}
+		})
+	}
+
+	// Without a key an encrypted backup can still be checked, though its
+	// LSN is not known.
+	info, err := VerifyBackup(bytes.NewReader(enc), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Encrypted || info.LSN != 0 {
+		t.Fatalf("info = %+v", info)
+	}
+}
+
+// A corrupted backup is rejected and leaves the database being replaced,
+// and its directory, as they were.
+func TestRestoreCorrupted(t *testing.T) {
+	data := backupOf(t, nil, sampleBlobs())
+	slot := PageSize + 4
+	page1 := backupHeaderSize + slot
+	for _, tc := range []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{"bad magic", func(b []byte) []byte { b[0] ^= 0xff; return b }},
+		{"bad page size", func(b []byte) []byte { b[9] ^= 0x01; return b }},
+		{"no pages", func(b []byte) []byte { clear(b[16:24]); return b }},
+		{"header date", func(b []byte) []byte { b[24] ^= 0x01; return b }},
+		{"page byte", func(b []byte) []byte { b[page1+100] ^= 0x01; return b }},
+		{"page checksum", func(b []byte) []byte { b[page1+PageSize] ^= 0x01; return b }},
+		{"trailer", func(b []byte) []byte { b[len(b)-1] ^= 0x01; return b }},
+		{"truncated header", func(b []byte) []byte { return b[:backupHeaderSize-1] }},
+		{"truncated page", func(b []byte) []byte { return b[:page1+PageSize/2] }},
+		{"truncated trailer", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"trailing data", func(b []byte) []byte { return append(b, 0) }},
+		{"empty", func(b []byte) []byte { return nil }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bad := tc.corrupt(bytes.Clone(data))
+			if _, err := VerifyBackup(bytes.NewReader(bad), nil); !errors.Is(err, ErrBadBackup) {
+				t.Fatalf("verify: got %v, want ErrBadBackup", err)
+			}
+			dir := t.TempDir()
+			out := filepath.Join(dir, "db")
+			if err := os.WriteFile(out, []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Restore(bytes.NewReader(bad), out, nil); !errors.Is(err, ErrBadBackup) {
+				t.Fatalf("restore: got %v, want ErrBadBackup", err)
+			}
+			if got, err := os.ReadFile(out); err != nil || string(got) != "old" {
+				t.Fatalf("database after a failed restore = %q, %v", got, err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("directory after a failed restore holds %d entries", len(entries))
+			}
+		})
+	}
+}
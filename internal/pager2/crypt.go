@@ -0,0 +1,282 @@
+package pager2
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Encryption at rest. With a key every page and every WAL record is sealed
+// with AES-256-GCM under a fresh random nonce:
+//
+//	page       nonce (12) | sealed page (4096 + 16 byte tag)
+//	page 0     header (16) | nonce (12) | sealed rest of the page
+//	WAL frame  length u32 | nonce (12) | sealed record
+//
+// so each page takes PageSize+28 bytes of the file. A page is authenticated
+// together with its id, page 0 also with its header, and a WAL frame with
+// its offset in the WAL, so a page or record that is modified, swapped or
+// moved fails to open. The header of page 0 stays in the clear and marks
+// the file as encrypted (format version 2), so opening it without a key, or
+// a plain file with one, fails with a clear error. Archived WAL segments
+// and backups of an encrypted database stay encrypted.
+
+// KeySize is the length of an encryption key.
+const KeySize = 32
+
+const (
+	nonceSize    = 12
+	sealOverhead = nonceSize + 16
+)
+
+var (
+	// ErrEncrypted is returned when an encrypted database is opened without
+	// a key.
+	ErrEncrypted = errors.New("database is encrypted; a key is needed")
+	// ErrNotEncrypted is returned when a plain database is opened with a
+	// key.
+	ErrNotEncrypted = errors.New("database is not encrypted; encrypt it with sharkdb rekey")
+	// ErrAuth is returned for pages and WAL records that fail to open.
+	ErrAuth = errors.New("authentication failed: wrong key or tampered data")
+)
+
+// ParseKey reads a key given as 32 raw bytes or 64 hex digits; whitespace
+// around hex digits is ignored.
+func ParseKey(b []byte) ([]byte, error) {
+	if len(b) == KeySize {
+		return b, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("pager: a key is %d raw bytes or %d hex digits", KeySize, 2*KeySize)
+	}
+	return key, nil
+}
+
+// crypter seals pages and WAL records. A nil crypter leaves them in the
+// clear, so callers need not tell the two cases apart.
+type crypter struct {
+	aead cipher.AEAD
+}
+
+func newCrypter(key []byte) (*crypter, error) {
+	if key == nil {
+		return nil, nil
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("pager: key is %d bytes, want %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &crypter{aead: aead}, nil
+}
+
+// slotSize returns the bytes a page takes in the file.
+func (c *crypter) slotSize() int64 {
+	if c == nil {
+		return PageSize
+	}
+	return PageSize + sealOverhead
+}
+
+func nonce() []byte {
+	n := make([]byte, nonceSize)
+	if _, err := rand.Read(n); err != nil {
+		panic("pager: no randomness for a nonce: " + err.Error())
+	}
+	return n
+}
+
+// sealPage returns page pid as stored in the file. The header of page 0 is
+// marked encrypted and kept in the clear.
+func (c *crypter) sealPage(pid uint64, page []byte) []byte {
+	if c == nil {
+		return page
+	}
+	ad := binary.LittleEndian.AppendUint64(nil, pid)
+	out := make([]byte, 0, c.slotSize())
+	body := page
+	if pid == 0 {
+		hdr := make([]byte, metaHeaderSize)
+		copy(hdr, page)
+		binary.LittleEndian.PutUint32(hdr[8:12], encryptedVersion)
+		binary.LittleEndian.PutUint32(hdr[12:16], binary.LittleEndian.Uint32(hdr[12:16])|flagEncrypted)
+		out = append(out, hdr...)
+		ad = append(ad, hdr...)
+		body = page[metaHeaderSize:]
+	}
+	n := nonce()
+	out = append(out, n...)
+	return c.aead.Seal(out, n, body, ad)
+}
+
+// openPage returns the page held by slot, the bytes of page pid in the
+// file.
+func (c *crypter) openPage(pid uint64, slot []byte) ([]byte, error) {
+	if c == nil {
+		return slot, nil
+	}
+	ad := binary.LittleEndian.AppendUint64(nil, pid)
+	var out []byte
+	sealed := slot
+	if pid == 0 {
+		out = append(out, slot[:metaHeaderSize]...)
+		ad = append(ad, slot[:metaHeaderSize]...)
+		sealed = slot[metaHeaderSize:]
+	}
+	out, err := c.aead.Open(out, sealed[:nonceSize], sealed[nonceSize:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("pager: page %d: %w", pid, ErrAuth)
+	}
+	return out, nil
+}
+
+// sealRecord frames a WAL record written at offset off of the WAL.
+func (c *crypter) sealRecord(off int64, rec []byte) []byte {
+	out := make([]byte, 4, 4+sealOverhead+len(rec))
+	binary.LittleEndian.PutUint32(out, uint32(sealOverhead+len(rec)))
+	n := nonce()
+	out = append(out, n...)
+	return c.aead.Seal(out, n, rec, binary.LittleEndian.AppendUint64(nil, uint64(off)))
+}
+
+// openWAL returns the records held by the frames of a WAL and the length of
+// the frames that opened. A frame cut short at the end, or the last frame
+// failing to open, is a torn write and ends the WAL; any other frame that
+// fails to open is an error.
+func (c *crypter) openWAL(data []byte) ([]byte, int, error) {
+	if c == nil {
+		return data, len(data), nil
+	}
+	var out []byte
+	off := 0
+	for off+4 <= len(data) {
+		n := int(binary.LittleEndian.Uint32(data[off:]))
+		end := off + 4 + n
+		if n < sealOverhead || end > len(data) {
+			break
+		}
+		frame := data[off+4 : end]
+		rec, err := c.aead.Open(nil, frame[:nonceSize], frame[nonceSize:], binary.LittleEndian.AppendUint64(nil, uint64(off)))
+		if err != nil {
+			if end == len(data) {
+				break
+			}
+			return out, off, fmt.Errorf("pager: WAL record at offset %d: %w", off, ErrAuth)
+		}
+		out = append(out, rec...)
+		off = end
+	}
+	return out, off, nil
+}
+
+// headerEncrypted reports whether a file starting with hdr is encrypted.
+func headerEncrypted(hdr []byte) bool {
+	return len(hdr) >= metaHeaderSize && bytes.HasPrefix(hdr, fileMagic[:]) &&
+		binary.LittleEndian.Uint32(hdr[12:16])&flagEncrypted != 0
+}
+
+// checkKey compares the encryption of a file starting with hdr with the
+// key given for it.
+func checkKey(hdr []byte, c *crypter) error {
+	switch enc := headerEncrypted(hdr); {
+	case enc && c == nil:
+		return ErrEncrypted
+	case !enc && c != nil:
+		return ErrNotEncrypted
+	}
+	return nil
+}
+
+// Rekey rewrites the database at path under newKey, or in the clear if
+// newKey is nil. opts open the database as it is, with its current key and
+// WAL archive; the WAL is replayed and closed first, so every page is in the
+// file. The new file is written under a temporary name and renamed over
+// path once synced, so a crash leaves either the old file or the new one.
+// The database must not be open. Archived WAL segments and backups keep
+// the old key, so take a new backup after rekeying.
+func Rekey(path string, opts Options, newKey []byte) (uint64, error) {
+	nc, err := newCrypter(newKey)
+	if err != nil {
+		return 0, err
+	}
+	opts.SegmentBytes = -1
+	p, err := OpenOptions(path, opts)
+	if err != nil {
+		return 0, err
+	}
+	if err := p.Close(); err != nil {
+		return 0, err
+	}
+	r, err := OpenRaw(path, opts.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	if r.Tail != 0 {
+		return 0, fmt.Errorf("pager: %s ends with %d bytes of a partial page", path, r.Tail)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".rekey-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	err = rekeyPages(r, tmp, nc)
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = os.Stat(path); err == nil {
+			err = tmp.Chmod(fi.Mode().Perm())
+		}
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return r.Pages, syncDir(filepath.Dir(path))
+}
+
+// rekeyPages copies the pages of r to w, sealed with c.
+func rekeyPages(r *RawFile, w io.WriterAt, c *crypter) error {
+	for pid := uint64(0); pid < r.Pages; pid++ {
+		page, err := r.Page(pid)
+		if err != nil {
+			return err
+		}
+		if pid == 0 {
+			// Re-encode the meta so the header matches the new encryption.
+			m, _, err := decodeMeta(page)
+			if err != nil {
+				return err
+			}
+			if page, err = encodeMeta(m); err != nil {
+				return err
+			}
+		}
+		if _, err := w.WriteAt(c.sealPage(pid, page), int64(pid)*c.slotSize()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package pager2
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+// checkBlobs opens the database at path with key and compares its blobs.
+func checkBlobs(t *testing.T, path string, key []byte, want map[uint64][]byte) {
+	t.Helper()
+	p, err := OpenOptions(path, Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for id, blob := range want {
+		got, err := p.ReadTableBlob(id)
+		if err != nil {
+			t.Fatalf("blob %d: %v", id, err)
+		}
+		if !bytes.Equal(got, blob) {
+			t.Fatalf("blob %d: got %d bytes, want %d", id, len(got), len(blob))
+		}
+	}
+}
+
+func sampleBlobs() map[uint64][]byte {
+	return map[uint64][]byte{
+		1: []byte("small"),
+		2: bytes.Repeat([]byte("spans several pages "), 1000),
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "enc.db")
+	key := testKey(1)
+	blobs := sampleBlobs()
+	storeBlobs(t, path, key, blobs)
+	checkBlobs(t, path, key, blobs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("spans several pages")) {
+		t.Fatal("plaintext found in the encrypted file")
+	}
+}
+
+func TestEncryptedWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "enc.db")
+	storeBlobs(t, path, testKey(1), sampleBlobs())
+
+	if _, err := OpenOptions(path, Options{Key: testKey(2)}); !errors.Is(err, ErrAuth) {
+		t.Fatalf("wrong key: got %v, want ErrAuth", err)
+	}
+	if _, err := OpenOptions(path, Options{}); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("no key: got %v, want ErrEncrypted", err)
+	}
+}
+
+func TestPlainWithKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.db")
+	storeBlobs(t, path, nil, sampleBlobs())
+
+	if _, err := OpenOptions(path, Options{Key: testKey(1)}); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("got %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rekey.db")
+	blobs := sampleBlobs()
+	storeBlobs(t, path, nil, blobs)
+
+	// plain -> encrypted -> another key -> plain
+	steps := []struct{ from, to []byte }{
+		{nil, testKey(1)},
+		{testKey(1), testKey(2)},
+		{testKey(2), nil},
+	}
+	for _, s := range steps {
+		if _, err := Rekey(path, Options{Key: s.from}, s.to); err != nil {
+			t.Fatal(err)
+		}
+		checkBlobs(t, path, s.to, blobs)
+		if s.from != nil {
+			if _, err := OpenOptions(path, Options{Key: s.from}); err == nil {
+				t.Fatal("old key still opens the database")
+			}
+		}
+	}
+}
+
+func TestRekeyWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rekey.db")
+	blobs := sampleBlobs()
+	storeBlobs(t, path, testKey(1), blobs)
+
+	if _, err := Rekey(path, Options{Key: testKey(2)}, testKey(3)); !errors.Is(err, ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+	checkBlobs(t, path, testKey(1), blobs)
+}
+
+func TestParseKey(t *testing.T) {
+	raw := testKey(7)
+	if k, err := ParseKey(raw); err != nil || !bytes.Equal(k, raw) {
+		t.Fatalf("raw key: %v", err)
+	}
+	hexKey := strings.Repeat("07", KeySize) + "\n"
+	if k, err := ParseKey([]byte(hexKey)); err != nil || !bytes.Equal(k, raw) {
+		t.Fatalf("hex key: %v", err)
+	}
+	if _, err := ParseKey([]byte("abcd")); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
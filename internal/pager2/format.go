@@ -0,0 +1,109 @@
+package pager2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+
+	"sharkDB/internal/pager"
+)
+
+// Page 0 starts with a header that identifies the file:
+//
+//	offset  size  field
+//	0       8     magic "SHKDB\x00\x00\x00"
+//	8       4     format version
+//	12      4     flags: 1 = encrypted (see crypt.go)
+//	16            gob-encoded Meta
+//
+// Integers are little-endian. The version is the oldest format that can read
+// the file: plain files are written as version 1 and encrypted ones as
+// version 2. Files written before the header existed hold the gob at offset
+// 0; they are read as format version 0 and gain the header the next time
+// their meta is written, which Open always does.
+
+var fileMagic = [8]byte{'S', 'H', 'K', 'D', 'B', 0, 0, 0}
+
+// FormatVersion is the newest on-disk format this package reads.
+const FormatVersion = 2
+
+const (
+	plainVersion     = 1
+	encryptedVersion = 2
+	flagEncrypted    = 1
+	metaHeaderSize   = 16
+)
+
+var (
+	// ErrNotDatabase is returned for files that are not sharkDB databases.
+	ErrNotDatabase = errors.New("not a sharkDB database")
+	// ErrLegacyFormat is returned for databases written by the legacy
+	// single-image pager, which sharkdb migrate converts.
+	ErrLegacyFormat = errors.New("legacy single-image database; convert it with sharkdb migrate")
+	// ErrFormatVersion is returned for databases written in a newer format.
+	ErrFormatVersion = errors.New("unsupported database format version")
+)
+
+// encodeMeta encodes m into a page, failing if it does not fit.
+func encodeMeta(m Meta) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		return nil, err
+	}
+	if buf.Len() > PageSize-metaHeaderSize {
+		return nil, fmt.Errorf("pager: meta is %d bytes, more than a page holds", buf.Len())
+	}
+	page := make([]byte, PageSize)
+	copy(page, fileMagic[:])
+	binary.LittleEndian.PutUint32(page[8:12], plainVersion)
+	copy(page[metaHeaderSize:], buf.Bytes())
+	return page, nil
+}
+
+// decodeMeta decodes page 0 and returns the meta and the format version the
+// page was written in.
+func decodeMeta(page []byte) (Meta, uint32, error) {
+	var m Meta
+	body, version := page, uint32(0)
+	if bytes.HasPrefix(page, fileMagic[:]) {
+		version = binary.LittleEndian.Uint32(page[8:12])
+		if version > FormatVersion {
+			return m, version, fmt.Errorf("%w %d (this build reads up to %d)", ErrFormatVersion, version, FormatVersion)
+		}
+		body = page[metaHeaderSize:]
+	}
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&m); err != nil {
+		if version == 0 {
+			return m, 0, ErrNotDatabase
+		}
+		return m, version, err
+	}
+	return m, version, nil
+}
+
+// isLegacy reports whether the file at path is an image written by the
+// legacy pager: one gob-encoded pager.DBImage.
+func isLegacy(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var img pager.DBImage
+	return gob.NewDecoder(f).Decode(&img) == nil
+}
+
+// formatError explains why the file at path could not be opened as a
+// database, telling legacy images apart from other files.
+func formatError(path string, err error) error {
+	switch {
+	case errors.Is(err, ErrNotDatabase) && isLegacy(path):
+		err = ErrLegacyFormat
+	case errors.Is(err, ErrAuth):
+		err = ErrAuth // page 0 does not open: most likely the wrong key
+	}
+	return fmt.Errorf("pager: %s: %w", path, err)
+}
@@ -0,0 +1,172 @@
+package pager2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sharkDB/internal/pager"
+)
+
+// headerless encodes m the way files were written before the header.
+func headerless(t *testing.T, m Meta) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	page := make([]byte, PageSize)
+	copy(page, buf.Bytes())
+	return page
+}
+
+// withVersion returns a page with the header of the given version.
+func withVersion(page []byte, version uint32) []byte {
+	page = bytes.Clone(page)
+	binary.LittleEndian.PutUint32(page[8:12], version)
+	return page
+}
+
+func TestDecodeMeta(t *testing.T) {
+	m := Meta{LSN: 7, NextTableID: 3, Tables: map[string]uint64{"a": 1}}
+	current, err := encodeMeta(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := bytes.Repeat([]byte{0xa5}, PageSize)
+	for _, tc := range []struct {
+		name    string
+		page    []byte
+		version uint32
+		want    error
+	}{
+		{"current", current, plainVersion, nil},
+		{"encrypted version", withVersion(current, encryptedVersion), encryptedVersion, nil},
+		{"headerless", headerless(t, m), 0, nil},
+		{"newer version", withVersion(current, FormatVersion+1), FormatVersion + 1, ErrFormatVersion},
+		{"not a database", garbage, 0, ErrNotDatabase},
+		{"zeros", make([]byte, PageSize), 0, ErrNotDatabase},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, version, err := decodeMeta(tc.page)
+			if !errors.Is(err, tc.want) || (err != nil) != (tc.want != nil) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+			if version != tc.version {
+				t.Fatalf("version = %d, want %d", version, tc.version)
+			}
+			if err == nil && (got.LSN != m.LSN || got.Tables["a"] != 1) {
+				t.Fatalf("meta = %+v", got)
+			}
+		})
+	}
+
+	// A header whose body does not decode is damage, not another file.
+	bad := bytes.Clone(current)
+	copy(bad[metaHeaderSize:], garbage)
+	if _, _, err := decodeMeta(bad); err == nil || errors.Is(err, ErrNotDatabase) {
+		t.Fatalf("damaged meta: %v", err)
+	}
+}
+
+func TestOpenFormat(t *testing.T) {
+	meta, err := encodeMeta(Meta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		write func(t *testing.T, path string)
+		want  error
+	}{
+		{"legacy image", func(t *testing.T, path string) {
+			old, err := pager.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := old.StoreTableBlob(1, []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrLegacyFormat},
+		{"short file", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrNotDatabase},
+		{"other file", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, bytes.Repeat([]byte("text "), PageSize), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrNotDatabase},
+		{"newer version", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, withVersion(meta, FormatVersion+1), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrFormatVersion},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			tc.write(t, path)
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(path); !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+				t.Fatal("a failed Open changed the file")
+			}
+			if _, err := os.Stat(path + ".wal"); !os.IsNotExist(err) {
+				t.Fatalf("a failed Open left a WAL: %v", err)
+			}
+		})
+	}
+}
+
+// A file written before the header existed opens, and gains the header.
+func TestOpenHeaderless(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	storeBlobs(t, path, nil, sampleBlobs())
+	p, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := p.Meta()
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(headerless(t, m), 0)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rf, err := OpenRaw(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := rf.Version()
+	rf.Close()
+	if err != nil || v != 0 {
+		t.Fatalf("version before opening = %d, %v", v, err)
+	}
+	checkBlobs(t, path, nil, sampleBlobs())
+	if rf, err = OpenRaw(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if v, err := rf.Version(); err != nil || v != plainVersion {
+		t.Fatalf("version after opening = %d, %v", v, err)
+	}
+}
@@ -0,0 +1,102 @@
+package pager2
+
+import (
+	"fmt"
+	"os"
+)
+
+// With Options.MMap the database file is mapped read-only into memory and
+// blob reads take pages straight from the mapping: no read call, no copy
+// into the page cache and no copy out of it. Writes still go through the
+// file with WriteAt, and the WAL is untouched; on Linux the mapping is
+// shared with the page cache, so it sees every write at once. The file only
+// grows while it is open, and a read past the end of the mapping maps the
+// file again at its new size. Pages of an encrypted database are opened
+// from the mapping into the page cache, which saves the read call but not
+// the copies.
+//
+// Slices into the mapping are only valid until the next remap, which can
+// happen on any read, so they never leave the pager lock: ReadTableBlob
+// copies the blob out before it returns. Snapshots read pages without the
+// lock and so keep reading the file.
+
+// mapping is a read-only memory map of the database file.
+type mapping struct {
+	f    *os.File
+	data []byte
+}
+
+func newMapping(f *os.File) (*mapping, error) {
+	m := &mapping{f: f}
+	if err := m.remap(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// remap maps the file again at its current size.
+func (m *mapping) remap() error {
+	fi, err := m.f.Stat()
+	if err != nil {
+		return err
+	}
+	data, err := mmapFile(m.f, fi.Size())
+	if err != nil {
+		return fmt.Errorf("pager: mmap: %w", err)
+	}
+	old := m.data
+	m.data = data
+	if old != nil {
+		return munmap(old)
+	}
+	return nil
+}
+
+// slot returns the size bytes of page pid as they are in the file. The
+// slice must not be written to or kept past the pager lock.
+func (m *mapping) slot(pid uint64, size int64) ([]byte, error) {
+	off := int64(pid) * size
+	if off+size > int64(len(m.data)) {
+		if err := m.remap(); err != nil {
+			return nil, err
+		}
+		if off+size > int64(len(m.data)) {
+			return nil, fmt.Errorf("pager: page %d is past the end of the file", pid)
+		}
+	}
+	return m.data[off : off+size : off+size], nil
+}
+
+func (m *mapping) close() error {
+	if m == nil || m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
+
+// viewPage returns page pid for reading only. With a mapping of a plain
+// file the page is a slice into the mapping, valid while p.mu is held;
+// otherwise it comes from readPage.
+func (p *Pager) viewPage(pid uint64) ([]byte, error) {
+	if p.mm == nil {
+		return p.readPage(pid)
+	}
+	slot, err := p.mm.slot(pid, p.slot)
+	if err != nil {
+		return nil, err
+	}
+	if p.crypt == nil {
+		return slot, nil
+	}
+	if b, ok := p.cacheGet(pid); ok {
+		return b, nil
+	}
+	page, err := p.crypt.openPage(pid, slot)
+	if err != nil {
+		return nil, err
+	}
+	p.cachePut(pid, page)
+	return page, nil
+}
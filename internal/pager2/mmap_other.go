@@ -0,0 +1,16 @@
+//go:build !linux
+
+package pager2
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+func munmap(b []byte) error {
+	return nil
+}
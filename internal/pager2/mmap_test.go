@@ -0,0 +1,80 @@
+//go:build linux
+
+package pager2
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestMMapReads(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		key  []byte
+	}{
+		{"plain", nil},
+		{"encrypted", testKey(6)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			blobs := sampleBlobs()
+			storeBlobs(t, path, tc.key, blobs)
+			p, err := OpenOptions(path, Options{Key: tc.key, MMap: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			// The mapping gives the same blobs as the file.
+			for id, want := range blobs {
+				got, err := p.ReadTableBlob(id)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Fatalf("blob %d: %d bytes, %v", id, len(got), err)
+				}
+				// The blob is a copy, not a view of the mapping.
+				clear(got)
+				if again, _ := p.ReadTableBlob(id); !bytes.Equal(again, want) {
+					t.Fatalf("blob %d changed when the caller's copy was cleared", id)
+				}
+			}
+
+			// Writes that grow the file are read back through a remap, and
+			// rewrites of mapped pages are seen at once.
+			grown := bytes.Repeat([]byte("grows the file "), 2000)
+			if err := p.StoreTableBlobs(map[uint64][]byte{3: grown, 1: []byte("rewritten")}); err != nil {
+				t.Fatal(err)
+			}
+			for id, want := range map[uint64][]byte{1: []byte("rewritten"), 2: blobs[2], 3: grown} {
+				got, err := p.ReadTableBlob(id)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Fatalf("after writing, blob %d: %d bytes, %v", id, len(got), err)
+				}
+			}
+			if err := p.DeleteTableBlob(3); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := p.ReadTableBlob(3); err != nil || got != nil {
+				t.Fatalf("deleted blob = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestMMapPastEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	storeBlobs(t, path, nil, sampleBlobs())
+	p, err := OpenOptions(path, Options{MMap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.viewPage(1 << 20); err == nil {
+		t.Fatal("a page past the end of the file was mapped")
+	}
+	if _, err := p.viewPage(1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,21 +5,25 @@ import (
 	"encoding/binary"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"io"
+	"log"
+	"maps"
 	"os"
+	"sort"
 	"sync"
+	"time"
+
+	"sharkDB/internal/dbmeta"
 )
 
 const PageSize = 4096
 
-// Meta is stored (gob-encoded) in page 0.
-type Meta struct {
-	Tables      map[string]uint64 // table name -> table id
-	NextTableID uint64
-
-	TableHead map[uint64]uint64 // table id -> head page id of blob chain (0 if none)
-	FreeList  uint64            // head page id of free list (0 if empty)
-}
+// Meta and IndexMeta are the database meta, stored (gob-encoded) in page 0.
+type (
+	Meta      = dbmeta.Meta
+	IndexMeta = dbmeta.IndexMeta
+)
 
 type Pager struct {
 	mu   sync.Mutex
@@ -30,58 +34,144 @@ type Pager struct {
 	cache    map[uint64][]byte
 	order    []uint64
 	maxCache int
+
+	// While backups > 0, chains are queued in meta.Deferred instead of
+	// freed; see Snapshot.
+	backups int
+
+	opts       Options
+	segFirst   uint64 // LSN of the first commit in the WAL segment, 0 if none
+	archiveErr string // last archiving failure logged
+
+	crypt  *crypter // nil unless the database is encrypted
+	slot   int64    // bytes a page takes in the file
+	walOff int64    // size of the WAL, the offset of the next record
+
+	mm *mapping // nil unless Options.MMap; see mmap.go
+}
+
+// Options configure the WAL. The WAL is written in segments: once a write
+// leaves it at SegmentBytes or more, the segment is closed and the WAL starts
+// over. Every record in a closed segment has already been applied to the
+// database file, so it is only needed for point-in-time recovery; with
+// ArchiveDir set it is copied there first, otherwise it is dropped.
+//
+// With Key set the database is encrypted; see crypt.go. A new database is
+// created encrypted, and an existing one must have been encrypted with the
+// same key. With MMap set, blob reads come from a memory map of the file
+// (Linux only); see mmap.go.
+type Options struct {
+	ArchiveDir   string
+	SegmentBytes int64  // 0 means DefaultSegmentBytes, < 0 never closes segments
+	Key          []byte // KeySize bytes, or nil for a plain database
+	MMap         bool
 }
 
+// DefaultSegmentBytes is the WAL segment size used when none is given.
+const DefaultSegmentBytes = 64 << 20
+
 func Open(path string) (*Pager, error) {
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	return OpenOptions(path, Options{})
+}
+
+// OpenOptions opens the database at path with the given WAL options. Any
+// WAL left by a crash is replayed, and archived if ArchiveDir is set, before
+// it is cleared.
+func OpenOptions(path string, opts Options) (*Pager, error) {
+	if opts.SegmentBytes == 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if opts.ArchiveDir != "" {
+		if err := os.MkdirAll(opts.ArchiveDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	c, err := newCrypter(opts.Key)
 	if err != nil {
 		return nil, err
 	}
-	wal, err := os.OpenFile(path+".wal", os.O_RDWR|os.O_CREATE, 0666)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
-		f.Close()
 		return nil, err
 	}
-	p := &Pager{f: f, wal: wal, cache: make(map[uint64][]byte), maxCache: 512}
+	p := &Pager{f: f, cache: make(map[uint64][]byte), maxCache: 512, opts: opts, crypt: c, slot: c.slotSize()}
 	fi, err := f.Stat()
 	if err != nil {
 		f.Close()
-		wal.Close()
 		return nil, err
 	}
-	if fi.Size() < PageSize {
+	// Check the format before creating a WAL next to a file that is not ours.
+	created := fi.Size() == 0
+	hdr := make([]byte, metaHeaderSize)
+	if !created {
+		if _, err := f.ReadAt(hdr, 0); err != nil && !errors.Is(err, io.EOF) {
+			f.Close()
+			return nil, err
+		}
+		if err := checkKey(hdr, c); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("pager: %s: %w", path, err)
+		}
+	}
+	switch {
+	case created:
 		// initialize new file with empty meta in page 0
-		if err := ensureSize(f, PageSize); err != nil {
+		if err := ensureSize(f, p.slot); err != nil {
 			f.Close()
-			wal.Close()
 			return nil, err
 		}
 		p.meta = Meta{Tables: make(map[string]uint64), TableHead: make(map[uint64]uint64)}
 		if err := p.flushMeta(); err != nil {
 			f.Close()
-			wal.Close()
 			return nil, err
 		}
-		return p, nil
+	case fi.Size() < p.slot:
+		f.Close()
+		return nil, formatError(path, ErrNotDatabase)
+	default:
+		if err := p.loadMeta(); err != nil {
+			f.Close()
+			return nil, formatError(path, err)
+		}
 	}
-	if err := p.loadMeta(); err != nil {
+	p.wal, err = os.OpenFile(path+".wal", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
 		f.Close()
-		wal.Close()
 		return nil, err
 	}
+	wal := p.wal
+	if opts.MMap {
+		if p.mm, err = newMapping(f); err != nil {
+			f.Close()
+			wal.Close()
+			return nil, err
+		}
+	}
+	if created {
+		return p, nil
+	}
 	if p.meta.Tables == nil {
 		p.meta.Tables = make(map[string]uint64)
 	}
 	if p.meta.TableHead == nil {
 		p.meta.TableHead = make(map[uint64]uint64)
 	}
-	// Replay any WAL on startup, then truncate the WAL
+	// Replay any WAL on startup, then archive and truncate the WAL
 	if err := p.replayWAL(); err != nil {
+		p.mm.close()
 		f.Close()
 		wal.Close()
 		return nil, err
 	}
-	if err := p.truncateWAL(); err != nil {
+	// Chains a backup held back when the process stopped are free now.
+	if err := p.freeDeferred(); err != nil {
+		p.mm.close()
+		f.Close()
+		wal.Close()
+		return nil, err
+	}
+	if err := p.switchWAL(); err != nil {
+		p.mm.close()
 		f.Close()
 		wal.Close()
 		return nil, err
@@ -97,13 +187,16 @@ func ensureSize(f *os.File, size int64) error {
 }
 
 func (p *Pager) loadMeta() error {
-	buf := make([]byte, PageSize)
+	buf := make([]byte, p.slot)
 	if _, err := p.f.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
 		return err
 	}
-	dec := gob.NewDecoder(bytes.NewReader(buf))
-	var m Meta
-	if err := dec.Decode(&m); err != nil {
+	page, err := p.crypt.openPage(0, buf)
+	if err != nil {
+		return err
+	}
+	m, _, err := decodeMeta(page)
+	if err != nil {
 		return err
 	}
 	p.meta = m
@@ -111,31 +204,91 @@ func (p *Pager) loadMeta() error {
 }
 
 func (p *Pager) flushMeta() error {
-	// gob encode into a PageSize buffer
-	buf := make([]byte, PageSize)
-	w := newBytesWriter(buf)
-	enc := gob.NewEncoder(w)
-	if err := enc.Encode(p.meta); err != nil {
+	return p.writeMeta(p.meta)
+}
+
+// writeMeta writes m to page 0 and syncs the file.
+func (p *Pager) writeMeta(m Meta) error {
+	buf, err := encodeMeta(m)
+	if err != nil {
 		return err
 	}
-	if _, err := p.f.WriteAt(buf, 0); err != nil {
+	if _, err := p.f.WriteAt(p.crypt.sealPage(0, buf), 0); err != nil {
 		return err
 	}
 	// don't cache meta page
 	return p.f.Sync()
 }
 
+// Meta returns the current meta. Writes replace the maps of the meta rather
+// than changing them, so callers may read a returned meta without the lock.
 func (p *Pager) Meta() Meta {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	return p.meta
 }
 
+// Close closes the database and WAL files.
+func (p *Pager) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.mm.close()
+	if werr := p.wal.Close(); err == nil {
+		err = werr
+	}
+	if ferr := p.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Sync flushes the database and WAL files to stable storage. Every write
+// already syncs both before it returns, so this is a no-op in practice; it
+// lets the pager serve as a storage.Storage (see storage.Pager2).
+func (p *Pager) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.wal.Sync(); err != nil {
+		return err
+	}
+	return p.f.Sync()
+}
+
+// UpdateMeta changes the catalog part of the meta: tables, ids, indexes and
+// schemas. The change is logged so point-in-time recovery replays it. mut
+// works on a copy, which replaces the meta only once it is logged and
+// written, so a failed update leaves the meta as it was.
 func (p *Pager) UpdateMeta(mut func(m *Meta)) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	mut(&p.meta)
-	return p.flushMeta()
+	m := p.meta.Clone()
+	mut(&m)
+	off := p.walOff
+	if err := p.walAppendMeta(m); err != nil {
+		return p.walUndo(off, err)
+	}
+	if err := p.walAppendCommit(); err != nil {
+		return p.walUndo(off, err)
+	}
+	if err := p.walSync(); err != nil {
+		return err
+	}
+	m.LSN = p.meta.LSN
+	if err := p.writeMeta(m); err != nil {
+		return err
+	}
+	p.meta = m
+	p.maybeSwitchWAL()
+	return nil
+}
+
+// ownHeads gives the meta a TableHead map of its own before a write changes
+// it, since metas returned by Meta share the old one.
+func (p *Pager) ownHeads() {
+	p.meta.TableHead = maps.Clone(p.meta.TableHead)
+	if p.meta.TableHead == nil {
+		p.meta.TableHead = make(map[uint64]uint64)
+	}
 }
 
 // fault injection helper for WAL crash testing
@@ -184,19 +337,28 @@ func (p *Pager) readPage(pid uint64) ([]byte, error) {
 	if b, ok := p.cacheGet(pid); ok {
 		return b, nil
 	}
-	buf := make([]byte, PageSize)
-	if _, err := p.f.ReadAt(buf, int64(pid)*PageSize); err != nil {
+	buf, err := p.readSlot(pid)
+	if err != nil {
 		return nil, err
 	}
 	p.cachePut(pid, buf)
 	return buf, nil
 }
 
+// readSlot reads page pid from the file, bypassing the cache.
+func (p *Pager) readSlot(pid uint64) ([]byte, error) {
+	buf := make([]byte, p.slot)
+	if _, err := p.f.ReadAt(buf, int64(pid)*p.slot); err != nil {
+		return nil, err
+	}
+	return p.crypt.openPage(pid, buf)
+}
+
 func (p *Pager) writePage(pid uint64, page []byte) error {
 	if len(page) != PageSize {
 		return errors.New("invalid page size")
 	}
-	if _, err := p.f.WriteAt(page, int64(pid)*PageSize); err != nil {
+	if _, err := p.f.WriteAt(p.crypt.sealPage(pid, page), int64(pid)*p.slot); err != nil {
 		return err
 	}
 	p.cachePut(pid, page)
@@ -208,10 +370,96 @@ func (p *Pager) StoreTableBlob(tableID uint64, blob []byte) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	// WAL append
+	off := p.walOff
 	if err := p.walAppendStore(tableID, blob); err != nil {
+		return p.walUndo(off, err)
+	}
+	if err := p.walAppendCommit(); err != nil {
+		return p.walUndo(off, err)
+	}
+	if err := p.walSync(); err != nil {
 		return err
 	}
 	walFail("after_wal_store")
+	p.ownHeads()
+	if err := p.applyStore(tableID, blob); err != nil {
+		return err
+	}
+	walFail("before_meta_flush")
+	if err := p.flushMeta(); err != nil {
+		return err
+	}
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	p.maybeSwitchWAL()
+	return nil
+}
+
+// StoreTableBlobs writes several table blobs as one unit. All blobs are logged
+// in a single WAL record before any page is touched, so after a crash replay
+// applies either every blob or none of them.
+func (p *Pager) StoreTableBlobs(blobs map[uint64][]byte) error {
+	return p.StoreTableBlobsMeta(blobs, nil)
+}
+
+// StoreTableBlobsMeta is StoreTableBlobs that also changes the catalog part
+// of the meta, as UpdateMeta does, in the same commit. mut may be nil.
+func (p *Pager) StoreTableBlobsMeta(blobs map[uint64][]byte, mut func(m *Meta)) error {
+	if len(blobs) == 0 && mut == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]uint64, 0, len(blobs))
+	for id := range blobs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var m Meta
+	off := p.walOff
+	if len(ids) > 0 {
+		if err := p.walAppendMulti(ids, blobs); err != nil {
+			return p.walUndo(off, err)
+		}
+	}
+	if mut != nil {
+		m = p.meta.Clone()
+		mut(&m)
+		if err := p.walAppendMeta(m); err != nil {
+			return p.walUndo(off, err)
+		}
+	}
+	if err := p.walAppendCommit(); err != nil {
+		return p.walUndo(off, err)
+	}
+	if err := p.walSync(); err != nil {
+		return err
+	}
+	walFail("after_wal_multi")
+	p.ownHeads()
+	for _, id := range ids {
+		if err := p.applyStore(id, blobs[id]); err != nil {
+			return err
+		}
+	}
+	if mut != nil {
+		p.setCatalog(m)
+	}
+	walFail("before_meta_flush")
+	if err := p.flushMeta(); err != nil {
+		return err
+	}
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	p.maybeSwitchWAL()
+	return nil
+}
+
+// applyStore replaces the page chain of tableID with blob without logging.
+// The caller flushes meta.
+func (p *Pager) applyStore(tableID uint64, blob []byte) error {
 	// free old chain if exists
 	if head, ok := p.meta.TableHead[tableID]; ok && head != 0 {
 		if err := p.freeChain(head); err != nil {
@@ -221,10 +469,7 @@ func (p *Pager) StoreTableBlob(tableID uint64, blob []byte) error {
 	}
 	if len(blob) == 0 {
 		// nothing to store
-		if err := p.flushMeta(); err != nil {
-			return err
-		}
-		return p.walSync()
+		return nil
 	}
 	// write new chain
 	const headerSize = 12 // next(8) + dataLen(4)
@@ -240,66 +485,62 @@ func (p *Pager) StoreTableBlob(tableID uint64, blob []byte) error {
 			return err
 		}
 		pages = append(pages, pid)
-		page := make([]byte, PageSize)
-		binary.LittleEndian.PutUint32(page[8:12], uint32(end-off))
-		copy(page[headerSize:], blob[off:end])
-		if err := p.writePage(pid, page); err != nil {
-			return err
-		}
 	}
-	// link next pointers
-	for i := 0; i < len(pages); i++ {
+	for i, pid := range pages {
+		off := i * capPerPage
+		end := off + capPerPage
+		if end > len(blob) {
+			end = len(blob)
+		}
 		var next uint64
 		if i+1 < len(pages) {
 			next = pages[i+1]
-		} else {
-			next = 0
 		}
-		pg, err := p.readPage(pages[i])
-		if err != nil {
-			return err
-		}
-		binary.LittleEndian.PutUint64(pg[:8], next)
-		if err := p.writePage(pages[i], pg); err != nil {
+		page := make([]byte, PageSize)
+		binary.LittleEndian.PutUint64(page[:8], next)
+		binary.LittleEndian.PutUint32(page[8:12], uint32(end-off))
+		copy(page[headerSize:], blob[off:end])
+		if err := p.writePage(pid, page); err != nil {
 			return err
 		}
 	}
-	walFail("before_meta_flush")
 	p.meta.TableHead[tableID] = pages[0]
-	if err := p.flushMeta(); err != nil {
-		return err
-	}
-	if err := p.f.Sync(); err != nil {
-		return err
-	}
-	return p.walSync()
+	return nil
 }
 
-// LoadTableBlob reads the blob chain for tableID.
+// LoadTableBlob reads the blob chain for tableID. ok is false if there is
+// none or it cannot be read; ReadTableBlob tells the two apart.
 func (p *Pager) LoadTableBlob(tableID uint64) ([]byte, bool) {
+	blob, err := p.ReadTableBlob(tableID)
+	return blob, err == nil && blob != nil
+}
+
+// ReadTableBlob reads the blob chain for tableID, returning nil if there is
+// none and an error if a page cannot be read or fails to authenticate.
+func (p *Pager) ReadTableBlob(tableID uint64) ([]byte, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	head := p.meta.TableHead[tableID]
 	if head == 0 {
-		return nil, false
+		return nil, nil
 	}
 	const headerSize = 12
-	var out []byte
+	out := []byte{}
 	pid := head
 	for pid != 0 {
-		buf, err := p.readPage(pid)
+		buf, err := p.viewPage(pid)
 		if err != nil {
-			return nil, false
+			return nil, err
 		}
 		next := binary.LittleEndian.Uint64(buf[:8])
 		n := int(binary.LittleEndian.Uint32(buf[8:12]))
 		if headerSize+n > len(buf) {
-			return nil, false
+			return nil, fmt.Errorf("pager: blob %d: page %d states a data length that does not fit in a page", tableID, pid)
 		}
 		out = append(out, buf[headerSize:headerSize+n]...)
 		pid = next
 	}
-	return out, true
+	return out, nil
 }
 
 // DeleteTableBlob frees the chain for tableID.
@@ -307,25 +548,39 @@ func (p *Pager) DeleteTableBlob(tableID uint64) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	// WAL append
+	off := p.walOff
 	if err := p.walAppendDelete(tableID); err != nil {
-		return err
+		return p.walUndo(off, err)
 	}
-	walFail("after_wal_delete")
-	head := p.meta.TableHead[tableID]
-	if head == 0 {
-		return p.walSync()
+	if err := p.walAppendCommit(); err != nil {
+		return p.walUndo(off, err)
 	}
-	if err := p.freeChain(head); err != nil {
+	if err := p.walSync(); err != nil {
 		return err
 	}
+	walFail("after_wal_delete")
+	p.ownHeads()
+	if head := p.meta.TableHead[tableID]; head != 0 {
+		if err := p.freeChain(head); err != nil {
+			return err
+		}
+	}
 	delete(p.meta.TableHead, tableID)
 	if err := p.flushMeta(); err != nil {
 		return err
 	}
-	return p.walSync()
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	p.maybeSwitchWAL()
+	return nil
 }
 
 func (p *Pager) freeChain(head uint64) error {
+	if p.backups > 0 {
+		p.meta.Deferred = append(p.meta.Deferred, head)
+		return nil
+	}
 	pid := head
 	for pid != 0 {
 		buf, err := p.readPage(pid)
@@ -365,10 +620,14 @@ func (p *Pager) allocPage() (uint64, error) {
 		return 0, err
 	}
 	size := fi.Size()
-	pid := uint64(size / PageSize)
-	if err := ensureSize(p.f, size+PageSize); err != nil {
+	pid := uint64(size / p.slot)
+	if err := ensureSize(p.f, size+p.slot); err != nil {
 		return 0, err
 	}
+	if p.crypt != nil {
+		// A zeroed slot does not open; seal an empty page into it.
+		return pid, p.writePage(pid, make([]byte, PageSize))
+	}
 	// page is zeroed; cache it
 	p.cachePut(pid, make([]byte, PageSize))
 	return pid, nil
@@ -381,15 +640,25 @@ func (p *Pager) walAppendStore(tableID uint64, blob []byte) error {
 	hdr[0] = 1
 	binary.LittleEndian.PutUint64(hdr[1:9], tableID)
 	binary.LittleEndian.PutUint64(hdr[9:17], uint64(len(blob)))
-	if _, err := p.wal.Write(hdr); err != nil {
-		return err
-	}
-	if len(blob) > 0 {
-		if _, err := p.wal.Write(blob); err != nil {
-			return err
-		}
-	}
-	return nil
+	return p.walWrite(hdr, blob)
+}
+
+func (p *Pager) walAppendMulti(ids []uint64, blobs map[uint64][]byte) error {
+	// record: 3 | count | bodyLen | (tableID | blobLen | blob)*
+	bodyLen := 0
+	for _, id := range ids {
+		bodyLen += 16 + len(blobs[id])
+	}
+	rec := make([]byte, 17, 17+bodyLen)
+	rec[0] = 3
+	binary.LittleEndian.PutUint64(rec[1:9], uint64(len(ids)))
+	binary.LittleEndian.PutUint64(rec[9:17], uint64(bodyLen))
+	for _, id := range ids {
+		rec = binary.LittleEndian.AppendUint64(rec, id)
+		rec = binary.LittleEndian.AppendUint64(rec, uint64(len(blobs[id])))
+		rec = append(rec, blobs[id]...)
+	}
+	return p.walWrite(rec)
 }
 
 func (p *Pager) walAppendDelete(tableID uint64) error {
@@ -397,12 +666,75 @@ func (p *Pager) walAppendDelete(tableID uint64) error {
 	hdr[0] = 2
 	binary.LittleEndian.PutUint64(hdr[1:9], tableID)
 	// blobLen=0
-	if _, err := p.wal.Write(hdr); err != nil {
+	return p.walWrite(hdr)
+}
+
+func (p *Pager) walAppendMeta(meta Meta) error {
+	// record: 4 | 0 | len | gob of the catalog fields of the meta
+	var buf bytes.Buffer
+	m := Meta{Tables: meta.Tables, NextTableID: meta.NextTableID, Indexes: meta.Indexes, Schemas: meta.Schemas, Compression: meta.Compression, Expiring: meta.Expiring}
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		return err
+	}
+	rec := make([]byte, 17, 17+buf.Len())
+	rec[0] = 4
+	binary.LittleEndian.PutUint64(rec[9:17], uint64(buf.Len()))
+	return p.walWrite(rec, buf.Bytes())
+}
+
+// walAppendCommit ends the records of one write with a commit marker that
+// gives them the next LSN and the time. Replay applies records only when it
+// reaches their marker, except for a crash-left tail; see replay.
+func (p *Pager) walAppendCommit() error {
+	// record: 5 | lsn | unix nanos
+	lsn := p.meta.LSN + 1
+	hdr := make([]byte, 17)
+	hdr[0] = 5
+	binary.LittleEndian.PutUint64(hdr[1:9], lsn)
+	binary.LittleEndian.PutUint64(hdr[9:17], uint64(time.Now().UnixNano()))
+	if err := p.walWrite(hdr); err != nil {
 		return err
 	}
+	p.meta.LSN = lsn
+	if p.segFirst == 0 {
+		p.segFirst = lsn
+	}
 	return nil
 }
 
+// walWrite appends one record, given in parts, to the WAL. An encrypted
+// WAL seals it into a single frame.
+func (p *Pager) walWrite(parts ...[]byte) error {
+	if p.crypt != nil {
+		parts = [][]byte{p.crypt.sealRecord(p.walOff, bytes.Join(parts, nil))}
+	}
+	for _, b := range parts {
+		if len(b) == 0 {
+			continue
+		}
+		n, err := p.wal.Write(b)
+		p.walOff += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walUndo cuts the WAL back to off after appending the records of a write
+// failed, so that neither replay nor the next write sees a partial group.
+// It returns err.
+func (p *Pager) walUndo(off int64, err error) error {
+	if terr := p.wal.Truncate(off); terr != nil {
+		return fmt.Errorf("%w (and truncating the WAL failed: %v)", err, terr)
+	}
+	if _, serr := p.wal.Seek(off, io.SeekStart); serr != nil {
+		return fmt.Errorf("%w (and seeking the WAL failed: %v)", err, serr)
+	}
+	p.walOff = off
+	return err
+}
+
 func (p *Pager) walSync() error {
 	if p.wal == nil {
 		return nil
@@ -414,7 +746,55 @@ func (p *Pager) truncateWAL() error {
 	if p.wal == nil {
 		return nil
 	}
-	return p.wal.Truncate(0)
+	if err := p.wal.Truncate(0); err != nil {
+		return err
+	}
+	p.segFirst = 0
+	p.walOff = 0
+	// the WAL is not opened for appending; write from the start again
+	_, err := p.wal.Seek(0, io.SeekStart)
+	return err
+}
+
+// SwitchWAL closes the current WAL segment, archiving it if configured, so
+// that the archive covers every write made so far.
+func (p *Pager) SwitchWAL() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.switchWAL()
+}
+
+func (p *Pager) switchWAL() error {
+	fi, err := p.wal.Stat()
+	if err != nil || fi.Size() == 0 {
+		return err
+	}
+	if p.opts.ArchiveDir != "" {
+		if err := p.archiveWAL(fi.Size()); err != nil {
+			return err
+		}
+	}
+	return p.truncateWAL()
+}
+
+// maybeSwitchWAL closes the segment once it is full. The write that filled
+// it has already succeeded, so a failure to archive is logged, not returned;
+// the segment then keeps growing and archiving is retried after each write.
+func (p *Pager) maybeSwitchWAL() {
+	if p.opts.SegmentBytes < 0 {
+		return
+	}
+	if fi, err := p.wal.Stat(); err != nil || fi.Size() < p.opts.SegmentBytes {
+		return
+	}
+	if err := p.switchWAL(); err != nil {
+		if err.Error() != p.archiveErr {
+			log.Printf("pager: closing WAL segment: %v (retrying after each write)", err)
+		}
+		p.archiveErr = err.Error()
+		return
+	}
+	p.archiveErr = ""
 }
 
 func (p *Pager) replayWAL() error {
@@ -429,96 +809,174 @@ func (p *Pager) replayWAL() error {
 	if err != nil {
 		return err
 	}
+	p.walOff = int64(len(data))
+	data, valid, err := p.crypt.openWAL(data)
+	if err != nil {
+		return err
+	}
+	if int64(valid) < p.walOff {
+		// Drop a torn frame so the marker below starts a frame of its own.
+		if err := p.wal.Truncate(int64(valid)); err != nil {
+			return err
+		}
+		if _, err := p.wal.Seek(int64(valid), io.SeekStart); err != nil {
+			return err
+		}
+		p.walOff = int64(valid)
+	}
+	st, err := p.replay(data, nil)
+	if err != nil {
+		return err
+	}
+	p.segFirst = st.first
+	if st.tail {
+		// Give the tail a marker so the archived segment replays it too.
+		if err := p.walAppendCommit(); err != nil {
+			return err
+		}
+		if err := p.walSync(); err != nil {
+			return err
+		}
+	}
+	return p.flushMeta()
+}
+
+// walState describes a replayed WAL segment.
+type walState struct {
+	first   uint64 // LSN of the first commit marker, 0 if none
+	last    int64  // commit time of the last group applied, unix nanos
+	tail    bool   // records after the last marker were applied
+	stopped bool   // stop ended the replay
+}
+
+// replay applies the records of a WAL segment. Records belong to the commit
+// marker that follows them and are applied as a group when it is reached;
+// groups whose LSN is not above the meta's are already in the file and are
+// skipped. A torn record at the end is ignored.
+//
+// With stop nil this is crash recovery: records after the last marker are
+// applied as well (a WAL written before commit markers existed has no
+// markers at all) and meta is flushed after every group. Otherwise it is
+// point-in-time recovery: replay ends before the first group for which stop
+// reports true, a gap in the LSNs is an error, an unmarked tail is dropped
+// and the caller flushes meta.
+func (p *Pager) replay(data []byte, stop func(lsn uint64, nanos int64) bool) (walState, error) {
+	var st walState
+	var pending [][]byte
 	off := 0
+	p.ownHeads()
 	for off+17 <= len(data) {
-		recType := data[off]
-		tableID := binary.LittleEndian.Uint64(data[off+1 : off+9])
-		blobLen := int(binary.LittleEndian.Uint64(data[off+9 : off+17]))
-		off += 17
+		rec := data[off:]
+		recType := rec[0]
+		n := 17
 		switch recType {
-		case 1:
-			if off+blobLen > len(data) {
-				return nil
+		case 1, 3, 4:
+			n += int(binary.LittleEndian.Uint64(rec[9:17]))
+			if n < 17 || n > len(rec) {
+				off = len(data)
+				continue
+			}
+			pending = append(pending, rec[:n])
+		case 2:
+			pending = append(pending, rec[:n])
+		case 5:
+			lsn := binary.LittleEndian.Uint64(rec[1:9])
+			nanos := int64(binary.LittleEndian.Uint64(rec[9:17]))
+			if st.first == 0 {
+				st.first = lsn
 			}
-			blob := data[off : off+blobLen]
-			off += blobLen
-			// apply as store without logging
-			// free old
-			if head, ok := p.meta.TableHead[tableID]; ok && head != 0 {
-				if err := p.freeChain(head); err != nil {
-					return err
+			group := pending
+			pending = nil
+			if lsn <= p.meta.LSN {
+				break
+			}
+			if stop != nil {
+				if stop(lsn, nanos) {
+					st.stopped = true
+					return st, nil
+				}
+				if lsn != p.meta.LSN+1 {
+					return st, fmt.Errorf("pager: WAL is missing LSNs %d to %d", p.meta.LSN+1, lsn-1)
 				}
-				p.meta.TableHead[tableID] = 0
 			}
-			if len(blob) > 0 {
-				const headerSize = 12
-				capPerPage := PageSize - headerSize
-				var pages []uint64
-				for o := 0; o < len(blob); o += capPerPage {
-					e := o + capPerPage
-					if e > len(blob) {
-						e = len(blob)
-					}
-					pid, err := p.allocPage()
-					if err != nil {
-						return err
-					}
-					pages = append(pages, pid)
-					chunk := blob[o:e]
-					page := make([]byte, PageSize)
-					binary.LittleEndian.PutUint32(page[8:12], uint32(len(chunk)))
-					copy(page[headerSize:], chunk)
-					if _, err := p.f.WriteAt(page, int64(pid)*PageSize); err != nil {
-						return err
-					}
+			for _, r := range group {
+				if err := p.applyRecord(r); err != nil {
+					return st, err
 				}
-				for i := 0; i < len(pages); i++ {
-					var next uint64
-					if i+1 < len(pages) {
-						next = pages[i+1]
-					}
-					nb := make([]byte, 8)
-					binary.LittleEndian.PutUint64(nb, next)
-					if _, err := p.f.WriteAt(nb, int64(pages[i])*PageSize); err != nil {
-						return err
-					}
+			}
+			p.meta.LSN = lsn
+			st.last = nanos
+			if stop == nil {
+				if err := p.flushMeta(); err != nil {
+					return st, err
 				}
-				p.meta.TableHead[tableID] = pages[0]
 			}
-			if err := p.flushMeta(); err != nil {
+		default:
+			off = len(data)
+			continue
+		}
+		off += n
+	}
+	if stop != nil || len(pending) == 0 {
+		return st, nil
+	}
+	for _, r := range pending {
+		if err := p.applyRecord(r); err != nil {
+			return st, err
+		}
+	}
+	st.tail = true
+	return st, p.flushMeta()
+}
+
+// applyRecord applies one complete WAL record without logging it.
+func (p *Pager) applyRecord(rec []byte) error {
+	id := binary.LittleEndian.Uint64(rec[1:9])
+	body := rec[17:]
+	switch rec[0] {
+	case 1:
+		return p.applyStore(id, body)
+	case 2:
+		if head := p.meta.TableHead[id]; head != 0 {
+			if err := p.freeChain(head); err != nil {
 				return err
 			}
-		case 2:
-			if head := p.meta.TableHead[tableID]; head != 0 {
-				if err := p.freeChain(head); err != nil {
-					return err
-				}
+		}
+		delete(p.meta.TableHead, id)
+	case 3:
+		// multi-table store: id holds the entry count
+		for i, bo := uint64(0), 0; i < id; i++ {
+			if bo+16 > len(body) {
+				return nil
 			}
-			delete(p.meta.TableHead, tableID)
-			if err := p.flushMeta(); err != nil {
+			tid := binary.LittleEndian.Uint64(body[bo : bo+8])
+			n := int(binary.LittleEndian.Uint64(body[bo+8 : bo+16]))
+			bo += 16
+			if bo+n > len(body) {
+				return nil
+			}
+			if err := p.applyStore(tid, body[bo:bo+n]); err != nil {
 				return err
 			}
-		default:
-			return nil
+			bo += n
+		}
+	case 4:
+		var m Meta
+		if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&m); err != nil {
+			return err
 		}
+		p.setCatalog(m)
 	}
 	return nil
 }
 
-// minimal writer that writes to provided backing buffer
-type bytesWriter struct {
-	buf []byte
-	off int
-}
-
-func newBytesWriter(buf []byte) *bytesWriter { return &bytesWriter{buf: buf} }
-
-func (w *bytesWriter) Write(p []byte) (int, error) {
-	if w.off+len(p) > len(w.buf) {
-		// truncate to fit
-		p = p[:len(w.buf)-w.off]
-	}
-	n := copy(w.buf[w.off:], p)
-	w.off += n
-	return n, nil
+// setCatalog replaces the catalog part of the meta, the fields a meta
+// record logs, with that of m.
+func (p *Pager) setCatalog(m Meta) {
+	if m.Tables == nil {
+		m.Tables = make(map[string]uint64)
+	}
+	p.meta.Tables, p.meta.NextTableID = m.Tables, m.NextTableID
+	p.meta.Indexes, p.meta.Schemas = m.Indexes, m.Schemas
+	p.meta.Compression, p.meta.Expiring = m.Compression, m.Expiring
 }
@@ -0,0 +1,96 @@
+package pager2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Metas returned by Meta are read without the lock, so writes must replace
+// the maps instead of changing them. Run with -race.
+func TestMetaCopyOnWrite(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	before := p.Meta()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			m := p.Meta()
+			for name, id := range m.Tables {
+				_ = m.TableHead[id]
+				_ = name
+			}
+		}
+	}()
+	for i := 1; i <= 50; i++ {
+		name := fmt.Sprintf("t%d", i)
+		if err := p.UpdateMeta(func(m *Meta) {
+			m.Tables[name] = uint64(i)
+			m.NextTableID = uint64(i)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.StoreTableBlob(uint64(i), []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if len(before.Tables) != 0 || len(before.TableHead) != 0 {
+		t.Fatalf("an earlier meta changed: %d tables, %d heads", len(before.Tables), len(before.TableHead))
+	}
+	if m := p.Meta(); len(m.Tables) != 50 || len(m.TableHead) != 50 {
+		t.Fatalf("meta has %d tables and %d heads, want 50", len(m.Tables), len(m.TableHead))
+	}
+}
+
+// A meta update whose WAL write fails leaves the meta and the WAL as they
+// were, and later writes go through.
+func TestUpdateMetaWALFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	p, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal := p.wal
+	ro, err := os.Open(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	p.wal = ro
+	err = p.UpdateMeta(func(m *Meta) { m.Tables["lost"] = 1 })
+	p.wal = wal
+	if err == nil {
+		t.Fatal("UpdateMeta succeeded with an unwritable WAL")
+	}
+	if _, ok := p.Meta().Tables["lost"]; ok {
+		t.Fatal("a failed update changed the meta")
+	}
+	if err := p.UpdateMeta(func(m *Meta) { m.Tables["kept"] = 2 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	m := p.Meta()
+	if _, ok := m.Tables["lost"]; ok {
+		t.Fatal("the failed update came back on reopen")
+	}
+	if m.Tables["kept"] != 2 {
+		t.Fatalf("tables after reopen = %v", m.Tables)
+	}
+}
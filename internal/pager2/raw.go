@@ -0,0 +1,199 @@
+package pager2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RawFile reads a database file page by page. It neither replays nor
+// clears the WAL and never writes, so it is safe to use on a damaged file
+// that Open might make worse; it is meant for checking and inspecting.
+type RawFile struct {
+	f        *os.File
+	path     string
+	crypt    *crypter
+	Pages    uint64 // whole pages in the file
+	Tail     int64  // bytes after the last whole page
+	WALBytes int64  // size of the WAL, 0 if there is none
+}
+
+// OpenRaw opens the database at path read-only. An encrypted database
+// needs its key; pages that fail to open are reported by Page.
+func OpenRaw(path string, key []byte) (*RawFile, error) {
+	c, err := newCrypter(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	hdr := make([]byte, metaHeaderSize)
+	f.ReadAt(hdr, 0)
+	if err := checkKey(hdr, c); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("pager: %s: %w", path, err)
+	}
+	slot := c.slotSize()
+	r := &RawFile{f: f, path: path, crypt: c, Pages: uint64(fi.Size() / slot), Tail: fi.Size() % slot}
+	if wi, err := os.Stat(path + ".wal"); err == nil {
+		r.WALBytes = wi.Size()
+	}
+	return r, nil
+}
+
+// Close closes the file.
+func (r *RawFile) Close() error { return r.f.Close() }
+
+// Page reads page pid.
+func (r *RawFile) Page(pid uint64) ([]byte, error) {
+	if pid >= r.Pages {
+		return nil, fmt.Errorf("pager: page %d is past the end of the file (%d pages)", pid, r.Pages)
+	}
+	buf := make([]byte, r.crypt.slotSize())
+	if _, err := r.f.ReadAt(buf, int64(pid)*r.crypt.slotSize()); err != nil {
+		return nil, err
+	}
+	return r.crypt.openPage(pid, buf)
+}
+
+// Meta decodes the meta in page 0.
+func (r *RawFile) Meta() (Meta, error) {
+	m, _, err := r.meta()
+	return m, err
+}
+
+// Version returns the format version page 0 was written in.
+func (r *RawFile) Version() (uint32, error) {
+	_, v, err := r.meta()
+	return v, err
+}
+
+func (r *RawFile) meta() (Meta, uint32, error) {
+	buf, err := r.Page(0)
+	if err != nil {
+		return Meta{}, 0, err
+	}
+	m, v, err := decodeMeta(buf)
+	if err != nil {
+		if errors.Is(err, ErrNotDatabase) && isLegacy(r.path) {
+			err = ErrLegacyFormat
+		}
+		return m, v, fmt.Errorf("pager: meta page: %w", err)
+	}
+	return m, v, nil
+}
+
+// ChainPage splits a page of a blob chain into the id of the next page (0
+// at the end) and the blob bytes it holds. ok is false when the stated
+// length does not fit in the page.
+func ChainPage(page []byte) (next uint64, data []byte, ok bool) {
+	const headerSize = 12
+	next = binary.LittleEndian.Uint64(page[:8])
+	n := int(binary.LittleEndian.Uint32(page[8:12]))
+	if headerSize+n > len(page) {
+		return next, nil, false
+	}
+	return next, page[headerSize : headerSize+n], true
+}
+
+// FreePage returns the id of the next page on the free list (0 at the end)
+// from a free page.
+func FreePage(page []byte) uint64 {
+	return binary.LittleEndian.Uint64(page[:8])
+}
+
+// WALRecord is one record of the WAL, as read by RawFile.WAL.
+type WALRecord struct {
+	LSN   uint64    // of the commit that ends it; 0 after the last commit
+	Time  time.Time // of that commit
+	Blobs map[uint64][]byte
+	// Delete is the blob a delete record frees; Meta the catalog a meta
+	// record sets (its TableHead and FreeList are unset).
+	Delete uint64
+	Meta   *Meta
+}
+
+// WAL reads the records in the database's WAL, in order. Reading stops at
+// the first record that is torn or unknown; rest is the number of bytes
+// from there to the end of the file.
+func (r *RawFile) WAL() (recs []WALRecord, rest int, err error) {
+	data, err := os.ReadFile(r.path + ".wal")
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	size := len(data)
+	// An encrypted WAL yields the records of the frames before the first
+	// that fails to open, and the error.
+	data, valid, werr := r.crypt.openWAL(data)
+	torn := size - valid // sealed frames that did not open
+	pending := 0         // records not yet followed by a commit
+	off := 0
+	for off+17 <= len(data) {
+		rec := data[off:]
+		id := binary.LittleEndian.Uint64(rec[1:9])
+		n := 17
+		if rec[0] == 1 || rec[0] == 3 || rec[0] == 4 {
+			n += int(binary.LittleEndian.Uint64(rec[9:17]))
+			if n < 17 || n > len(rec) {
+				break
+			}
+		}
+		body := rec[17:n]
+		var wr WALRecord
+		switch rec[0] {
+		case 1:
+			wr.Blobs = map[uint64][]byte{id: body}
+		case 2:
+			wr.Delete = id
+		case 3:
+			wr.Blobs = make(map[uint64][]byte)
+			for i, bo := uint64(0), 0; i < id; i++ {
+				if bo+16 > len(body) {
+					return recs, len(data) - off + torn, werr
+				}
+				tid := binary.LittleEndian.Uint64(body[bo : bo+8])
+				bn := int(binary.LittleEndian.Uint64(body[bo+8 : bo+16]))
+				bo += 16
+				if bn < 0 || bo+bn > len(body) {
+					return recs, len(data) - off + torn, werr
+				}
+				wr.Blobs[tid] = body[bo : bo+bn]
+				bo += bn
+			}
+		case 4:
+			var m Meta
+			if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&m); err != nil {
+				return recs, len(data) - off + torn, werr
+			}
+			wr.Meta = &m
+		case 5:
+			t := time.Unix(0, int64(binary.LittleEndian.Uint64(rec[9:17])))
+			for i := len(recs) - pending; i < len(recs); i++ {
+				recs[i].LSN, recs[i].Time = id, t
+			}
+			pending = 0
+			off += n
+			continue
+		default:
+			return recs, len(data) - off + torn, werr
+		}
+		recs = append(recs, wr)
+		pending++
+		off += n
+	}
+	return recs, len(data) - off + torn, werr
+}
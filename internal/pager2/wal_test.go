@@ -0,0 +1,176 @@
+package pager2
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// storeBlobs writes blobs into a new database at path and closes it.
+func storeBlobs(t *testing.T, path string, key []byte, blobs map[uint64][]byte) {
+	t.Helper()
+	p, err := OpenOptions(path, Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.StoreTableBlobs(blobs); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// crashEnv names the database a child process writes to before it is
+// killed at the point named by SHARKDB_WAL_FAIL.
+const crashEnv = "PAGER2_CRASH_DB"
+
+// TestCrashChild is the write a crashed child makes; it is skipped when
+// the test binary runs normally.
+func TestCrashChild(t *testing.T) {
+	path := os.Getenv(crashEnv)
+	if path == "" {
+		t.Skip("only run as a crashing child")
+	}
+	p, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch os.Getenv("SHARKDB_WAL_FAIL") {
+	case "after_wal_store":
+		err = p.StoreTableBlob(1, []byte("new"))
+	case "after_wal_multi":
+		err = p.StoreTableBlobs(map[uint64][]byte{1: []byte("new"), 2: bytes.Repeat([]byte("x"), 3*PageSize)})
+	case "before_meta_flush":
+		err = p.StoreTableBlobsMeta(map[uint64][]byte{1: []byte("new"), 2: bytes.Repeat([]byte("x"), 3*PageSize)}, func(m *Meta) {
+			m.Tables["t"] = 1
+			m.Expiring = map[uint64]bool{1: true}
+		})
+	case "after_wal_delete":
+		err = p.DeleteTableBlob(1)
+	}
+	t.Fatalf("write returned (%v) instead of crashing", err)
+}
+
+func TestCrashReplay(t *testing.T) {
+	cases := []struct {
+		point string
+		want  map[uint64]string // blob id -> content after replay
+		meta  bool              // the write also marked table t expiring
+	}{
+		{"after_wal_store", map[uint64]string{1: "new"}, false},
+		{"after_wal_multi", map[uint64]string{1: "new", 2: string(bytes.Repeat([]byte("x"), 3*PageSize))}, false},
+		{"before_meta_flush", map[uint64]string{1: "new", 2: string(bytes.Repeat([]byte("x"), 3*PageSize))}, true},
+		{"after_wal_delete", map[uint64]string{1: ""}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.point, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "crash.db")
+			storeBlobs(t, path, nil, map[uint64][]byte{1: []byte("old")})
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestCrashChild$")
+			cmd.Env = append(os.Environ(), crashEnv+"="+path, "SHARKDB_WAL_FAIL="+c.point)
+			out, err := cmd.CombinedOutput()
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) || exit.ExitCode() != 2 {
+				t.Fatalf("child did not crash at %s: %v\n%s", c.point, err, out)
+			}
+
+			p, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			for id, want := range c.want {
+				got, err := p.ReadTableBlob(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Fatalf("blob %d after replay: got %.20q, want %.20q", id, got, want)
+				}
+			}
+			if m := p.Meta(); c.meta != (m.Tables["t"] == 1 && m.Expiring[1]) {
+				t.Fatalf("meta after replay: tables %v, expiring %v", m.Tables, m.Expiring)
+			}
+		})
+	}
+}
+
+func TestPointInTimeRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "live.db")
+	archive := filepath.Join(dir, "archive")
+	p, err := OpenOptions(path, Options{ArchiveDir: archive, SegmentBytes: 2 * PageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.StoreTableBlob(1, []byte("v0")); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := p.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if _, err := snap.WriteTo(&backup); err != nil {
+		t.Fatal(err)
+	}
+	snap.Close()
+
+	// Write v1..v5, remembering the LSN after each, with a page-sized
+	// blob alongside so several segments fill up and are archived.
+	lsns := make([]uint64, 6)
+	lsns[0] = p.Meta().LSN
+	for i := 1; i <= 5; i++ {
+		blobs := map[uint64][]byte{
+			1: []byte{'v', byte('0' + i)},
+			2: bytes.Repeat([]byte{byte(i)}, PageSize),
+		}
+		if err := p.StoreTableBlobs(blobs); err != nil {
+			t.Fatal(err)
+		}
+		lsns[i] = p.Meta().LSN
+	}
+	if err := p.SwitchWAL(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, lsn := range lsns {
+		out := filepath.Join(dir, "restored.db")
+		os.Remove(out)
+		_, rec, err := RestoreTo(bytes.NewReader(backup.Bytes()), out, archive, Target{LSN: lsn}, nil)
+		if err != nil {
+			t.Fatalf("restore to LSN %d: %v", lsn, err)
+		}
+		if rec.LSN != lsn {
+			t.Fatalf("restore to LSN %d stopped at %d", lsn, rec.LSN)
+		}
+		r, err := Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.ReadTableBlob(1)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []byte{'v', byte('0' + i)}; !bytes.Equal(got, want) {
+			t.Fatalf("restore to LSN %d: got %q, want %q", lsn, got, want)
+		}
+	}
+
+	out := filepath.Join(dir, "beyond.db")
+	_, _, err = RestoreTo(bytes.NewReader(backup.Bytes()), out, archive, Target{LSN: lsns[5] + 10}, nil)
+	if !errors.Is(err, ErrTargetNotReached) {
+		t.Fatalf("target past the archive: got %v, want ErrTargetNotReached", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal("failed restore left a file behind")
+	}
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -17,10 +18,26 @@ var (
 
 // Parse a very small command language:
 // CREATE <table>
+// CREATE [TABLE] <table> (<column> <type> [REQUIRED] [DEFAULT <v>], ...)
+// SCHEMA <table>
+// SCAN <table> [start] [limit] [WHERE <filter>] [PROJECT <path>, ...]
+// COUNT <table> [RANGE <a> <b>] | RANK <table> <key> | NTH <table> <i>
+// AGG <table> [PREFIX <p> | RANGE <a> <b>] COUNT|SUM|MIN|MAX|AVG [path] [GROUPBY PREFIXLEN <n>]
 // INSERT <table> <key> <value>
+// SETEX <table> <key> <seconds> <value>
 // GET <table> <key>
 // UPDATE <table> <key> <value>
 // DELETE <table> <key>
+// CAS <table> <key> <expected> <new>
+// SETNX <table> <key> <value>
+// INCR <table> <key> | DECR <table> <key> | INCRBY <table> <key> <n>
+// EXPIRE <table> <key> <seconds> | TTL <table> <key>
+// CREATE INDEX <name> ON <table> (<field>) | DROP INDEX <name>
+// FIND <table> WHERE <field> <op> <value>
+// DUMP <table> [file|STREAM] [FORMAT tsv|csv|jsonl] [HEADER] [KEY <column>]
+// LOAD <table> <file|STREAM> [FORMAT tsv|csv|jsonl] [HEADER] [KEY <column>] [ON ERROR skip|abort]
+// STATS <table> | COMPRESS <table> none|fast|best
+// BACKUP <path> | BACKUP STREAM
 // BEGIN [READONLY]
 // COMMIT
 // ABORT
@@ -37,6 +54,30 @@ func Parse(line string) (Command, error) {
 	args := fields[1:]
 	switch cmd {
 	case "CREATE":
+		if len(args) > 0 && strings.EqualFold(args[0], "INDEX") {
+			// CREATE INDEX <name> ON <table> (<field>)
+			if len(args) < 5 || !strings.EqualFold(args[2], "ON") {
+				return Command{}, fmt.Errorf("usage: CREATE INDEX <name> ON <table> (<field>)")
+			}
+			field := strings.Join(args[4:], "")
+			if !strings.HasPrefix(field, "(") || !strings.HasSuffix(field, ")") || len(field) < 3 {
+				return Command{}, fmt.Errorf("usage: CREATE INDEX <name> ON <table> (<field>)")
+			}
+			cmd, args = "CREATEINDEX", []string{args[1], args[3], field[1 : len(field)-1]}
+			break
+		}
+		if len(args) >= 2 && strings.EqualFold(args[0], "TABLE") {
+			args = args[1:]
+		}
+		// CREATE <table> (<columns>) gives the column list as a 2nd arg
+		if len(args) >= 2 {
+			def := strings.Join(args[1:], " ")
+			if !strings.HasPrefix(def, "(") || !strings.HasSuffix(def, ")") {
+				return Command{}, fmt.Errorf("CREATE expects a column list in parentheses")
+			}
+			args = []string{args[0], def[1 : len(def)-1]}
+			break
+		}
 		if len(args) != 1 {
 			return Command{}, fmt.Errorf("CREATE requires 1 arg")
 		}
@@ -46,6 +87,15 @@ func Parse(line string) (Command, error) {
 		}
 		// allow spaces in value by joining tail
 		args = []string{args[0], args[1], strings.Join(args[2:], " ")}
+	case "SETEX":
+		// The TTL comes before the value, so any value is taken literally.
+		if len(args) < 4 {
+			return Command{}, fmt.Errorf("SETEX requires 4 args")
+		}
+		if secs, err := strconv.ParseInt(args[2], 10, 64); err != nil || secs <= 0 {
+			return Command{}, fmt.Errorf("SETEX seconds must be a positive integer")
+		}
+		args = []string{args[0], args[1], args[2], strings.Join(args[3:], " ")}
 	case "GET":
 		if len(args) != 2 {
 			return Command{}, fmt.Errorf("GET requires 2 args")
@@ -55,15 +105,64 @@ func Parse(line string) (Command, error) {
 			return Command{}, fmt.Errorf("UPDATE requires 3 args")
 		}
 		args = []string{args[0], args[1], strings.Join(args[2:], " ")}
+	case "CAS":
+		// CAS <table> <key> <expected> <new...>; expected is a single token
+		if len(args) < 4 {
+			return Command{}, fmt.Errorf("CAS requires 4 args")
+		}
+		args = []string{args[0], args[1], args[2], strings.Join(args[3:], " ")}
+	case "SETNX":
+		if len(args) < 3 {
+			return Command{}, fmt.Errorf("SETNX requires 3 args")
+		}
+		args = []string{args[0], args[1], strings.Join(args[2:], " ")}
+	case "INCR", "DECR":
+		if len(args) != 2 {
+			return Command{}, fmt.Errorf("%s requires 2 args", cmd)
+		}
+	case "INCRBY":
+		if len(args) != 3 {
+			return Command{}, fmt.Errorf("INCRBY requires 3 args")
+		}
+		if _, err := strconv.ParseInt(args[2], 10, 64); err != nil {
+			return Command{}, fmt.Errorf("INCRBY increment must be an integer")
+		}
+	case "EXPIRE":
+		if len(args) != 3 {
+			return Command{}, fmt.Errorf("EXPIRE requires 3 args")
+		}
+		if secs, err := strconv.ParseInt(args[2], 10, 64); err != nil || secs <= 0 {
+			return Command{}, fmt.Errorf("EXPIRE seconds must be a positive integer")
+		}
+	case "TTL":
+		if len(args) != 2 {
+			return Command{}, fmt.Errorf("TTL requires 2 args")
+		}
 	case "DELETE":
 		// Allow either DELETE <table> <key> (row delete) or DELETE <table> (drop table shorthand)
 		if len(args) != 2 && len(args) != 1 {
 			return Command{}, fmt.Errorf("DELETE requires 1 or 2 args")
 		}
 	case "DROP":
+		if len(args) == 2 && strings.EqualFold(args[0], "INDEX") {
+			cmd, args = "DROPINDEX", args[1:]
+			break
+		}
 		if len(args) != 1 {
 			return Command{}, fmt.Errorf("DROP requires 1 arg")
 		}
+	case "FIND":
+		// FIND <table> WHERE <field> <op> <value...>
+		if len(args) < 5 || !strings.EqualFold(args[1], "WHERE") {
+			return Command{}, fmt.Errorf("usage: FIND <table> WHERE <field> <op> <value>")
+		}
+		args = []string{args[0], args[2], args[3], strings.Join(args[4:], " ")}
+	case "AGG":
+		a, err := parseAgg(args)
+		if err != nil {
+			return Command{}, err
+		}
+		args = a
 	case "BEGIN":
 		if len(args) > 1 {
 			return Command{}, fmt.Errorf("BEGIN takes optional READONLY")
@@ -80,7 +179,20 @@ func Parse(line string) (Command, error) {
 			return Command{}, fmt.Errorf("TABLES takes no args")
 		}
 	case "SCAN":
-		// SCAN <table> [startKey] [limit]
+		// SCAN <table> [startKey] [limit] [WHERE <filter>] [PROJECT <fields>]
+		// becomes SCANQUERY <table> <start> <limit> <filter> <fields>
+		if pos, where, project, ok := splitClauses(args); ok {
+			if pos < 1 || pos > 3 {
+				return Command{}, fmt.Errorf("SCAN requires 1..3 args before WHERE/PROJECT")
+			}
+			if where == "" && project == "" {
+				return Command{}, fmt.Errorf("SCAN WHERE/PROJECT needs an expression")
+			}
+			q := []string{args[0], "", "", where, project}
+			copy(q[1:3], args[1:pos])
+			cmd, args = "SCANQUERY", q
+			break
+		}
 		if len(args) < 1 || len(args) > 3 {
 			return Command{}, fmt.Errorf("SCAN requires 1..3 args")
 		}
@@ -90,18 +202,47 @@ func Parse(line string) (Command, error) {
 			return Command{}, fmt.Errorf("PREFIXSCAN requires 2..3 args")
 		}
 	case "COUNT":
+		// COUNT <table> [RANGE <a> <b>]
+		if len(args) == 4 && strings.EqualFold(args[1], "RANGE") {
+			args = []string{args[0], args[2], args[3]}
+			break
+		}
 		if len(args) != 1 {
-			return Command{}, fmt.Errorf("COUNT requires 1 arg")
+			return Command{}, fmt.Errorf("COUNT requires 1 arg or <table> RANGE <a> <b>")
 		}
-	case "DUMP":
-		// DUMP <table> [filepath]
-		if len(args) != 1 && len(args) != 2 {
-			return Command{}, fmt.Errorf("DUMP requires 1 or 2 args")
+	case "RANK":
+		if len(args) != 2 {
+			return Command{}, fmt.Errorf("RANK requires 2 args")
 		}
-	case "LOAD":
-		// LOAD <table> <filepath> (TSV: key\tvalue per line)
+	case "NTH":
 		if len(args) != 2 {
-			return Command{}, fmt.Errorf("LOAD requires 2 args")
+			return Command{}, fmt.Errorf("NTH requires 2 args")
+		}
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return Command{}, fmt.Errorf("NTH position must be an integer")
+		}
+	case "DUMP", "LOAD":
+		// <cmd> <table> STREAM ... becomes DUMPSTREAM or LOADSTREAM with
+		// the same arguments and an empty file.
+		stream := len(args) > 1 && strings.EqualFold(args[1], "STREAM")
+		if stream {
+			args = append([]string{args[0]}, args[2:]...)
+		}
+		a, err := parseTransfer(cmd, args, stream)
+		if err != nil {
+			return Command{}, err
+		}
+		args = a
+		if stream {
+			cmd += "STREAM"
+		}
+	case "BACKUP":
+		if len(args) != 1 {
+			return Command{}, fmt.Errorf("usage: BACKUP <path> | BACKUP STREAM")
+		}
+		// BACKUP STREAM sends the backup back over the connection.
+		if strings.EqualFold(args[0], "STREAM") {
+			cmd, args = "BACKUPSTREAM", nil
 		}
 	case "EXISTS":
 		if len(args) != 2 {
@@ -115,10 +256,19 @@ func Parse(line string) (Command, error) {
 		if len(args) != 1 {
 			return Command{}, fmt.Errorf("TRUNCATE requires 1 arg")
 		}
+	case "SCHEMA":
+		if len(args) != 1 {
+			return Command{}, fmt.Errorf("SCHEMA requires 1 arg")
+		}
 	case "STATS":
 		if len(args) != 1 {
 			return Command{}, fmt.Errorf("STATS requires 1 arg")
 		}
+	case "COMPRESS":
+		if len(args) != 2 {
+			return Command{}, fmt.Errorf("usage: COMPRESS <table> none|fast|best")
+		}
+		args[1] = strings.ToLower(args[1])
 	case "HELP", "EXIT", "QUIT":
 		if len(args) != 0 {
 			return Command{}, fmt.Errorf("%s takes no args", cmd)
@@ -133,6 +283,139 @@ func Parse(line string) (Command, error) {
 	return Command{Name: cmd, Args: args}, nil
 }
 
+// parseAgg normalizes AGG arguments to
+// <table> <PREFIX|RANGE|""> <a> <b> <func> <path> <groupLen>.
+func parseAgg(args []string) ([]string, error) {
+	usage := fmt.Errorf("usage: AGG <table> [PREFIX <p> | RANGE <a> <b>] COUNT|SUM|MIN|MAX|AVG [path] [GROUPBY PREFIXLEN <n>]")
+	if len(args) < 2 {
+		return nil, usage
+	}
+	out := []string{args[0], "", "", "", "", "", ""}
+	rest := args[1:]
+	switch {
+	case strings.EqualFold(rest[0], "PREFIX"):
+		if len(rest) < 2 {
+			return nil, usage
+		}
+		out[1], out[2], rest = "PREFIX", rest[1], rest[2:]
+	case strings.EqualFold(rest[0], "RANGE"):
+		if len(rest) < 3 {
+			return nil, usage
+		}
+		out[1], out[2], out[3], rest = "RANGE", rest[1], rest[2], rest[3:]
+	}
+	if len(rest) == 0 {
+		return nil, usage
+	}
+	fn := strings.ToUpper(rest[0])
+	switch fn {
+	case "COUNT", "SUM", "MIN", "MAX", "AVG":
+	default:
+		return nil, fmt.Errorf("AGG: unknown aggregate %s", rest[0])
+	}
+	out[4], rest = fn, rest[1:]
+	if len(rest) > 0 && !strings.EqualFold(rest[0], "GROUPBY") {
+		out[5], rest = rest[0], rest[1:]
+	}
+	if out[5] == "" && fn != "COUNT" {
+		return nil, fmt.Errorf("AGG: %s requires a JSON path", fn)
+	}
+	if len(rest) > 0 {
+		if len(rest) != 3 || !strings.EqualFold(rest[0], "GROUPBY") || !strings.EqualFold(rest[1], "PREFIXLEN") {
+			return nil, usage
+		}
+		if n, err := strconv.Atoi(rest[2]); err != nil || n <= 0 {
+			return nil, fmt.Errorf("AGG: PREFIXLEN must be a positive integer")
+		}
+		out[6] = rest[2]
+	}
+	return out, nil
+}
+
+// parseTransfer normalizes
+//
+//	DUMP <table> [file|STREAM] [FORMAT tsv|csv|jsonl] [HEADER] [KEY <column>]
+//	LOAD <table> <file|STREAM> [FORMAT tsv|csv|jsonl] [HEADER] [KEY <column>] [ON ERROR skip|abort]
+//
+// to table, file, format, HEADER or "", key column and, for LOAD, the error
+// policy. An empty file means DUMP prints the rows; streams have no file.
+func parseTransfer(cmd string, args []string, stream bool) ([]string, error) {
+	usage := fmt.Errorf("usage: DUMP <table> [file|STREAM] [FORMAT tsv|csv|jsonl] [HEADER] [KEY <column>]")
+	out := []string{"", "", "tsv", "", ""}
+	if cmd == "LOAD" {
+		usage = fmt.Errorf("usage: LOAD <table> <file|STREAM> [FORMAT tsv|csv|jsonl] [HEADER] [KEY <column>] [ON ERROR skip|abort]")
+		out = append(out, "abort")
+	}
+	if len(args) == 0 {
+		return nil, usage
+	}
+	out[0], args = args[0], args[1:]
+	option := func(a string) bool {
+		switch strings.ToUpper(a) {
+		case "FORMAT", "HEADER", "KEY", "ON":
+			return true
+		}
+		return false
+	}
+	if len(args) > 0 && !option(args[0]) && !stream {
+		out[1], args = args[0], args[1:]
+	}
+	if cmd == "LOAD" && out[1] == "" && !stream {
+		return nil, usage
+	}
+	for len(args) > 0 {
+		switch kw := strings.ToUpper(args[0]); {
+		case kw == "FORMAT" && len(args) >= 2:
+			f := strings.ToLower(args[1])
+			if f != "tsv" && f != "csv" && f != "jsonl" {
+				return nil, fmt.Errorf("%s: unknown format %s", cmd, args[1])
+			}
+			out[2], args = f, args[2:]
+		case kw == "HEADER":
+			out[3], args = "HEADER", args[1:]
+		case kw == "KEY" && len(args) >= 2:
+			out[4], args = args[1], args[2:]
+		case kw == "ON" && cmd == "LOAD" && len(args) >= 3 && strings.EqualFold(args[1], "ERROR"):
+			p := strings.ToLower(args[2])
+			if p != "skip" && p != "abort" {
+				return nil, fmt.Errorf("LOAD: ON ERROR takes skip or abort")
+			}
+			out[5], args = p, args[3:]
+		default:
+			return nil, usage
+		}
+	}
+	return out, nil
+}
+
+// splitClauses finds trailing WHERE and PROJECT clauses, in either order.
+// It returns the number of arguments before them and the clause texts.
+func splitClauses(args []string) (pos int, where, project string, ok bool) {
+	pos = len(args)
+	cur := ""
+	var w, p []string
+	for i, a := range args {
+		switch {
+		case strings.EqualFold(a, "WHERE") && cur != "WHERE":
+			cur = "WHERE"
+		case strings.EqualFold(a, "PROJECT") && cur != "PROJECT":
+			cur = "PROJECT"
+		case cur == "WHERE":
+			w = append(w, a)
+			continue
+		case cur == "PROJECT":
+			p = append(p, a)
+			continue
+		default:
+			continue
+		}
+		if !ok {
+			pos, ok = i, true
+		}
+	}
+	return pos, strings.Join(w, " "), strings.Join(p, " "), ok
+}
+
 func splitFields(s string) []string {
 	// simple whitespace split respecting double quotes for the value is overkill here
 	// We'll just split by spaces and re-join for value in Parse above.
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTTL(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want Command
+		err  string
+	}{
+		{"INSERT t k v", Command{"INSERT", []string{"t", "k", "v"}}, ""},
+		// INSERT takes its whole tail as the value, TTL words included.
+		{"INSERT t k retry after TTL 5", Command{"INSERT", []string{"t", "k", "retry after TTL 5"}}, ""},
+		{"insert t k v TTL 0", Command{"INSERT", []string{"t", "k", "v TTL 0"}}, ""},
+		{"SETEX t k 60 v", Command{"SETEX", []string{"t", "k", "60", "v"}}, ""},
+		{"setex t k 5 a TTL 5", Command{"SETEX", []string{"t", "k", "5", "a TTL 5"}}, ""},
+		{"SETEX t k 0 v", Command{}, "positive"},
+		{"SETEX t k -3 v", Command{}, "positive"},
+		{"SETEX t k soon v", Command{}, "positive"},
+		{"SETEX t k 5", Command{}, "requires 4 args"},
+		{"EXPIRE t k 10", Command{"EXPIRE", []string{"t", "k", "10"}}, ""},
+		{"EXPIRE t k x", Command{}, "positive integer"},
+		{"EXPIRE t k 0", Command{}, "positive integer"},
+		{"EXPIRE t k -1", Command{}, "positive integer"},
+	} {
+		got, err := Parse(tc.line)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Parse(%q): got %v, want an error containing %q", tc.line, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want Command
+		err  bool
+	}{
+		{"CREATE INDEX by_age ON users (age)", Command{"CREATEINDEX", []string{"by_age", "users", "age"}}, false},
+		{"create index by_city on users ( $.address.city )", Command{"CREATEINDEX", []string{"by_city", "users", "$.address.city"}}, false},
+		{"CREATE INDEX by_age ON users age", Command{}, true},
+		{"CREATE INDEX by_age users (age)", Command{}, true},
+		{"CREATE INDEX by_age ON users ()", Command{}, true},
+		{"DROP INDEX by_age", Command{"DROPINDEX", []string{"by_age"}}, false},
+		{"FIND users WHERE age >= 26", Command{"FIND", []string{"users", "age", ">=", "26"}}, false},
+		{"FIND users WHERE city = New York", Command{"FIND", []string{"users", "city", "=", "New York"}}, false},
+		{"FIND users age = 26", Command{}, true},
+		{"FIND users WHERE age =", Command{}, true},
+	} {
+		got, err := Parse(tc.line)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", tc.line, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", tc.line, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseScanQuery(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want Command
+		err  bool
+	}{
+		{"SCAN users", Command{"SCAN", []string{"users"}}, false},
+		{"SCAN users WHERE $.age > 25", Command{"SCANQUERY", []string{"users", "", "", "$.age > 25", ""}}, false},
+		{"SCAN users a 10 WHERE $.age > 25 PROJECT $.name, $.email", Command{"SCANQUERY", []string{"users", "a", "10", "$.age > 25", "$.name, $.email"}}, false},
+		{"scan users project name where age >= 3", Command{"SCANQUERY", []string{"users", "", "", "age >= 3", "name"}}, false},
+		{"SCAN users WHERE", Command{}, true},
+		{"SCAN WHERE $.age > 1", Command{}, true},
+		{"SCAN users a 10 x WHERE $.age > 1", Command{}, true},
+	} {
+		got, err := Parse(tc.line)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", tc.line, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", tc.line, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseAgg(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+		err  string
+	}{
+		{"AGG t COUNT", []string{"t", "", "", "", "COUNT", "", ""}, ""},
+		{"agg t sum $.n", []string{"t", "", "", "", "SUM", "$.n", ""}, ""},
+		{"AGG t PREFIX eu AVG n GROUPBY PREFIXLEN 4", []string{"t", "PREFIX", "eu", "", "AVG", "n", "4"}, ""},
+		{"AGG t RANGE a b MAX n", []string{"t", "RANGE", "a", "b", "MAX", "n", ""}, ""},
+		{"AGG t COUNT GROUPBY PREFIXLEN 2", []string{"t", "", "", "", "COUNT", "", "2"}, ""},
+		{"AGG t", nil, "usage"},
+		{"AGG t PREFIX", nil, "usage"},
+		{"AGG t RANGE a", nil, "usage"},
+		{"AGG t MEDIAN n", nil, "unknown aggregate"},
+		{"AGG t SUM", nil, "requires a JSON path"},
+		{"AGG t COUNT GROUPBY PREFIXLEN 0", nil, "positive integer"},
+		{"AGG t COUNT GROUPBY 2", nil, "usage"},
+	} {
+		got, err := Parse(tc.line)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Parse(%q): got %v, want an error containing %q", tc.line, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got.Name != "AGG" || !reflect.DeepEqual(got.Args, tc.want) {
+			t.Errorf("Parse(%q) = %+v, %v; want AGG %q", tc.line, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package salvage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"sharkDB/internal/bptree"
+	"sharkDB/internal/catalog"
+	"sharkDB/internal/compress"
+	"sharkDB/internal/engine"
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/schema"
+	"sharkDB/internal/storage"
+)
+
+// Salvage copies whatever can still be read from a damaged database into a
+// new one. It trusts nothing the checker would flag:
+//
+//   - The catalog comes from the meta page, updated by the meta records in
+//     the WAL that the file lacks. If page 0 does not decode, the newest
+//     meta record in the WAL is used instead; failing that, every page
+//     chain that decodes as a tree is kept as a table named after its first
+//     page.
+//   - Each table's tree comes from its page chain, or from the WAL when the
+//     WAL holds a newer image. When the chain is broken or does not decode,
+//     the newest image the WAL holds, even an older one, is used.
+//   - Keys are collected from every leaf of the tree whatever its internal
+//     nodes say, and rows a schema'd table cannot decode are dropped.
+//
+// The new database is built from scratch through the catalog, so its page
+// chains, free list and tree shapes are consistent; indexes are rebuilt from
+// the salvaged rows rather than copied.
+
+// Table reports the salvage of one table.
+type Table struct {
+	Name    string
+	Source  string // where its tree came from; "" if it was lost
+	Keys    int
+	Dropped int // rows dropped because they did not decode
+}
+
+// Report is the result of a salvage.
+type Report struct {
+	Tables  []Table
+	Indexes []string // indexes rebuilt
+	Notes   []string // problems met along the way
+}
+
+func (r *Report) note(format string, args ...any) {
+	r.Notes = append(r.Notes, fmt.Sprintf(format, args...))
+}
+
+// Lost returns the number of tables nothing could be saved from.
+func (r *Report) Lost() int {
+	n := 0
+	for _, t := range r.Tables {
+		if t.Source == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// image is a candidate tree for a blob.
+type image struct {
+	data   []byte
+	source string
+}
+
+// Run salvages the database at path into a new database at out, which must
+// not exist yet. The key of an encrypted database is used for out too. The
+// error is non-nil only if nothing could be attempted; what was lost is in
+// the Report.
+func Run(path, out string, key []byte) (*Report, error) {
+	if _, err := os.Stat(out); err == nil {
+		return nil, fmt.Errorf("%s already exists", out)
+	}
+	rf, err := pager2.OpenRaw(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rf.Close()
+	r := &Report{}
+
+	m, err := rf.Meta()
+	metaOK := err == nil
+	headsKnown := metaOK // the meta page lists every chain
+	if !metaOK {
+		r.note("meta page: %v", err)
+		m = pager2.Meta{}
+	}
+	recs, rest, err := rf.WAL()
+	if err != nil {
+		r.note("WAL: %v", err)
+	}
+	if rest > 0 {
+		r.note("WAL: the last %d bytes are torn or unreadable and were ignored", rest)
+	}
+
+	// current holds the tree each blob has in the file as updated by the
+	// WAL; older holds the newest WAL image of blobs, for broken chains.
+	current := make(map[uint64]image)
+	older := make(map[uint64]image)
+	if metaOK {
+		for id, head := range m.TableHead {
+			data, err := readChain(rf, head)
+			if err != nil {
+				r.note("%s: %v", blobName(m, id), err)
+				continue
+			}
+			current[id] = image{data, "file"}
+		}
+	}
+	newer := func(rec pager2.WALRecord) bool {
+		return !metaOK || rec.LSN == 0 || rec.LSN > m.LSN
+	}
+	for _, rec := range recs {
+		src := fmt.Sprintf("WAL LSN %d", rec.LSN)
+		if rec.LSN == 0 {
+			src = "WAL tail"
+		}
+		if !newer(rec) {
+			for id, data := range rec.Blobs {
+				older[id] = image{data, src}
+			}
+			continue
+		}
+		for id, data := range rec.Blobs {
+			current[id] = image{data, src}
+		}
+		if rec.Delete != 0 {
+			delete(current, rec.Delete)
+		}
+		if rec.Meta != nil {
+			m.Tables, m.NextTableID = rec.Meta.Tables, rec.Meta.NextTableID
+			m.Indexes, m.Schemas = rec.Meta.Indexes, rec.Meta.Schemas
+			m.Compression = rec.Meta.Compression
+			metaOK = true
+		}
+	}
+
+	var tables []found
+	if metaOK {
+		names := make([]string, 0, len(m.Tables))
+		for name := range m.Tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			id := m.Tables[name]
+			f := found{name: name, schema: m.Schemas[id], compression: m.Compression[id]}
+			f.tree, f.source = pickTree(r, name, current[id], older[id])
+			if f.tree == nil && current[id].data == nil && older[id].data == nil {
+				if _, ok := m.TableHead[id]; headsKnown && !ok {
+					f.tree, f.source = bptree.New(), "empty table" // it never had rows
+				}
+			}
+			tables = append(tables, f)
+		}
+	} else {
+		r.note("no catalog survives; keeping every page chain that decodes as a tree")
+		for _, c := range scanChains(rf) {
+			name := fmt.Sprintf("recovered_page%d", c.head)
+			t, err := decodeTree(c.data)
+			if err != nil {
+				continue
+			}
+			tables = append(tables, found{name: name, tree: t, source: fmt.Sprintf("chain at page %d", c.head)})
+		}
+	}
+
+	p, err := pager2.OpenOptions(out, pager2.Options{Key: key})
+	if err != nil {
+		return r, err
+	}
+	if err := build(r, p, tables, m); err != nil {
+		p.Close()
+		os.Remove(out)
+		os.Remove(out + ".wal")
+		return r, err
+	}
+	// Every write is in the file; leave no WAL behind.
+	if err := p.SwitchWAL(); err != nil {
+		p.Close()
+		return r, err
+	}
+	return r, p.Close()
+}
+
+// found is a table to write to the new database.
+type found struct {
+	name, schema string
+	compression  string
+	tree         *bptree.BPTree
+	source       string
+}
+
+// build writes the salvaged tables to p and rebuilds the indexes of m on
+// them.
+func build(r *Report, p *pager2.Pager, tables []found, m pager2.Meta) error {
+	cat := catalog.New(storage.Pager2(p))
+	for _, f := range tables {
+		t := Table{Name: f.name}
+		if f.tree != nil {
+			tree, kept, dropped, err := rebuild(f.tree, f.schema)
+			if err != nil {
+				r.note("table %s: %v", f.name, err)
+			}
+			if err == nil {
+				if err := cat.CreateTableSchema(f.name, f.schema); err != nil {
+					return err
+				}
+				id, _ := cat.GetTableID(f.name)
+				if method, err := compress.Parse(f.compression); err != nil {
+					r.note("table %s: %v; storing it uncompressed", f.name, err)
+				} else if err := cat.SetTableCompression(id, method); err != nil {
+					return err
+				}
+				if err := cat.StoreTree(id, tree); err != nil {
+					return err
+				}
+				t.Source, t.Keys, t.Dropped = f.source, kept, dropped
+			}
+		}
+		r.Tables = append(r.Tables, t)
+	}
+
+	eng := engine.New(storage.Pager2(p))
+	ixNames := make([]string, 0, len(m.Indexes))
+	for name := range m.Indexes {
+		ixNames = append(ixNames, name)
+	}
+	sort.Strings(ixNames)
+	for _, name := range ixNames {
+		ix := m.Indexes[name]
+		table := ""
+		for tn, id := range m.Tables {
+			if id == ix.TableID {
+				table = tn
+			}
+		}
+		if _, ok := cat.GetTableID(table); !ok {
+			r.note("index %s: its table was lost", name)
+			continue
+		}
+		if _, err := eng.CreateIndex(name, table, ix.Field); err != nil {
+			r.note("index %s: %v", name, err)
+			continue
+		}
+		r.Indexes = append(r.Indexes, name)
+	}
+	return nil
+}
+
+// blobName names the table or index stored under blob id.
+func blobName(m pager2.Meta, id uint64) string {
+	for name, tid := range m.Tables {
+		if tid == id {
+			return "table " + name
+		}
+	}
+	for name, ix := range m.Indexes {
+		if ix.TreeID == id {
+			return "index " + name
+		}
+	}
+	return fmt.Sprintf("blob %d", id)
+}
+
+// pickTree decodes the first usable candidate: the current image, then the
+// older WAL image.
+func pickTree(r *Report, name string, cands ...image) (*bptree.BPTree, string) {
+	for _, c := range cands {
+		if c.data == nil {
+			continue
+		}
+		t, err := decodeTree(c.data)
+		if err != nil {
+			r.note("table %s: tree from %s does not decode: %v", name, c.source, err)
+			continue
+		}
+		if err := t.Validate(); err != nil {
+			r.note("table %s: tree from %s is damaged, keeping the keys in its leaves: %v", name, c.source, err)
+		}
+		return t, c.source
+	}
+	return nil, ""
+}
+
+func decodeTree(data []byte) (*bptree.BPTree, error) {
+	data, err := compress.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	var t bptree.BPTree
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// rebuild collects the entries in every leaf of t, drops rows that do not
+// decode with the table's schema and builds a fresh tree from the rest.
+// Where a key turns up in more than one leaf the highest version wins.
+func rebuild(t *bptree.BPTree, def string) (*bptree.BPTree, int, int, error) {
+	var s *schema.Schema
+	if def != "" {
+		var err error
+		if s, err = schema.Parse(def); err != nil {
+			return nil, 0, 0, fmt.Errorf("schema %q: %w", def, err)
+		}
+	}
+	entries := make(map[string]bptree.Entry)
+	dropped := 0
+	var walk func(n *bptree.Node, depth int)
+	walk = func(n *bptree.Node, depth int) {
+		if n == nil || depth > 64 {
+			return
+		}
+		if !n.IsLeaf {
+			for _, c := range n.Children {
+				walk(c, depth+1)
+			}
+			return
+		}
+		for i, k := range n.Keys {
+			if i >= len(n.Values) {
+				dropped++
+				continue
+			}
+			e := bptree.Entry{Value: n.Values[i]}
+			if i < len(n.Versions) {
+				e.Version = n.Versions[i]
+			}
+			if i < len(n.Expires) {
+				e.ExpiresAt = n.Expires[i]
+			}
+			if s != nil {
+				if _, err := s.Decode(e.Value); err != nil {
+					dropped++
+					continue
+				}
+			}
+			if old, ok := entries[k]; ok && old.Version >= e.Version {
+				continue
+			}
+			entries[k] = e
+		}
+	}
+	walk(t.Root, 0)
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := bptree.NewBuilder()
+	b.SetSeq(t.Seq)
+	for _, k := range keys {
+		e := entries[k]
+		if err := b.Add(k, e); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return b.Finish(), len(keys), dropped, nil
+}
+
+// readChain reads a blob's page chain, failing on anything the checker
+// would report.
+func readChain(rf *pager2.RawFile, head uint64) ([]byte, error) {
+	var data []byte
+	seen := make(map[uint64]bool)
+	for pid := head; pid != 0; {
+		if seen[pid] {
+			return nil, fmt.Errorf("page chain loops at page %d", pid)
+		}
+		seen[pid] = true
+		page, err := rf.Page(pid)
+		if err != nil {
+			return nil, err
+		}
+		next, d, ok := pager2.ChainPage(page)
+		if !ok {
+			return nil, fmt.Errorf("page %d states a data length that does not fit in a page", pid)
+		}
+		data = append(data, d...)
+		pid = next
+	}
+	return data, nil
+}
+
+type chain struct {
+	head uint64
+	data []byte
+}
+
+// scanChains finds the page chains of the file without the meta: pages no
+// other page links to start a chain, and chains that cannot be read whole
+// are skipped.
+func scanChains(rf *pager2.RawFile) []chain {
+	linked := make(map[uint64]bool)
+	for pid := uint64(1); pid < rf.Pages; pid++ {
+		if page, err := rf.Page(pid); err == nil {
+			if next, _, ok := pager2.ChainPage(page); ok {
+				linked[next] = true
+			}
+		}
+	}
+	var out []chain
+	for pid := uint64(1); pid < rf.Pages; pid++ {
+		if linked[pid] {
+			continue
+		}
+		if data, err := readChain(rf, pid); err == nil && len(data) > 0 {
+			out = append(out, chain{pid, data})
+		}
+	}
+	return out
+}
+
+// Print writes the report for people.
+func (r *Report) Print(w io.Writer) {
+	for _, t := range r.Tables {
+		if t.Source == "" {
+			fmt.Fprintf(w, "table %s: LOST\n", t.Name)
+			continue
+		}
+		fmt.Fprintf(w, "table %s: %d keys from %s", t.Name, t.Keys, t.Source)
+		if t.Dropped > 0 {
+			fmt.Fprintf(w, " (%d undecodable rows dropped)", t.Dropped)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, name := range r.Indexes {
+		fmt.Fprintf(w, "index %s: rebuilt\n", name)
+	}
+	for _, n := range r.Notes {
+		fmt.Fprintln(w, "note:", n)
+	}
+}
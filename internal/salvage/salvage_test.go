@@ -0,0 +1,218 @@
+package salvage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sharkDB/internal/engine"
+	"sharkDB/internal/pager2"
+	"sharkDB/internal/storage"
+)
+
+const rows = 300
+
+// buildDB writes a database with a table a indexed on n and a table b, both
+// spanning several pages, and returns its path and the head page of each
+// table's chain. The WAL is kept, as it is after a crash.
+func buildDB(t *testing.T) (string, map[string]uint64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "src.db")
+	p, err := pager2.OpenOptions(path, pager2.Options{SegmentBytes: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := engine.New(storage.Pager2(p))
+	for _, table := range []string{"a", "b"} {
+		if _, err := e.Create(table); err != nil {
+			t.Fatal(err)
+		}
+		b := e.NewBatch()
+		for i := 0; i < rows; i++ {
+			if err := b.Put(table, fmt.Sprintf("key%04d", i), fmt.Sprintf(`{"n":%d,"pad":"%s"}`, i, strings.Repeat("v", 20))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.CreateIndex("a_n", "a", "n"); err != nil {
+		t.Fatal(err)
+	}
+	m := p.Meta()
+	heads := map[string]uint64{"a": m.TableHead[m.Tables["a"]], "b": m.TableHead[m.Tables["b"]]}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, heads
+}
+
+// overwrite writes data into the file at off.
+func overwrite(t *testing.T, path string, off int64, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(data, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openOut opens a salvaged database with the engine.
+func openOut(t *testing.T, out string) *engine.Engine {
+	t.Helper()
+	p, err := pager2.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return engine.New(storage.Pager2(p))
+}
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(t *testing.T, path string, heads map[string]uint64)
+		sources map[string]string // table -> prefix of its source; "" if lost
+		note    string            // a note that must be made
+	}{
+		{"clean", func(*testing.T, string, map[string]uint64) {},
+			map[string]string{"a": "file", "b": "file"}, ""},
+		{"truncated file", func(t *testing.T, path string, heads map[string]uint64) {
+			if err := os.Truncate(path, 2*pager2.PageSize); err != nil {
+				t.Fatal(err)
+			}
+		}, map[string]string{"a": "WAL LSN", "b": "WAL LSN"}, "table a"},
+		{"truncated file without WAL", func(t *testing.T, path string, heads map[string]uint64) {
+			if err := os.Truncate(path, 2*pager2.PageSize); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(path + ".wal"); err != nil {
+				t.Fatal(err)
+			}
+		}, map[string]string{"a": "", "b": ""}, "table a"},
+		{"looping chain", func(t *testing.T, path string, heads map[string]uint64) {
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], heads["a"])
+			overwrite(t, path, int64(heads["a"])*pager2.PageSize, buf[:])
+		}, map[string]string{"a": "WAL LSN", "b": "file"}, "page chain loops"},
+		{"meta page destroyed", func(t *testing.T, path string, heads map[string]uint64) {
+			overwrite(t, path, 16, bytes.Repeat([]byte{0xff}, 256))
+		}, map[string]string{"a": "WAL", "b": "WAL"}, "meta page"},
+		{"torn WAL tail", func(t *testing.T, path string, heads map[string]uint64) {
+			f, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.Write([]byte("torn record")); err != nil {
+				t.Fatal(err)
+			}
+		}, map[string]string{"a": "file", "b": "file"}, "torn"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, heads := buildDB(t)
+			tc.corrupt(t, path, heads)
+			out := filepath.Join(t.TempDir(), "out.db")
+			r, err := Run(path, out, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report bytes.Buffer
+			r.Print(&report)
+			lost := 0
+			for _, tab := range r.Tables {
+				want, ok := tc.sources[tab.Name]
+				if !ok {
+					t.Fatalf("unexpected table %s:\n%s", tab.Name, report.String())
+				}
+				if want == "" {
+					lost++
+				}
+				if !strings.HasPrefix(tab.Source, want) || (want == "") != (tab.Source == "") {
+					t.Fatalf("table %s from %q, want %q:\n%s", tab.Name, tab.Source, want, report.String())
+				}
+				if want != "" && (tab.Keys != rows || tab.Dropped != 0) {
+					t.Fatalf("table %s: %d keys, %d dropped", tab.Name, tab.Keys, tab.Dropped)
+				}
+			}
+			if len(r.Tables) != len(tc.sources) || r.Lost() != lost {
+				t.Fatalf("%d tables, %d lost:\n%s", len(r.Tables), r.Lost(), report.String())
+			}
+			if tc.note != "" && !strings.Contains(strings.Join(r.Notes, "\n"), tc.note) {
+				t.Fatalf("no note mentions %q:\n%s", tc.note, report.String())
+			}
+			if fi, err := os.Stat(out + ".wal"); err == nil && fi.Size() != 0 {
+				t.Fatalf("salvage left a %d byte WAL", fi.Size())
+			}
+
+			e := openOut(t, out)
+			if tc.sources["a"] == "" {
+				return
+			}
+			if len(r.Indexes) != 1 || r.Indexes[0] != "a_n" {
+				t.Fatalf("indexes rebuilt = %v", r.Indexes)
+			}
+			got, err := e.Find("a", "n", ">=", "295")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 5 {
+				t.Fatalf("find on the rebuilt index = %v", got)
+			}
+			if v, err := e.Get("b", "key0007"); err != nil || !strings.Contains(v, `"n":7`) {
+				t.Fatalf("b/key0007 = %q, %v", v, err)
+			}
+		})
+	}
+}
+
+// Without a catalog or WAL every chain that decodes as a tree is kept.
+func TestRunWithoutCatalog(t *testing.T) {
+	path, _ := buildDB(t)
+	overwrite(t, path, 16, bytes.Repeat([]byte{0xff}, 256))
+	if err := os.Remove(path + ".wal"); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.db")
+	r, err := Run(path, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := 0
+	for _, tab := range r.Tables {
+		if !strings.HasPrefix(tab.Name, "recovered_page") {
+			t.Fatalf("table %s", tab.Name)
+		}
+		keys += tab.Keys
+	}
+	// Both tables and the index tree are found.
+	if len(r.Tables) != 3 || keys != 3*rows || r.Lost() != 0 {
+		var buf bytes.Buffer
+		r.Print(&buf)
+		t.Fatalf("recovered %d tables, %d keys:\n%s", len(r.Tables), keys, buf.String())
+	}
+	openOut(t, out)
+}
+
+func TestRunOutputExists(t *testing.T) {
+	path, _ := buildDB(t)
+	out := filepath.Join(t.TempDir(), "out.db")
+	if err := os.WriteFile(out, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(path, out, nil); err == nil {
+		t.Fatal("salvage over an existing file succeeded")
+	}
+	if got, _ := os.ReadFile(out); string(got) != "keep" {
+		t.Fatalf("existing file now holds %q", got)
+	}
+}
@@ -0,0 +1,436 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A schema lists the typed columns of a table. Rows are written as JSON
+// objects, validated against the schema and stored in a compact binary
+// form:
+//
+//	version byte (1)
+//	uvarint column count
+//	presence bitmap, one bit per column
+//	the present values in column order
+//
+// Values are encoded as zigzag varints (int), big-endian IEEE 754 bits
+// (float), a 0/1 byte (bool), varint Unix nanoseconds (timestamp) and
+// uvarint-length-prefixed bytes (string, bytes). Reads decode rows back to
+// JSON objects with the columns in schema order.
+
+var (
+	ErrInvalid   = errors.New("invalid row")
+	ErrBadSchema = errors.New("invalid schema")
+)
+
+const rowVersion = 1
+
+type Type uint8
+
+const (
+	Int Type = iota + 1
+	Float
+	String
+	Bool
+	Bytes
+	Timestamp
+)
+
+var typeNames = map[Type]string{
+	Int:       "int",
+	Float:     "float",
+	String:    "string",
+	Bool:      "bool",
+	Bytes:     "bytes",
+	Timestamp: "timestamp",
+}
+
+func (t Type) String() string { return typeNames[t] }
+
+func parseType(s string) (Type, bool) {
+	for t, name := range typeNames {
+		if strings.EqualFold(s, name) {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Column is one typed column. Default holds the value used when a row
+// omits the column, in the same Go representation as decoded values.
+type Column struct {
+	Name       string
+	Type       Type
+	Required   bool
+	HasDefault bool
+	Default    any
+}
+
+type Schema struct {
+	Columns []Column
+}
+
+// Parse reads a column list such as
+//
+//	name string REQUIRED, age int DEFAULT 0, joined timestamp
+//
+// with or without the surrounding parentheses.
+func Parse(def string) (*Schema, error) {
+	def = strings.TrimSpace(def)
+	if strings.HasPrefix(def, "(") && strings.HasSuffix(def, ")") {
+		def = def[1 : len(def)-1]
+	}
+	s := &Schema{}
+	seen := make(map[string]bool)
+	for _, part := range splitColumns(def) {
+		toks := tokenize(part)
+		if len(toks) < 2 {
+			return nil, fmt.Errorf("%w: column %q needs a name and a type", ErrBadSchema, strings.TrimSpace(part))
+		}
+		c := Column{Name: toks[0]}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("%w: duplicate column %s", ErrBadSchema, c.Name)
+		}
+		seen[c.Name] = true
+		t, ok := parseType(toks[1])
+		if !ok {
+			return nil, fmt.Errorf("%w: column %s has unknown type %s", ErrBadSchema, c.Name, toks[1])
+		}
+		c.Type = t
+		for i := 2; i < len(toks); i++ {
+			switch strings.ToUpper(toks[i]) {
+			case "REQUIRED", "NOT_NULL":
+				c.Required = true
+			case "DEFAULT":
+				if i+1 >= len(toks) {
+					return nil, fmt.Errorf("%w: column %s: DEFAULT needs a value", ErrBadSchema, c.Name)
+				}
+				i++
+				var raw any
+				if err := json.Unmarshal([]byte(toks[i]), &raw); err != nil {
+					raw = toks[i] // bare word, e.g. DEFAULT guest
+				}
+				v, err := convert(c, jsonValue(toks[i], raw))
+				if err != nil {
+					return nil, fmt.Errorf("%w: column %s: bad default: %v", ErrBadSchema, c.Name, err)
+				}
+				c.HasDefault, c.Default = true, v
+			default:
+				return nil, fmt.Errorf("%w: column %s: unexpected %q", ErrBadSchema, c.Name, toks[i])
+			}
+		}
+		s.Columns = append(s.Columns, c)
+	}
+	if len(s.Columns) == 0 {
+		return nil, fmt.Errorf("%w: no columns", ErrBadSchema)
+	}
+	return s, nil
+}
+
+// jsonValue keeps integers exact by handing them to convert as json.Number.
+func jsonValue(tok string, raw any) any {
+	if _, ok := raw.(float64); ok {
+		return json.Number(tok)
+	}
+	return raw
+}
+
+// String returns the canonical column list, which Parse accepts.
+func (s *Schema) String() string {
+	parts := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		p := c.Name + " " + c.Type.String()
+		if c.Required {
+			p += " REQUIRED"
+		}
+		if c.HasDefault {
+			b, _ := json.Marshal(toJSON(c, c.Default))
+			p += " DEFAULT " + string(b)
+		}
+		parts[i] = p
+	}
+	return strings.Join(parts, ", ")
+}
+
+// splitColumns splits on commas outside double quotes.
+func splitColumns(def string) []string {
+	var parts []string
+	inQuote, esc := false, false
+	start := 0
+	for i := 0; i < len(def); i++ {
+		switch ch := def[i]; {
+		case esc:
+			esc = false
+		case ch == '\\' && inQuote:
+			esc = true
+		case ch == '"':
+			inQuote = !inQuote
+		case ch == ',' && !inQuote:
+			parts = append(parts, def[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(def[start:]) != "" || len(parts) > 0 {
+		parts = append(parts, def[start:])
+	}
+	return parts
+}
+
+// tokenize splits on whitespace, keeping double-quoted strings whole.
+func tokenize(s string) []string {
+	var toks []string
+	var cur strings.Builder
+	inQuote, esc := false, false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case esc:
+			esc = false
+		case ch == '\\' && inQuote:
+			esc = true
+		case ch == '"':
+			inQuote = !inQuote
+		case !inQuote && (ch == ' ' || ch == '\t' || ch == '\n'):
+			if cur.Len() > 0 {
+				toks = append(toks, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteByte(ch)
+	}
+	if cur.Len() > 0 {
+		toks = append(toks, cur.String())
+	}
+	return toks
+}
+
+// convert checks a decoded JSON value against the column type and returns
+// it as int64, float64, string, bool, []byte or time.Time.
+func convert(c Column, v any) (any, error) {
+	switch c.Type {
+	case Int:
+		if n, ok := v.(json.Number); ok {
+			if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("expected int")
+	case Float:
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("expected float")
+	case String:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("expected string")
+	case Bool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("expected bool")
+	case Bytes:
+		if s, ok := v.(string); ok {
+			if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("expected base64 bytes")
+	case Timestamp:
+		if s, ok := v.(string); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return ts.UTC(), nil
+			}
+		}
+		return nil, fmt.Errorf("expected RFC 3339 timestamp")
+	}
+	return nil, fmt.Errorf("unknown type")
+}
+
+// toJSON converts a column value to the form it takes in a JSON row.
+func toJSON(c Column, v any) any {
+	switch x := v.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// Encode validates a JSON object against the schema and returns the binary
+// row. Missing columns take their default; a missing required column, an
+// unknown column or a value of the wrong type is an ErrInvalid.
+func (s *Schema) Encode(value string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil || obj == nil {
+		return "", fmt.Errorf("%w: value must be a JSON object", ErrInvalid)
+	}
+	if dec.More() {
+		return "", fmt.Errorf("%w: trailing data after JSON object", ErrInvalid)
+	}
+	for name := range obj {
+		if s.column(name) < 0 {
+			return "", fmt.Errorf("%w: unknown column %s", ErrInvalid, name)
+		}
+	}
+	n := len(s.Columns)
+	buf := []byte{rowVersion}
+	buf = binary.AppendUvarint(buf, uint64(n))
+	bitmap := len(buf)
+	buf = append(buf, make([]byte, (n+7)/8)...)
+	for i, c := range s.Columns {
+		raw, ok := obj[c.Name]
+		var v any
+		switch {
+		case ok && raw != nil:
+			var err error
+			if v, err = convert(c, raw); err != nil {
+				return "", fmt.Errorf("%w: column %s: %v", ErrInvalid, c.Name, err)
+			}
+		case c.HasDefault:
+			v = c.Default
+		case c.Required:
+			return "", fmt.Errorf("%w: column %s is required", ErrInvalid, c.Name)
+		default:
+			continue
+		}
+		buf[bitmap+i/8] |= 1 << (i % 8)
+		buf = appendValue(buf, v)
+	}
+	return string(buf), nil
+}
+
+func appendValue(buf []byte, v any) []byte {
+	switch x := v.(type) {
+	case int64:
+		return binary.AppendVarint(buf, x)
+	case float64:
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(x))
+	case bool:
+		if x {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case string:
+		buf = binary.AppendUvarint(buf, uint64(len(x)))
+		return append(buf, x...)
+	case []byte:
+		buf = binary.AppendUvarint(buf, uint64(len(x)))
+		return append(buf, x...)
+	case time.Time:
+		return binary.AppendVarint(buf, x.UnixNano())
+	}
+	return buf
+}
+
+// Decode turns a binary row back into a JSON object.
+func (s *Schema) Decode(row string) (string, error) {
+	r := []byte(row)
+	bad := fmt.Errorf("%w: corrupt row encoding", ErrInvalid)
+	if len(r) == 0 || r[0] != rowVersion {
+		return "", bad
+	}
+	r = r[1:]
+	n, k := binary.Uvarint(r)
+	if k <= 0 || n > uint64(len(r))*8 {
+		return "", bad
+	}
+	r = r[k:]
+	nb := int(n+7) / 8
+	if len(r) < nb {
+		return "", bad
+	}
+	bitmap, r := r[:nb], r[nb:]
+	var out bytes.Buffer
+	out.WriteByte('{')
+	first := true
+	for i := 0; i < int(n); i++ {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		// Rows never hold more columns than the schema that wrote them.
+		if i >= len(s.Columns) {
+			return "", bad
+		}
+		c := s.Columns[i]
+		var v any
+		switch c.Type {
+		case Int, Timestamp:
+			x, k := binary.Varint(r)
+			if k <= 0 {
+				return "", bad
+			}
+			r = r[k:]
+			v = x
+			if c.Type == Timestamp {
+				v = time.Unix(0, x).UTC()
+			}
+		case Float:
+			if len(r) < 8 {
+				return "", bad
+			}
+			v = math.Float64frombits(binary.BigEndian.Uint64(r))
+			r = r[8:]
+		case Bool:
+			if len(r) < 1 {
+				return "", bad
+			}
+			v = r[0] == 1
+			r = r[1:]
+		case String, Bytes:
+			l, k := binary.Uvarint(r)
+			if k <= 0 || uint64(len(r)-k) < l {
+				return "", bad
+			}
+			b := r[k : k+int(l)]
+			r = r[k+int(l):]
+			if c.Type == String {
+				v = string(b)
+			} else {
+				v = append([]byte(nil), b...)
+			}
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(c.Name)
+		val, err := json.Marshal(toJSON(c, v))
+		if err != nil {
+			return "", err
+		}
+		out.Write(name)
+		out.WriteByte(':')
+		out.Write(val)
+	}
+	if len(r) != 0 {
+		return "", bad
+	}
+	out.WriteByte('}')
+	return out.String(), nil
+}
+
+func (s *Schema) column(name string) int {
+	for i, c := range s.Columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
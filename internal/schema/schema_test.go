@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		def  string
+		want string // canonical form, or the error text
+		err  bool
+	}{
+		{"name string", "name string", false},
+		{"name STRING required, age int default 0", "name string REQUIRED, age int DEFAULT 0", false},
+		{`city string DEFAULT "Oslo, Norway", ok bool DEFAULT true`, `city string DEFAULT "Oslo, Norway", ok bool DEFAULT true`, false},
+		{"at timestamp, raw bytes, score float DEFAULT 1.5", "at timestamp, raw bytes, score float DEFAULT 1.5", false},
+		{"", "no columns", true},
+		{"name", "needs a name and a type", true},
+		{"name text", "unknown type text", true},
+		{"a int, a string", "duplicate column a", true},
+		{"age int DEFAULT", "DEFAULT needs a value", true},
+		{"age int DEFAULT x", "bad default", true},
+		{`age int DEFAULT "1"`, "bad default", true},
+		{"age int UNIQUE", `unexpected "UNIQUE"`, true},
+	} {
+		s, err := Parse(tc.def)
+		if tc.err {
+			if !errors.Is(err, ErrBadSchema) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Parse(%q): got %v, want ErrBadSchema mentioning %q", tc.def, err, tc.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.def, err)
+			continue
+		}
+		if got := s.String(); got != tc.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tc.def, got, tc.want)
+		}
+		if again, err := Parse(s.String()); err != nil || again.String() != s.String() {
+			t.Errorf("canonical form %q does not parse back: %v", s.String(), err)
+		}
+	}
+}
+
+const testSchema = "name string REQUIRED, age int DEFAULT 0, score float, admin bool, avatar bytes, seen timestamp"
+
+func TestRoundTrip(t *testing.T) {
+	s, err := Parse(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ in, out string }{
+		{`{"name":"ann"}`, `{"name":"ann","age":0}`},
+		{`{"age":-7,"name":"bob"}`, `{"name":"bob","age":-7}`},
+		{`{"name":"c","age":9223372036854775807,"score":2.5,"admin":true}`, `{"name":"c","age":9223372036854775807,"score":2.5,"admin":true}`},
+		{`{"name":"d","avatar":"AAEC/w==","seen":"2024-05-01T12:00:00.5+02:00"}`, `{"name":"d","age":0,"avatar":"AAEC/w==","seen":"2024-05-01T10:00:00.5Z"}`},
+		{`{"name":"e","score":null}`, `{"name":"e","age":0}`},
+		{`{"name":""}`, `{"name":"","age":0}`},
+	} {
+		row, err := s.Encode(tc.in)
+		if err != nil {
+			t.Errorf("Encode(%s): %v", tc.in, err)
+			continue
+		}
+		got, err := s.Decode(row)
+		if err != nil || got != tc.out {
+			t.Errorf("Decode(Encode(%s)) = %s, %v; want %s", tc.in, got, err, tc.out)
+		}
+	}
+}
+
+// Rows that do not fit the schema are rejected with ErrInvalid.
+func TestEncodeRejects(t *testing.T) {
+	s, err := Parse(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ in, want string }{
+		{`{"age":1}`, "name is required"},
+		{`{"name":null}`, "name is required"},
+		{`{"name":"a","extra":1}`, "unknown column extra"},
+		{`{"name":1}`, "expected string"},
+		{`{"name":"a","age":"1"}`, "expected int"},
+		{`{"name":"a","age":1.5}`, "expected int"},
+		{`{"name":"a","age":9223372036854775808}`, "expected int"},
+		{`{"name":"a","score":"high"}`, "expected float"},
+		{`{"name":"a","admin":"yes"}`, "expected bool"},
+		{`{"name":"a","avatar":"not base64!"}`, "expected base64 bytes"},
+		{`{"name":"a","seen":"yesterday"}`, "expected RFC 3339 timestamp"},
+		{`["a"]`, "must be a JSON object"},
+		{`null`, "must be a JSON object"},
+		{`plain text`, "must be a JSON object"},
+		{`{"name":"a"} {}`, "trailing data"},
+	} {
+		_, err := s.Encode(tc.in)
+		if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Encode(%s): got %v, want ErrInvalid mentioning %q", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	s, err := Parse("name string, age int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := s.Encode(`{"name":"ann","age":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wide, err := Parse("name string, age int, extra int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wideRow, err := wide.Encode(`{"extra":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ name, row string }{
+		{"empty", ""},
+		{"wrong version", "\x09" + row[1:]},
+		{"truncated", row[:len(row)-1]},
+		{"trailing bytes", row + "x"},
+		{"more columns than the schema", wideRow},
+	} {
+		if _, err := s.Decode(tc.row); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", tc.name, err)
+		}
+	}
+}
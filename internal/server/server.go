@@ -2,12 +2,20 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
+	"net/textproto"
+	"strconv"
 	"strings"
+	"time"
 
+	"sharkDB/internal/bptree"
 	"sharkDB/internal/engine"
+	"sharkDB/internal/format"
 	"sharkDB/internal/parser"
 	"sharkDB/internal/txn"
 )
@@ -40,18 +48,28 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 	wr := bufio.NewWriter(conn)
 	_, _ = fmt.Fprintln(wr, "sharkDB server ready. Send commands; close socket to exit.")
 	_ = wr.Flush()
-	in := bufio.NewScanner(conn)
+	tp := textproto.NewReader(bufio.NewReader(conn))
 	var inTx bool
 	var writeTx bool
 	var curTx *txn.Tx
 	authed := opts.RequireToken == ""
-	for in.Scan() {
-		line := strings.TrimSpace(in.Text())
+	for {
+		raw, err := tp.ReadLine()
+		if err != nil {
+			break
+		}
+		line := strings.TrimSpace(raw)
 		if line == "" {
 			continue
 		}
 		cmd, err := parser.Parse(line)
 		if err != nil {
+			if streamsRows(line) {
+				// Skip the rows so they are not read as commands.
+				if _, err := io.Copy(io.Discard, tp.DotReader()); err != nil {
+					break
+				}
+			}
 			fmt.Fprintln(wr, "ERR:", err)
 			wr.Flush()
 			continue
@@ -80,10 +98,24 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 			fmt.Fprintln(wr, "Commands:")
 			fmt.Fprintln(wr, "  BEGIN [READONLY] | COMMIT | ABORT")
 			fmt.Fprintln(wr, "  CREATE <table> | DROP <table> | RENAME <old> <new> | TRUNCATE <table>")
+			fmt.Fprintln(wr, "  CREATE TABLE <table> (<col> int|float|string|bool|bytes|timestamp [REQUIRED] [DEFAULT v], ...)")
+			fmt.Fprintln(wr, "  SCHEMA <table>")
 			fmt.Fprintln(wr, "  INSERT <table> <key> <value> | UPDATE <table> <key> <value> | DELETE <table> [key]")
+			fmt.Fprintln(wr, "  CAS <table> <key> <expected> <new> | SETNX <table> <key> <value>")
+			fmt.Fprintln(wr, "  INCR <table> <key> | DECR <table> <key> | INCRBY <table> <key> <n>")
+			fmt.Fprintln(wr, "  SETEX <table> <key> <seconds> <value> | EXPIRE <table> <key> <seconds> | TTL <table> <key>")
 			fmt.Fprintln(wr, "  GET <table> <key> | EXISTS <table> <key>")
+			fmt.Fprintln(wr, "  CREATE INDEX <name> ON <table> (<field>) | DROP INDEX <name>")
+			fmt.Fprintln(wr, "  FIND <table> WHERE <field> =|!=|<|<=|>|>= <value>")
 			fmt.Fprintln(wr, "  TABLES | SCAN <table> [start] [limit] | PREFIXSCAN <table> <prefix> [limit]")
-			fmt.Fprintln(wr, "  COUNT <table> | STATS <table> | DUMP <table> [file] | LOAD <table> <file>")
+			fmt.Fprintln(wr, "  SCAN <table> [start] [limit] WHERE <filter> PROJECT <path>, ...")
+			fmt.Fprintln(wr, "  AGG <table> [PREFIX <p> | RANGE <a> <b>] COUNT|SUM|MIN|MAX|AVG [path] [GROUPBY PREFIXLEN <n>]")
+			fmt.Fprintln(wr, "  COUNT <table> [RANGE <a> <b>] | RANK <table> <key> | NTH <table> <i>")
+			fmt.Fprintln(wr, "  STATS <table> | COMPRESS <table> none|fast|best")
+			fmt.Fprintln(wr, "  DUMP <table> [STREAM] [FORMAT tsv|csv|jsonl] [HEADER] [KEY <col>]")
+			fmt.Fprintln(wr, "  LOAD <table> STREAM [FORMAT tsv|csv|jsonl] [HEADER] [KEY <col>] [ON ERROR skip|abort]")
+			fmt.Fprintln(wr, "    (rows follow, then a line holding a lone \".\"; lines starting with \".\" get another \".\")")
+			fmt.Fprintln(wr, "  BACKUP STREAM  (sent back over the connection)")
 			fmt.Fprintln(wr, "  HELP | EXIT | QUIT")
 			wr.Flush()
 			continue
@@ -150,7 +182,13 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 				writeTx = true
 				implicit = true
 			}
-			out, err := eng.Create(cmd.Args[0])
+			var out string
+			var err error
+			if len(cmd.Args) == 2 {
+				out, err = eng.CreateWithSchema(cmd.Args[0], cmd.Args[1])
+			} else {
+				out, err = eng.Create(cmd.Args[0])
+			}
 			if err != nil {
 				fmt.Fprintln(wr, "ERR:", err)
 			} else {
@@ -162,7 +200,7 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 				inTx = false
 				writeTx = false
 			}
-		case "INSERT":
+		case "INSERT", "SETEX":
 			if opts.ReadOnly {
 				fmt.Fprintln(wr, "ERR: read-only")
 				wr.Flush()
@@ -180,7 +218,16 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 				writeTx = true
 				implicit = true
 			}
-			out, err := eng.Insert(cmd.Args[0], cmd.Args[1], strings.Join(cmd.Args[2:], " "))
+			var out string
+			if cmd.Name == "SETEX" {
+				secs, _ := strconv.ParseInt(cmd.Args[2], 10, 64)
+				var ttl time.Duration
+				if ttl, err = engine.TTLSeconds(secs); err == nil {
+					out, err = eng.InsertTTL(cmd.Args[0], cmd.Args[1], cmd.Args[3], ttl)
+				}
+			} else {
+				out, err = eng.Insert(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+			}
 			if err != nil {
 				fmt.Fprintln(wr, "ERR:", err)
 			} else {
@@ -222,6 +269,149 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 				inTx = false
 				writeTx = false
 			}
+		case "CAS":
+			if opts.ReadOnly {
+				fmt.Fprintln(wr, "ERR: read-only")
+				wr.Flush()
+				continue
+			}
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				wr.Flush()
+				continue
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			out, err := eng.CompareAndSwap(cmd.Args[0], cmd.Args[1], cmd.Args[2], cmd.Args[3])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "SETNX":
+			if opts.ReadOnly {
+				fmt.Fprintln(wr, "ERR: read-only")
+				wr.Flush()
+				continue
+			}
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				wr.Flush()
+				continue
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			ok, err := eng.SetNX(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, ok)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "INCR", "DECR", "INCRBY":
+			if opts.ReadOnly {
+				fmt.Fprintln(wr, "ERR: read-only")
+				wr.Flush()
+				continue
+			}
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				wr.Flush()
+				continue
+			}
+			delta := int64(1)
+			if cmd.Name == "DECR" {
+				delta = -1
+			} else if cmd.Name == "INCRBY" {
+				delta, _ = strconv.ParseInt(cmd.Args[2], 10, 64)
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			n, err := eng.IncrBy(cmd.Args[0], cmd.Args[1], delta)
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, n)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "EXPIRE":
+			if opts.ReadOnly {
+				fmt.Fprintln(wr, "ERR: read-only")
+				wr.Flush()
+				continue
+			}
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				wr.Flush()
+				continue
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			secs, _ := strconv.ParseInt(cmd.Args[2], 10, 64)
+			ttl, err := engine.TTLSeconds(secs)
+			var ok bool
+			if err == nil {
+				ok, err = eng.Expire(cmd.Args[0], cmd.Args[1], ttl)
+			}
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, ok)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "TTL":
+			d, err := eng.TTL(cmd.Args[0], cmd.Args[1])
+			switch {
+			case errors.Is(err, bptree.ErrKeyNotFound):
+				fmt.Fprintln(wr, -2)
+			case err != nil:
+				fmt.Fprintln(wr, "ERR:", err)
+			case d == engine.NoExpiry:
+				fmt.Fprintln(wr, -1)
+			default:
+				fmt.Fprintln(wr, int64(math.Ceil(d.Seconds())))
+			}
 		case "DELETE":
 			if opts.ReadOnly {
 				fmt.Fprintln(wr, "ERR: read-only")
@@ -304,6 +494,75 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 				inTx = false
 				writeTx = false
 			}
+		case "CREATEINDEX":
+			if opts.ReadOnly {
+				fmt.Fprintln(wr, "ERR: read-only")
+				wr.Flush()
+				continue
+			}
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				wr.Flush()
+				continue
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			out, err := eng.CreateIndex(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "DROPINDEX":
+			if opts.ReadOnly {
+				fmt.Fprintln(wr, "ERR: read-only")
+				wr.Flush()
+				continue
+			}
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				wr.Flush()
+				continue
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			out, err := eng.DropIndex(cmd.Args[0])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
+			}
+		case "FIND":
+			pairs, err := eng.Find(cmd.Args[0], cmd.Args[1], cmd.Args[2], cmd.Args[3])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				for _, kv := range pairs {
+					fmt.Fprintf(wr, "%s\t%s\n", kv[0], kv[1])
+				}
+			}
 		case "GET":
 			v, err := eng.Get(cmd.Args[0], cmd.Args[1])
 			if err != nil {
@@ -336,6 +595,42 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 					fmt.Fprintf(wr, "%s\t%s\n", kv[0], kv[1])
 				}
 			}
+		case "SCANQUERY":
+			limit := 0
+			if cmd.Args[2] != "" {
+				if _, err := fmt.Sscanf(cmd.Args[2], "%d", &limit); err != nil {
+					fmt.Fprintln(wr, "ERR: bad limit")
+					wr.Flush()
+					continue
+				}
+			}
+			sel, err := engine.ParseSelection(cmd.Args[3], cmd.Args[4])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+				wr.Flush()
+				continue
+			}
+			pairs, _, err := eng.SelectPage(cmd.Args[0], "", cmd.Args[1], true, limit, sel)
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				for _, kv := range pairs {
+					fmt.Fprintf(wr, "%s\t%s\n", kv[0], kv[1])
+				}
+			}
+		case "AGG":
+			res, err := eng.Aggregate(cmd.Args[0], engine.AggQueryFromArgs(cmd.Args[1:]))
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				for _, g := range res {
+					if cmd.Args[6] != "" {
+						fmt.Fprintf(wr, "%s\t%s\n", g.Group, engine.FormatAggValue(g.Value))
+					} else {
+						fmt.Fprintln(wr, engine.FormatAggValue(g.Value))
+					}
+				}
+			}
 		case "PREFIXSCAN":
 			prefix := cmd.Args[1]
 			limit := 0
@@ -400,28 +695,215 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 				inTx = false
 				writeTx = false
 			}
+		case "SCHEMA":
+			def, err := eng.Schema(cmd.Args[0])
+			switch {
+			case err != nil:
+				fmt.Fprintln(wr, "ERR:", err)
+			case def == "":
+				fmt.Fprintln(wr, "(no schema)")
+			default:
+				fmt.Fprintln(wr, def)
+			}
 		case "STATS":
 			s, err := eng.Stats(cmd.Args[0])
 			if err != nil {
 				fmt.Fprintln(wr, "ERR:", err)
 			} else {
-				fmt.Fprintf(wr, "count=%d height=%d min=%s max=%s\n", s.Count, s.Height, s.MinKey, s.MaxKey)
+				fmt.Fprintln(wr, s)
+			}
+		case "COMPRESS":
+			if opts.ReadOnly {
+				fmt.Fprintln(wr, "ERR: read-only")
+				wr.Flush()
+				continue
+			}
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				wr.Flush()
+				continue
+			}
+			implicit := false
+			if !inTx || !writeTx {
+				curTx = tm.Begin(false)
+				inTx = true
+				writeTx = true
+				implicit = true
+			}
+			out, err := eng.Compress(cmd.Args[0], cmd.Args[1])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, out)
+			}
+			if implicit {
+				curTx.Commit()
+				curTx = nil
+				inTx = false
+				writeTx = false
 			}
 		case "COUNT":
-			n, err := eng.Count(cmd.Args[0])
+			var n int
+			var err error
+			if len(cmd.Args) == 3 {
+				n, err = eng.CountRange(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+			} else {
+				n, err = eng.Count(cmd.Args[0])
+			}
 			if err != nil {
 				fmt.Fprintln(wr, "ERR:", err)
 			} else {
 				fmt.Fprintln(wr, n)
 			}
+		case "RANK":
+			n, err := eng.Rank(cmd.Args[0], cmd.Args[1])
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, n)
+			}
+		case "NTH":
+			i, _ := strconv.Atoi(cmd.Args[1])
+			k, v, err := eng.Nth(cmd.Args[0], i)
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintf(wr, "%s\t%s\n", k, v)
+			}
 		case "DUMP":
-			pairs, err := eng.Scan(cmd.Args[0], "", 0)
+			// Rows are written to the connection in the requested format.
+			if cmd.Args[1] != "" {
+				fmt.Fprintln(wr, "ERR: DUMP to a file is not available over TCP; use DUMP <table> STREAM")
+				break
+			}
+			fo, err := format.OptionsFromArgs(cmd.Args[2:])
+			var rw format.RowWriter
+			if err == nil {
+				rw, err = format.NewWriter(wr, fo)
+			}
+			if err == nil {
+				_, err = eng.Dump(cmd.Args[0], rw)
+			}
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			}
+		case "DUMPSTREAM":
+			// Rows are framed like the input of LOAD ... STREAM: lines that
+			// start with "." are dot-stuffed and a lone "." ends the rows. A
+			// status line follows the terminator.
+			dw := textproto.NewWriter(wr).DotWriter()
+			fo, err := format.OptionsFromArgs(cmd.Args[2:])
+			var rw format.RowWriter
+			if err == nil {
+				rw, err = format.NewWriter(dw, fo)
+			}
+			n := 0
+			if err == nil {
+				n, err = eng.Dump(cmd.Args[0], rw)
+			}
+			if cerr := dw.Close(); err == nil {
+				err = cerr
+			}
 			if err != nil {
 				fmt.Fprintln(wr, "ERR:", err)
 			} else {
-				for _, kv := range pairs {
-					fmt.Fprintf(wr, "%s\t%s\n", kv[0], kv[1])
+				fmt.Fprintf(wr, "Exported %d rows\n", n)
+			}
+		case "LOAD":
+			fmt.Fprintln(wr, "ERR: LOAD from a file is not available over TCP; use LOAD <table> STREAM")
+		case "LOADSTREAM":
+			// Rows follow the command, dot-stuffed and ended by a line
+			// holding a lone "."; they are read to the end even when the
+			// load fails so the connection stays in step. They are sorted
+			// before the write lock is taken.
+			dr := tp.DotReader()
+			var res engine.LoadResult
+			err := func() error {
+				if opts.ReadOnly {
+					return errors.New("read-only")
+				}
+				if !authed {
+					return errors.New("unauthorized")
+				}
+				fo, err := format.OptionsFromArgs(cmd.Args[2:])
+				if err != nil {
+					return err
+				}
+				rows, err := format.NewReader(dr, fo)
+				if err != nil {
+					return err
 				}
+				l, err := eng.NewBulkLoad(cmd.Args[0])
+				if err != nil {
+					return err
+				}
+				defer l.Close()
+				if err := l.AddFrom(rows, fo.SkipErrors); err != nil {
+					return err
+				}
+				implicit := false
+				if !inTx || !writeTx {
+					curTx = tm.Begin(false)
+					inTx = true
+					writeTx = true
+					implicit = true
+				}
+				res, err = l.Commit()
+				if implicit {
+					curTx.Commit()
+					curTx = nil
+					inTx = false
+					writeTx = false
+				}
+				return err
+			}()
+			if _, derr := io.Copy(io.Discard, dr); derr != nil {
+				break // the connection is gone; the next read ends the loop
+			}
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+			} else {
+				fmt.Fprintln(wr, res)
+				for _, e := range res.Errors {
+					fmt.Fprintln(wr, "  skipped", e)
+				}
+			}
+		case "BACKUP":
+			fmt.Fprintln(wr, "ERR: BACKUP to a file is not available over TCP; use BACKUP STREAM")
+		case "BACKUPSTREAM":
+			// The backup is sent back over the connection: a line
+			// "BACKUP <n>" followed by exactly n bytes of backup stream.
+			// It copies the whole database, so it needs the token like a
+			// write, but is allowed on read-only servers.
+			if !authed {
+				fmt.Fprintln(wr, "ERR: unauthorized")
+				break
+			}
+			var lock *txn.Tx
+			if !inTx || !writeTx {
+				lock = tm.Begin(false)
+			}
+			snap, err := eng.Snapshot()
+			if lock != nil {
+				lock.Commit()
+			}
+			if err != nil {
+				fmt.Fprintln(wr, "ERR:", err)
+				break
+			}
+			fmt.Fprintf(wr, "BACKUP %d\n", snap.Size())
+			_, err = snap.WriteTo(wr)
+			if cerr := snap.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				// The client cannot tell where the stream broke off, so
+				// the connection cannot stay in step.
+				log.Printf("backup stream: %v", err)
+				if curTx != nil {
+					curTx.Abort()
+				}
+				return
 			}
 		default:
 			fmt.Fprintln(wr, "ERR: unknown command")
@@ -432,3 +914,10 @@ func handleConn(conn net.Conn, eng *engine.Engine, tm *txn.Manager, opts Options
 		curTx.Abort()
 	}
 }
+
+// streamsRows reports whether line is a LOAD ... STREAM command, which is
+// followed by rows even when it fails to parse.
+func streamsRows(line string) bool {
+	f := strings.Fields(line)
+	return len(f) >= 3 && strings.EqualFold(f[0], "LOAD") && strings.EqualFold(f[2], "STREAM")
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+
+	"sharkDB/internal/dbmeta"
+	"sharkDB/internal/pager"
+)
+
+// Legacy stores the database in the single-image format of internal/pager:
+// every write re-encodes the whole file, without a WAL, so a crash in the
+// middle of a write can lose the file. It exists to run against images not
+// yet converted with sharkdb migrate.
+type Legacy struct {
+	p *pager.Pager
+}
+
+// OpenLegacy opens or creates the legacy image at path.
+func OpenLegacy(path string) (*Legacy, error) {
+	p, err := pager.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Legacy{p: p}, nil
+}
+
+func (l *Legacy) Meta() dbmeta.Meta {
+	return fromLegacy(l.p.Meta())
+}
+
+// UpdateMeta applies mut to a copy of the meta, so that metas handed out
+// by Meta keep their maps unchanged.
+func (l *Legacy) UpdateMeta(mut func(m *dbmeta.Meta)) error {
+	return l.p.UpdateMeta(func(lm *pager.Meta) {
+		m := fromLegacy(*lm).Clone()
+		mut(&m)
+		*lm = toLegacy(m)
+	})
+}
+
+// ReadTableBlob returns a copy of the blob, since the legacy pager hands
+// out the slice held in its image.
+func (l *Legacy) ReadTableBlob(id uint64) ([]byte, error) {
+	blob, ok := l.p.LoadTableBlob(id)
+	if !ok {
+		return nil, nil
+	}
+	return bytes.Clone(blob), nil
+}
+
+func (l *Legacy) StoreTableBlob(id uint64, blob []byte) error {
+	return l.p.StoreTableBlob(id, blob)
+}
+
+func (l *Legacy) StoreTableBlobs(blobs map[uint64][]byte) error {
+	return l.p.StoreTableBlobs(blobs)
+}
+
+func (l *Legacy) StoreTableBlobsMeta(blobs map[uint64][]byte, mut func(m *dbmeta.Meta)) error {
+	if mut == nil {
+		return l.p.StoreTableBlobs(blobs)
+	}
+	return l.p.StoreTableBlobsMeta(blobs, func(lm *pager.Meta) {
+		m := fromLegacy(*lm).Clone()
+		mut(&m)
+		*lm = toLegacy(m)
+	})
+}
+
+func (l *Legacy) DeleteTableBlob(id uint64) error {
+	return l.p.DeleteTableBlob(id)
+}
+
+func (l *Legacy) Sync() error { return l.p.Sync() }
+
+// Snapshot returns ErrNoBackup; copy the image file while no writer runs.
+func (l *Legacy) Snapshot() (Snapshot, error) { return nil, ErrNoBackup }
+
+// SwitchWAL returns ErrNoWAL.
+func (l *Legacy) SwitchWAL() error { return ErrNoWAL }
+
+// Close syncs the image; the legacy pager holds no open files.
+func (l *Legacy) Close() error { return l.p.Sync() }
+
+func fromLegacy(lm pager.Meta) dbmeta.Meta {
+	m := dbmeta.Meta{
+		Tables:      lm.Tables,
+		NextTableID: lm.NextTableID,
+		Schemas:     lm.Schemas,
+		Compression: lm.Compression,
+		Expiring:    lm.Expiring,
+	}
+	if m.Tables == nil {
+		m.Tables = make(map[string]uint64)
+	}
+	if lm.Indexes != nil {
+		m.Indexes = make(map[string]dbmeta.IndexMeta, len(lm.Indexes))
+		for name, ix := range lm.Indexes {
+			m.Indexes[name] = dbmeta.IndexMeta{TableID: ix.TableID, Field: ix.Field, TreeID: ix.TreeID}
+		}
+	}
+	return m
+}
+
+func toLegacy(m dbmeta.Meta) pager.Meta {
+	lm := pager.Meta{
+		Tables:      m.Tables,
+		NextTableID: m.NextTableID,
+		Schemas:     m.Schemas,
+		Compression: m.Compression,
+		Expiring:    m.Expiring,
+	}
+	if m.Indexes != nil {
+		lm.Indexes = make(map[string]pager.IndexMeta, len(m.Indexes))
+		for name, ix := range m.Indexes {
+			lm.Indexes[name] = pager.IndexMeta{TableID: ix.TableID, Field: ix.Field, TreeID: ix.TreeID}
+		}
+	}
+	return lm
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"bytes"
+	"sync"
+
+	"sharkDB/internal/dbmeta"
+)
+
+// Memory keeps the meta and blobs in memory. It suits tests and scratch
+// databases: nothing is written anywhere, and everything is lost on Close
+// or when the process exits.
+type Memory struct {
+	mu    sync.Mutex
+	meta  dbmeta.Meta
+	blobs map[uint64][]byte
+}
+
+// NewMemory returns an empty in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{
+		meta:  dbmeta.Meta{Tables: make(map[string]uint64), TableHead: make(map[uint64]uint64)},
+		blobs: make(map[uint64][]byte),
+	}
+}
+
+// Meta returns the current meta. UpdateMeta never changes the maps of a
+// meta it has handed out, so callers may read them without the lock.
+func (m *Memory) Meta() dbmeta.Meta {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.meta
+}
+
+// UpdateMeta applies mut to a copy of the meta and then replaces it.
+func (m *Memory) UpdateMeta(mut func(meta *dbmeta.Meta)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	meta := m.meta.Clone()
+	mut(&meta)
+	m.meta = meta
+	return nil
+}
+
+// ReadTableBlob returns a copy of the blob, so callers cannot change the
+// stored one.
+func (m *Memory) ReadTableBlob(id uint64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return bytes.Clone(m.blobs[id]), nil
+}
+
+func (m *Memory) StoreTableBlob(id uint64, blob []byte) error {
+	return m.StoreTableBlobs(map[uint64][]byte{id: blob})
+}
+
+func (m *Memory) StoreTableBlobs(blobs map[uint64][]byte) error {
+	return m.StoreTableBlobsMeta(blobs, nil)
+}
+
+func (m *Memory) StoreTableBlobsMeta(blobs map[uint64][]byte, mut func(meta *dbmeta.Meta)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, blob := range blobs {
+		m.blobs[id] = bytes.Clone(blob)
+	}
+	if mut != nil {
+		meta := m.meta.Clone()
+		mut(&meta)
+		m.meta = meta
+	}
+	return nil
+}
+
+func (m *Memory) DeleteTableBlob(id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, id)
+	return nil
+}
+
+// Snapshot returns ErrNoBackup.
+func (m *Memory) Snapshot() (Snapshot, error) { return nil, ErrNoBackup }
+
+// SwitchWAL returns ErrNoWAL.
+func (m *Memory) SwitchWAL() error { return ErrNoWAL }
+
+// Sync does nothing: there is nowhere durable to write to.
+func (m *Memory) Sync() error { return nil }
+
+// Close drops everything the backend holds.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs = make(map[uint64][]byte)
+	m.meta = dbmeta.Meta{Tables: make(map[string]uint64), TableHead: make(map[uint64]uint64)}
+	return nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"sharkDB/internal/dbmeta"
+	"sharkDB/internal/pager2"
+)
+
+// Storage is what the catalog and the engine need from a backend: the
+// catalog meta and one blob per table or index tree. Blob ids are the table
+// and index tree ids of the meta. A backend applies each call atomically;
+// StoreTableBlobs writes all its blobs or none.
+//
+// Three backends exist:
+//
+//   - pager2: the paged file with its WAL (*pager2.Pager, see Pager2), the default
+//   - legacy: the single-image file of internal/pager, one gob per file
+//   - mem: in memory only, gone when the process exits
+//
+// Only pager2 keeps the TableHead, FreeList and LSN fields of the meta;
+// the others leave them zero.
+type Storage interface {
+	// Meta returns the current meta. Its maps must not be modified.
+	Meta() dbmeta.Meta
+	// UpdateMeta applies mut to the meta and persists the result.
+	UpdateMeta(mut func(m *dbmeta.Meta)) error
+	// ReadTableBlob returns the blob stored under id, or nil if there is none.
+	ReadTableBlob(id uint64) ([]byte, error)
+	StoreTableBlob(id uint64, blob []byte) error
+	StoreTableBlobs(blobs map[uint64][]byte) error
+	// StoreTableBlobsMeta stores blobs as StoreTableBlobs does and applies
+	// mut, if not nil, to the meta, all in one atomic write.
+	StoreTableBlobsMeta(blobs map[uint64][]byte, mut func(m *dbmeta.Meta)) error
+	DeleteTableBlob(id uint64) error
+	// Sync makes every completed write durable.
+	Sync() error
+	Close() error
+	// Snapshot captures the database for a backup, or returns ErrNoBackup.
+	Snapshot() (Snapshot, error)
+	// SwitchWAL closes the current WAL segment, archiving it if configured,
+	// or returns ErrNoWAL.
+	SwitchWAL() error
+}
+
+// Snapshot is a consistent image of the database being backed up; see
+// pager2.Snapshot. Close must be called once it has been written out.
+type Snapshot interface {
+	// Size returns the length of the stream WriteTo produces.
+	Size() int64
+	WriteTo(w io.Writer) (int64, error)
+	// WriteFile writes the backup to path, never leaving a partial file.
+	WriteFile(path string) (int64, error)
+	Close() error
+}
+
+var (
+	// ErrNoBackup is returned by Snapshot for backends without a backup
+	// format.
+	ErrNoBackup = errors.New("backups need the pager2 storage backend")
+	// ErrNoWAL is returned by SwitchWAL for backends without a WAL.
+	ErrNoWAL = errors.New("the WAL needs the pager2 storage backend")
+)
+
+// Pager2 serves p as a Storage.
+func Pager2(p *pager2.Pager) Storage { return paged{p} }
+
+// paged adapts *pager2.Pager, whose Snapshot returns its concrete type.
+type paged struct {
+	*pager2.Pager
+}
+
+func (p paged) Snapshot() (Snapshot, error) {
+	snap, err := p.Pager.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// MemPrefix selects the in-memory backend when a database path starts with
+// it, as in "mem://" or "mem://scratch".
+const MemPrefix = "mem://"
+
+// Kinds lists the backend names Open accepts.
+var Kinds = []string{"pager2", "legacy", "mem"}
+
+// ErrOptions is returned when pager2 options are given to another backend.
+var ErrOptions = errors.New("storage: encryption, mmap and WAL options need the pager2 backend")
+
+// Open opens the database at path with the backend named kind. An empty
+// kind means mem for paths starting with MemPrefix and pager2 for the rest.
+// opts apply to pager2 only; giving a key, mmap or a WAL archive to another
+// backend is an error.
+func Open(path, kind string, opts pager2.Options) (Storage, error) {
+	if kind == "" {
+		kind = "pager2"
+		if strings.HasPrefix(path, MemPrefix) {
+			kind = "mem"
+		}
+	}
+	if kind != "pager2" && (opts.Key != nil || opts.MMap || opts.ArchiveDir != "") {
+		return nil, ErrOptions
+	}
+	switch kind {
+	case "pager2":
+		p, err := pager2.OpenOptions(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		return Pager2(p), nil
+	case "legacy":
+		return OpenLegacy(path)
+	case "mem":
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("storage: unknown backend %q (want %s)", kind, strings.Join(Kinds, ", "))
+}
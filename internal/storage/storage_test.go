@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"sharkDB/internal/dbmeta"
+	"sharkDB/internal/pager2"
+)
+
+// backends opens each backend on a fresh path.
+func backends(t *testing.T) map[string]Storage {
+	t.Helper()
+	out := make(map[string]Storage)
+	for _, kind := range Kinds {
+		s, err := Open(filepath.Join(t.TempDir(), "db"), kind, pager2.Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		t.Cleanup(func() { s.Close() })
+		out[kind] = s
+	}
+	return out
+}
+
+func TestBackends(t *testing.T) {
+	for kind, s := range backends(t) {
+		t.Run(kind, func(t *testing.T) {
+			before := s.Meta()
+			if err := s.UpdateMeta(func(m *dbmeta.Meta) {
+				m.Tables["a"] = 1
+				m.NextTableID = 2
+				m.Schemas = map[uint64]string{1: "n int"}
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := before.Tables["a"]; ok {
+				t.Fatal("UpdateMeta changed the maps of a meta handed out before")
+			}
+			if m := s.Meta(); m.Tables["a"] != 1 || m.NextTableID != 2 || m.Schemas[1] != "n int" {
+				t.Fatalf("meta = %+v", m)
+			}
+
+			if got, err := s.ReadTableBlob(1); err != nil || got != nil {
+				t.Fatalf("missing blob = %q, %v", got, err)
+			}
+			if err := s.StoreTableBlob(1, []byte("one")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.StoreTableBlobsMeta(map[uint64][]byte{2: []byte("two")}, func(m *dbmeta.Meta) {
+				m.Tables["b"] = 2
+				m.NextTableID = 3
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if m := s.Meta(); m.Tables["b"] != 2 || m.NextTableID != 3 {
+				t.Fatalf("meta after StoreTableBlobsMeta = %+v", m)
+			}
+			got, err := s.ReadTableBlob(1)
+			if err != nil || string(got) != "one" {
+				t.Fatalf("blob 1 = %q, %v", got, err)
+			}
+			clear(got)
+			if got, _ := s.ReadTableBlob(1); string(got) != "one" {
+				t.Fatalf("blob 1 changed with the caller's copy: %q", got)
+			}
+			if err := s.DeleteTableBlob(1); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.ReadTableBlob(1); err != nil || got != nil {
+				t.Fatalf("deleted blob = %q, %v", got, err)
+			}
+			if got, err := s.ReadTableBlob(2); err != nil || !bytes.Equal(got, []byte("two")) {
+				t.Fatalf("blob 2 = %q, %v", got, err)
+			}
+			if err := s.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			snap, err := s.Snapshot()
+			switch kind {
+			case "pager2":
+				if err != nil {
+					t.Fatal(err)
+				}
+				snap.Close()
+			default:
+				if !errors.Is(err, ErrNoBackup) {
+					t.Fatalf("Snapshot: got %v, want ErrNoBackup", err)
+				}
+			}
+			if err := s.SwitchWAL(); (kind == "pager2") != (err == nil) || (err != nil && !errors.Is(err, ErrNoWAL)) {
+				t.Fatalf("SwitchWAL: %v", err)
+			}
+		})
+	}
+}
+
+// The file backends keep what was written across a reopen.
+func TestReopen(t *testing.T) {
+	for _, kind := range []string{"pager2", "legacy"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			s, err := Open(path, kind, pager2.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.StoreTableBlobsMeta(map[uint64][]byte{1: []byte("kept")}, func(m *dbmeta.Meta) {
+				m.Tables["a"], m.NextTableID = 1, 2
+				m.Indexes = map[string]dbmeta.IndexMeta{"a_n": {TableID: 1, Field: "$.n", TreeID: 2}}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if s, err = Open(path, kind, pager2.Options{}); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			m := s.Meta()
+			if m.Tables["a"] != 1 || m.Indexes["a_n"].Field != "$.n" {
+				t.Fatalf("meta = %+v", m)
+			}
+			if got, err := s.ReadTableBlob(1); err != nil || string(got) != "kept" {
+				t.Fatalf("blob = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		path string
+		kind string
+		opts pager2.Options
+		want error
+	}{
+		{"key for legacy", filepath.Join(dir, "a"), "legacy", pager2.Options{Key: bytes.Repeat([]byte{1}, pager2.KeySize)}, ErrOptions},
+		{"mmap for mem", "mem://", "mem", pager2.Options{MMap: true}, ErrOptions},
+		{"archive for mem path", "mem://x", "", pager2.Options{ArchiveDir: dir}, ErrOptions},
+		{"unknown kind", filepath.Join(dir, "b"), "tape", pager2.Options{}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Open(tc.path, tc.kind, tc.opts)
+			if err == nil {
+				s.Close()
+				t.Fatal("opened")
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	// mem:// paths default to the memory backend.
+	s, err := Open("mem://scratch", "", pager2.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.(*Memory); !ok {
+		t.Fatalf("mem:// opened a %T", s)
+	}
+}